@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderAPIKey = "X-API-Key"
+	apiKeyTag    = "ibg"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyStore looks up keys by prefix and records their use
+type APIKeyStore interface {
+	APIKeyByPrefix(ctx context.Context, prefix string) (entity.APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// GenerateAPIKey returns a new plaintext key "ibg_<prefix>_<secret>", its
+// public prefix and the hash to store
+func GenerateAPIKey() (plaintext, prefix, hash string, err error) {
+	p := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err = rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(p)
+	plaintext = fmt.Sprintf("%s_%s_%s", apiKeyTag, prefix, base64.RawURLEncoding.EncodeToString(secret))
+	return plaintext, prefix, HashAPIKey(plaintext), nil
+}
+
+// HashAPIKey hashes a plaintext key, keys are random enough for a plain sha256
+func HashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseAPIKey(plaintext string) (prefix string, ok bool) {
+	parts := strings.SplitN(plaintext, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTag || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+type APIKeyAuthenticator struct {
+	store  APIKeyStore
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+func NewAPIKeyAuthenticator(store APIKeyStore, logger *slog.Logger) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{store: store, logger: logger, now: time.Now}
+}
+
+// Authenticate checks the key hash, revocation and expiry then records its use
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, plaintext string) (Principal, error) {
+	prefix, ok := parseAPIKey(plaintext)
+	if !ok {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	key, err := a.store.APIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidAPIKey, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(plaintext)), []byte(key.Hash)) != 1 {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	now := a.now()
+	if !key.Active(now) {
+		return Principal{}, fmt.Errorf("%w: revoked or expired", ErrInvalidAPIKey)
+	}
+	if err := a.store.TouchAPIKey(ctx, key.ID, now); err != nil {
+		a.logger.WarnCtx(ctx, "failed to record api key use", slog.String("api_key_id", key.ID), slog.Any("error", err))
+	}
+	return Principal{
+		Subject:     SubjectForAPIKey(key.ID),
+		Method:      MethodAPIKey,
+		Permissions: key.Scopes,
+	}, nil
+}
+
+// SubjectForAPIKey is the principal subject of an api key
+func SubjectForAPIKey(id string) string {
+	return "api-key:" + id
+}
+
+// AuthenticateRequest authenticates the X-API-Key header of the request
+func (a *APIKeyAuthenticator) AuthenticateRequest(c echo.Context) (Principal, bool, error) {
+	plaintext := c.Request().Header.Get(HeaderAPIKey)
+	if plaintext == "" {
+		return Principal{}, false, nil
+	}
+	p, err := a.Authenticate(c.Request().Context(), plaintext)
+	return p, true, err
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeAPIKeys struct {
+	keys    map[string]entity.APIKey
+	touched map[string]time.Time
+}
+
+func (f *fakeAPIKeys) APIKeyByPrefix(_ context.Context, prefix string) (entity.APIKey, error) {
+	key, ok := f.keys[prefix]
+	if !ok {
+		return entity.APIKey{}, ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+func (f *fakeAPIKeys) TouchAPIKey(_ context.Context, id string, at time.Time) error {
+	f.touched[id] = at
+	return nil
+}
+
+func (f *fakeAPIKeys) issue(t *testing.T, id string, mod func(k *entity.APIKey)) string {
+	plaintext, prefix, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	key := entity.APIKey{ID: id, Prefix: prefix, Hash: hash, Scopes: []entity.Permission{entity.PermUsersRead}}
+	if mod != nil {
+		mod(&key)
+	}
+	f.keys[prefix] = key
+	return plaintext
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	store := &fakeAPIKeys{keys: map[string]entity.APIKey{}, touched: map[string]time.Time{}}
+	a := NewAPIKeyAuthenticator(store, slog.Default())
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	valid := store.issue(t, "1", func(k *entity.APIKey) { k.ExpiresAt = &future })
+	revoked := store.issue(t, "2", func(k *entity.APIKey) { k.RevokedAt = &past })
+	expired := store.issue(t, "3", func(k *entity.APIKey) { k.ExpiresAt = &past })
+	prefix, _ := parseAPIKey(valid)
+
+	testCase := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{name: "valid", key: valid, valid: true},
+		{name: "revoked", key: revoked},
+		{name: "expired", key: expired},
+		{name: "wrong secret", key: apiKeyTag + "_" + prefix + "_forged"},
+		{name: "unknown prefix", key: apiKeyTag + "_000000000000_secret"},
+		{name: "malformed", key: "not-a-key"},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := a.Authenticate(context.Background(), tc.key)
+			if !tc.valid {
+				assert.ErrorIs(t, err, ErrInvalidAPIKey)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, "api-key:1", p.Subject)
+				assert.Equal(t, MethodAPIKey, p.Method)
+				assert.True(t, p.Has(entity.PermUsersRead))
+				assert.False(t, p.Has(entity.PermUsersDelete))
+				assert.Contains(t, store.touched, "1")
+			}
+		})
+	}
+	assert.NotContains(t, store.touched, "2")
+	assert.NotContains(t, store.touched, "3")
+}
+
+func TestAPIKeyScopesSkipRoleBindings(t *testing.T) {
+	store := &fakeAPIKeys{keys: map[string]entity.APIKey{}, touched: map[string]time.Time{}}
+	key := store.issue(t, "1", nil)
+
+	e := echo.New()
+	g := e.Group("/user",
+		Middleware(NewAPIKeyAuthenticator(store, slog.Default())),
+		NewAuthorizer(fakePermissions{}).Middleware(),
+	)
+	g.GET("", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, Require(entity.PermUsersRead))
+	g.DELETE("/:id", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, Require(entity.PermUsersDelete))
+
+	testCase := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		code   int
+	}{
+		{name: "scoped read", method: http.MethodGet, path: "/user", key: key, code: http.StatusOK},
+		{name: "unscoped delete", method: http.MethodDelete, path: "/user/1", key: key, code: http.StatusForbidden},
+		{name: "no key", method: http.MethodGet, path: "/user", code: http.StatusUnauthorized},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.key != "" {
+				req.Header.Set(HeaderAPIKey, tc.key)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tc.code, rec.Code)
+		})
+	}
+}
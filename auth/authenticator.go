@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+const (
+	MethodJWT     = "jwt"
+	MethodAPIKey  = "api_key"
+	MethodSession = "session"
+)
+
+var ErrMissingCredentials = errors.New("missing credentials")
+
+// Authenticator verifies one kind of credentials. ok is false when the
+// request does not carry credentials of that kind.
+type Authenticator interface {
+	AuthenticateRequest(c echo.Context) (p Principal, ok bool, err error)
+}
+
+// Middleware authenticates requests with the first authenticator whose
+// credentials are present and rejects requests carrying none
+func Middleware(authenticators ...Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, a := range authenticators {
+				p, ok, err := a.AuthenticateRequest(c)
+				if !ok {
+					continue
+				}
+				if err != nil {
+					return unauthorized(c, err)
+				}
+				SetPrincipal(c, p)
+				return next(c)
+			}
+			return unauthorized(c, ErrMissingCredentials)
+		}
+	}
+}
+
+func unauthorized(c echo.Context, err error) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="api"`)
+	return utils.JSON(c, "authenticate", http.StatusUnauthorized, err)
+}
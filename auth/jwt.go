@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken      = errors.New("invalid bearer token")
+	ErrNoVerificationKey = errors.New("no jwt verification key configured")
+	ErrMissingClaim      = errors.New("issuer and audience must be configured")
+)
+
+// JWTConfig configures the verification of bearer tokens. HS256 tokens are
+// verified with Secret, RS256 and ES256 tokens with the keys of JWKSFile.
+type JWTConfig struct {
+	Secret   []byte
+	JWKSFile string
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// JWTConfigFromEnv reads SESSION_SECRET, JWKS_FILE, JWT_ISSUER and JWT_AUDIENCE
+func JWTConfigFromEnv() JWTConfig {
+	return JWTConfig{
+		Secret:   []byte(os.Getenv("SESSION_SECRET")),
+		JWKSFile: os.Getenv("JWKS_FILE"),
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Leeway:   30 * time.Second,
+	}
+}
+
+type JWTAuthenticator struct {
+	secret []byte
+	keys   map[string]any
+	parser *jwt.Parser
+}
+
+func NewJWTAuthenticator(cfg JWTConfig) (*JWTAuthenticator, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, ErrMissingClaim
+	}
+	a := &JWTAuthenticator{secret: cfg.Secret}
+	var methods []string
+	if len(cfg.Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoVerificationKey
+	}
+	a.parser = jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	)
+	return a, nil
+}
+
+// keyFunc picks the verification key matching the token algorithm so a
+// public key can never be used as an HMAC secret
+func (a *JWTAuthenticator) keyFunc(t *jwt.Token) (any, error) {
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return a.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		if key, ok := a.publicKey(t).(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	case jwt.SigningMethodES256.Alg():
+		if key, ok := a.publicKey(t).(*ecdsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s key for kid %q", t.Method.Alg(), t.Header["kid"])
+}
+
+func (a *JWTAuthenticator) publicKey(t *jwt.Token) any {
+	kid, _ := t.Header["kid"].(string)
+	return a.keys[kid]
+}
+
+// localSubjects prefix the subjects of the session and api key principals,
+// a token claiming one would take over its role bindings
+var localSubjects = []string{SubjectForUser(""), SubjectForAPIKey("")}
+
+// Authenticate verifies the token signature, issuer, audience and expiry
+func (a *JWTAuthenticator) Authenticate(token string) (Principal, error) {
+	claims := new(jwt.RegisteredClaims)
+	if _, err := a.parser.ParseWithClaims(token, claims, a.keyFunc); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return Principal{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	for _, prefix := range localSubjects {
+		if strings.HasPrefix(claims.Subject, prefix) {
+			return Principal{}, fmt.Errorf("%w: reserved subject %q", ErrInvalidToken, claims.Subject)
+		}
+	}
+	return Principal{Subject: claims.Subject, Method: MethodJWT}, nil
+}
+
+// AuthenticateRequest authenticates the "Authorization: Bearer" token of the request
+func (a *JWTAuthenticator) AuthenticateRequest(c echo.Context) (Principal, bool, error) {
+	token, ok := bearerToken(c.Request())
+	if !ok {
+		return Principal{}, false, nil
+	}
+	p, err := a.Authenticate(token)
+	return p, true, err
+}
+
+// Middleware rejects requests without a valid bearer token
+func (a *JWTAuthenticator) Middleware() echo.MiddlewareFunc {
+	return Middleware(a)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get(echo.HeaderAuthorization)
+	const prefix = "Bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "integra"
+	testAudience = "integra-api"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func writeJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) string {
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+	}}
+	raw, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, raw, 0o600))
+	return path
+}
+
+func claims(mod func(c *jwt.RegisteredClaims)) jwt.RegisteredClaims {
+	c := jwt.RegisteredClaims{
+		Subject:   "alice",
+		Issuer:    testIssuer,
+		Audience:  jwt.ClaimStrings{testAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	if mod != nil {
+		mod(&c)
+	}
+	return c
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, c jwt.RegisteredClaims) string {
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	a, err := NewJWTAuthenticator(JWTConfig{
+		Secret:   testSecret,
+		JWKSFile: writeJWKS(t, rsaKey, ecKey),
+		Issuer:   testIssuer,
+		Audience: testAudience,
+	})
+	require.NoError(t, err)
+
+	testCase := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "hs256", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(nil)), valid: true},
+		{name: "rs256", token: sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil)), valid: true},
+		{name: "es256", token: sign(t, jwt.SigningMethodES256, "ec-1", ecKey, claims(nil)), valid: true},
+		{name: "wrong secret", token: sign(t, jwt.SigningMethodHS256, "", []byte("another secret"), claims(nil))},
+		{name: "unknown kid", token: sign(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, claims(nil))},
+		{name: "rsa kid with ec key", token: sign(t, jwt.SigningMethodES256, "rsa-1", ecKey, claims(nil))},
+		{name: "unsupported alg", token: sign(t, jwt.SigningMethodHS512, "", testSecret, claims(nil))},
+		{name: "wrong issuer", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.Issuer = "someone else"
+		}))},
+		{name: "wrong audience", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"other-api"}
+		}))},
+		{name: "expired", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		}))},
+		{name: "missing expiry", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = nil
+		}))},
+		{name: "missing subject", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.Subject = ""
+		}))},
+		{name: "session subject", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.Subject = SubjectForUser("42")
+		}))},
+		{name: "api key subject", token: sign(t, jwt.SigningMethodHS256, "", testSecret, claims(func(c *jwt.RegisteredClaims) {
+			c.Subject = SubjectForAPIKey("1")
+		}))},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := a.Authenticate(tc.token)
+			if tc.valid {
+				if assert.NoError(t, err) {
+					assert.Equal(t, "alice", p.Subject)
+				}
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestNewJWTAuthenticatorConfig(t *testing.T) {
+	_, err := NewJWTAuthenticator(JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	assert.ErrorIs(t, err, ErrNoVerificationKey)
+
+	_, err = NewJWTAuthenticator(JWTConfig{Secret: testSecret})
+	assert.ErrorIs(t, err, ErrMissingClaim)
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	a, err := NewJWTAuthenticator(JWTConfig{Secret: testSecret, Issuer: testIssuer, Audience: testAudience})
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.GET("/user", func(c echo.Context) error {
+		return c.String(http.StatusOK, Subject(c))
+	}, a.Middleware())
+
+	testCase := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{name: "no header", code: http.StatusUnauthorized},
+		{name: "basic auth", header: "Basic YWxpY2U6cGFzcw==", code: http.StatusUnauthorized},
+		{name: "garbage token", header: "Bearer not.a.jwt", code: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer " + sign(t, jwt.SigningMethodHS256, "", testSecret, claims(nil)), code: http.StatusOK},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			if tc.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.code, rec.Code)
+			if tc.code == http.StatusOK {
+				assert.Equal(t, "alice", rec.Body.String())
+			} else {
+				assert.NotEmpty(t, rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
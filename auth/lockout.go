@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/entity"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrLoginLocked = errors.New("too many failed logins, try again later")
+
+// LockoutStore counts failed logins per throttle key
+type LockoutStore interface {
+	LoginThrottles(ctx context.Context, keys ...string) ([]entity.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, key string) error
+}
+
+type LockoutConfig struct {
+	// AccountThreshold is the number of failures locking an account
+	AccountThreshold int
+	// IPThreshold is the number of failures locking a client ip. It is
+	// higher than AccountThreshold since offices share an address.
+	IPThreshold int
+	// Window is how long failures are remembered
+	Window time.Duration
+	// BaseDelay is the first lockout, it doubles with every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// LockoutConfigFromEnv reads LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES,
+// LOGIN_FAILURE_WINDOW, LOGIN_LOCKOUT_BASE and LOGIN_LOCKOUT_MAX
+func LockoutConfigFromEnv() LockoutConfig {
+	return LockoutConfig{
+		AccountThreshold: envInt("LOGIN_MAX_FAILURES", 5),
+		IPThreshold:      envInt("LOGIN_IP_MAX_FAILURES", 50),
+		Window:           envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		BaseDelay:        envDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		MaxDelay:         envDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+	}
+}
+
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// AccountKey is the throttle key of the account signing in as userName.
+// Accounts are keyed by name so unknown names lock out like existing ones.
+func AccountKey(userName string) string {
+	return "account:" + strings.ToLower(userName)
+}
+
+// IPKey is the throttle key of a client address
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Lockout is a throttle key locked by a failed login
+type Lockout struct {
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+// LoginLimiter locks accounts and client ips out after repeated failed
+// logins, for exponentially longer periods
+type LoginLimiter struct {
+	cfg   LockoutConfig
+	store LockoutStore
+	now   func() time.Time
+}
+
+func NewLoginLimiter(cfg LockoutConfig, store LockoutStore) *LoginLimiter {
+	return &LoginLimiter{cfg: cfg, store: store, now: time.Now}
+}
+
+// LockedUntil returns the end of the lockout of the account or the ip, the
+// zero time when logins are allowed
+func (l *LoginLimiter) LockedUntil(ctx context.Context, userName, ip string) (time.Time, error) {
+	throttles, err := l.store.LoginThrottles(ctx, AccountKey(userName), IPKey(ip))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var until time.Time
+	for _, t := range throttles {
+		if t.Locked(l.now()) && t.LockedUntil.After(until) {
+			until = *t.LockedUntil
+		}
+	}
+	return until, nil
+}
+
+// Failure records a failed login and returns the lockouts it caused
+func (l *LoginLimiter) Failure(ctx context.Context, userName, ip string) ([]Lockout, error) {
+	var lockouts []Lockout
+	for _, k := range []struct {
+		key       string
+		threshold int
+	}{
+		{key: AccountKey(userName), threshold: l.cfg.AccountThreshold},
+		{key: IPKey(ip), threshold: l.cfg.IPThreshold},
+	} {
+		failures, err := l.store.RecordLoginFailure(ctx, k.key, l.cfg.Window)
+		if err != nil {
+			return lockouts, err
+		}
+		delay := l.delay(failures, k.threshold)
+		if delay == 0 {
+			continue
+		}
+		until := l.now().Add(delay)
+		if err := l.store.LockLogin(ctx, k.key, until); err != nil {
+			return lockouts, err
+		}
+		lockouts = append(lockouts, Lockout{Key: k.key, Failures: failures, Until: until})
+	}
+	return lockouts, nil
+}
+
+// Success forgets the failures of an account once its user signed in, the
+// failures of the ip are kept
+func (l *LoginLimiter) Success(ctx context.Context, userName string) error {
+	return l.store.ClearLoginFailures(ctx, AccountKey(userName))
+}
+
+// delay is the lockout after failures, BaseDelay at the threshold and
+// doubling with every further failure
+func (l *LoginLimiter) delay(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	d := l.cfg.BaseDelay
+	for i := threshold; i < failures && d < l.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.cfg.MaxDelay {
+		d = l.cfg.MaxDelay
+	}
+	return d
+}
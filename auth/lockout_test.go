@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type fakeLockouts map[string]*entity.LoginThrottle
+
+func (f fakeLockouts) LoginThrottles(_ context.Context, keys ...string) ([]entity.LoginThrottle, error) {
+	var out []entity.LoginThrottle
+	for _, k := range keys {
+		if t, ok := f[k]; ok {
+			out = append(out, *t)
+		}
+	}
+	return out, nil
+}
+
+func (f fakeLockouts) RecordLoginFailure(_ context.Context, key string, _ time.Duration) (int, error) {
+	t, ok := f[key]
+	if !ok {
+		t = &entity.LoginThrottle{Key: key}
+		f[key] = t
+	}
+	t.Failures++
+	return t.Failures, nil
+}
+
+func (f fakeLockouts) LockLogin(_ context.Context, key string, until time.Time) error {
+	f[key].LockedUntil = &until
+	return nil
+}
+
+func (f fakeLockouts) ClearLoginFailures(_ context.Context, key string) error {
+	if _, ok := f[key]; !ok {
+		return errors.New("not found")
+	}
+	delete(f, key)
+	return nil
+}
+
+func TestLoginLimiter(t *testing.T) {
+	store := fakeLockouts{}
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLoginLimiter(LockoutConfig{
+		AccountThreshold: 3,
+		IPThreshold:      5,
+		Window:           15 * time.Minute,
+		BaseDelay:        time.Minute,
+		MaxDelay:         10 * time.Minute,
+	}, store)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	testCase := []struct {
+		failure  int
+		lockouts []Lockout
+	}{
+		{failure: 1},
+		{failure: 2},
+		{failure: 3, lockouts: []Lockout{{Key: "account:john", Failures: 3, Until: now.Add(time.Minute)}}},
+		{failure: 4, lockouts: []Lockout{{Key: "account:john", Failures: 4, Until: now.Add(2 * time.Minute)}}},
+		{failure: 5, lockouts: []Lockout{
+			{Key: "account:john", Failures: 5, Until: now.Add(4 * time.Minute)},
+			{Key: "ip:10.0.0.1", Failures: 5, Until: now.Add(time.Minute)},
+		}},
+		{failure: 6, lockouts: []Lockout{
+			{Key: "account:john", Failures: 6, Until: now.Add(8 * time.Minute)},
+			{Key: "ip:10.0.0.1", Failures: 6, Until: now.Add(2 * time.Minute)},
+		}},
+		{failure: 7, lockouts: []Lockout{
+			{Key: "account:john", Failures: 7, Until: now.Add(10 * time.Minute)},
+			{Key: "ip:10.0.0.1", Failures: 7, Until: now.Add(4 * time.Minute)},
+		}},
+	}
+	for _, tc := range testCase {
+		lockouts, err := l.Failure(ctx, "John", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, tc.lockouts, lockouts, "failure %d", tc.failure)
+	}
+
+	until, err := l.LockedUntil(ctx, "john", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(10*time.Minute), until)
+
+	until, err = l.LockedUntil(ctx, "jane", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(4*time.Minute), until, "the ip is locked for every account")
+
+	until, err = l.LockedUntil(ctx, "jane", "10.0.0.2")
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	require.NoError(t, l.Success(ctx, "john"))
+	until, err = l.LockedUntil(ctx, "john", "10.0.0.2")
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	now = now.Add(time.Hour)
+	until, err = l.LockedUntil(ctx, "jane", "10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, until.IsZero(), "lockouts end")
+}
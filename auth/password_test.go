@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$"))
+
+	other, err := HashPassword("correct horse battery staple")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salts must differ")
+
+	ok, err := VerifyPassword("correct horse battery staple", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword("Correct horse battery staple", hash)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = VerifyPassword("anything", "$2a$10$bcrypt")
+	assert.ErrorIs(t, err, ErrInvalidHash)
+}
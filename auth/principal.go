@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+)
+
+const principalKey = "auth.principal"
+
+type principalCtxKey struct{}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject string
+	// Method is the mechanism that authenticated the caller, e.g. "jwt"
+	Method      string
+	Roles       []string
+	Permissions []entity.Permission
+	// UserID and SessionID are set for users signed in with a session cookie
+	UserID    string
+	SessionID string
+	// MFA is set when the session was signed in with a second factor
+	MFA bool
+}
+
+// Has reports whether the principal was granted perm
+func (p Principal) Has(perm entity.Permission) bool {
+	for _, granted := range p.Permissions {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPrincipal exposes p to the handlers through the echo context and to
+// the logs through the request context
+func SetPrincipal(c echo.Context, p Principal) {
+	c.Set(principalKey, p)
+	req := c.Request()
+	c.SetRequest(req.WithContext(custom_slog.WithPrincipal(req.Context(), p.Subject)))
+}
+
+// PrincipalFrom returns the authenticated caller of the request, if any
+func PrincipalFrom(c echo.Context) (Principal, bool) {
+	p, ok := c.Get(principalKey).(Principal)
+	return p, ok
+}
+
+// Subject returns the subject of the authenticated caller or "" for anonymous requests
+func Subject(c echo.Context) string {
+	p, _ := PrincipalFrom(c)
+	return p.Subject
+}
+
+// Can reports whether the caller of the request was granted perm, handlers
+// use it for field level decisions
+func Can(c echo.Context, perm entity.Permission) bool {
+	p, ok := PrincipalFrom(c)
+	return ok && p.Has(perm)
+}
+
+// WithPrincipal exposes p through ctx for transports without an echo
+// context, such as the grpc api
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return custom_slog.WithPrincipal(context.WithValue(ctx, principalCtxKey{}, p), p.Subject)
+}
+
+// PrincipalFromContext returns the caller stored with WithPrincipal, if any
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+var (
+	ErrForbidden       = errors.New("permission denied")
+	ErrUnauthenticated = errors.New("request is not authenticated")
+)
+
+// PermissionStore resolves the roles and permissions bound to a subject
+type PermissionStore interface {
+	SubjectPermissions(ctx context.Context, subject string) ([]string, []entity.Permission, error)
+}
+
+type Authorizer struct {
+	store PermissionStore
+}
+
+func NewAuthorizer(store PermissionStore) *Authorizer {
+	return &Authorizer{store: store}
+}
+
+// Authorize loads the roles and permissions bound to p. API keys keep the
+// permissions of their scopes and sessions signed in without a second
+// factor lose the permissions requiring one.
+func (a *Authorizer) Authorize(ctx context.Context, p Principal) (Principal, error) {
+	if p.Method == MethodAPIKey {
+		return p, nil
+	}
+	roles, perms, err := a.store.SubjectPermissions(ctx, p.Subject)
+	if err != nil {
+		return p, err
+	}
+	p.Roles = roles
+	p.Permissions = perms
+	if p.Method == MethodSession && !p.MFA {
+		p.Permissions = make([]entity.Permission, 0, len(perms))
+		for _, perm := range perms {
+			if !perm.RequiresMFA() {
+				p.Permissions = append(p.Permissions, perm)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Middleware authorizes the authenticated principal, it must run after an
+// authentication middleware
+func (a *Authorizer) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p, ok := PrincipalFrom(c)
+			if !ok {
+				return unauthorized(c, ErrUnauthenticated)
+			}
+			p, err := a.Authorize(c.Request().Context(), p)
+			if err != nil {
+				return utils.JSON(c, "authorize", http.StatusInternalServerError, err)
+			}
+			SetPrincipal(c, p)
+			return next(c)
+		}
+	}
+}
+
+// Require rejects callers that were not granted perm
+func Require(perm entity.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p, ok := PrincipalFrom(c)
+			if !ok {
+				return unauthorized(c, ErrUnauthenticated)
+			}
+			if !p.Has(perm) && perm.RequiresMFA() && p.Method == MethodSession && !p.MFA {
+				return utils.JSON(c, "authorize", http.StatusForbidden, fmt.Errorf("%w: %s", ErrMFARequired, perm))
+			}
+			if !p.Has(perm) {
+				return utils.JSON(c, "authorize", http.StatusForbidden, fmt.Errorf("%w: %s", ErrForbidden, perm))
+			}
+			return next(c)
+		}
+	}
+}
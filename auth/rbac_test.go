@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakePermissions map[string][]entity.Permission
+
+func (f fakePermissions) SubjectPermissions(_ context.Context, subject string) ([]string, []entity.Permission, error) {
+	if subject == "broken" {
+		return nil, nil, errors.New("database is down")
+	}
+	return []string{subject}, f[subject], nil
+}
+
+// fakeAuthn authenticates the subject sent in the X-Subject header
+func fakeAuthn(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if sub := c.Request().Header.Get("X-Subject"); sub != "" {
+			SetPrincipal(c, Principal{Subject: sub, Method: "test"})
+		}
+		return next(c)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	store := fakePermissions{
+		entity.RoleViewer: {entity.PermUsersRead},
+		entity.RoleAdmin:  {entity.PermUsersRead, entity.PermUsersDelete},
+	}
+	e := echo.New()
+	g := e.Group("/user", fakeAuthn, NewAuthorizer(store).Middleware())
+	g.GET("", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, Require(entity.PermUsersRead))
+	g.DELETE("/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, Require(entity.PermUsersDelete))
+
+	testCase := []struct {
+		name    string
+		method  string
+		path    string
+		subject string
+		code    int
+	}{
+		{name: "anonymous", method: http.MethodGet, path: "/user", code: http.StatusUnauthorized},
+		{name: "viewer reads", method: http.MethodGet, path: "/user", subject: entity.RoleViewer, code: http.StatusOK},
+		{name: "viewer deletes", method: http.MethodDelete, path: "/user/1", subject: entity.RoleViewer, code: http.StatusForbidden},
+		{name: "admin deletes", method: http.MethodDelete, path: "/user/1", subject: entity.RoleAdmin, code: http.StatusOK},
+		{name: "unbound subject", method: http.MethodGet, path: "/user", subject: "nobody", code: http.StatusForbidden},
+		{name: "store failure", method: http.MethodGet, path: "/user", subject: "broken", code: http.StatusInternalServerError},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.subject != "" {
+				req.Header.Set("X-Subject", tc.subject)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tc.code, rec.Code)
+		})
+	}
+}
+
+func TestRequireMFA(t *testing.T) {
+	store := fakePermissions{
+		SubjectForUser("1"): {entity.PermUsersRead, entity.PermUsersDelete},
+	}
+	e := echo.New()
+	sessionAuthn := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			SetPrincipal(c, Principal{
+				Subject: SubjectForUser("1"),
+				Method:  MethodSession,
+				UserID:  "1",
+				MFA:     c.Request().Header.Get("X-MFA") != "",
+			})
+			return next(c)
+		}
+	}
+	g := e.Group("/user", sessionAuthn, NewAuthorizer(store).Middleware())
+	g.GET("", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, Require(entity.PermUsersRead))
+	g.DELETE("/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, Require(entity.PermUsersDelete))
+
+	testCase := []struct {
+		name   string
+		method string
+		path   string
+		mfa    bool
+		code   int
+	}{
+		{name: "read without mfa", method: http.MethodGet, path: "/user", code: http.StatusOK},
+		{name: "delete without mfa", method: http.MethodDelete, path: "/user/2", code: http.StatusForbidden},
+		{name: "delete with mfa", method: http.MethodDelete, path: "/user/2", mfa: true, code: http.StatusOK},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.mfa {
+				req.Header.Set("X-MFA", "1")
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tc.code, rec.Code)
+			if tc.code == http.StatusForbidden {
+				assert.Contains(t, rec.Body.String(), ErrMFARequired.Error())
+			}
+		})
+	}
+}
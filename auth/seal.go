@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// the purposes the keys of a Sealer are derived for, the secrets sealed for
+// one purpose cannot be opened for another
+const (
+	PurposeTOTPSecrets    = "totp secret encryption"
+	PurposeWebhookSecrets = "webhook secret encryption"
+)
+
+var (
+	ErrNoSealingKey   = errors.New("SESSION_SECRET must be set to encrypt secrets at rest")
+	ErrInvalidSealing = errors.New("sealed secret cannot be opened")
+)
+
+// Sealer encrypts the secrets stored at rest with AES-GCM
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer derives the key sealing the secrets of purpose from key
+func NewSealer(key []byte, purpose string) (*Sealer, error) {
+	if len(key) == 0 {
+		return nil, ErrNoSealingKey
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// SealerFromEnv seals the secrets of purpose with a key derived from
+// SESSION_SECRET
+func SealerFromEnv(purpose string) (*Sealer, error) {
+	return NewSealer([]byte(os.Getenv("SESSION_SECRET")), purpose)
+}
+
+// Seal encrypts secret for storage
+func (s *Sealer) Seal(secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret returned by Seal
+func (s *Sealer) Open(sealed string) (string, error) {
+	b, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < s.aead.NonceSize() {
+		return "", ErrInvalidSealing
+	}
+	n := s.aead.NonceSize()
+	secret, err := s.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSealing, err)
+	}
+	return string(secret), nil
+}
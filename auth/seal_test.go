@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	sealer, err := NewSealer(testSecret, PurposeWebhookSecrets)
+	require.NoError(t, err)
+
+	sealed, err := sealer.Seal("whsec_test")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "whsec_test")
+	opened, err := sealer.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_test", opened)
+
+	again, err := sealer.Seal("whsec_test")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "every seal has its own nonce")
+
+	// the key of a purpose cannot open the secrets of another
+	totp, err := NewSealer(testSecret, PurposeTOTPSecrets)
+	require.NoError(t, err)
+	_, err = totp.Open(sealed)
+	assert.ErrorIs(t, err, ErrInvalidSealing)
+
+	for _, invalid := range []string{"", "not base64!", sealed[:len(sealed)-2]} {
+		_, err = sealer.Open(invalid)
+		assert.ErrorIs(t, err, ErrInvalidSealing, invalid)
+	}
+
+	_, err = NewSealer(nil, PurposeWebhookSecrets)
+	assert.ErrorIs(t, err, ErrNoSealingKey)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const SessionCookie = "ibg_session"
+
+var (
+	ErrNoSessionSecret = errors.New("SESSION_SECRET must be set")
+	ErrInvalidSession  = errors.New("invalid session")
+)
+
+// SessionStore looks up server side sessions
+type SessionStore interface {
+	SessionByID(ctx context.Context, id string) (entity.Session, entity.Status, error)
+}
+
+type SessionConfig struct {
+	Secret []byte
+	TTL    time.Duration
+	// Secure restricts the cookie to https
+	Secure bool
+}
+
+// SessionConfigFromEnv reads SESSION_SECRET and SESSION_TTL, cookies are
+// https only unless APP_ENV is development
+func SessionConfigFromEnv() SessionConfig {
+	ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 12 * time.Hour
+	}
+	return SessionConfig{
+		Secret: []byte(os.Getenv("SESSION_SECRET")),
+		TTL:    ttl,
+		Secure: os.Getenv("APP_ENV") != "development",
+	}
+}
+
+// SessionManager issues signed session cookies and authenticates them
+type SessionManager struct {
+	cfg   SessionConfig
+	store SessionStore
+	now   func() time.Time
+}
+
+func NewSessionManager(cfg SessionConfig, store SessionStore) (*SessionManager, error) {
+	if len(cfg.Secret) == 0 {
+		return nil, ErrNoSessionSecret
+	}
+	return &SessionManager{cfg: cfg, store: store, now: time.Now}, nil
+}
+
+// SubjectForUser is the principal subject of a locally signed in user
+func SubjectForUser(userID string) string {
+	return "user:" + userID
+}
+
+// NewSession returns the cookie token of a new session of userID and the
+// session to store, whose id is the hash of the token
+func (m *SessionManager) NewSession(userID, ip, userAgent string) (string, entity.Session, error) {
+	token, id, err := NewToken()
+	if err != nil {
+		return "", entity.Session{}, err
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	return token, entity.Session{
+		ID:        id,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		ExpiresAt: m.now().Add(m.cfg.TTL),
+	}, nil
+}
+
+func (m *SessionManager) sign(token string) string {
+	mac := hmac.New(sha256.New, m.cfg.Secret)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Cookie returns the signed cookie holding token
+func (m *SessionManager) Cookie(token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token + "." + m.sign(token),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   m.cfg.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ClearCookie returns a cookie deleting the session cookie
+func (m *SessionManager) ClearCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.cfg.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// token returns the token of a validly signed session cookie
+func (m *SessionManager) token(r *http.Request) (string, bool, error) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false, nil
+	}
+	token, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(m.sign(token))) {
+		return "", true, fmt.Errorf("%w: bad signature", ErrInvalidSession)
+	}
+	return token, true, nil
+}
+
+// Authenticate returns the principal of an active session of an active user
+func (m *SessionManager) Authenticate(ctx context.Context, token string) (Principal, error) {
+	sess, status, err := m.store.SessionByID(ctx, HashToken(token))
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	if !sess.Active(m.now()) {
+		return Principal{}, fmt.Errorf("%w: revoked or expired", ErrInvalidSession)
+	}
+	if status != entity.Active {
+		return Principal{}, fmt.Errorf("%w: user is not active", ErrInvalidSession)
+	}
+	return Principal{
+		Subject:   SubjectForUser(sess.UserID),
+		Method:    MethodSession,
+		UserID:    sess.UserID,
+		SessionID: sess.ID,
+		MFA:       sess.MFA,
+	}, nil
+}
+
+// AuthenticateRequest authenticates the session cookie of the request
+func (m *SessionManager) AuthenticateRequest(c echo.Context) (Principal, bool, error) {
+	token, ok, err := m.token(c.Request())
+	if !ok || err != nil {
+		return Principal{}, ok, err
+	}
+	p, err := m.Authenticate(c.Request().Context(), token)
+	return p, true, err
+}
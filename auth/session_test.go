@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeSessions map[string]struct {
+	sess   entity.Session
+	status entity.Status
+}
+
+func (f fakeSessions) SessionByID(_ context.Context, id string) (entity.Session, entity.Status, error) {
+	s, ok := f[id]
+	if !ok {
+		return entity.Session{}, entity.Inactive, ErrInvalidSession
+	}
+	return s.sess, s.status, nil
+}
+
+func TestSessionCookie(t *testing.T) {
+	store := fakeSessions{}
+	m, err := NewSessionManager(SessionConfig{Secret: testSecret, TTL: time.Hour, Secure: true}, store)
+	require.NoError(t, err)
+
+	newCookie := func(userID string, status entity.Status, mod func(s *entity.Session)) *http.Cookie {
+		token, sess, err := m.NewSession(userID, "127.0.0.1", "test")
+		require.NoError(t, err)
+		if mod != nil {
+			mod(&sess)
+		}
+		store[sess.ID] = struct {
+			sess   entity.Session
+			status entity.Status
+		}{sess, status}
+		return m.Cookie(token, sess.ExpiresAt)
+	}
+	past := time.Now().Add(-time.Minute)
+
+	valid := newCookie("1", entity.Active, nil)
+	assert.True(t, valid.HttpOnly)
+	assert.True(t, valid.Secure)
+	forged := *valid
+	forged.Value = forged.Value[:len(forged.Value)-2] + "xx"
+
+	testCase := []struct {
+		name   string
+		cookie *http.Cookie
+		ok     bool
+		valid  bool
+	}{
+		{name: "valid", cookie: valid, ok: true, valid: true},
+		{name: "no cookie"},
+		{name: "forged signature", cookie: &forged, ok: true},
+		{name: "revoked", cookie: newCookie("2", entity.Active, func(s *entity.Session) { s.RevokedAt = &past }), ok: true},
+		{name: "expired", cookie: newCookie("3", entity.Active, func(s *entity.Session) { s.ExpiresAt = past }), ok: true},
+		{name: "terminated user", cookie: newCookie("4", entity.Terminated, nil), ok: true},
+		{name: "inactive user", cookie: newCookie("5", entity.Inactive, nil), ok: true},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			p, ok, err := m.AuthenticateRequest(c)
+			assert.Equal(t, tc.ok, ok)
+			if !tc.ok {
+				return
+			}
+			if tc.valid {
+				if assert.NoError(t, err) {
+					assert.Equal(t, SubjectForUser("1"), p.Subject)
+					assert.Equal(t, "1", p.UserID)
+					assert.Equal(t, MethodSession, p.Method)
+				}
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidSession)
+		})
+	}
+}
+
+func TestNewSessionManagerRequiresSecret(t *testing.T) {
+	_, err := NewSessionManager(SessionConfig{}, fakeSessions{})
+	assert.ErrorIs(t, err, ErrNoSessionSecret)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, see RFC 6238. They are the defaults of authenticator
+// apps, which ignore any other value.
+const (
+	totpPeriod    = 30
+	totpDigits    = 6
+	totpSecretLen = 20
+
+	recoveryCodeLen = 10
+)
+
+var (
+	ErrInvalidMFACode     = errors.New("invalid mfa code")
+	ErrInvalidTOTPSecret  = errors.New("invalid totp secret")
+	ErrMFARequired        = errors.New("multi-factor authentication required")
+	ErrNoMFAEncryptionKey = errors.New("SESSION_SECRET must be set to encrypt totp secrets")
+
+	b32                    = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeSeparators = strings.NewReplacer("-", "", " ", "")
+)
+
+type TOTPConfig struct {
+	// Issuer is the account label shown by authenticator apps
+	Issuer string
+	// Key derives the key encrypting the secrets at rest
+	Key []byte
+	// Skew is the number of periods a code may drift from the server clock
+	Skew int64
+}
+
+// TOTPConfigFromEnv reads MFA_ISSUER, secrets are encrypted with a key
+// derived from SESSION_SECRET
+func TOTPConfigFromEnv() TOTPConfig {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "assessment-bg"
+	}
+	return TOTPConfig{Issuer: issuer, Key: []byte(os.Getenv("SESSION_SECRET")), Skew: 1}
+}
+
+// TOTP generates and verifies time based one time passwords
+type TOTP struct {
+	cfg    TOTPConfig
+	sealer *Sealer
+	now    func() time.Time
+}
+
+func NewTOTP(cfg TOTPConfig) (*TOTP, error) {
+	if len(cfg.Key) == 0 {
+		return nil, ErrNoMFAEncryptionKey
+	}
+	sealer, err := NewSealer(cfg.Key, PurposeTOTPSecrets)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTP{cfg: cfg, sealer: sealer, now: time.Now}, nil
+}
+
+// NewSecret returns a random base32 encoded secret
+func (t *TOTP) NewSecret() (string, error) {
+	b := make([]byte, totpSecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// URI returns the otpauth uri of secret that authenticator apps import, usually from a qr code
+func (t *TOTP) URI(account, secret string) string {
+	label := url.PathEscape(t.cfg.Issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", t.cfg.Issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Seal encrypts secret for storage
+func (t *TOTP) Seal(secret string) (string, error) {
+	return t.sealer.Seal(secret)
+}
+
+// Open decrypts a secret returned by Seal
+func (t *TOTP) Open(sealed string) (string, error) {
+	secret, err := t.sealer.Open(sealed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTOTPSecret, err)
+	}
+	return secret, nil
+}
+
+// Verify checks code against the periods around the current time and
+// returns the period it matched. Periods up to lastStep were used already
+// and are rejected so a code cannot be replayed.
+func (t *TOTP) Verify(secret, code string, lastStep int64) (int64, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidTOTPSecret, err)
+	}
+	now := t.now().Unix() / totpPeriod
+	for step := now - t.cfg.Skew; step <= now+t.cfg.Skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidMFACode
+}
+
+// totpCode is the HOTP value of key for the counter step, see RFC 4226 section 5.3
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// GenerateRecoveryCodes returns n single use codes formatted "xxxxx-xxxxx"
+// and the hashes to store
+func GenerateRecoveryCodes(n int) (codes, hashes []string, err error) {
+	// 10 base32 characters are 50 random bits
+	b := make([]byte, 7)
+	for i := 0; i < n; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(b32.EncodeToString(b))[:recoveryCodeLen]
+		c := code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:]
+		codes = append(codes, c)
+		hashes = append(hashes, HashRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode is the stored identifier of a recovery code, it ignores
+// case and separators so codes can be typed as printed
+func HashRecoveryCode(code string) string {
+	return HashToken(strings.ToLower(recoveryCodeSeparators.Replace(code)))
+}
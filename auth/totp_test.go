@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the sha1 seed of the RFC 6238 test vectors, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func newTestTOTP(t *testing.T, at time.Time) *TOTP {
+	totp, err := NewTOTP(TOTPConfig{Issuer: "ibg", Key: testSecret, Skew: 1})
+	require.NoError(t, err)
+	totp.now = func() time.Time { return at }
+	return totp
+}
+
+func TestTOTPCode(t *testing.T) {
+	key, err := b32.DecodeString(rfcSecret)
+	require.NoError(t, err)
+	// RFC 6238 appendix B, truncated to 6 digits
+	testCase := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tc := range testCase {
+		assert.Equal(t, tc.want, totpCode(key, tc.unix/totpPeriod))
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	step := at.Unix() / totpPeriod
+	totp := newTestTOTP(t, at)
+	key, err := b32.DecodeString(rfcSecret)
+	require.NoError(t, err)
+
+	testCase := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     int64
+		err      error
+	}{
+		{name: "current period", code: totpCode(key, step), want: step},
+		{name: "previous period", code: totpCode(key, step-1), want: step - 1},
+		{name: "next period", code: totpCode(key, step+1), want: step + 1},
+		{name: "too old", code: totpCode(key, step-2), err: ErrInvalidMFACode},
+		{name: "replayed", code: totpCode(key, step), lastStep: step, err: ErrInvalidMFACode},
+		{name: "wrong code", code: "000000", err: ErrInvalidMFACode},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := totp.Verify(rfcSecret, tc.code, tc.lastStep)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTOTPSecret(t *testing.T) {
+	totp := newTestTOTP(t, time.Now())
+	secret, err := totp.NewSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	sealed, err := totp.Seal(secret)
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, secret)
+	opened, err := totp.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, secret, opened)
+
+	other, err := NewTOTP(TOTPConfig{Key: []byte("another secret")})
+	require.NoError(t, err)
+	_, err = other.Open(sealed)
+	assert.ErrorIs(t, err, ErrInvalidTOTPSecret)
+
+	u, err := url.Parse(totp.URI("john doe", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/ibg:john doe", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "ibg", u.Query().Get("issuer"))
+
+	_, err = NewTOTP(TOTPConfig{})
+	assert.ErrorIs(t, err, ErrNoMFAEncryptionKey)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, code)
+		assert.False(t, seen[code])
+		seen[code] = true
+		assert.Equal(t, hashes[i], HashRecoveryCode(code))
+	}
+	assert.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(" "+strings.ToUpper(strings.Replace(codes[0], "-", "", 1))))
+}
@@ -1,43 +1,348 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/events"
+	"github.com/ellis90/assessment-bg/graph"
+	"github.com/ellis90/assessment-bg/mailer"
+	"github.com/ellis90/assessment-bg/openapi"
 	"github.com/ellis90/assessment-bg/router"
+	"github.com/ellis90/assessment-bg/rpc"
 	"github.com/ellis90/assessment-bg/service"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
 	"github.com/joho/godotenv"
-	log "github.com/sirupsen/logrus"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long the servers wait for the running requests
+const shutdownTimeout = 30 * time.Second
+
+var log *slog.Logger
+
 // init gets called before the main function
 func init() {
-	if err := godotenv.Load(); err != nil {
+	envErr := godotenv.Load()
+	var err error
+	if log, err = custom_slog.StructuredLog(); err != nil {
+		log.Warn("falling back to masking personal data in logs", slog.Any("error", err))
+	}
+	slog.SetDefault(log)
+	if envErr != nil {
 		log.Error("No .env file found create")
 		os.Exit(1)
 	}
 }
 
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	log.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
 func main() {
+	// the workers and servers stop on SIGINT or SIGTERM, the store is
+	// closed once all of them did
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	src := datastore.SourceFromEnv()
+	log.Info("connecting to database", slog.String("dsn", src))
+	store, err := datastore.NewStore(log, src)
+	if err != nil {
+		fatal("failed to connect to database", err)
+	}
+
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		fatal("failed to configure mailer", err)
+	}
+	accountMail := service.NewAccountMailer(store, mail, "", log)
 
-	host := os.Getenv("HOST")
-	dbUSER := os.Getenv("DB_USERNAME")
-	pass := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbPort := os.Getenv("DB_PORT")
+	groups, err := service.NewGroupServices(
+		service.WithGroupLogger(log),
+		service.WithGroupRepository(store, nil),
+		service.WithGroupUserRepository(store, nil),
+	)
+	if err != nil {
+		fatal("failed to create group service", err)
+	}
 
-	src := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUSER, pass, host, dbPort, dbName)
-	log.Println(src)
+	// user changes made through any api are streamed to the grpc watchers
+	// and recompute the dynamic members of groups
+	userEvents := rpc.NewUserEvents()
+	users := groups.Repository(userEvents.Repository(store))
 
 	cs, err := service.NewCustomerServices(
-		service.WithPGXConfiguration(log.New(), src),
+		service.WithLogger(log),
+		service.WithCustomerRepository(users, nil),
+		service.WithScheduledChangeRepository(store, nil),
+		service.WithManagerRepository(store, nil),
+		service.WithAccountMailer(accountMail),
+	)
+	if err != nil {
+		fatal("failed to create service", err)
+	}
+
+	scheduler, err := service.NewChangeScheduler(
+		service.WithSchedulerLogger(log),
+		service.WithSchedulerRepository(store, nil),
+		service.WithSchedulerIntervalFromEnv(),
+		service.WithAppliedListener(func(ctx context.Context, u entity.User) {
+			userEvents.Publish(rpc.UserEvent{Type: rpc.UserUpdated, User: u})
+			if err := groups.RefreshUser(ctx, u); err != nil {
+				log.ErrorCtx(ctx, "failed to recompute the groups of a user", slog.Any("error", err))
+			}
+		}),
+	)
+	if err != nil {
+		fatal("failed to create change scheduler", err)
+	}
+
+	// EVENT_SINK selects where the events of users are published, see
+	// events.FromEnv
+	sink, err := events.FromEnv()
+	if err != nil {
+		fatal("failed to create event sink", err)
+	}
+	// the secrets of the webhooks are sealed with a key derived from SESSION_SECRET
+	webhookSealer, sealerErr := auth.SealerFromEnv(auth.PurposeWebhookSecrets)
+	webhooks, err := service.NewWebhookServices(
+		service.WithWebhookLogger(log),
+		service.WithWebhookRepository(store, nil),
+		service.WithWebhookSealer(webhookSealer, sealerErr),
+	)
+	if err != nil {
+		fatal("failed to create webhook service", err)
+	}
+	if err := webhooks.SealSecrets(ctx); err != nil {
+		fatal("failed to seal webhook secrets", err)
+	}
+	// the webhooks get the events the relay publishes, they are queued
+	// there for the dispatcher
+	relay, err := service.NewOutboxRelay(
+		service.WithRelayLogger(log),
+		service.WithRelayRepository(store, nil),
+		service.WithRelaySink(events.MultiSink{sink, webhooks}, nil),
+		service.WithRelayIntervalFromEnv(),
+	)
+	if err != nil {
+		fatal("failed to create outbox relay", err)
+	}
+	dispatcher, err := service.NewWebhookDispatcher(
+		service.WithDispatcherLogger(log),
+		service.WithDispatcherRepository(store, nil),
+		service.WithDispatcherSealer(webhookSealer, sealerErr),
+		service.WithDispatcherFromEnv(),
+	)
+	if err != nil {
+		fatal("failed to create webhook dispatcher", err)
+	}
+
+	ss, err := service.NewSCIMServices(
+		service.WithSCIMLogger(log),
+		service.WithSCIMRepository(users, nil),
+		service.WithSCIMAccountMailer(accountMail),
+	)
+	if err != nil {
+		fatal("failed to create scim service", err)
+	}
+
+	resolver, err := graph.NewResolver(
+		graph.WithLogger(log),
+		graph.WithUserRepository(users, nil),
+		graph.WithAccountMailer(accountMail),
+	)
+	if err != nil {
+		fatal("failed to create graphql resolver", err)
+	}
+	complexityLimit, err := graph.ComplexityLimitFromEnv()
+	if err != nil {
+		fatal("failed to configure graphql", err)
+	}
+
+	ds, err := service.NewDepartmentServices(
+		service.WithDepartmentLogger(log),
+		service.WithDepartmentRepository(store, nil),
+		service.WithDepartmentsChangedListener(func(ctx context.Context) {
+			if err := groups.RefreshAll(ctx); err != nil {
+				log.ErrorCtx(ctx, "failed to recompute the members of groups", slog.Any("error", err))
+			}
+		}),
+	)
+	if err != nil {
+		fatal("failed to create department service", err)
+	}
+
+	rs, err := service.NewRoleServices(
+		service.WithRoleLogger(log),
+		service.WithRoleRepository(store, nil),
+	)
+	if err != nil {
+		fatal("failed to create role service", err)
+	}
+
+	ks, err := service.NewAPIKeyServices(
+		service.WithAPIKeyLogger(log),
+		service.WithAPIKeyRepository(store, nil),
+	)
+	if err != nil {
+		fatal("failed to create api key service", err)
+	}
+
+	sessions, err := auth.NewSessionManager(auth.SessionConfigFromEnv(), store)
+	if err != nil {
+		fatal("failed to configure sessions", err)
+	}
+
+	totp, err := auth.NewTOTP(auth.TOTPConfigFromEnv())
+	if err != nil {
+		fatal("failed to configure mfa", err)
+	}
+
+	as, err := service.NewAuthServices(
+		service.WithAuthLogger(log),
+		service.WithAuthRepository(store, store, nil),
+		service.WithSessionManager(sessions),
+		service.WithAccountFlows(users, store, accountMail),
+		service.WithMFA(store, totp),
+		service.WithLoginLimiter(auth.NewLoginLimiter(auth.LockoutConfigFromEnv(), store), store),
 	)
 	if err != nil {
-		log.Fatal("failed to create service")
+		fatal("failed to create auth service", err)
+	}
+
+	// RBAC_BOOTSTRAP_ADMIN grants the admin role to a first subject so role
+	// bindings can be managed through the api
+	if subject := os.Getenv("RBAC_BOOTSTRAP_ADMIN"); subject != "" {
+		_, err := store.BindRole(ctx, entity.RoleBinding{
+			Subject:   subject,
+			Role:      entity.RoleAdmin,
+			CreatedBy: "bootstrap",
+		})
+		if err != nil {
+			fatal("failed to bootstrap admin", err)
+		}
 	}
 
-	e := router.Router(cs)
-	if err := e.Start(":9090"); err != nil {
-		log.Fatal("failed to start up server")
+	jwtAuth, err := auth.NewJWTAuthenticator(auth.JWTConfigFromEnv())
+	if err != nil {
+		fatal("failed to configure jwt authentication", err)
+	}
+
+	us, err := rpc.NewUserServer(
+		rpc.WithLogger(log),
+		rpc.WithUserRepository(users, nil),
+		rpc.WithAccountMailer(accountMail),
+		rpc.WithUserEvents(userEvents),
+	)
+	if err != nil {
+		fatal("failed to create grpc user service", err)
+	}
+
+	apiKeys := auth.NewAPIKeyAuthenticator(store, log)
+	authorizer := auth.NewAuthorizer(store)
+
+	lis, err := net.Listen("tcp", rpc.AddrFromEnv())
+	if err != nil {
+		fatal("failed to listen for grpc", err)
+	}
+	gs := rpc.NewServer(us, rpc.Config{
+		Logger:     log,
+		APIKeys:    apiKeys,
+		JWT:        jwtAuth,
+		Authorizer: authorizer,
+	})
+
+	// OPENAPI_VALIDATE_REQUESTS and OPENAPI_VALIDATE_RESPONSES check the
+	// traffic against the document served at /openapi.json
+	var validate echo.MiddlewareFunc
+	if validation := openapi.ValidationConfigFromEnv(); validation.Enabled() {
+		validation.Logger = log
+		if validate, err = openapi.NewValidator(validation); err != nil {
+			fatal("failed to configure openapi validation", err)
+		}
+	}
+
+	e := router.Router(router.Services{
+		Customer:   cs,
+		Department: ds,
+		Group:      groups,
+		Role:       rs,
+		APIKey:     ks,
+		Webhook:    webhooks,
+		Auth:       as,
+		SCIM:       ss,
+		GraphQL:    graph.NewServer(resolver, complexityLimit),
+	}, router.Config{
+		Logger:       log,
+		Authenticate: auth.Middleware(apiKeys, sessions, jwtAuth),
+		Authorize:    authorizer.Middleware(),
+		Validate:     validate,
+		// API_V1_DEPRECATED_AT and API_V1_SUNSET date the retirement of /v1
+		V1Deprecation: router.V1DeprecationFromEnv(),
+	})
+
+	var (
+		wg     sync.WaitGroup
+		failed = make(chan struct{}, 1)
+	)
+	// run runs f until ctx is done, f failing stops everything else
+	run := func(msg string, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(); err != nil {
+				log.Error(msg, slog.Any("error", err))
+				select {
+				case failed <- struct{}{}:
+				default:
+				}
+				stop()
+			}
+		}()
+	}
+	for _, worker := range []interface{ Run(context.Context) }{scheduler, relay, dispatcher} {
+		wg.Add(1)
+		go func(worker interface{ Run(context.Context) }) {
+			defer wg.Done()
+			worker.Run(ctx)
+		}(worker)
+	}
+	run("failed to start up grpc server", func() error { return gs.Serve(lis) })
+	run("failed to start up server", func() error {
+		if err := e.Start(":9090"); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+
+	<-ctx.Done()
+	log.Info("shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdown); err != nil {
+		log.Error("failed to shut down server", slog.Any("error", err))
+	}
+	rpc.Shutdown(shutdown, gs)
+	wg.Wait()
+	if err := store.Close(); err != nil {
+		log.Error("failed to close database", slog.Any("error", err))
+	}
+	select {
+	case <-failed:
+		os.Exit(1)
+	default:
 	}
 }
@@ -1,6 +1,7 @@
 #!/usr/bin/env /bin/sh
 
 SECRET=$(openssl rand -base64 32)
+PII_HASH_KEY=$(openssl rand -base64 32)
 
 echo  SESSION_SECRET="${SECRET}" > .env
 {
@@ -12,6 +13,51 @@ echo  SESSION_SECRET="${SECRET}" > .env
   echo  HOST="integra_db"
 
   echo  DB_PORT="5432"
+  # JWT_ISSUER and JWT_AUDIENCE must match the iss and aud claims of bearer tokens
+  echo  JWT_ISSUER="integra"
+  echo  JWT_AUDIENCE="integra-api"
+  # JWKS_FILE optionally points to the public keys verifying RS256/ES256 tokens
+  echo  JWKS_FILE=""
+  # SESSION_TTL is the lifetime of login sessions
+  echo  SESSION_TTL="12h"
+  # MFA_ISSUER is the account label shown by authenticator apps
+  echo  MFA_ISSUER="integra"
+  # failed logins lock an account after LOGIN_MAX_FAILURES and a client ip after
+  # LOGIN_IP_MAX_FAILURES within LOGIN_FAILURE_WINDOW, for LOGIN_LOCKOUT_BASE
+  # doubling with every further failure up to LOGIN_LOCKOUT_MAX
+  echo  LOGIN_MAX_FAILURES="5"
+  echo  LOGIN_IP_MAX_FAILURES="50"
+  echo  LOGIN_FAILURE_WINDOW="15m"
+  echo  LOGIN_LOCKOUT_BASE="1m"
+  echo  LOGIN_LOCKOUT_MAX="1h"
+  # GRPC_PORT is the port of the grpc user service
+  echo  GRPC_PORT="9091"
+  # GRAPHQL_COMPLEXITY_LIMIT rejects graphql operations costing more, every
+  # field costs one and lists of users multiply by their page size
+  echo  GRAPHQL_COMPLEXITY_LIMIT="1000"
+  # OPENAPI_VALIDATE_REQUESTS rejects requests not matching /openapi.json,
+  # OPENAPI_VALIDATE_RESPONSES logs the responses not matching it
+  echo  OPENAPI_VALIDATE_REQUESTS="false"
+  echo  OPENAPI_VALIDATE_RESPONSES="false"
+  # API_V1_DEPRECATED_AT and API_V1_SUNSET are the RFC 3339 times announced
+  # in the Deprecation and Sunset headers of /v1 and the unversioned routes
+  echo  API_V1_DEPRECATED_AT=""
+  echo  API_V1_SUNSET=""
+  # SCHEDULED_CHANGES_INTERVAL is how often the scheduled user changes due are applied
+  echo  SCHEDULED_CHANGES_INTERVAL="1m"
+  # APP_BASE_URL prefixes the links of verification and password reset emails
+  echo  APP_BASE_URL="http://localhost:9191"
+  # MAILER is smtp, file or memory, smtp uses SMTP_HOST, SMTP_PORT,
+  # SMTP_USERNAME and SMTP_PASSWORD, file writes .eml files to MAIL_DIR
+  echo  MAILER="file"
+  echo  MAIL_DIR="mail"
+  echo  MAIL_FROM="no-reply@integra.local"
+  # RBAC_BOOTSTRAP_ADMIN is the jwt subject granted the admin role at start up
+  echo  RBAC_BOOTSTRAP_ADMIN=""
+  # LOG_PII_MODE is how personal data is logged: mask, hash or plain, the
+  # hash mode keys its HMAC with LOG_PII_HASH_KEY
+  echo  LOG_PII_MODE="mask"
+  echo  LOG_PII_HASH_KEY="${PII_HASH_KEY}"
 } >> .env
 
 echo "${SECRET}"
\ No newline at end of file
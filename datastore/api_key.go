@@ -0,0 +1,145 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"strings"
+	"time"
+)
+
+const apiKeyColumns = "id, name, prefix, key_hash, scopes, expires_at, created_by, created_at, last_used_at, revoked_at"
+
+// CreateAPIKey stores a new key, key.Hash must already be set
+func (s *Store) CreateAPIKey(ctx context.Context, key entity.APIKey) (entity.APIKey, error) {
+	row := s.SQLBuilder.Insert(apiKeysSchema).SetMap(map[string]any{
+		"name":       key.Name,
+		"prefix":     key.Prefix,
+		"key_hash":   key.Hash,
+		"scopes":     joinScopes(key.Scopes),
+		"expires_at": key.ExpiresAt,
+		"created_by": key.CreatedBy,
+	}).Suffix(`RETURNING "id", "created_at"`).QueryRowContext(ctx)
+	if err := row.Scan(&key.ID, &key.CreatedAt); err != nil {
+		return entity.APIKey{}, fmt.Errorf(errorMsg, ErrCreateAPIKey, err)
+	}
+	s.Logger.InfoCtx(ctx, "api key created", slog.String("api_key_id", key.ID), slog.String("prefix", key.Prefix))
+	return key, nil
+}
+
+func (s *Store) APIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	rows, err := s.SQLBuilder.Select(apiKeyColumns).From(apiKeysSchema).OrderBy("id").QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchAPIKey, err)
+	}
+	defer s.closeRows(ctx, rows)
+
+	var keys []entity.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchAPIKey, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// APIKeyByPrefix returns the key identified by the public prefix of its plaintext
+func (s *Store) APIKeyByPrefix(ctx context.Context, prefix string) (entity.APIKey, error) {
+	row := s.SQLBuilder.Select(apiKeyColumns).From(apiKeysSchema).
+		Where(squirrel.Eq{"prefix": prefix}).QueryRowContext(ctx)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.APIKey{}, ErrAPIKeyNotFound
+		}
+		return entity.APIKey{}, fmt.Errorf(errorMsg, ErrFetchAPIKey, err)
+	}
+	return key, nil
+}
+
+// RotateAPIKey replaces the secret of an active key keeping its scopes and expiry
+func (s *Store) RotateAPIKey(ctx context.Context, id, prefix, hash string) (entity.APIKey, error) {
+	row := s.SQLBuilder.Update(apiKeysSchema).SetMap(map[string]any{
+		"prefix":       prefix,
+		"key_hash":     hash,
+		"last_used_at": nil,
+	}).Where(squirrel.Eq{"id": id, "revoked_at": nil}).
+		Suffix("RETURNING " + apiKeyColumns).QueryRowContext(ctx)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.APIKey{}, ErrAPIKeyNotFound
+		}
+		return entity.APIKey{}, fmt.Errorf(errorMsg, ErrUpdateAPIKey, err)
+	}
+	s.Logger.InfoCtx(ctx, "api key rotated", slog.String("api_key_id", id), slog.String("prefix", prefix))
+	return key, nil
+}
+
+// RevokeAPIKey disables a key for good
+func (s *Store) RevokeAPIKey(ctx context.Context, id string) error {
+	res, err := s.SQLBuilder.Update(apiKeysSchema).
+		Set("revoked_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": id, "revoked_at": nil}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateAPIKey, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	s.Logger.InfoCtx(ctx, "api key revoked", slog.String("api_key_id", id))
+	return nil
+}
+
+// TouchAPIKey records the last time a key authenticated a request
+func (s *Store) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	_, err := s.SQLBuilder.Update(apiKeysSchema).
+		Set("last_used_at", at).
+		Where(squirrel.Eq{"id": id}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateAPIKey, err)
+	}
+	return nil
+}
+
+func scanAPIKey(row squirrel.RowScanner) (entity.APIKey, error) {
+	var (
+		key    entity.APIKey
+		scopes string
+	)
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		&scopes,
+		&key.ExpiresAt,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	for _, scope := range strings.Fields(scopes) {
+		key.Scopes = append(key.Scopes, entity.Permission(scope))
+	}
+	return key, nil
+}
+
+func joinScopes(scopes []entity.Permission) string {
+	out := make([]string, len(scopes))
+	for i, scope := range scopes {
+		out[i] = string(scope)
+	}
+	return strings.Join(out, " ")
+}
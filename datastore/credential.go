@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/jackc/pgconn"
+	"golang.org/x/exp/slog"
+)
+
+// SetPassword stores the password hash of a user, replacing the previous one
+func (s *Store) SetPassword(ctx context.Context, userID, hash string) error {
+	_, err := s.SQLBuilder.Insert(credentialsSchema).SetMap(map[string]any{
+		"user_id":       userID,
+		"password_hash": hash,
+	}).Suffix(`ON CONFLICT ("user_id") DO UPDATE SET "password_hash" = EXCLUDED."password_hash", "updated_at" = now()`).
+		ExecContext(ctx)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			return ErrCustomerNotFound
+		}
+		return fmt.Errorf(errorMsg, ErrSetPassword, err)
+	}
+	s.Logger.InfoCtx(ctx, "password set", slog.String("user_id", userID))
+	return nil
+}
+
+// PasswordHash returns the password hash of a user, ErrCredentialsNotFound
+// when none was set
+func (s *Store) PasswordHash(ctx context.Context, userID string) (string, error) {
+	var hash string
+	err := s.SQLBuilder.Select("password_hash").
+		From(credentialsSchema).
+		Where(squirrel.Eq{"user_id": userID}).
+		QueryRowContext(ctx).
+		Scan(&hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrCredentialsNotFound
+		}
+		return "", fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	return hash, nil
+}
+
+// CredentialsByUserName returns the user signing in as userName with its password hash
+func (s *Store) CredentialsByUserName(ctx context.Context, userName string) (entity.User, string, error) {
+	var (
+		user entity.User
+		hash string
+	)
+	err := s.SQLBuilder.Select("u.id, u.user_name, u.first_name, u.last_name, u.email, u.department, u.user_status, c.password_hash").
+		From(usersView+" u").
+		Join(credentialsSchema+" c ON c.user_id = u.id").
+		Where(squirrel.Eq{"u.user_name": userName}).
+		QueryRowContext(ctx).
+		Scan(
+			&user.ID,
+			&user.UserName,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			&user.Department,
+			(*statusWrapper)(&user.UserStatus),
+			&hash,
+		)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.User{}, "", ErrCredentialsNotFound
+		}
+		return entity.User{}, "", fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	return user, hash, nil
+}
@@ -1,26 +1,38 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Masterminds/squirrel"
 	"github.com/ellis90/assessment-bg/datastore/model"
 	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/log/logrusadapter"
 	"github.com/jackc/pgx/v4/stdlib"
-	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slog"
+	"os"
+	"strconv"
 )
 
 type Store struct {
-	Logger     *logrus.Logger
+	Logger     *slog.Logger
 	DB         *sql.DB
 	SQLBuilder squirrel.StatementBuilderType
 }
 
+// SourceFromEnv is the url of the database HOST, DB_PORT and DB_NAME that
+// DB_USERNAME signs in to with DB_PASSWORD
+func SourceFromEnv() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		os.Getenv("DB_USERNAME"), os.Getenv("DB_PASSWORD"), os.Getenv("HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"))
+}
+
 // NewStore is a factory function that open a connection to db
-func NewStore(logger *logrus.Logger, src string) (*Store, error) {
+func NewStore(logger *slog.Logger, src string) (*Store, error) {
 	var (
 		err  error
 		conn *pgx.ConnConfig
@@ -29,7 +41,7 @@ func NewStore(logger *logrus.Logger, src string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn.Logger = logrusadapter.NewLogger(logger)
+	conn.Logger = custom_slog.NewLogger(logger)
 	db := stdlib.OpenDB(*conn)
 	err = validateSchema(db)
 	if err != nil {
@@ -43,92 +55,291 @@ func NewStore(logger *logrus.Logger, src string) (*Store, error) {
 	}, err
 }
 
-//Close connection
+// Close connection
 func (s *Store) Close() error {
 	return s.DB.Close()
 }
 
 // Queries to communicate with the DB
 
-// Create add new entity to the db
-func (s *Store) Create(cus model.Customer) (model.Customer, error) {
+// Create add new entity to the db, users are created with an initial status
+func (s *Store) Create(ctx context.Context, cus model.Customer) (model.Customer, error) {
+	if !cus.GetUserStatus().Initial() {
+		return model.Customer{}, entity.ErrInitialStatus
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrFailedToCreateCustomer, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	departmentID, department, err := s.departmentID(ctx, tx, cus.GetDepartment())
+	if err != nil {
+		return model.Customer{}, err
+	}
+	attributes, err := attributesValue(cus.GetAttributes())
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrFailedToCreateCustomer, err)
+	}
 	row := s.SQLBuilder.Insert(usersSchema).SetMap(map[string]any{
-		"user_name":   cus.GetUserName(),
-		"first_name":  cus.GetFirstName(),
-		"last_name":   cus.GetLastName(),
-		"email":       cus.GetEmail(),
-		"department":  cus.GetDepartment(),
-		"user_status": cus.GetUserStatus(),
-	}).Suffix(`RETURNING "id"`).QueryRow()
+		"user_name":     cus.GetUserName(),
+		"first_name":    cus.GetFirstName(),
+		"last_name":     cus.GetLastName(),
+		"email":         cus.GetEmail(),
+		"department_id": departmentID,
+		"user_status":   statusWrapper(cus.GetUserStatus()),
+		"attributes":    attributes,
+	}).Suffix(`RETURNING "id"`).RunWith(tx).QueryRowContext(ctx)
 
 	var Id string
 	if err := row.Scan(&Id); err != nil {
+		if isUniqueViolation(err) {
+			return model.Customer{}, fmt.Errorf(errorMsg, ErrCustomerExists, err)
+		}
+		if isForeignKeyViolation(err) {
+			return model.Customer{}, fmt.Errorf(errorMsg, ErrDepartmentNotFound, err)
+		}
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrFailedToCreateCustomer, err)
+	}
+	if err := s.recordEvents(ctx, tx, entity.EventUserCreated, nil, Id); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrFailedToCreateCustomer, err)
+	}
+	if err := tx.Commit(); err != nil {
 		return model.Customer{}, fmt.Errorf(errorMsg, ErrFailedToCreateCustomer, err)
 	}
 	cus.SetID(Id)
-	s.Logger.Info("customer created successfully")
+	cus.SetDepartment(department)
+	cus.SetManagerID(nil)
+	s.Logger.InfoCtx(ctx, "customer created successfully", slog.String("id", Id))
 	return cus, nil
 }
 
-func (s *Store) Update(cus model.Customer) (model.Customer, error) {
-	_, err := s.SQLBuilder.Update(
+// Update replaces a user keeping its status, ChangeStatus changes it with
+// a reason and entity.ErrStatusNeedsReason is returned for any other status
+func (s *Store) Update(ctx context.Context, cus model.Customer) (model.Customer, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	current, err := s.lockUser(ctx, tx, cus.GetID())
+	if err != nil {
+		return model.Customer{}, err
+	}
+	if current.GetUserStatus() != cus.GetUserStatus() {
+		return model.Customer{}, entity.ErrStatusNeedsReason
+	}
+	departmentID, department, err := s.departmentID(ctx, tx, cus.GetDepartment())
+	if err != nil {
+		return model.Customer{}, err
+	}
+	changes := map[string]interface{}{
+		"user_name":     cus.GetUserName(),
+		"first_name":    cus.GetFirstName(),
+		"last_name":     cus.GetLastName(),
+		"email":         cus.GetEmail(),
+		"department_id": departmentID,
+		"user_status":   statusWrapper(cus.GetUserStatus()),
+		// a new address has to be verified again
+		"email_verified": squirrel.Expr("email_verified AND email = ?", cus.GetEmail()),
+	}
+	// users replaced without attributes keep theirs, an empty set clears them
+	if cus.GetAttributes() == nil {
+		cus.SetAttributes(current.GetAttributes())
+	} else if changes["attributes"], err = attributesValue(cus.GetAttributes()); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	_, err = s.SQLBuilder.Update(
 		usersSchema,
 	).SetMap(
-		map[string]interface{}{
-			"user_name":   cus.GetUserName(),
-			"first_name":  cus.GetFirstName(),
-			"last_name":   cus.GetLastName(),
-			"email":       cus.GetEmail(),
-			"department":  cus.GetDepartment(),
-			"user_status": cus.GetUserStatus(),
-		},
+		changes,
 	).Where(
 		squirrel.Eq{"id": cus.GetID()},
-	).Exec()
+	).RunWith(tx).ExecContext(ctx)
 	if err != nil {
-		return model.Customer{}, fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
+		if isUniqueViolation(err) {
+			return model.Customer{}, fmt.Errorf(errorMsg, ErrCustomerExists, err)
+		}
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
 	}
+	if err := s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, cus.GetID()); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	cus.SetDepartment(department)
+	cus.SetManagerID(current.GetManagerID())
 	return cus, nil
 }
 
-func (s *Store) Get() (model.Customers, error) {
+func (s *Store) Get(ctx context.Context) (model.Customers, error) {
 	var customers model.Customers
-	rows, err := s.SQLBuilder.Select("id, user_name, first_name, last_name, email, department, user_status").From(usersSchema).Query()
+	rows, err := s.SQLBuilder.Select(userColumns).From(usersView).OrderBy("id").QueryContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
 	}
 
-	defer func() {
-		if err := rows.Close(); err != nil {
-			s.Logger.Error(err.Error())
-		}
-	}()
-	s.Logger.Info("done fetching users")
+	defer s.closeRows(ctx, rows)
 	for rows.Next() {
-		s.Logger.Info("done next row")
 		sr, err := scanUserRows(rows)
 		if err != nil {
 			return nil, err
 		}
+		s.Logger.DebugCtx(ctx, "scanned user row", slog.Any("user", sr.GetExportedCustomer().User))
 		customers = append(customers, sr)
 	}
-	s.Logger.Info(customers)
+	s.Logger.InfoCtx(ctx, "done fetching users", slog.Int("count", len(customers)))
 	return customers, nil
 }
 
-func (s *Store) Delete(id string) error {
-	_, err := s.SQLBuilder.Delete(
+// GetByID returns the user with the given id
+func (s *Store) GetByID(ctx context.Context, id string) (model.Customer, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		// ids are bigserial, anything else cannot exist
+		return model.Customer{}, ErrCustomerNotFound
+	}
+	row := s.SQLBuilder.Select(userColumns).From(usersView).Where(squirrel.Eq{"id": id}).QueryRowContext(ctx)
+	return s.getOne(row)
+}
+
+// GetByIDs returns the users with the given ids ordered by id, ids without
+// a user are skipped
+func (s *Store) GetByIDs(ctx context.Context, ids []string) (model.Customers, error) {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := strconv.ParseInt(id, 10, 64); err == nil {
+			valid = append(valid, id)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, nil
+	}
+	rows, err := s.SQLBuilder.Select(userColumns).From(usersView).
+		Where(squirrel.Eq{"id": valid}).OrderBy("id").QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	defer s.closeRows(ctx, rows)
+	var customers model.Customers
+	for rows.Next() {
+		cus, err := scanUserRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+		}
+		customers = append(customers, cus)
+	}
+	return customers, rows.Err()
+}
+
+// GetByEmail returns the user owning the given email address
+func (s *Store) GetByEmail(ctx context.Context, email string) (model.Customer, error) {
+	row := s.SQLBuilder.Select(userColumns).From(usersView).Where(squirrel.Eq{"email": email}).QueryRowContext(ctx)
+	return s.getOne(row)
+}
+
+func (s *Store) getOne(row squirrel.RowScanner) (model.Customer, error) {
+	cus, err := scanUserRows(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Customer{}, ErrCustomerNotFound
+		}
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	return cus, nil
+}
+
+// MarkEmailVerified verifies the address of a user as long as it is still email
+func (s *Store) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := s.SQLBuilder.Update(usersSchema).
+		Set("email_verified", true).
+		Where(squirrel.Eq{"id": userID, "email": email}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrCustomerNotFound
+	}
+	if err := s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, userID); err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateCustomer, err)
+	}
+	return nil
+}
+
+// Delete deletes a user, its reports are handed to its own manager
+func (s *Store) Delete(ctx context.Context, id string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := lockChart(ctx, tx); err != nil {
+		return fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
+	}
+	if err := s.reassignReports(ctx, tx, id); err != nil {
+		return fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
+	}
+	// the event carries the user as it was before the delete
+	if err := s.recordEvents(ctx, tx, entity.EventUserDeleted, nil, id); err != nil {
+		return fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
+	}
+	_, err = s.SQLBuilder.Delete(
 		usersSchema,
-	).Where(squirrel.Eq{"id": id}).Exec()
+	).Where(squirrel.Eq{"id": id}).RunWith(tx).ExecContext(ctx)
 	if err != nil {
 		return fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrDeleteCustomer, err)
+	}
 	return nil
 }
 
-func scanUserRows(row squirrel.RowScanner) (model.Customer, error) {
-	as := new(entity.User)
-	err := row.Scan(
+// attributesValue stores the attributes of a user as a json object
+func attributesValue(attributes map[string]string) (string, error) {
+	if attributes == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(attributes)
+	return string(b), err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation
+}
+
+func (s *Store) closeRows(ctx context.Context, rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		s.Logger.ErrorCtx(ctx, "failed to close rows", slog.Any("error", err))
+	}
+}
+
+// scanUserRows scans the userColumns followed by extra
+func scanUserRows(row squirrel.RowScanner, extra ...any) (model.Customer, error) {
+	var (
+		as         = new(entity.User)
+		manager    sql.NullString
+		attributes []byte
+	)
+	err := row.Scan(append([]any{
 		&as.ID,
 		&as.UserName,
 		&as.FirstName,
@@ -136,13 +347,24 @@ func scanUserRows(row squirrel.RowScanner) (model.Customer, error) {
 		&as.Email,
 		&as.Department,
 		(*statusWrapper)(&as.UserStatus),
-	)
-	logrus.Info(as, "userpage")
+		&as.EmailVerified,
+		&manager,
+		&attributes,
+	}, extra...)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return model.Customer{}, err
 		}
 		return model.Customer{}, err
 	}
+	if manager.Valid {
+		as.ManagerID = &manager.String
+	}
+	if err := json.Unmarshal(attributes, &as.Attributes); err != nil {
+		return model.Customer{}, err
+	}
+	if len(as.Attributes) == 0 {
+		as.Attributes = nil
+	}
 	return model.AddCustomer(as), nil
 }
@@ -0,0 +1,151 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	log   *slog.Logger
+	store *Store
+	// link connects to the database store migrated
+	link string
+)
+
+// fatal logs err and exits, the container is left to expire
+func fatal(msg string, err error) {
+	log.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// TestMain runs the tests of the queries against postgres, every test
+// creates the rows it reads so they share the database
+func TestMain(m *testing.M) {
+	code := 0
+	defer func() {
+		os.Exit(code)
+	}()
+
+	log = slog.New(slog.NewTextHandler(os.Stderr))
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		fatal("Could not connect to docker", err)
+	}
+
+	err = pool.Client.Ping()
+	if err != nil {
+		fatal("Could not connect to Docker", err)
+	}
+
+	src := map[string]string{
+		"user":     "postgres",
+		"password": "password",
+		"db":       "datastore_test",
+	}
+
+	runOpts := dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "12-alpine",
+		Env: []string{
+			"POSTGRES_USER=" + src["user"],
+			"POSTGRES_PASSWORD=" + src["password"],
+			"POSTGRES_DB=" + src["db"],
+		},
+	}
+	resource, err := pool.RunWithOptions(&runOpts,
+		func(config *docker.HostConfig) {
+			// set AutoRemove to true so that stopped container goes away by itself
+			config.AutoRemove = true
+			config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+		})
+
+	if err != nil {
+		fatal("could not start postgres container", err)
+	}
+
+	defer func() {
+		err = pool.Purge(resource)
+		if err != nil {
+			log.Error("Could not purge resource", slog.Any("error", err))
+		}
+	}()
+
+	// Tell docker to hard kill the container in 120 seconds
+	if err := resource.Expire(120); err != nil {
+		log.Error("Could not expire resource", slog.Any("error", err))
+	}
+
+	pool.MaxWait = 120 * time.Second
+	link = fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", src["user"], src["password"], resource.GetHostPort("5432/tcp"), src["db"])
+	if err = pool.Retry(func() error {
+		db, err := sql.Open("pgx", link)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	}); err != nil {
+		fatal("Could not connect to postgres server", err)
+	}
+
+	store, err = NewStore(log, link)
+	if err != nil {
+		fatal("could not migrate postgres container", err)
+	}
+	defer store.Close()
+
+	code = m.Run()
+}
+
+// newDatabase creates an empty database named name next to the database of
+// store and returns its link
+func newDatabase(t *testing.T, name string) string {
+	t.Helper()
+	_, err := store.DB.Exec(`CREATE DATABASE "` + name + `"`)
+	require.NoError(t, err)
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	u.Path = "/" + name
+	return u.String()
+}
+
+// newDepartment creates a department named name below parent, a root
+// department when parent is empty
+func newDepartment(t *testing.T, name, parent string) entity.Department {
+	t.Helper()
+	department := entity.Department{Name: name}
+	if parent != "" {
+		department.ParentID = &parent
+	}
+	department, err := store.CreateDepartment(context.Background(), department)
+	require.NoError(t, err)
+	return department
+}
+
+// newUser creates an active user of the department named department, its
+// name is unique across the tests
+func newUser(t *testing.T, name, department string) entity.User {
+	t.Helper()
+	cus, err := store.Create(context.Background(), model.AddCustomer(&entity.User{
+		UserName:   name,
+		FirstName:  name,
+		LastName:   "test",
+		Email:      name + "@example.com",
+		Department: department,
+		UserStatus: entity.Active,
+	}))
+	require.NoError(t, err)
+	return cus.GetExportedCustomer().User
+}
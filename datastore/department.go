@@ -0,0 +1,420 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"strconv"
+	"strings"
+)
+
+const (
+	departmentColumns = "d.id, d.name, d.parent_id, d.created_at, r.members, r.headcount"
+
+	// departmentRollup pairs every department with itself and each department
+	// below it in closure, and counts their users in rollup. The tree is
+	// walked from every root, which is cheap for the size of an organisation
+	departmentRollup = `WITH RECURSIVE closure (ancestor_id, id, depth) AS (
+		SELECT id, id, 0 FROM departments
+		UNION ALL
+		SELECT c.ancestor_id, d.id, c.depth + 1 FROM departments d JOIN closure c ON d.parent_id = c.id
+	), rollup AS (
+		SELECT c.ancestor_id AS id,
+		       count(u.id) FILTER (WHERE c.depth = 0) AS members,
+		       count(u.id) AS headcount
+		FROM closure c LEFT JOIN users u ON u.department_id = c.id
+		GROUP BY c.ancestor_id
+	)`
+
+	// departmentSubtree lists the ids of a department and every department below it
+	departmentSubtree = `WITH RECURSIVE subtree (id) AS (
+		SELECT id FROM departments WHERE id = ?
+		UNION ALL
+		SELECT d.id FROM departments d JOIN subtree s ON d.parent_id = s.id
+	)`
+)
+
+// CreateDepartment creates a department, below its parent if it has one
+func (s *Store) CreateDepartment(ctx context.Context, department entity.Department) (entity.Department, error) {
+	var parentID any
+	if department.ParentID != nil {
+		if _, err := strconv.ParseInt(*department.ParentID, 10, 64); err != nil {
+			return entity.Department{}, ErrParentNotFound
+		}
+		parentID = *department.ParentID
+	}
+	var id string
+	err := s.SQLBuilder.Insert(departmentsSchema).
+		Columns("name", "parent_id").
+		Values(strings.TrimSpace(department.Name), parentID).
+		Suffix(`RETURNING "id"`).
+		QueryRowContext(ctx).
+		Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return entity.Department{}, ErrDepartmentExists
+		}
+		if isForeignKeyViolation(err) {
+			return entity.Department{}, ErrParentNotFound
+		}
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	return s.DepartmentByID(ctx, id)
+}
+
+// Departments lists the departments by name with their number of members
+func (s *Store) Departments(ctx context.Context) ([]entity.Department, error) {
+	rows, err := s.departments().OrderBy("lower(d.name)").QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	defer s.closeRows(ctx, rows)
+	departments := make([]entity.Department, 0)
+	for rows.Next() {
+		d, err := scanDepartment(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+		}
+		departments = append(departments, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	return departments, nil
+}
+
+func (s *Store) DepartmentByID(ctx context.Context, id string) (entity.Department, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.Department{}, ErrDepartmentNotFound
+	}
+	d, err := scanDepartment(s.departments().Where(squirrel.Eq{"d.id": id}).QueryRowContext(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.Department{}, ErrDepartmentNotFound
+		}
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	return d, nil
+}
+
+// MoveDepartment moves a department and everything below it under parentID,
+// or makes it a root without a parent. Moving a department below itself is
+// refused with ErrDepartmentCycle
+func (s *Store) MoveDepartment(ctx context.Context, id string, parentID *string) (entity.Department, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.Department{}, ErrDepartmentNotFound
+	}
+	var parent any
+	if parentID != nil {
+		if _, err := strconv.ParseInt(*parentID, 10, 64); err != nil {
+			return entity.Department{}, ErrParentNotFound
+		}
+		parent = *parentID
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := lockDepartmentTree(ctx, tx); err != nil {
+		return entity.Department{}, err
+	}
+	if parentID != nil {
+		below, err := s.inSubtree(ctx, tx, id, *parentID)
+		if err != nil {
+			return entity.Department{}, err
+		}
+		if below {
+			return entity.Department{}, ErrDepartmentCycle
+		}
+	}
+	res, err := s.SQLBuilder.Update(departmentsSchema).
+		Set("parent_id", parent).
+		Where(squirrel.Eq{"id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return entity.Department{}, ErrParentNotFound
+		}
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return entity.Department{}, ErrDepartmentNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	return s.DepartmentByID(ctx, id)
+}
+
+// DepartmentSubtree lists a department and every department below it, by
+// depth then name
+func (s *Store) DepartmentSubtree(ctx context.Context, id string) ([]entity.DepartmentNode, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, ErrDepartmentNotFound
+	}
+	nodes, err := s.departmentNodes(ctx, s.departments().Column("c.depth").
+		Join("closure c ON c.id = d.id").
+		Where(squirrel.Eq{"c.ancestor_id": id}).
+		OrderBy("c.depth", "lower(d.name)"))
+	if err != nil {
+		return nil, err
+	}
+	// the subtree of an existing department holds at least the department
+	if len(nodes) == 0 {
+		return nil, ErrDepartmentNotFound
+	}
+	return nodes, nil
+}
+
+// DepartmentAncestors lists the departments above a department from its
+// parent up to its root
+func (s *Store) DepartmentAncestors(ctx context.Context, id string) ([]entity.DepartmentNode, error) {
+	if _, err := s.DepartmentByID(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.departmentNodes(ctx, s.departments().Column("c.depth").
+		Join("closure c ON c.ancestor_id = d.id").
+		Where(squirrel.Eq{"c.id": id}).
+		Where("c.depth > 0").
+		OrderBy("c.depth"))
+}
+
+// DepartmentUsers lists the users of a department and of every department
+// below it
+func (s *Store) DepartmentUsers(ctx context.Context, id string) ([]entity.User, error) {
+	if _, err := s.DepartmentByID(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.users(ctx, s.SQLBuilder.Select(userColumns).
+		Prefix(departmentSubtree, id).
+		From(usersView).
+		Where("department_id IN (SELECT id FROM subtree)").
+		OrderBy("id"))
+}
+
+// RenameDepartment renames a department, its members reference it by id so
+// they all move with it
+func (s *Store) RenameDepartment(ctx context.Context, id, name string) (entity.Department, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.Department{}, ErrDepartmentNotFound
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := s.SQLBuilder.Update(departmentsSchema).
+		Set("name", strings.TrimSpace(name)).
+		Where(squirrel.Eq{"id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return entity.Department{}, ErrDepartmentExists
+		}
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return entity.Department{}, ErrDepartmentNotFound
+	}
+	// the department of every member changes
+	if err := s.recordMembersUpdated(ctx, tx, id); err != nil {
+		return entity.Department{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	return s.DepartmentByID(ctx, id)
+}
+
+func (s *Store) MergeDepartment(ctx context.Context, id, into string) (entity.Department, error) {
+	if id == into {
+		return entity.Department{}, ErrMergeIntoItself
+	}
+	for _, dep := range []string{id, into} {
+		if _, err := strconv.ParseInt(dep, 10, 64); err != nil {
+			return entity.Department{}, ErrDepartmentNotFound
+		}
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the departments below id move under into, which must not be one of them
+	if err := lockDepartmentTree(ctx, tx); err != nil {
+		return entity.Department{}, err
+	}
+	var found int
+	err = s.SQLBuilder.Select("count(*)").From(departmentsSchema).
+		Where(squirrel.Eq{"id": []string{id, into}}).
+		RunWith(tx).QueryRowContext(ctx).Scan(&found)
+	if err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	if found != 2 {
+		return entity.Department{}, ErrDepartmentNotFound
+	}
+	below, err := s.inSubtree(ctx, tx, id, into)
+	if err != nil {
+		return entity.Department{}, err
+	}
+	if below {
+		return entity.Department{}, ErrDepartmentCycle
+	}
+	moves := []struct{ table, column string }{
+		{usersSchema, "department_id"},
+		{pendingChangesSchema, "department_id"},
+		{departmentsSchema, "parent_id"},
+	}
+	members, err := s.ids(ctx, s.SQLBuilder.Select("id").From(usersSchema).
+		Where(squirrel.Eq{"department_id": id}).
+		RunWith(tx))
+	if err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	for _, move := range moves {
+		_, err := s.SQLBuilder.Update(move.table).
+			Set(move.column, into).
+			Where(squirrel.Eq{move.column: id}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+		}
+	}
+	_, err = s.SQLBuilder.Delete(departmentsSchema).Where(squirrel.Eq{"id": id}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	if err := s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, members...); err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.Department{}, fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	s.Logger.InfoCtx(ctx, "departments merged", slog.String("id", id), slog.String("into", into))
+	return s.DepartmentByID(ctx, into)
+}
+
+// DeleteDepartment deletes a department without members, departments below
+// it or scheduled moves
+func (s *Store) DeleteDepartment(ctx context.Context, id string) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return ErrDepartmentNotFound
+	}
+	res, err := s.SQLBuilder.Delete(departmentsSchema).Where(squirrel.Eq{"id": id}).ExecContext(ctx)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrDepartmentInUse
+		}
+		return fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrDepartmentNotFound
+	}
+	return nil
+}
+
+// departmentID returns the id and name of the department named name,
+// regardless of case
+func (s *Store) departmentID(ctx context.Context, runner squirrel.BaseRunner, name string) (string, string, error) {
+	var id, canonical string
+	err := s.SQLBuilder.Select("id, name").From(departmentsSchema).
+		Where("lower(name) = lower(?)", strings.TrimSpace(name)).
+		RunWith(runner).QueryRowContext(ctx).
+		Scan(&id, &canonical)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", fmt.Errorf("%w: %q", ErrDepartmentNotFound, name)
+		}
+		return "", "", fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	return id, canonical, nil
+}
+
+// inSubtree reports whether other is id or a department below it
+func (s *Store) inSubtree(ctx context.Context, runner squirrel.BaseRunner, id, other string) (bool, error) {
+	var below bool
+	err := s.SQLBuilder.Select().
+		Prefix(departmentSubtree, id).
+		Column(squirrel.Expr("EXISTS (SELECT 1 FROM subtree WHERE id = ?)", other)).
+		RunWith(runner).QueryRowContext(ctx).
+		Scan(&below)
+	if err != nil {
+		return false, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	return below, nil
+}
+
+// lockDepartmentTree serializes the changes of the tree, two departments
+// moved below each other at once would otherwise both pass the cycle check
+func lockDepartmentTree(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE "+departmentsSchema+" IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	return nil
+}
+
+// recordMembersUpdated records an update of every member of a department
+func (s *Store) recordMembersUpdated(ctx context.Context, tx *sql.Tx, id string) error {
+	members, err := s.ids(ctx, s.SQLBuilder.Select("id").From(usersSchema).
+		Where(squirrel.Eq{"department_id": id}).
+		RunWith(tx))
+	if err == nil {
+		err = s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, members...)
+	}
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveDepartment, err)
+	}
+	return nil
+}
+
+func (s *Store) departments() squirrel.SelectBuilder {
+	return s.SQLBuilder.Select(departmentColumns).
+		Prefix(departmentRollup).
+		From(departmentsSchema + " d").
+		Join("rollup r ON r.id = d.id")
+}
+
+func (s *Store) departmentNodes(ctx context.Context, query squirrel.SelectBuilder) ([]entity.DepartmentNode, error) {
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	defer s.closeRows(ctx, rows)
+	nodes := make([]entity.DepartmentNode, 0)
+	for rows.Next() {
+		var node entity.DepartmentNode
+		if node.Department, err = scanDepartment(rows, &node.Depth); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchDepartment, err)
+	}
+	return nodes, nil
+}
+
+// scanDepartment scans the departmentColumns followed by extra
+func scanDepartment(row squirrel.RowScanner, extra ...any) (entity.Department, error) {
+	var (
+		d      entity.Department
+		parent sql.NullString
+	)
+	dest := append([]any{&d.ID, &d.Name, &parent, &d.CreatedAt, &d.Members, &d.Headcount}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return entity.Department{}, err
+	}
+	if parent.Valid {
+		d.ParentID = &parent.String
+	}
+	return d, nil
+}
@@ -0,0 +1,223 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDepartmentNamesIgnoreCase(t *testing.T) {
+	ctx := context.Background()
+	finance := newDepartment(t, "Finance", "")
+
+	_, err := store.CreateDepartment(ctx, entity.Department{Name: " FINANCE "})
+	assert.ErrorIs(t, err, ErrDepartmentExists)
+
+	user := newUser(t, "ledger", " finance ")
+	assert.Equal(t, "Finance", user.Department)
+
+	got, err := store.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Finance", got.GetDepartment())
+
+	finance, err = store.DepartmentByID(ctx, finance.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, finance.Members)
+}
+
+func TestMergeDepartment(t *testing.T) {
+	ctx := context.Background()
+	support := newDepartment(t, "Support", "")
+	helpdesk := newDepartment(t, "Helpdesk", "")
+	user := newUser(t, "caller", "helpdesk")
+
+	_, err := store.MergeDepartment(ctx, support.ID, support.ID)
+	assert.ErrorIs(t, err, ErrMergeIntoItself)
+	assert.ErrorIs(t, store.DeleteDepartment(ctx, helpdesk.ID), ErrDepartmentInUse)
+
+	merged, err := store.MergeDepartment(ctx, helpdesk.ID, support.ID)
+	require.NoError(t, err)
+	assert.Equal(t, support.ID, merged.ID)
+	assert.Equal(t, 1, merged.Members)
+
+	got, err := store.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Support", got.GetDepartment())
+
+	_, err = store.DepartmentByID(ctx, helpdesk.ID)
+	assert.ErrorIs(t, err, ErrDepartmentNotFound)
+}
+
+// TestDepartmentsMigration checks that 000010 moves the department names of
+// users and scheduled changes to departments, one per spelling ignoring case
+// and surrounding spaces
+func TestDepartmentsMigration(t *testing.T) {
+	db, err := sql.Open("pgx", newDatabase(t, "departments_migration"))
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := newMigrate(db)
+	require.NoError(t, err)
+	require.NoError(t, m.Migrate(9))
+
+	users := map[string]any{
+		"upper":  "Engineering",
+		"lower":  " engineering ",
+		"shout":  "ENGINEERING",
+		"sales":  "Sales",
+		"none":   nil,
+		"spaces": "  ",
+	}
+	for name, department := range users {
+		_, err := db.Exec(`INSERT INTO users (user_name, first_name, last_name, email, department, user_status)
+			VALUES ($1, $1, 'test', $1 || '@example.com', $2, 'A')`, name, department)
+		require.NoError(t, err)
+	}
+	changes := map[string]string{
+		"sales": "sales ",
+		// a department only scheduled is created too
+		"upper": "Legal",
+	}
+	for name, department := range changes {
+		_, err := db.Exec(`INSERT INTO scheduled_changes (user_id, department, effective_at, reason)
+			SELECT id, $2, now(), 'moved' FROM users WHERE user_name = $1`, name, department)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, m.Migrate(10))
+
+	var departments int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM departments`).Scan(&departments))
+	assert.Equal(t, 3, departments)
+
+	// the spelling kept of a department depends on the collation
+	moved := map[string]string{}
+	rows, err := db.Query(`SELECT user_name, department FROM users_view WHERE department <> ''`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var name, department string
+		require.NoError(t, rows.Scan(&name, &department))
+		moved[name] = department
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	assert.Len(t, moved, 4)
+	assert.Equal(t, moved["upper"], moved["lower"])
+	assert.Equal(t, moved["upper"], moved["shout"])
+	assert.Equal(t, "engineering", strings.ToLower(moved["upper"]))
+	assert.Equal(t, "sales", strings.ToLower(moved["sales"]))
+
+	var unassigned int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM users WHERE department_id IS NULL`).Scan(&unassigned))
+	assert.Equal(t, 2, unassigned)
+
+	scheduled := map[string]string{}
+	rows, err = db.Query(`SELECT u.user_name, d.name FROM scheduled_changes c
+		JOIN users u ON u.id = c.user_id JOIN departments d ON d.id = c.department_id`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var name, department string
+		require.NoError(t, rows.Scan(&name, &department))
+		scheduled[name] = department
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	assert.Equal(t, map[string]string{"sales": moved["sales"], "upper": "Legal"}, scheduled)
+
+	// going back names the users after their department
+	require.NoError(t, m.Migrate(9))
+	var department string
+	require.NoError(t, db.QueryRow(`SELECT department FROM users WHERE user_name = 'lower'`).Scan(&department))
+	assert.Equal(t, moved["upper"], department)
+}
+
+func TestDepartmentTree(t *testing.T) {
+	ctx := context.Background()
+	org := newDepartment(t, "Organisation", "")
+	eng := newDepartment(t, "Engineering", org.ID)
+	platform := newDepartment(t, "Platform", eng.ID)
+	apps := newDepartment(t, "Apps", eng.ID)
+	ops := newDepartment(t, "Operations", org.ID)
+	lead := newUser(t, "lead", eng.Name)
+	first := newUser(t, "builder", platform.Name)
+	second := newUser(t, "deployer", platform.Name)
+	newUser(t, "operator", ops.Name)
+
+	nodes, err := store.DepartmentSubtree(ctx, eng.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Engineering:0", "Apps:1", "Platform:1"}, names(nodes))
+	assert.Equal(t, 1, nodes[0].Members)
+	assert.Equal(t, 3, nodes[0].Headcount)
+
+	org, err = store.DepartmentByID(ctx, org.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, org.Members)
+	assert.Equal(t, 4, org.Headcount)
+
+	nodes, err = store.DepartmentAncestors(ctx, platform.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Engineering:1", "Organisation:2"}, names(nodes))
+
+	nodes, err = store.DepartmentAncestors(ctx, org.ID)
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	users, err := store.DepartmentUsers(ctx, eng.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{lead.ID, first.ID, second.ID}, userIDs(users))
+
+	_, err = store.DepartmentSubtree(ctx, "999999")
+	assert.ErrorIs(t, err, ErrDepartmentNotFound)
+
+	for _, parent := range []string{eng.ID, platform.ID} {
+		_, err = store.MoveDepartment(ctx, eng.ID, &parent)
+		assert.ErrorIs(t, err, ErrDepartmentCycle)
+	}
+	moved, err := store.MoveDepartment(ctx, platform.ID, &ops.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &ops.ID, moved.ParentID)
+	ops, err = store.DepartmentByID(ctx, ops.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, ops.Headcount)
+
+	apps, err = store.MoveDepartment(ctx, apps.ID, nil)
+	require.NoError(t, err)
+	assert.Nil(t, apps.ParentID)
+}
+
+// TestMoveDepartmentsConcurrently moves two departments below each other at
+// once, the lock of the tree lets only one of them pass the cycle check
+func TestMoveDepartmentsConcurrently(t *testing.T) {
+	ctx := context.Background()
+	left := newDepartment(t, "Left", "")
+	right := newDepartment(t, "Right", "")
+
+	errs := make(chan error, 2)
+	for _, move := range [][2]string{{left.ID, right.ID}, {right.ID, left.ID}} {
+		go func(id, parent string) {
+			_, err := store.MoveDepartment(ctx, id, &parent)
+			errs <- err
+		}(move[0], move[1])
+	}
+	var failed []error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	require.Len(t, failed, 1)
+	assert.ErrorIs(t, failed[0], ErrDepartmentCycle)
+}
+
+// names lists the nodes as name:depth
+func names(nodes []entity.DepartmentNode) []string {
+	out := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		out = append(out, node.Name+":"+strconv.Itoa(node.Depth))
+	}
+	return out
+}
@@ -0,0 +1,316 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"strconv"
+	"strings"
+)
+
+const groupColumns = "g.id, g.name, g.description, g.rules, g.created_at"
+
+func (s *Store) CreateGroup(ctx context.Context, group entity.Group) (entity.Group, error) {
+	rules, err := rulesValue(group.Rules)
+	if err != nil {
+		return entity.Group{}, fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	var id string
+	err = s.SQLBuilder.Insert(groupsSchema).SetMap(map[string]any{
+		"name":        strings.TrimSpace(group.Name),
+		"description": group.Description,
+		"rules":       rules,
+	}).Suffix(`RETURNING "id"`).
+		QueryRowContext(ctx).
+		Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return entity.Group{}, ErrGroupExists
+		}
+		return entity.Group{}, fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	return s.GroupByID(ctx, id)
+}
+
+// Groups lists the groups by name
+func (s *Store) Groups(ctx context.Context) ([]entity.Group, error) {
+	rows, err := s.SQLBuilder.Select(groupColumns).
+		From(groupsSchema + " g").
+		OrderBy("lower(g.name)").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	defer s.closeRows(ctx, rows)
+	groups := make([]entity.Group, 0)
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	return groups, nil
+}
+
+func (s *Store) GroupByID(ctx context.Context, id string) (entity.Group, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.Group{}, ErrGroupNotFound
+	}
+	g, err := scanGroup(s.SQLBuilder.Select(groupColumns).
+		From(groupsSchema + " g").
+		Where(squirrel.Eq{"g.id": id}).
+		QueryRowContext(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.Group{}, ErrGroupNotFound
+		}
+		return entity.Group{}, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	return g, nil
+}
+
+// UpdateGroup replaces the name, description and rules of a group, its
+// dynamic members are kept until they are set again
+func (s *Store) UpdateGroup(ctx context.Context, group entity.Group) (entity.Group, error) {
+	if _, err := strconv.ParseInt(group.ID, 10, 64); err != nil {
+		return entity.Group{}, ErrGroupNotFound
+	}
+	rules, err := rulesValue(group.Rules)
+	if err != nil {
+		return entity.Group{}, fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	res, err := s.SQLBuilder.Update(groupsSchema).SetMap(map[string]any{
+		"name":        strings.TrimSpace(group.Name),
+		"description": group.Description,
+		"rules":       rules,
+	}).Where(squirrel.Eq{"id": group.ID}).ExecContext(ctx)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return entity.Group{}, ErrGroupExists
+		}
+		return entity.Group{}, fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return entity.Group{}, ErrGroupNotFound
+	}
+	return s.GroupByID(ctx, group.ID)
+}
+
+// DeleteGroup deletes a group and its memberships
+func (s *Store) DeleteGroup(ctx context.Context, id string) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return ErrGroupNotFound
+	}
+	res, err := s.SQLBuilder.Delete(groupsSchema).Where(squirrel.Eq{"id": id}).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// AddStaticMember adds a user to a group by hand, a dynamic member stays one
+func (s *Store) AddStaticMember(ctx context.Context, groupID, userID string) error {
+	if _, err := s.GroupByID(ctx, groupID); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(userID, 10, 64); err != nil {
+		return ErrCustomerNotFound
+	}
+	_, err := s.SQLBuilder.Insert(groupMembersSchema).
+		Columns("group_id", "user_id", "static").
+		Values(groupID, userID, true).
+		Suffix("ON CONFLICT (group_id, user_id) DO UPDATE SET static = true").
+		ExecContext(ctx)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrCustomerNotFound
+		}
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	return nil
+}
+
+// RemoveStaticMember removes a user added by hand, users meeting the rules
+// of the group stay dynamic members
+func (s *Store) RemoveStaticMember(ctx context.Context, groupID, userID string) error {
+	if _, err := s.GroupByID(ctx, groupID); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(userID, 10, 64); err != nil {
+		return ErrGroupMemberNotFound
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	member := squirrel.Eq{"group_id": groupID, "user_id": userID, "static": true}
+	deleted, err := s.SQLBuilder.Delete(groupMembersSchema).
+		Where(member).Where("NOT dynamic").
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	updated, err := s.SQLBuilder.Update(groupMembersSchema).
+		Set("static", false).
+		Where(member).Where("dynamic").
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	d, _ := deleted.RowsAffected()
+	u, _ := updated.RowsAffected()
+	if d+u == 0 {
+		return ErrGroupMemberNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	return nil
+}
+
+// GroupMembers lists the members of a group by id
+func (s *Store) GroupMembers(ctx context.Context, groupID string) ([]entity.GroupMember, error) {
+	if _, err := s.GroupByID(ctx, groupID); err != nil {
+		return nil, err
+	}
+	rows, err := s.SQLBuilder.Select(userColumns + ", static, dynamic").
+		From(usersView).
+		Join(groupMembersSchema + " ON user_id = id").
+		Where(squirrel.Eq{"group_id": groupID}).
+		OrderBy("id").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	defer s.closeRows(ctx, rows)
+	members := make([]entity.GroupMember, 0)
+	for rows.Next() {
+		var m entity.GroupMember
+		cus, err := scanUserRows(rows, &m.Static, &m.Dynamic)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+		}
+		m.User = cus.GetExportedCustomer().User
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	return members, nil
+}
+
+// UserGroups lists the groups of a user ordered by name
+func (s *Store) UserGroups(ctx context.Context, userID string) ([]entity.UserGroup, error) {
+	if _, err := s.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.SQLBuilder.Select(groupColumns + ", m.static, m.dynamic").
+		From(groupMembersSchema + " m").
+		Join(groupsSchema + " g ON g.id = m.group_id").
+		Where(squirrel.Eq{"m.user_id": userID}).
+		OrderBy("lower(g.name)").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	defer s.closeRows(ctx, rows)
+	groups := make([]entity.UserGroup, 0)
+	for rows.Next() {
+		var g entity.UserGroup
+		if g.Group, err = scanGroup(rows, &g.Static, &g.Dynamic); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchGroup, err)
+	}
+	return groups, nil
+}
+
+func (s *Store) SetUserDynamicGroups(ctx context.Context, userID string, groupIDs []string) error {
+	return s.setDynamic(ctx, "user_id", userID, "group_id", groupIDs)
+}
+
+func (s *Store) SetGroupDynamicMembers(ctx context.Context, groupID string, userIDs []string) error {
+	return s.setDynamic(ctx, "group_id", groupID, "user_id", userIDs)
+}
+
+// setDynamic makes the rows of group_members whose column fixed is id
+// dynamic for exactly the values of other in ids
+func (s *Store) setDynamic(ctx context.Context, fixed, id, other string, ids []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// rows are deleted before they would break the check that a member is
+	// static or dynamic
+	stale := squirrel.And{squirrel.Eq{fixed: id}, squirrel.NotEq{other: ids}}
+	_, err = s.SQLBuilder.Delete(groupMembersSchema).
+		Where(stale).Where("NOT static").
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	_, err = s.SQLBuilder.Update(groupMembersSchema).
+		Set("dynamic", false).
+		Where(stale).Where("dynamic").
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	if len(ids) > 0 {
+		insert := s.SQLBuilder.Insert(groupMembersSchema).Columns(fixed, other, "dynamic")
+		for _, v := range ids {
+			insert = insert.Values(id, v, true)
+		}
+		_, err = insert.Suffix("ON CONFLICT (group_id, user_id) DO UPDATE SET dynamic = true").
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveGroup, err)
+	}
+	return nil
+}
+
+func rulesValue(rules []entity.GroupRule) (string, error) {
+	if rules == nil {
+		rules = []entity.GroupRule{}
+	}
+	b, err := json.Marshal(rules)
+	return string(b), err
+}
+
+// scanGroup scans the groupColumns followed by extra
+func scanGroup(row squirrel.RowScanner, extra ...any) (entity.Group, error) {
+	var (
+		g     entity.Group
+		rules []byte
+	)
+	dest := append([]any{&g.ID, &g.Name, &g.Description, &rules, &g.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return entity.Group{}, err
+	}
+	if err := json.Unmarshal(rules, &g.Rules); err != nil {
+		return entity.Group{}, err
+	}
+	return g, nil
+}
@@ -0,0 +1,99 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"time"
+)
+
+const loginThrottleColumns = "key, failures, last_failure_at, locked_until"
+
+// LoginThrottles returns the throttles of the given keys that recorded failures
+func (s *Store) LoginThrottles(ctx context.Context, keys ...string) ([]entity.LoginThrottle, error) {
+	rows, err := s.SQLBuilder.Select(loginThrottleColumns).
+		From(loginThrottlesSchema).
+		Where(squirrel.Eq{"key": keys}).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchLoginThrottle, err)
+	}
+	return s.scanLoginThrottles(ctx, rows)
+}
+
+// LockedLogins lists the accounts and ips currently locked out
+func (s *Store) LockedLogins(ctx context.Context) ([]entity.LoginThrottle, error) {
+	rows, err := s.SQLBuilder.Select(loginThrottleColumns).
+		From(loginThrottlesSchema).
+		Where("locked_until > now()").
+		OrderBy("locked_until DESC").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchLoginThrottle, err)
+	}
+	return s.scanLoginThrottles(ctx, rows)
+}
+
+// RecordLoginFailure counts a failed login of key and returns its recent
+// failures. Failures are forgotten window after the last one or after the
+// end of the last lockout, whichever is later.
+func (s *Store) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	var failures int
+	err := s.SQLBuilder.Insert(loginThrottlesSchema).SetMap(map[string]any{
+		"key":      key,
+		"failures": 1,
+	}).Suffix(`ON CONFLICT ("key") DO UPDATE SET
+		"failures" = CASE
+			WHEN GREATEST("login_throttles"."last_failure_at", COALESCE("login_throttles"."locked_until", '-infinity')) < now() - ? * interval '1 second' THEN 1
+			ELSE "login_throttles"."failures" + 1
+		END,
+		"last_failure_at" = now()
+		RETURNING "failures"`, window.Seconds()).
+		QueryRowContext(ctx).
+		Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf(errorMsg, ErrUpdateLoginThrottle, err)
+	}
+	return failures, nil
+}
+
+func (s *Store) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := s.SQLBuilder.Update(loginThrottlesSchema).
+		Set("locked_until", until).
+		Where(squirrel.Eq{"key": key}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateLoginThrottle, err)
+	}
+	return nil
+}
+
+// ClearLoginFailures forgets the failures of key and lifts its lockout
+func (s *Store) ClearLoginFailures(ctx context.Context, key string) error {
+	res, err := s.SQLBuilder.Delete(loginThrottlesSchema).
+		Where(squirrel.Eq{"key": key}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUpdateLoginThrottle, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrLoginThrottleNotFound
+	}
+	return nil
+}
+
+func (s *Store) scanLoginThrottles(ctx context.Context, rows *sql.Rows) ([]entity.LoginThrottle, error) {
+	defer s.closeRows(ctx, rows)
+
+	var throttles []entity.LoginThrottle
+	for rows.Next() {
+		var t entity.LoginThrottle
+		if err := rows.Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchLoginThrottle, err)
+		}
+		throttles = append(throttles, t)
+	}
+	return throttles, rows.Err()
+}
@@ -0,0 +1,208 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"strconv"
+)
+
+// reportingChain lists a user at depth 0 and its managers up to the root.
+// Its columns are not named like the columns of the users so they can be
+// joined without qualifying userColumns
+const reportingChain = `WITH RECURSIVE chain (link_id, link_depth) AS (
+	SELECT id, 0 FROM users WHERE id = ?
+	UNION ALL
+	SELECT u.manager_id, c.link_depth + 1 FROM users u JOIN chain c ON u.id = c.link_id
+	WHERE u.manager_id IS NOT NULL
+)`
+
+// SetManager makes managerID the manager of a user. Managers have to exist,
+// must not be terminated and must not report to the user themselves.
+func (s *Store) SetManager(ctx context.Context, userID string, managerID *string) (model.Customer, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrSetManager, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := lockChart(ctx, tx); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrSetManager, err)
+	}
+	cus, err := s.lockUser(ctx, tx, userID)
+	if err != nil {
+		return model.Customer{}, err
+	}
+	var manager any
+	if managerID != nil {
+		// the manager is locked so it is not terminated meanwhile
+		mgr, err := s.lockUser(ctx, tx, *managerID)
+		if err != nil {
+			if errors.Is(err, ErrCustomerNotFound) {
+				return model.Customer{}, ErrManagerNotFound
+			}
+			return model.Customer{}, err
+		}
+		if mgr.GetUserStatus() == entity.Terminated {
+			return model.Customer{}, ErrManagerTerminated
+		}
+		var reports bool
+		err = s.SQLBuilder.Select().
+			Prefix(reportingChain, *managerID).
+			Column(squirrel.Expr("EXISTS (SELECT 1 FROM chain WHERE link_id = ?)", userID)).
+			RunWith(tx).QueryRowContext(ctx).
+			Scan(&reports)
+		if err != nil {
+			return model.Customer{}, fmt.Errorf(errorMsg, ErrSetManager, err)
+		}
+		if reports {
+			return model.Customer{}, ErrManagerCycle
+		}
+		manager = *managerID
+	}
+	_, err = s.SQLBuilder.Update(usersSchema).
+		Set("manager_id", manager).
+		Where(squirrel.Eq{"id": userID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrSetManager, err)
+	}
+	if err := s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, userID); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrSetManager, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrSetManager, err)
+	}
+	cus.SetManagerID(managerID)
+	return cus, nil
+}
+
+// DirectReports lists the users managed by a user
+func (s *Store) DirectReports(ctx context.Context, userID string) ([]entity.User, error) {
+	if _, err := s.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.users(ctx, s.SQLBuilder.Select(userColumns).
+		From(usersView).
+		Where(squirrel.Eq{"manager_id": userID}).
+		OrderBy("id"))
+}
+
+// ReportingChain lists the manager of a user, its manager and so on up to
+// a user without a manager
+func (s *Store) ReportingChain(ctx context.Context, userID string) ([]entity.User, error) {
+	if _, err := s.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.users(ctx, s.SQLBuilder.Select(userColumns).
+		Prefix(reportingChain, userID).
+		From(usersView).
+		Join("chain ON chain.link_id = id").
+		Where("link_depth > 0").
+		OrderBy("link_depth"))
+}
+
+// OrgChart returns the users without a manager with everyone reporting to
+// them below, reports are ordered by id
+func (s *Store) OrgChart(ctx context.Context) ([]entity.OrgNode, error) {
+	users, err := s.users(ctx, s.SQLBuilder.Select(userColumns).From(usersView).OrderBy("id"))
+	if err != nil {
+		return nil, err
+	}
+	reports := make(map[string][]entity.User)
+	var roots []entity.User
+	for _, user := range users {
+		if user.ManagerID == nil {
+			roots = append(roots, user)
+			continue
+		}
+		reports[*user.ManagerID] = append(reports[*user.ManagerID], user)
+	}
+	var tree func(users []entity.User) []entity.OrgNode
+	tree = func(users []entity.User) []entity.OrgNode {
+		nodes := make([]entity.OrgNode, 0, len(users))
+		for _, user := range users {
+			nodes = append(nodes, entity.OrgNode{User: user, Reports: tree(reports[user.ID])})
+		}
+		return nodes
+	}
+	return tree(roots), nil
+}
+
+// lockChart serializes the changes of managers until the end of tx. Two
+// users made each other's manager at once would both pass the cycle check,
+// as would a manager set while reports are reassigned. It is taken before
+// the rows of users are locked so the transactions cannot deadlock.
+func lockChart(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('users.manager_id'))")
+	return err
+}
+
+// reassignReports hands the reports of a user leaving to its own manager, or
+// makes them roots when it has none
+func (s *Store) reassignReports(ctx context.Context, tx *sql.Tx, userID string) error {
+	if _, err := strconv.ParseInt(userID, 10, 64); err != nil {
+		return nil
+	}
+	// a no-op when the caller took it already
+	if err := lockChart(ctx, tx); err != nil {
+		return err
+	}
+	reports, err := s.ids(ctx, s.SQLBuilder.Update(usersSchema).
+		Set("manager_id", squirrel.Expr("(SELECT manager_id FROM "+usersSchema+" WHERE id = ?)", userID)).
+		Where(squirrel.Eq{"manager_id": userID}).
+		Suffix(`RETURNING "id"`).
+		RunWith(tx))
+	if err != nil {
+		return err
+	}
+	if len(reports) > 0 {
+		s.Logger.InfoCtx(ctx, "reports reassigned", slog.String("user_id", userID), slog.Int("count", len(reports)))
+	}
+	return s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, reports...)
+}
+
+// ids collects the ids returned by query
+func (s *Store) ids(ctx context.Context, query interface {
+	QueryContext(context.Context) (*sql.Rows, error)
+}) ([]string, error) {
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.closeRows(ctx, rows)
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) users(ctx context.Context, query squirrel.SelectBuilder) ([]entity.User, error) {
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	defer s.closeRows(ctx, rows)
+	users := make([]entity.User, 0)
+	for rows.Next() {
+		cus, err := scanUserRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+		}
+		users = append(users, cus.GetExportedCustomer().User)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	return users, nil
+}
@@ -0,0 +1,118 @@
+package datastore
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReportingChain(t *testing.T) {
+	ctx := context.Background()
+	newDepartment(t, "Management", "")
+	ceo := newUser(t, "ceo", "Management")
+	vp := newUser(t, "vp", "Management")
+	dev := newUser(t, "dev", "Management")
+
+	_, err := store.SetManager(ctx, vp.ID, &ceo.ID)
+	require.NoError(t, err)
+	_, err = store.SetManager(ctx, dev.ID, &vp.ID)
+	require.NoError(t, err)
+
+	chain, err := store.ReportingChain(ctx, dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{vp.ID, ceo.ID}, userIDs(chain))
+
+	reports, err := store.DirectReports(ctx, ceo.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{vp.ID}, userIDs(reports))
+
+	for _, manager := range []string{dev.ID, ceo.ID} {
+		_, err = store.SetManager(ctx, ceo.ID, &manager)
+		assert.ErrorIs(t, err, ErrManagerCycle)
+	}
+	unknown := "999999"
+	_, err = store.SetManager(ctx, dev.ID, &unknown)
+	assert.ErrorIs(t, err, ErrManagerNotFound)
+
+	// the reports of a terminated user move to its manager
+	_, err = store.ChangeStatus(ctx, entity.StatusChange{UserID: vp.ID, To: entity.Terminated, Reason: "left"})
+	require.NoError(t, err)
+	chain, err = store.ReportingChain(ctx, dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ceo.ID}, userIDs(chain))
+
+	_, err = store.SetManager(ctx, dev.ID, &vp.ID)
+	assert.ErrorIs(t, err, ErrManagerTerminated)
+}
+
+// TestLockChart holds the lock of the chart, managers are only set once it
+// is released
+func TestLockChart(t *testing.T) {
+	ctx := context.Background()
+	newDepartment(t, "Chart", "")
+	manager := newUser(t, "chart-manager", "Chart")
+	report := newUser(t, "chart-report", "Chart")
+
+	tx, err := store.DB.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer func() { _ = tx.Rollback() }()
+	require.NoError(t, lockChart(ctx, tx))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := store.SetManager(ctx, report.ID, &manager.ID)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("manager set while the chart was locked: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	require.NoError(t, tx.Commit())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("manager not set after the chart was unlocked")
+	}
+	got, err := store.GetByID(ctx, report.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &manager.ID, got.GetManagerID())
+}
+
+// TestSetManagersConcurrently makes two users each other's manager at once,
+// only one of them passes the cycle check
+func TestSetManagersConcurrently(t *testing.T) {
+	ctx := context.Background()
+	newDepartment(t, "Peers", "")
+	left := newUser(t, "left-peer", "Peers")
+	right := newUser(t, "right-peer", "Peers")
+
+	errs := make(chan error, 2)
+	for _, pair := range [][2]string{{left.ID, right.ID}, {right.ID, left.ID}} {
+		go func(userID, managerID string) {
+			_, err := store.SetManager(ctx, userID, &managerID)
+			errs <- err
+		}(pair[0], pair[1])
+	}
+	var failed []error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	require.Len(t, failed, 1)
+	assert.ErrorIs(t, failed[0], ErrManagerCycle)
+}
+
+func userIDs(users []entity.User) []string {
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
@@ -0,0 +1,176 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/jackc/pgconn"
+	"golang.org/x/exp/slog"
+)
+
+// SaveTOTP stores a new unconfirmed TOTP secret of a user, replacing an
+// unconfirmed one. A confirmed enrollment must be deleted first.
+func (s *Store) SaveTOTP(ctx context.Context, userID, secret string) error {
+	res, err := s.SQLBuilder.Insert(userMFASchema).SetMap(map[string]any{
+		"user_id": userID,
+		"secret":  secret,
+	}).Suffix(`ON CONFLICT ("user_id") DO UPDATE SET "secret" = EXCLUDED."secret", "last_used_step" = 0, "created_at" = now() WHERE "user_mfa"."confirmed_at" IS NULL`).
+		ExecContext(ctx)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			return ErrCustomerNotFound
+		}
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+func (s *Store) MFAEnrollment(ctx context.Context, userID string) (entity.MFAEnrollment, error) {
+	e := entity.MFAEnrollment{UserID: userID}
+	err := s.SQLBuilder.Select("secret, confirmed_at, last_used_step, created_at").
+		From(userMFASchema).
+		Where(squirrel.Eq{"user_id": userID}).
+		QueryRowContext(ctx).
+		Scan(&e.Secret, &e.ConfirmedAt, &e.LastUsedStep, &e.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.MFAEnrollment{}, ErrMFANotFound
+		}
+		return entity.MFAEnrollment{}, fmt.Errorf(errorMsg, ErrFetchMFA, err)
+	}
+	return e, nil
+}
+
+// ConfirmTOTP enables the enrollment of a user with the period of the code
+// that confirmed it and stores the hashes of its recovery codes
+func (s *Store) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := s.SQLBuilder.Update(userMFASchema).
+		Set("confirmed_at", squirrel.Expr("now()")).
+		Set("last_used_step", step).
+		Where(squirrel.Eq{"user_id": userID, "confirmed_at": nil}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	s.Logger.InfoCtx(ctx, "mfa enabled", slog.String("user_id", userID))
+	return nil
+}
+
+// UseTOTPStep records the period of an accepted code, it fails with
+// ErrMFACodeUsed when a code of that period or a later one was accepted
+func (s *Store) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	res, err := s.SQLBuilder.Update(userMFASchema).
+		Set("last_used_step", step).
+		Where(squirrel.Eq{"user_id": userID}).
+		Where(squirrel.Lt{"last_used_step": step}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMFACodeUsed
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates the recovery codes of a user for new ones
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	return nil
+}
+
+func (s *Store) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, recoveryCodes []string) error {
+	_, err := s.SQLBuilder.Delete(recoveryCodesSchema).
+		Where(squirrel.Eq{"user_id": userID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	if len(recoveryCodes) == 0 {
+		return nil
+	}
+	insert := s.SQLBuilder.Insert(recoveryCodesSchema).Columns("id", "user_id")
+	for _, id := range recoveryCodes {
+		insert = insert.Values(id, userID)
+	}
+	if _, err := insert.RunWith(tx).ExecContext(ctx); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used
+func (s *Store) UseRecoveryCode(ctx context.Context, userID, id string) error {
+	res, err := s.SQLBuilder.Update(recoveryCodesSchema).
+		Set("used_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": id, "user_id": userID, "used_at": nil}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInvalidRecoveryCode
+	}
+	s.Logger.InfoCtx(ctx, "recovery code used", slog.String("user_id", userID))
+	return nil
+}
+
+// DeleteMFA removes the enrollment and the recovery codes of a user
+func (s *Store) DeleteMFA(ctx context.Context, userID string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := s.SQLBuilder.Delete(userMFASchema).
+		Where(squirrel.Eq{"user_id": userID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMFANotFound
+	}
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveMFA, err)
+	}
+	s.Logger.InfoCtx(ctx, "mfa reset", slog.String("user_id", userID))
+	return nil
+}
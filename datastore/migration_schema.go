@@ -13,15 +13,7 @@ import (
 var fs embed.FS
 
 func validateSchema(db *sql.DB) error {
-	sourceDriver, err := iofs.New(fs, "migrations")
-	if err != nil {
-		return err
-	}
-	targetInstance, err := postgres.WithInstance(db, new(postgres.Config))
-	if err != nil {
-		return err
-	}
-	m, err := migrate.NewWithInstance("iofs", sourceDriver, "postgres", targetInstance)
+	m, err := newMigrate(db)
 	if err != nil {
 		return err
 	}
@@ -31,3 +23,16 @@ func validateSchema(db *sql.DB) error {
 	}
 	return nil
 }
+
+// newMigrate migrates db with the embedded migrations
+func newMigrate(db *sql.DB) (*migrate.Migrate, error) {
+	sourceDriver, err := iofs.New(fs, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	targetInstance, err := postgres.WithInstance(db, new(postgres.Config))
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithInstance("iofs", sourceDriver, "postgres", targetInstance)
+}
@@ -0,0 +1,34 @@
+CREATE TABLE "roles" (
+                                "name" varchar(50) PRIMARY KEY,
+                                "description" varchar(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE "role_permissions" (
+                                "role" varchar(50) NOT NULL REFERENCES "roles" ("name") ON DELETE CASCADE,
+                                "permission" varchar(100) NOT NULL,
+                                PRIMARY KEY ("role", "permission")
+);
+
+CREATE TABLE "role_bindings" (
+                                "subject" varchar(255) NOT NULL,
+                                "role" varchar(50) NOT NULL REFERENCES "roles" ("name") ON DELETE CASCADE,
+                                "created_by" varchar(255) NOT NULL DEFAULT '',
+                                "created_at" timestamptz NOT NULL DEFAULT now(),
+                                PRIMARY KEY ("subject", "role")
+);
+
+INSERT INTO "roles" ("name", "description") VALUES
+    ('viewer', 'read only access to users'),
+    ('editor', 'create and update users'),
+    ('admin', 'full access including deletes and role management');
+
+INSERT INTO "role_permissions" ("role", "permission") VALUES
+    ('viewer', 'users:read'),
+    ('editor', 'users:read'),
+    ('editor', 'users:read_pii'),
+    ('editor', 'users:write'),
+    ('admin', 'users:read'),
+    ('admin', 'users:read_pii'),
+    ('admin', 'users:write'),
+    ('admin', 'users:delete'),
+    ('admin', 'roles:manage');
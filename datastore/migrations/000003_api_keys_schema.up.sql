@@ -0,0 +1,15 @@
+CREATE TABLE "api_keys" (
+                                "id" bigserial PRIMARY KEY,
+                                "name" varchar(100) NOT NULL,
+                                "prefix" varchar(32) NOT NULL UNIQUE,
+                                "key_hash" varchar(64) NOT NULL,
+                                "scopes" varchar(255) NOT NULL,
+                                "expires_at" timestamptz,
+                                "created_by" varchar(255) NOT NULL DEFAULT '',
+                                "created_at" timestamptz NOT NULL DEFAULT now(),
+                                "last_used_at" timestamptz,
+                                "revoked_at" timestamptz
+);
+
+INSERT INTO "role_permissions" ("role", "permission") VALUES
+    ('admin', 'api_keys:manage');
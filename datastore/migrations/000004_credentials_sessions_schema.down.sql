@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission = 'credentials:manage';
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS credentials;
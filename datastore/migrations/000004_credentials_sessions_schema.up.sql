@@ -0,0 +1,20 @@
+CREATE TABLE "credentials" (
+                                "user_id" bigint PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "password_hash" varchar(255) NOT NULL,
+                                "updated_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE "sessions" (
+                                "id" varchar(64) PRIMARY KEY,
+                                "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "ip" varchar(64) NOT NULL DEFAULT '',
+                                "user_agent" varchar(255) NOT NULL DEFAULT '',
+                                "created_at" timestamptz NOT NULL DEFAULT now(),
+                                "expires_at" timestamptz NOT NULL,
+                                "revoked_at" timestamptz
+);
+
+CREATE INDEX "sessions_user_id_idx" ON "sessions" ("user_id");
+
+INSERT INTO "role_permissions" ("role", "permission") VALUES
+    ('admin', 'credentials:manage');
@@ -0,0 +1,13 @@
+ALTER TABLE "users" ADD COLUMN "email_verified" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE "user_tokens" (
+                                "id" varchar(64) PRIMARY KEY,
+                                "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "purpose" varchar(20) NOT NULL,
+                                "email" varchar(255) NOT NULL DEFAULT '',
+                                "created_at" timestamptz NOT NULL DEFAULT now(),
+                                "expires_at" timestamptz NOT NULL,
+                                "used_at" timestamptz
+);
+
+CREATE INDEX "user_tokens_user_id_purpose_idx" ON "user_tokens" ("user_id", "purpose");
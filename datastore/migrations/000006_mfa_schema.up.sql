@@ -0,0 +1,17 @@
+ALTER TABLE "sessions" ADD COLUMN "mfa" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE "user_mfa" (
+                                "user_id" bigint PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "secret" varchar(255) NOT NULL,
+                                "confirmed_at" timestamptz,
+                                "last_used_step" bigint NOT NULL DEFAULT 0,
+                                "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE "mfa_recovery_codes" (
+                                "id" varchar(64) PRIMARY KEY,
+                                "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "used_at" timestamptz
+);
+
+CREATE INDEX "mfa_recovery_codes_user_id_idx" ON "mfa_recovery_codes" ("user_id");
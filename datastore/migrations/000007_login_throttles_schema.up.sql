@@ -0,0 +1,8 @@
+CREATE TABLE "login_throttles" (
+                                "key" varchar(255) PRIMARY KEY,
+                                "failures" integer NOT NULL DEFAULT 0,
+                                "last_failure_at" timestamptz NOT NULL DEFAULT now(),
+                                "locked_until" timestamptz
+);
+
+CREATE INDEX "login_throttles_locked_until_idx" ON "login_throttles" ("locked_until");
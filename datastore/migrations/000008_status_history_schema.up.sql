@@ -0,0 +1,11 @@
+CREATE TABLE "status_history" (
+                                "id" bigserial PRIMARY KEY,
+                                "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "from_status" varchar(1) NOT NULL,
+                                "to_status" varchar(1) NOT NULL,
+                                "reason" varchar(500) NOT NULL DEFAULT '',
+                                "changed_by" varchar(255) NOT NULL DEFAULT '',
+                                "changed_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "status_history_user_id_idx" ON "status_history" ("user_id", "changed_at");
@@ -0,0 +1,21 @@
+CREATE TABLE "scheduled_changes" (
+                                "id" bigserial PRIMARY KEY,
+                                "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+                                "to_status" varchar(1),
+                                "department" varchar(255),
+                                "effective_at" timestamptz NOT NULL,
+                                "reason" varchar(500) NOT NULL,
+                                "created_by" varchar(255) NOT NULL DEFAULT '',
+                                "created_at" timestamptz NOT NULL DEFAULT now(),
+                                "applied_at" timestamptz,
+                                "canceled_at" timestamptz,
+                                "canceled_by" varchar(255),
+                                "failed_at" timestamptz,
+                                "failure" text,
+                                CHECK ("to_status" IS NOT NULL OR "department" IS NOT NULL)
+);
+
+-- the worker only scans the pending changes
+CREATE INDEX "scheduled_changes_pending_idx" ON "scheduled_changes" ("effective_at")
+    WHERE "applied_at" IS NULL AND "canceled_at" IS NULL AND "failed_at" IS NULL;
+CREATE INDEX "scheduled_changes_user_id_idx" ON "scheduled_changes" ("user_id");
@@ -0,0 +1,14 @@
+DROP VIEW IF EXISTS users_view;
+
+ALTER TABLE "scheduled_changes" ADD COLUMN "department" varchar(255);
+UPDATE "scheduled_changes" SET "department" = "d"."name"
+FROM "departments" "d" WHERE "d"."id" = "scheduled_changes"."department_id";
+ALTER TABLE "scheduled_changes" DROP COLUMN IF EXISTS "department_id";
+ALTER TABLE "scheduled_changes" ADD CHECK ("to_status" IS NOT NULL OR "department" IS NOT NULL);
+
+ALTER TABLE "users" ADD COLUMN "department" varchar(255);
+UPDATE "users" SET "department" = "d"."name"
+FROM "departments" "d" WHERE "d"."id" = "users"."department_id";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "department_id";
+
+DROP TABLE IF EXISTS departments;
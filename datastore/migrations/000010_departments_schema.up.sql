@@ -0,0 +1,38 @@
+CREATE TABLE "departments" (
+                                "id" bigserial PRIMARY KEY,
+                                "name" varchar(255) NOT NULL,
+                                "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+-- names are unique regardless of case so "Engineering" and "engineering" cannot coexist
+CREATE UNIQUE INDEX "departments_name_idx" ON "departments" (lower("name"));
+
+-- the spellings of a department differing by case or surrounding spaces are
+-- merged, other variants are merged through the api
+INSERT INTO "departments" ("name")
+SELECT min(trim("department")) FROM (
+    SELECT "department" FROM "users"
+    UNION ALL
+    SELECT "department" FROM "scheduled_changes"
+) AS "spellings"
+WHERE trim(coalesce("department", '')) <> ''
+GROUP BY lower(trim("department"));
+
+ALTER TABLE "users" ADD COLUMN "department_id" bigint REFERENCES "departments" ("id") ON DELETE RESTRICT;
+UPDATE "users" SET "department_id" = "d"."id"
+FROM "departments" "d" WHERE lower(trim("users"."department")) = lower("d"."name");
+ALTER TABLE "users" DROP COLUMN "department";
+CREATE INDEX "users_department_id_idx" ON "users" ("department_id");
+
+ALTER TABLE "scheduled_changes" ADD COLUMN "department_id" bigint REFERENCES "departments" ("id") ON DELETE RESTRICT;
+UPDATE "scheduled_changes" SET "department_id" = "d"."id"
+FROM "departments" "d" WHERE lower(trim("scheduled_changes"."department")) = lower("d"."name");
+-- dropping the column drops the check requiring a status or a department
+ALTER TABLE "scheduled_changes" DROP COLUMN "department";
+ALTER TABLE "scheduled_changes" ADD CHECK ("to_status" IS NOT NULL OR "department_id" IS NOT NULL);
+
+-- users_view is read instead of users so the users keep their department name
+CREATE VIEW "users_view" AS
+SELECT "u"."id", "u"."user_name", "u"."first_name", "u"."last_name", "u"."email",
+       coalesce("d"."name", '') AS "department", "u"."user_status", "u"."email_verified", "u"."department_id"
+FROM "users" "u" LEFT JOIN "departments" "d" ON "d"."id" = "u"."department_id";
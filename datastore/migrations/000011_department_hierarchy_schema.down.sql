@@ -0,0 +1 @@
+ALTER TABLE "departments" DROP COLUMN IF EXISTS "parent_id";
@@ -0,0 +1,5 @@
+-- departments without a parent are the roots of the organisation, cycles
+-- longer than a department parenting itself are refused by the datastore
+ALTER TABLE "departments" ADD COLUMN "parent_id" bigint REFERENCES "departments" ("id") ON DELETE RESTRICT;
+ALTER TABLE "departments" ADD CONSTRAINT "departments_parent_check" CHECK ("parent_id" <> "id");
+CREATE INDEX "departments_parent_id_idx" ON "departments" ("parent_id");
@@ -0,0 +1,7 @@
+DROP VIEW IF EXISTS users_view;
+CREATE VIEW "users_view" AS
+SELECT "u"."id", "u"."user_name", "u"."first_name", "u"."last_name", "u"."email",
+       coalesce("d"."name", '') AS "department", "u"."user_status", "u"."email_verified", "u"."department_id"
+FROM "users" "u" LEFT JOIN "departments" "d" ON "d"."id" = "u"."department_id";
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "manager_id";
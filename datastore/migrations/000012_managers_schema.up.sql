@@ -0,0 +1,12 @@
+-- users without a manager are the roots of the org chart, longer cycles are
+-- refused by the datastore. Deleted and terminated managers hand their
+-- reports to their own manager before this foreign key applies
+ALTER TABLE "users" ADD COLUMN "manager_id" bigint REFERENCES "users" ("id") ON DELETE SET NULL;
+ALTER TABLE "users" ADD CONSTRAINT "users_manager_check" CHECK ("manager_id" <> "id");
+CREATE INDEX "users_manager_id_idx" ON "users" ("manager_id");
+
+CREATE OR REPLACE VIEW "users_view" AS
+SELECT "u"."id", "u"."user_name", "u"."first_name", "u"."last_name", "u"."email",
+       coalesce("d"."name", '') AS "department", "u"."user_status", "u"."email_verified", "u"."department_id",
+       "u"."manager_id"
+FROM "users" "u" LEFT JOIN "departments" "d" ON "d"."id" = "u"."department_id";
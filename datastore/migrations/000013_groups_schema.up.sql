@@ -0,0 +1,30 @@
+-- attributes are the custom fields of a user the rules of groups may match
+ALTER TABLE "users" ADD COLUMN "attributes" jsonb NOT NULL DEFAULT '{}';
+
+CREATE OR REPLACE VIEW "users_view" AS
+SELECT "u"."id", "u"."user_name", "u"."first_name", "u"."last_name", "u"."email",
+       coalesce("d"."name", '') AS "department", "u"."user_status", "u"."email_verified", "u"."department_id",
+       "u"."manager_id", "u"."attributes"
+FROM "users" "u" LEFT JOIN "departments" "d" ON "d"."id" = "u"."department_id";
+
+CREATE TABLE "groups" (
+                           "id" bigserial PRIMARY KEY,
+                           "name" varchar(255) NOT NULL,
+                           "description" varchar(1000) NOT NULL DEFAULT '',
+                           -- the rules a user has to meet all of to be a dynamic member
+                           "rules" jsonb NOT NULL DEFAULT '[]',
+                           "created_at" timestamptz NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX "groups_name_idx" ON "groups" (lower("name"));
+
+-- static members are added by hand, dynamic members are recomputed from the
+-- rules whenever a user or a group changes
+CREATE TABLE "group_members" (
+                                 "group_id" bigint NOT NULL REFERENCES "groups" ("id") ON DELETE CASCADE,
+                                 "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+                                 "static" boolean NOT NULL DEFAULT false,
+                                 "dynamic" boolean NOT NULL DEFAULT false,
+                                 PRIMARY KEY ("group_id", "user_id"),
+                                 CHECK ("static" OR "dynamic")
+);
+CREATE INDEX "group_members_user_id_idx" ON "group_members" ("user_id");
@@ -0,0 +1,14 @@
+-- the events of the changes of users, written in the transaction of the
+-- change and published by the relay afterwards. user_id has no foreign key,
+-- the events of deleted users are kept.
+CREATE TABLE "outbox" (
+                          "id" bigserial PRIMARY KEY,
+                          "user_id" bigint NOT NULL,
+                          "type" varchar(64) NOT NULL,
+                          "payload" jsonb NOT NULL,
+                          "occurred_at" timestamptz NOT NULL DEFAULT now(),
+                          "published_at" timestamptz,
+                          "attempts" integer NOT NULL DEFAULT 0,
+                          "last_error" text NOT NULL DEFAULT ''
+);
+CREATE INDEX "outbox_pending_idx" ON "outbox" ("id") WHERE "published_at" IS NULL;
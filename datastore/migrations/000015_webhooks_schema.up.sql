@@ -0,0 +1,30 @@
+CREATE TABLE "webhooks" (
+                            "id" bigserial PRIMARY KEY,
+                            "url" varchar(2048) NOT NULL,
+                            -- the event types delivered separated by spaces, every type when empty
+                            "event_types" varchar(255) NOT NULL DEFAULT '',
+                            "secret" varchar(255) NOT NULL,
+                            "created_by" varchar(255) NOT NULL DEFAULT '',
+                            "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+-- an event is delivered to a webhook once however often the relay publishes it
+CREATE TABLE "webhook_deliveries" (
+                                      "id" bigserial PRIMARY KEY,
+                                      "webhook_id" bigint NOT NULL REFERENCES "webhooks" ("id") ON DELETE CASCADE,
+                                      "event_id" bigint NOT NULL,
+                                      "event_type" varchar(64) NOT NULL,
+                                      "payload" jsonb NOT NULL,
+                                      "status" varchar(16) NOT NULL DEFAULT 'pending',
+                                      "attempts" integer NOT NULL DEFAULT 0,
+                                      "next_attempt_at" timestamptz NOT NULL DEFAULT now(),
+                                      "last_status_code" integer NOT NULL DEFAULT 0,
+                                      "last_error" text NOT NULL DEFAULT '',
+                                      "created_at" timestamptz NOT NULL DEFAULT now(),
+                                      "delivered_at" timestamptz,
+                                      UNIQUE ("webhook_id", "event_id")
+);
+CREATE INDEX "webhook_deliveries_due_idx" ON "webhook_deliveries" ("next_attempt_at") WHERE "status" = 'pending';
+
+INSERT INTO "role_permissions" ("role", "permission") VALUES
+    ('admin', 'webhooks:manage');
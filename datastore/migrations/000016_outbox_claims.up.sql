@@ -0,0 +1,3 @@
+-- a relay claims the events it publishes until claimed_until, the events of
+-- a relay that stopped before marking them are claimed again afterwards
+ALTER TABLE "outbox" ADD COLUMN "claimed_until" timestamptz;
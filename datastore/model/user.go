@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ellis90/assessment-bg/entity"
@@ -10,9 +11,16 @@ import (
 var (
 	ErrInvalidPerson = errors.New("a customer/user has a missing field")
 	// use a single instance of Validate, it caches struct info
-	validate *validator.Validate
+	validate = validator.New()
 )
 
+func init() {
+	// status accepts the statuses defined by entity.Statuses
+	_ = validate.RegisterValidation("status", func(fl validator.FieldLevel) bool {
+		return entity.Status(fl.Field().Int()).Valid()
+	})
+}
+
 type Customer struct {
 	person *entity.User
 }
@@ -21,11 +29,24 @@ type ExportCustomer struct {
 	User entity.User
 }
 
+// legacyUser is the user of the v1 api, its status is numbered
+type legacyUser struct {
+	entity.User
+	UserStatus int `json:"userStatus"`
+}
+
+// MarshalJSON keeps the numbered statuses of the v1 api, entity.Status is
+// marshalled by name
+func (e ExportCustomer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ User legacyUser }{
+		User: legacyUser{User: e.User, UserStatus: int(e.User.UserStatus)},
+	})
+}
+
 type Customers []Customer
 type ExportCustomers []ExportCustomer
 
 func NewCustomer(user *entity.User) (Customer, error) {
-	validate = validator.New()
 	if err := validateStruct(user); err != nil {
 		return Customer{}, err
 	}
@@ -60,12 +81,39 @@ func (c *Customer) GetEmail() string {
 	return c.person.Email
 }
 
+// SetDepartment sets the canonical spelling of the department of the user
+func (c *Customer) SetDepartment(name string) {
+	c.person.Department = name
+}
+
 func (c *Customer) GetDepartment() string {
 	return c.person.Department
 }
 
-func (c *Customer) GetUserStatus() string {
-	return c.person.UserStatus.String()
+// SetManagerID sets the manager of the user, nil for none
+func (c *Customer) SetManagerID(id *string) {
+	c.person.ManagerID = id
+}
+
+func (c *Customer) GetManagerID() *string {
+	return c.person.ManagerID
+}
+
+// SetAttributes sets the custom attributes of the user
+func (c *Customer) SetAttributes(attributes map[string]string) {
+	c.person.Attributes = attributes
+}
+
+func (c *Customer) GetAttributes() map[string]string {
+	return c.person.Attributes
+}
+
+func (c *Customer) GetEmailVerified() bool {
+	return c.person.EmailVerified
+}
+
+func (c *Customer) GetUserStatus() entity.Status {
+	return c.person.UserStatus
 }
 
 func (c *Customer) GetExportedCustomer() ExportCustomer {
@@ -82,9 +130,14 @@ func (cs Customers) GetExportedCustomers() ExportCustomers {
 	return expc
 }
 
-func validateStruct(user *entity.User) error {
+// Validate checks the validate tags of any entity
+func Validate(s any) error {
+	return validateStruct(s)
+}
+
+func validateStruct(s any) error {
 	// returns nil or ValidationErrors ( []FieldError )
-	err := validate.Struct(user)
+	err := validate.Struct(s)
 	if err != nil {
 
 		// this check is only needed when your code could produce
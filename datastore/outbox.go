@@ -0,0 +1,241 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	outboxColumns = "id, user_id, type, payload, occurred_at"
+	// outboxClaim is how long claimed events are left to the caller claiming
+	// them, longer than a batch takes to publish within the sink timeouts
+	outboxClaim = 30 * time.Minute
+)
+
+// eventPayload is what the outbox keeps of an event besides its columns
+type eventPayload struct {
+	User         entity.User          `json:"user"`
+	StatusChange *entity.StatusChange `json:"statusChange,omitempty"`
+}
+
+// recordEvents writes an event of typ for every user of ids to the outbox.
+// It runs in the transaction of the change, so the events are published if
+// and only if the change is committed, and reads the users from it so they
+// carry the change.
+func (s *Store) recordEvents(ctx context.Context, tx *sql.Tx, typ entity.EventType, change *entity.StatusChange, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	users, err := s.users(ctx, s.SQLBuilder.Select(userColumns).
+		From(usersView).
+		Where(squirrel.Eq{"id": ids}).
+		OrderBy("id").
+		RunWith(tx))
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	insert := s.SQLBuilder.Insert(outboxSchema).Columns("user_id", "type", "payload")
+	for _, u := range users {
+		payload, err := json.Marshal(eventPayload{User: u, StatusChange: change})
+		if err != nil {
+			return err
+		}
+		insert = insert.Values(u.ID, string(typ), string(payload))
+	}
+	_, err = insert.RunWith(tx).ExecContext(ctx)
+	return err
+}
+
+// PublishEvents passes at most limit unpublished events to publish, oldest
+// first, and marks those it accepted published. The events are claimed in a
+// transaction, published outside of it and marked in another, so a slow sink
+// holds no connection or lock. After publish fails for an event the later
+// events of its user are held back, and the events of users with events
+// claimed by another caller are left to it, so the events of a user are
+// published in order. Events published before a failure to mark them are
+// published again once their claim expired.
+func (s *Store) PublishEvents(ctx context.Context, limit int, publish func(entity.Event) error) (int, error) {
+	published, attempted := 0, 0
+	held := map[string]bool{}
+	for attempted < limit {
+		// the events of held users are skipped so they cannot fill the batch
+		events, err := s.claimEvents(ctx, held, limit-attempted)
+		if err != nil {
+			return published, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		var done, released []string
+		failed := map[string]string{}
+		for _, event := range events {
+			if held[event.UserID] {
+				released = append(released, event.ID)
+				continue
+			}
+			attempted++
+			if err := publish(event); err != nil {
+				held[event.UserID] = true
+				failed[event.ID] = err.Error()
+				s.Logger.WarnCtx(ctx, "failed to publish event", slog.String("id", event.ID),
+					slog.String("user_id", event.UserID), slog.Any("error", err))
+				continue
+			}
+			done = append(done, event.ID)
+		}
+		if err := s.settleEvents(ctx, done, failed, released); err != nil {
+			return published, err
+		}
+		published += len(done)
+		if !anyHeld(events, held) {
+			break
+		}
+	}
+	return published, nil
+}
+
+// claimEvents claims at most limit unpublished events for outboxClaim, the
+// oldest first. Events of the users of skipped and of users with events
+// claimed before are not claimed. Claims are taken one caller at a time.
+func (s *Store) claimEvents(ctx context.Context, skipped map[string]bool, limit int) ([]entity.Event, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('outbox'))"); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	users := make([]string, 0, len(skipped))
+	for userID := range skipped {
+		users = append(users, userID)
+	}
+	claimable, args, err := squirrel.Select("id").
+		From(outboxSchema).
+		Where(squirrel.Eq{"published_at": nil}).
+		Where("(claimed_until IS NULL OR claimed_until < now())").
+		Where("user_id NOT IN (SELECT user_id FROM " + outboxSchema +
+			" WHERE published_at IS NULL AND claimed_until >= now())").
+		Where(squirrel.NotEq{"user_id": users}).
+		OrderBy("id").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	rows, err := s.SQLBuilder.Update(outboxSchema).
+		Set("claimed_until", squirrel.Expr(fmt.Sprintf("now() + interval '%d seconds'", int(outboxClaim.Seconds())))).
+		Where("id IN ("+claimable+")", args...).
+		Suffix("RETURNING " + outboxColumns).
+		RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	events, err := s.scanEvents(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	// RETURNING keeps no order
+	sort.Slice(events, func(i, j int) bool {
+		a, _ := strconv.ParseInt(events[i].ID, 10, 64)
+		b, _ := strconv.ParseInt(events[j].ID, 10, 64)
+		return a < b
+	})
+	return events, nil
+}
+
+// settleEvents marks the claimed events of done published, counts the
+// attempt of those of failed with their error and gives up the claims of
+// released and failed
+func (s *Store) settleEvents(ctx context.Context, done []string, failed map[string]string, released []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if len(done) > 0 {
+		_, err := s.SQLBuilder.Update(outboxSchema).
+			Set("published_at", squirrel.Expr("now()")).
+			Set("claimed_until", nil).
+			Where(squirrel.Eq{"id": done}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf(errorMsg, ErrPublishEvents, err)
+		}
+	}
+	for id, reason := range failed {
+		_, err := s.SQLBuilder.Update(outboxSchema).
+			Set("attempts", squirrel.Expr("attempts + 1")).
+			Set("last_error", reason).
+			Set("claimed_until", nil).
+			Where(squirrel.Eq{"id": id}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf(errorMsg, ErrPublishEvents, err)
+		}
+	}
+	if len(released) > 0 {
+		_, err := s.SQLBuilder.Update(outboxSchema).
+			Set("claimed_until", nil).
+			Where(squirrel.Eq{"id": released}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf(errorMsg, ErrPublishEvents, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	return nil
+}
+
+func (s *Store) scanEvents(ctx context.Context, rows *sql.Rows) ([]entity.Event, error) {
+	defer s.closeRows(ctx, rows)
+	events := make([]entity.Event, 0)
+	for rows.Next() {
+		var (
+			event   entity.Event
+			payload []byte
+			body    eventPayload
+		)
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &payload, &event.OccurredAt); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+		}
+		if err := json.Unmarshal(payload, &body); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+		}
+		event.User, event.StatusChange = body.User, body.StatusChange
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrPublishEvents, err)
+	}
+	return events, nil
+}
+
+// anyHeld tells whether events held back some of events, the events after
+// them may fill the batch
+func anyHeld(events []entity.Event, held map[string]bool) bool {
+	for _, event := range events {
+		if held[event.UserID] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// outboxRow is what the tests read of an event besides its columns
+type outboxRow struct {
+	attempts  int
+	lastError string
+	published bool
+	claimed   bool
+}
+
+func TestPublishEvents(t *testing.T) {
+	ctx := context.Background()
+	drainOutbox(t)
+	newDepartment(t, "Outbox", "")
+	failing := newUser(t, "outbox-failing", "Outbox")
+	other := newUser(t, "outbox-other", "Outbox")
+	_, err := store.SetManager(ctx, failing.ID, &other.ID)
+	require.NoError(t, err)
+
+	var attempted []entity.Event
+	published, err := store.PublishEvents(ctx, 10, func(event entity.Event) error {
+		attempted = append(attempted, event)
+		if event.UserID == failing.ID {
+			return errors.New("sink down")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	// the update of the failing user is held back behind its creation
+	require.Len(t, attempted, 2)
+	assert.Equal(t, failing.ID, attempted[0].UserID)
+	assert.Equal(t, entity.EventUserCreated, attempted[0].Type)
+	assert.Equal(t, other.ID, attempted[1].UserID)
+	assert.Equal(t, outboxRow{attempts: 1, lastError: "sink down"}, readOutbox(t, attempted[0].ID))
+	assert.Equal(t, outboxRow{published: true}, readOutbox(t, attempted[1].ID))
+
+	var retried []entity.Event
+	published, err = store.PublishEvents(ctx, 10, func(event entity.Event) error {
+		retried = append(retried, event)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	require.Len(t, retried, 2)
+	assert.Equal(t, attempted[0].ID, retried[0].ID)
+	assert.Equal(t, entity.EventUserUpdated, retried[1].Type)
+	assert.Equal(t, &other.ID, retried[1].User.ManagerID)
+	assert.Equal(t, outboxRow{attempts: 1, lastError: "sink down", published: true}, readOutbox(t, retried[0].ID))
+}
+
+// TestClaimedEvents claims events as another relay would, they and the
+// later events of their user are left to it until the claim expired
+func TestClaimedEvents(t *testing.T) {
+	ctx := context.Background()
+	drainOutbox(t)
+	newDepartment(t, "Claims", "")
+	user := newUser(t, "outbox-claimed", "Claims")
+
+	claimed, err := store.claimEvents(ctx, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, outboxRow{claimed: true}, readOutbox(t, claimed[0].ID))
+
+	_, err = store.SetManager(ctx, user.ID, nil)
+	require.NoError(t, err)
+	published, err := store.PublishEvents(ctx, 10, func(event entity.Event) error {
+		t.Errorf("event %s of a claimed user published", event.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, published)
+
+	_, err = store.DB.ExecContext(ctx, `UPDATE outbox SET claimed_until = now() - interval '1 second' WHERE id = $1`, claimed[0].ID)
+	require.NoError(t, err)
+	var types []entity.EventType
+	published, err = store.PublishEvents(ctx, 10, func(event entity.Event) error {
+		types = append(types, event.Type)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, []entity.EventType{entity.EventUserCreated, entity.EventUserUpdated}, types)
+	assert.Equal(t, outboxRow{published: true}, readOutbox(t, claimed[0].ID))
+}
+
+// drainOutbox publishes the events left by the other tests
+func drainOutbox(t *testing.T) {
+	t.Helper()
+	for {
+		published, err := store.PublishEvents(context.Background(), 100, func(entity.Event) error { return nil })
+		require.NoError(t, err)
+		if published == 0 {
+			return
+		}
+	}
+}
+
+func readOutbox(t *testing.T, id string) outboxRow {
+	t.Helper()
+	var row outboxRow
+	err := store.DB.QueryRow(`SELECT attempts, last_error, published_at IS NOT NULL, claimed_until IS NOT NULL
+		FROM outbox WHERE id = $1`, id).Scan(&row.attempts, &row.lastError, &row.published, &row.claimed)
+	require.NoError(t, err)
+	return row
+}
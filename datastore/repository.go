@@ -1,13 +1,41 @@
 package datastore
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"time"
 )
 
 const (
-	usersSchema = "users"
+	usersSchema           = "users"
+	usersView             = "users_view"
+	departmentsSchema     = "departments"
+	rolesSchema           = "roles"
+	rolePermissionsSchema = "role_permissions"
+	roleBindingsSchema    = "role_bindings"
+	apiKeysSchema         = "api_keys"
+	credentialsSchema     = "credentials"
+	sessionsSchema        = "sessions"
+	userTokensSchema      = "user_tokens"
+	userMFASchema         = "user_mfa"
+	recoveryCodesSchema   = "mfa_recovery_codes"
+	loginThrottlesSchema  = "login_throttles"
+	statusHistorySchema   = "status_history"
+	pendingChangesSchema  = "scheduled_changes"
+	groupsSchema          = "groups"
+	groupMembersSchema    = "group_members"
+	outboxSchema          = "outbox"
+	webhooksSchema        = "webhooks"
+	deliveriesSchema      = "webhook_deliveries"
+	errorMsg              = "%w: %v"
+
+	userColumns = "id, user_name, first_name, last_name, email, department, user_status, email_verified, manager_id, attributes"
+
+	// postgres error codes
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
 )
 
 var (
@@ -15,12 +43,230 @@ var (
 	ErrFailedToCreateCustomer = errors.New("failed to add customer")
 	ErrUpdateCustomer         = errors.New("failed to update customer")
 	ErrFetchCustomer          = errors.New("failed to fetch customer")
-	errorMsg                  = fmt.Sprintf("%e : %w\n")
+	ErrFetchRole              = errors.New("failed to fetch roles")
+	ErrBindRole               = errors.New("failed to bind role")
+	ErrUnbindRole             = errors.New("failed to unbind role")
+	ErrRoleNotFound           = errors.New("role not found")
+	ErrRoleBindingNotFound    = errors.New("role binding not found")
+	ErrCreateAPIKey           = errors.New("failed to create api key")
+	ErrFetchAPIKey            = errors.New("failed to fetch api key")
+	ErrUpdateAPIKey           = errors.New("failed to update api key")
+	ErrAPIKeyNotFound         = errors.New("api key not found")
+	ErrCustomerNotFound       = errors.New("customer not found")
+	ErrCustomerExists         = errors.New("user name or email already taken")
+	ErrSetPassword            = errors.New("failed to set password")
+	ErrCredentialsNotFound    = errors.New("credentials not found")
+	ErrCreateSession          = errors.New("failed to create session")
+	ErrFetchSession           = errors.New("failed to fetch session")
+	ErrRevokeSession          = errors.New("failed to revoke session")
+	ErrSessionNotFound        = errors.New("session not found")
+	ErrCreateToken            = errors.New("failed to create token")
+	ErrInvalidToken           = errors.New("invalid or expired token")
+	ErrSaveMFA                = errors.New("failed to save mfa enrollment")
+	ErrFetchMFA               = errors.New("failed to fetch mfa enrollment")
+	ErrMFANotFound            = errors.New("mfa is not enrolled")
+	ErrMFAAlreadyEnabled      = errors.New("mfa is already enabled")
+	ErrMFACodeUsed            = errors.New("mfa code was already used")
+	ErrInvalidRecoveryCode    = errors.New("invalid recovery code")
+	ErrFetchLoginThrottle     = errors.New("failed to fetch login throttle")
+	ErrUpdateLoginThrottle    = errors.New("failed to update login throttle")
+	ErrLoginThrottleNotFound  = errors.New("no failed logins recorded")
+	ErrChangeStatus           = errors.New("failed to change user status")
+	ErrFetchStatusHistory     = errors.New("failed to fetch status history")
+	ErrScheduleChange         = errors.New("failed to schedule change")
+	ErrFetchScheduledChange   = errors.New("failed to fetch scheduled changes")
+	ErrApplyScheduledChange   = errors.New("failed to apply scheduled change")
+	ErrPendingChangeNotFound  = errors.New("pending scheduled change not found")
+	ErrDepartmentNotFound     = errors.New("department not found")
+	ErrDepartmentExists       = errors.New("department name already taken")
+	ErrDepartmentInUse        = errors.New("department still has members, departments below it or scheduled moves")
+	ErrMergeIntoItself        = errors.New("a department cannot be merged into itself")
+	ErrDepartmentCycle        = errors.New("a department cannot be placed below itself")
+	ErrParentNotFound         = errors.New("parent department not found")
+	ErrManagerNotFound        = errors.New("manager not found")
+	ErrManagerCycle           = errors.New("a user cannot report to themselves or to one of their reports")
+	ErrManagerTerminated      = errors.New("terminated users cannot manage users")
+	ErrSetManager             = errors.New("failed to set manager")
+	ErrGroupNotFound          = errors.New("group not found")
+	ErrGroupExists            = errors.New("group name already taken")
+	ErrGroupMemberNotFound    = errors.New("user is not a static member of the group")
+	ErrFetchGroup             = errors.New("failed to fetch group")
+	ErrSaveGroup              = errors.New("failed to save group")
+	ErrPublishEvents          = errors.New("failed to publish events")
+	ErrWebhookNotFound        = errors.New("webhook not found")
+	ErrDeliveryNotFound       = errors.New("dead delivery not found")
+	ErrFetchWebhook           = errors.New("failed to fetch webhook")
+	ErrSaveWebhook            = errors.New("failed to save webhook")
+	ErrFetchDepartment        = errors.New("failed to fetch department")
+	ErrSaveDepartment         = errors.New("failed to save department")
 )
 
 type UserRepository interface {
-	Create(user model.Customer) (model.Customer, error)
-	Update(user model.Customer) (model.Customer, error)
-	Get() (model.Customers, error)
-	Delete(id string) error
+	Create(ctx context.Context, user model.Customer) (model.Customer, error)
+	Update(ctx context.Context, user model.Customer) (model.Customer, error)
+	Get(ctx context.Context) (model.Customers, error)
+	GetByID(ctx context.Context, id string) (model.Customer, error)
+	GetByIDs(ctx context.Context, ids []string) (model.Customers, error)
+	GetByEmail(ctx context.Context, email string) (model.Customer, error)
+	MarkEmailVerified(ctx context.Context, userID, email string) error
+	Delete(ctx context.Context, id string) error
+	// ChangeStatus moves a user along the transitions of entity.Status and
+	// records the change, Update enforces and records status changes too
+	ChangeStatus(ctx context.Context, change entity.StatusChange) (model.Customer, error)
+	StatusHistory(ctx context.Context, userID string) ([]entity.StatusChange, error)
+}
+
+type RoleRepository interface {
+	Roles(ctx context.Context) ([]entity.Role, error)
+	SubjectPermissions(ctx context.Context, subject string) ([]string, []entity.Permission, error)
+	RoleBindings(ctx context.Context, subject string) ([]entity.RoleBinding, error)
+	BindRole(ctx context.Context, rb entity.RoleBinding) (entity.RoleBinding, error)
+	UnbindRole(ctx context.Context, subject, role string) error
+}
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key entity.APIKey) (entity.APIKey, error)
+	APIKeys(ctx context.Context) ([]entity.APIKey, error)
+	APIKeyByPrefix(ctx context.Context, prefix string) (entity.APIKey, error)
+	RotateAPIKey(ctx context.Context, id, prefix, hash string) (entity.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+type CredentialRepository interface {
+	SetPassword(ctx context.Context, userID, hash string) error
+	PasswordHash(ctx context.Context, userID string) (string, error)
+	CredentialsByUserName(ctx context.Context, userName string) (entity.User, string, error)
+}
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, sess entity.Session) (entity.Session, error)
+	SessionByID(ctx context.Context, id string) (entity.Session, entity.Status, error)
+	UserSessions(ctx context.Context, userID string) ([]entity.Session, error)
+	RevokeSession(ctx context.Context, userID, id string) error
+}
+
+type TokenRepository interface {
+	CreateUserToken(ctx context.Context, t entity.UserToken) error
+	ConsumeUserToken(ctx context.Context, id string, purpose entity.TokenPurpose) (entity.UserToken, error)
+}
+
+type MFARepository interface {
+	SaveTOTP(ctx context.Context, userID, secret string) error
+	MFAEnrollment(ctx context.Context, userID string) (entity.MFAEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error
+	UseRecoveryCode(ctx context.Context, userID, id string) error
+	DeleteMFA(ctx context.Context, userID string) error
+}
+
+type LockoutRepository interface {
+	LoginThrottles(ctx context.Context, keys ...string) ([]entity.LoginThrottle, error)
+	LockedLogins(ctx context.Context) ([]entity.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, key string) error
+}
+
+// ScheduledChangeRepository stores the changes of users applied at a later
+// time, only pending changes are listed, cancelled and applied
+type ScheduledChangeRepository interface {
+	ScheduleChange(ctx context.Context, change entity.ScheduledChange) (entity.ScheduledChange, error)
+	// ScheduledChanges lists the pending changes of a user or of every user
+	// when userID is empty, the earliest first
+	ScheduledChanges(ctx context.Context, userID string) ([]entity.ScheduledChange, error)
+	CancelScheduledChange(ctx context.Context, id, canceledBy string) error
+	DueScheduledChanges(ctx context.Context, now time.Time, limit int) ([]entity.ScheduledChange, error)
+	// ApplyScheduledChange applies a pending change and returns the changed
+	// user, a change that cannot be applied is marked failed
+	ApplyScheduledChange(ctx context.Context, id string) (entity.User, error)
+}
+
+type DepartmentRepository interface {
+	CreateDepartment(ctx context.Context, department entity.Department) (entity.Department, error)
+	Departments(ctx context.Context) ([]entity.Department, error)
+	DepartmentByID(ctx context.Context, id string) (entity.Department, error)
+	RenameDepartment(ctx context.Context, id, name string) (entity.Department, error)
+	// MergeDepartment moves the members and scheduled moves of a department
+	// to into and deletes it
+	MergeDepartment(ctx context.Context, id, into string) (entity.Department, error)
+	DeleteDepartment(ctx context.Context, id string) error
+	// MoveDepartment moves a department and everything below it under
+	// parentID, a nil parentID makes it a root
+	MoveDepartment(ctx context.Context, id string, parentID *string) (entity.Department, error)
+	DepartmentSubtree(ctx context.Context, id string) ([]entity.DepartmentNode, error)
+	DepartmentAncestors(ctx context.Context, id string) ([]entity.DepartmentNode, error)
+	// DepartmentUsers lists the users of a department and its subtree
+	DepartmentUsers(ctx context.Context, id string) ([]entity.User, error)
+}
+
+// ManagerRepository keeps who reports to whom, every user has at most one
+// manager and no user reports to themselves through their reports
+type ManagerRepository interface {
+	// SetManager makes managerID the manager of a user, nil removes it
+	SetManager(ctx context.Context, userID string, managerID *string) (model.Customer, error)
+	DirectReports(ctx context.Context, userID string) ([]entity.User, error)
+	// ReportingChain lists the managers of a user from its own up to the root
+	ReportingChain(ctx context.Context, userID string) ([]entity.User, error)
+	OrgChart(ctx context.Context) ([]entity.OrgNode, error)
+}
+
+// GroupRepository keeps groups and their members. Static members are added
+// and removed by hand, the dynamic members are set by whoever evaluates the
+// rules of the groups.
+type GroupRepository interface {
+	CreateGroup(ctx context.Context, group entity.Group) (entity.Group, error)
+	Groups(ctx context.Context) ([]entity.Group, error)
+	GroupByID(ctx context.Context, id string) (entity.Group, error)
+	// UpdateGroup replaces the name, description and rules of a group
+	UpdateGroup(ctx context.Context, group entity.Group) (entity.Group, error)
+	DeleteGroup(ctx context.Context, id string) error
+	AddStaticMember(ctx context.Context, groupID, userID string) error
+	RemoveStaticMember(ctx context.Context, groupID, userID string) error
+	GroupMembers(ctx context.Context, groupID string) ([]entity.GroupMember, error)
+	UserGroups(ctx context.Context, userID string) ([]entity.UserGroup, error)
+	// SetUserDynamicGroups makes a user a dynamic member of exactly groupIDs
+	SetUserDynamicGroups(ctx context.Context, userID string, groupIDs []string) error
+	// SetGroupDynamicMembers makes exactly userIDs the dynamic members of a group
+	SetGroupDynamicMembers(ctx context.Context, groupID string, userIDs []string) error
+}
+
+// OutboxRepository hands the events recorded with the changes of users to
+// the relay publishing them
+type OutboxRepository interface {
+	// PublishEvents passes at most limit unpublished events to publish, the
+	// oldest first, and returns how many it accepted. publish is called
+	// outside of any transaction. The events of a user after one publish
+	// failed for are held back until it is accepted.
+	PublishEvents(ctx context.Context, limit int, publish func(entity.Event) error) (int, error)
+}
+
+// WebhookRepository keeps the webhooks and the deliveries of events to them
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error)
+	Webhooks(ctx context.Context) ([]entity.Webhook, error)
+	WebhookByID(ctx context.Context, id string) (entity.Webhook, error)
+	// UpdateWebhook replaces the url and event types of a webhook
+	UpdateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error)
+	RotateWebhookSecret(ctx context.Context, id, secret string) (entity.Webhook, error)
+	// SealWebhookSecrets replaces the secrets stored before they were sealed,
+	// those starting with entity.WebhookSecretPrefix, with what seal returns
+	// and returns how many it replaced
+	SealWebhookSecrets(ctx context.Context, seal func(secret string) (string, error)) (int, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	// EnqueueDeliveries adds a pending delivery of event to every webhook
+	// subscribing to its type, deliveries of the event enqueued before are kept
+	EnqueueDeliveries(ctx context.Context, event entity.Event) error
+	// ClaimDeliveries returns at most limit pending deliveries due at now and
+	// defers them by lease so nobody else attempts them meanwhile
+	ClaimDeliveries(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]entity.WebhookDelivery, error)
+	// RecordAttempt stores the status, attempts and outcome of a delivery
+	RecordAttempt(ctx context.Context, delivery entity.WebhookDelivery) error
+	// WebhookDeliveries lists the latest deliveries of a webhook, of every
+	// status when status is empty
+	WebhookDeliveries(ctx context.Context, webhookID string, status entity.DeliveryStatus) ([]entity.WebhookDelivery, error)
+	// ReplayDeliveries makes the dead deliveries of a webhook pending again,
+	// every one of them when deliveryID is empty, and returns how many
+	ReplayDeliveries(ctx context.Context, webhookID, deliveryID string) (int, error)
 }
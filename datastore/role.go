@@ -0,0 +1,140 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/jackc/pgconn"
+	"golang.org/x/exp/slog"
+)
+
+// Roles returns every role with its permissions
+func (s *Store) Roles(ctx context.Context) ([]entity.Role, error) {
+	rows, err := s.SQLBuilder.Select("r.name, r.description, rp.permission").
+		From(rolesSchema+" r").
+		LeftJoin(rolePermissionsSchema+" rp ON rp.role = r.name").
+		OrderBy("r.name", "rp.permission").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchRole, err)
+	}
+	defer s.closeRows(ctx, rows)
+
+	var roles []entity.Role
+	for rows.Next() {
+		var (
+			name, description string
+			permission        sql.NullString
+		)
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchRole, err)
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, entity.Role{Name: name, Description: description})
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, entity.Permission(permission.String))
+		}
+	}
+	return roles, rows.Err()
+}
+
+// SubjectPermissions returns the roles bound to subject and the union of their permissions
+func (s *Store) SubjectPermissions(ctx context.Context, subject string) ([]string, []entity.Permission, error) {
+	rows, err := s.SQLBuilder.Select("rb.role, rp.permission").
+		From(roleBindingsSchema + " rb").
+		LeftJoin(rolePermissionsSchema + " rp ON rp.role = rb.role").
+		Where(squirrel.Eq{"rb.subject": subject}).
+		OrderBy("rb.role").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf(errorMsg, ErrFetchRole, err)
+	}
+	defer s.closeRows(ctx, rows)
+
+	var (
+		roles       []string
+		permissions []entity.Permission
+		seen        = make(map[string]bool)
+	)
+	for rows.Next() {
+		var (
+			role       string
+			permission sql.NullString
+		)
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, nil, fmt.Errorf(errorMsg, ErrFetchRole, err)
+		}
+		if len(roles) == 0 || roles[len(roles)-1] != role {
+			roles = append(roles, role)
+		}
+		if permission.Valid && !seen[permission.String] {
+			seen[permission.String] = true
+			permissions = append(permissions, entity.Permission(permission.String))
+		}
+	}
+	return roles, permissions, rows.Err()
+}
+
+// RoleBindings lists the bindings, of a single subject when subject is not empty
+func (s *Store) RoleBindings(ctx context.Context, subject string) ([]entity.RoleBinding, error) {
+	q := s.SQLBuilder.Select("subject, role, created_by, created_at").
+		From(roleBindingsSchema).
+		OrderBy("subject", "role")
+	if subject != "" {
+		q = q.Where(squirrel.Eq{"subject": subject})
+	}
+	rows, err := q.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchRole, err)
+	}
+	defer s.closeRows(ctx, rows)
+
+	var bindings []entity.RoleBinding
+	for rows.Next() {
+		var rb entity.RoleBinding
+		if err := rows.Scan(&rb.Subject, &rb.Role, &rb.CreatedBy, &rb.CreatedAt); err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchRole, err)
+		}
+		bindings = append(bindings, rb)
+	}
+	return bindings, rows.Err()
+}
+
+// BindRole grants rb.Role to rb.Subject, binding an already bound role is a no-op
+func (s *Store) BindRole(ctx context.Context, rb entity.RoleBinding) (entity.RoleBinding, error) {
+	row := s.SQLBuilder.Insert(roleBindingsSchema).SetMap(map[string]any{
+		"subject":    rb.Subject,
+		"role":       rb.Role,
+		"created_by": rb.CreatedBy,
+	}).Suffix(`ON CONFLICT ("subject", "role") DO UPDATE SET "subject" = EXCLUDED."subject" RETURNING "created_by", "created_at"`).
+		QueryRowContext(ctx)
+	if err := row.Scan(&rb.CreatedBy, &rb.CreatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+			return entity.RoleBinding{}, fmt.Errorf(errorMsg, ErrRoleNotFound, rb.Role)
+		}
+		return entity.RoleBinding{}, fmt.Errorf(errorMsg, ErrBindRole, err)
+	}
+	s.Logger.InfoCtx(ctx, "role bound", slog.String("subject", rb.Subject), slog.String("role", rb.Role))
+	return rb, nil
+}
+
+// UnbindRole revokes role from subject
+func (s *Store) UnbindRole(ctx context.Context, subject, role string) error {
+	res, err := s.SQLBuilder.Delete(roleBindingsSchema).
+		Where(squirrel.Eq{"subject": subject, "role": role}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrUnbindRole, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrRoleBindingNotFound
+	}
+	s.Logger.InfoCtx(ctx, "role unbound", slog.String("subject", subject), slog.String("role", role))
+	return nil
+}
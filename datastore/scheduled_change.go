@@ -0,0 +1,235 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"strconv"
+	"time"
+)
+
+const pendingChangeColumns = "c.id, c.user_id, c.to_status, d.name, c.effective_at, c.reason, c.created_by, c.created_at"
+
+// pending matches the changes neither applied, cancelled nor failed
+var pending = squirrel.Eq{"applied_at": nil, "canceled_at": nil, "failed_at": nil}
+
+// ScheduleChange stores a change applied once its effective time has passed
+func (s *Store) ScheduleChange(ctx context.Context, change entity.ScheduledChange) (entity.ScheduledChange, error) {
+	var status, departmentID any
+	if change.Status != nil {
+		status = statusWrapper(*change.Status)
+	}
+	if change.Department != nil {
+		id, name, err := s.departmentID(ctx, s.DB, *change.Department)
+		if err != nil {
+			return entity.ScheduledChange{}, err
+		}
+		departmentID, change.Department = id, &name
+	}
+	err := s.SQLBuilder.Insert(pendingChangesSchema).SetMap(map[string]any{
+		"user_id":       change.UserID,
+		"to_status":     status,
+		"department_id": departmentID,
+		"effective_at":  change.EffectiveAt,
+		"reason":        change.Reason,
+		"created_by":    change.CreatedBy,
+	}).Suffix(`RETURNING "id", "created_at"`).
+		QueryRowContext(ctx).
+		Scan(&change.ID, &change.CreatedAt)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return entity.ScheduledChange{}, ErrCustomerNotFound
+		}
+		return entity.ScheduledChange{}, fmt.Errorf(errorMsg, ErrScheduleChange, err)
+	}
+	return change, nil
+}
+
+func (s *Store) ScheduledChanges(ctx context.Context, userID string) ([]entity.ScheduledChange, error) {
+	query := s.pendingChanges()
+	if userID != "" {
+		if _, err := strconv.ParseInt(userID, 10, 64); err != nil {
+			return []entity.ScheduledChange{}, nil
+		}
+		query = query.Where(squirrel.Eq{"c.user_id": userID})
+	}
+	rows, err := query.OrderBy("c.effective_at", "c.id").QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchScheduledChange, err)
+	}
+	return s.scanScheduledChanges(ctx, rows)
+}
+
+// CancelScheduledChange cancels a pending change, applied, failed and
+// cancelled changes are not found
+func (s *Store) CancelScheduledChange(ctx context.Context, id, canceledBy string) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return ErrPendingChangeNotFound
+	}
+	res, err := s.SQLBuilder.Update(pendingChangesSchema).
+		Set("canceled_at", squirrel.Expr("now()")).
+		Set("canceled_by", canceledBy).
+		Where(pending).
+		Where(squirrel.Eq{"id": id}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrScheduleChange, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrPendingChangeNotFound
+	}
+	return nil
+}
+
+// DueScheduledChanges lists at most limit pending changes effective at now
+func (s *Store) DueScheduledChanges(ctx context.Context, now time.Time, limit int) ([]entity.ScheduledChange, error) {
+	rows, err := s.pendingChanges().
+		Where(squirrel.LtOrEq{"c.effective_at": now}).
+		OrderBy("c.effective_at", "c.id").
+		Limit(uint64(limit)).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchScheduledChange, err)
+	}
+	return s.scanScheduledChanges(ctx, rows)
+}
+
+// ApplyScheduledChange applies a pending change to its user and records the
+// status change. The change is locked so concurrent workers apply it once,
+// a change the status transitions do not allow is marked failed.
+func (s *Store) ApplyScheduledChange(ctx context.Context, id string) (entity.User, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.User{}, ErrPendingChangeNotFound
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	row := s.pendingChanges().
+		Where(squirrel.Eq{"c.id": id}).
+		Suffix("FOR UPDATE OF c SKIP LOCKED").
+		RunWith(tx).QueryRowContext(ctx)
+	change, err := scanScheduledChange(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.User{}, ErrPendingChangeNotFound
+		}
+		return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+	}
+	// terminating reassigns the reports of the user
+	if change.Status != nil && *change.Status == entity.Terminated {
+		if err := lockChart(ctx, tx); err != nil {
+			return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+		}
+	}
+	cus, err := s.lockUser(ctx, tx, change.UserID)
+	if err != nil {
+		return entity.User{}, err
+	}
+	current := cus.GetExportedCustomer().User
+	next := change.Apply(current)
+	if err := current.UserStatus.Transition(next.UserStatus); err != nil {
+		_, failErr := s.SQLBuilder.Update(pendingChangesSchema).
+			Set("failed_at", squirrel.Expr("now()")).
+			Set("failure", err.Error()).
+			Where(squirrel.Eq{"id": id}).
+			RunWith(tx).ExecContext(ctx)
+		if failErr == nil {
+			failErr = tx.Commit()
+		}
+		if failErr != nil {
+			return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, failErr)
+		}
+		return entity.User{}, err
+	}
+	update := s.SQLBuilder.Update(usersSchema).Set("user_status", statusWrapper(next.UserStatus))
+	if change.Department != nil {
+		departmentID, _, err := s.departmentID(ctx, tx, *change.Department)
+		if err != nil {
+			return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+		}
+		update = update.Set("department_id", departmentID)
+	}
+	_, err = update.Where(squirrel.Eq{"id": change.UserID}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+	}
+	if change.Department != nil {
+		if err := s.recordEvents(ctx, tx, entity.EventUserUpdated, nil, change.UserID); err != nil {
+			return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+		}
+	}
+	if _, err := s.recordStatusChange(ctx, tx, entity.StatusChange{
+		UserID:    change.UserID,
+		From:      current.UserStatus,
+		To:        next.UserStatus,
+		Reason:    change.Reason,
+		ChangedBy: change.CreatedBy,
+	}); err != nil {
+		return entity.User{}, err
+	}
+	_, err = s.SQLBuilder.Update(pendingChangesSchema).
+		Set("applied_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": id}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return entity.User{}, fmt.Errorf(errorMsg, ErrApplyScheduledChange, err)
+	}
+	s.Logger.InfoCtx(ctx, "scheduled change applied", slog.String("id", id), slog.String("user_id", change.UserID))
+	return next, nil
+}
+
+func (s *Store) pendingChanges() squirrel.SelectBuilder {
+	return s.SQLBuilder.Select(pendingChangeColumns).
+		From(pendingChangesSchema + " c").
+		LeftJoin(departmentsSchema + " d ON d.id = c.department_id").
+		Where(pending)
+}
+
+func (s *Store) scanScheduledChanges(ctx context.Context, rows *sql.Rows) ([]entity.ScheduledChange, error) {
+	defer s.closeRows(ctx, rows)
+	changes := make([]entity.ScheduledChange, 0)
+	for rows.Next() {
+		change, err := scanScheduledChange(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchScheduledChange, err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchScheduledChange, err)
+	}
+	return changes, nil
+}
+
+func scanScheduledChange(row squirrel.RowScanner) (entity.ScheduledChange, error) {
+	var (
+		c          entity.ScheduledChange
+		status     sql.NullString
+		department sql.NullString
+	)
+	err := row.Scan(&c.ID, &c.UserID, &status, &department, &c.EffectiveAt, &c.Reason, &c.CreatedBy, &c.CreatedAt)
+	if err != nil {
+		return entity.ScheduledChange{}, err
+	}
+	if status.Valid {
+		to, err := entity.StatusFromCode(status.String)
+		if err != nil {
+			return entity.ScheduledChange{}, err
+		}
+		c.Status = &to
+	}
+	if department.Valid {
+		c.Department = &department.String
+	}
+	return c, nil
+}
@@ -0,0 +1,109 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+)
+
+const sessionColumns = "s.id, s.user_id, s.ip, s.user_agent, s.created_at, s.expires_at, s.revoked_at, s.mfa"
+
+func (s *Store) CreateSession(ctx context.Context, sess entity.Session) (entity.Session, error) {
+	row := s.SQLBuilder.Insert(sessionsSchema).SetMap(map[string]any{
+		"id":         sess.ID,
+		"user_id":    sess.UserID,
+		"ip":         sess.IP,
+		"user_agent": sess.UserAgent,
+		"expires_at": sess.ExpiresAt,
+		"mfa":        sess.MFA,
+	}).Suffix(`RETURNING "created_at"`).QueryRowContext(ctx)
+	if err := row.Scan(&sess.CreatedAt); err != nil {
+		return entity.Session{}, fmt.Errorf(errorMsg, ErrCreateSession, err)
+	}
+	s.Logger.InfoCtx(ctx, "session created", slog.String("user_id", sess.UserID))
+	return sess, nil
+}
+
+// SessionByID returns a session with the current status of its user
+func (s *Store) SessionByID(ctx context.Context, id string) (entity.Session, entity.Status, error) {
+	var status entity.Status
+	row := s.SQLBuilder.Select(sessionColumns + ", u.user_status").
+		From(sessionsSchema + " s").
+		Join(usersSchema + " u ON u.id = s.user_id").
+		Where(squirrel.Eq{"s.id": id}).
+		QueryRowContext(ctx)
+	sess, err := scanSession(row, (*statusWrapper)(&status))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.Session{}, status, ErrSessionNotFound
+		}
+		return entity.Session{}, status, fmt.Errorf(errorMsg, ErrFetchSession, err)
+	}
+	return sess, status, nil
+}
+
+// UserSessions lists the sessions of a user that are neither revoked nor expired
+func (s *Store) UserSessions(ctx context.Context, userID string) ([]entity.Session, error) {
+	rows, err := s.SQLBuilder.Select(sessionColumns).
+		From(sessionsSchema + " s").
+		Where(squirrel.Eq{"s.user_id": userID, "s.revoked_at": nil}).
+		Where("s.expires_at > now()").
+		OrderBy("s.created_at DESC").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchSession, err)
+	}
+	defer s.closeRows(ctx, rows)
+
+	var sessions []entity.Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchSession, err)
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes a session of a user, every session when id is empty
+func (s *Store) RevokeSession(ctx context.Context, userID, id string) error {
+	where := squirrel.Eq{"user_id": userID, "revoked_at": nil}
+	if id != "" {
+		where["id"] = id
+	}
+	res, err := s.SQLBuilder.Update(sessionsSchema).
+		Set("revoked_at", squirrel.Expr("now()")).
+		Where(where).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrRevokeSession, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 && id != "" {
+		return ErrSessionNotFound
+	}
+	s.Logger.InfoCtx(ctx, "session revoked", slog.String("user_id", userID))
+	return nil
+}
+
+func scanSession(row squirrel.RowScanner, extra ...any) (entity.Session, error) {
+	var sess entity.Session
+	dest := append([]any{
+		&sess.ID,
+		&sess.UserID,
+		&sess.IP,
+		&sess.UserAgent,
+		&sess.CreatedAt,
+		&sess.ExpiresAt,
+		&sess.RevokedAt,
+		&sess.MFA,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return entity.Session{}, err
+	}
+	return sess, nil
+}
@@ -0,0 +1,145 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"golang.org/x/exp/slog"
+	"strconv"
+)
+
+const statusHistoryColumns = "id, user_id, from_status, to_status, reason, changed_by, changed_at"
+
+// ChangeStatus moves a user to change.To and records who changed it and
+// why. Keeping the current status changes and records nothing.
+func (s *Store) ChangeStatus(ctx context.Context, change entity.StatusChange) (model.Customer, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// terminating reassigns the reports of the user
+	if change.To == entity.Terminated {
+		if err := lockChart(ctx, tx); err != nil {
+			return model.Customer{}, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+		}
+	}
+	cus, err := s.lockUser(ctx, tx, change.UserID)
+	if err != nil {
+		return model.Customer{}, err
+	}
+	change.From = cus.GetUserStatus()
+	if err := change.From.Transition(change.To); err != nil {
+		return model.Customer{}, err
+	}
+	if change.From == change.To {
+		return cus, nil
+	}
+	_, err = s.SQLBuilder.Update(usersSchema).
+		Set("user_status", statusWrapper(change.To)).
+		Where(squirrel.Eq{"id": change.UserID}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+	}
+	if _, err := s.recordStatusChange(ctx, tx, change); err != nil {
+		return model.Customer{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+	}
+	user := cus.GetExportedCustomer().User
+	user.UserStatus = change.To
+	return model.AddCustomer(&user), nil
+}
+
+// StatusHistory lists the status changes of a user, oldest first
+func (s *Store) StatusHistory(ctx context.Context, userID string) ([]entity.StatusChange, error) {
+	if _, err := s.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.SQLBuilder.Select(statusHistoryColumns).
+		From(statusHistorySchema).
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("changed_at", "id").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchStatusHistory, err)
+	}
+	defer s.closeRows(ctx, rows)
+	history := make([]entity.StatusChange, 0)
+	for rows.Next() {
+		var c entity.StatusChange
+		err := rows.Scan(&c.ID, &c.UserID, (*statusWrapper)(&c.From), (*statusWrapper)(&c.To),
+			&c.Reason, &c.ChangedBy, &c.ChangedAt)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchStatusHistory, err)
+		}
+		history = append(history, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchStatusHistory, err)
+	}
+	return history, nil
+}
+
+// lockUser returns a user and locks its row until the end of tx so
+// concurrent changes of its status are serialized
+func (s *Store) lockUser(ctx context.Context, tx *sql.Tx, id string) (model.Customer, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return model.Customer{}, ErrCustomerNotFound
+	}
+	// the view cannot be locked, its department is outer joined
+	_, err := s.SQLBuilder.Select("id").From(usersSchema).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("FOR UPDATE").
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return model.Customer{}, fmt.Errorf(errorMsg, ErrFetchCustomer, err)
+	}
+	row := s.SQLBuilder.Select(userColumns).From(usersView).
+		Where(squirrel.Eq{"id": id}).
+		RunWith(tx).QueryRowContext(ctx)
+	return s.getOne(row)
+}
+
+// recordStatusChange appends change to the status history unless the status
+// is kept. The caller defaults to the principal of the request. Every status
+// change passes here, so its event is recorded and terminated users hand
+// their reports to their own manager here too.
+func (s *Store) recordStatusChange(ctx context.Context, tx *sql.Tx, change entity.StatusChange) (entity.StatusChange, error) {
+	if change.From == change.To {
+		return change, nil
+	}
+	if change.ChangedBy == "" {
+		change.ChangedBy = custom_slog.FieldsFromContext(ctx).Principal
+	}
+	err := s.SQLBuilder.Insert(statusHistorySchema).SetMap(map[string]any{
+		"user_id":     change.UserID,
+		"from_status": statusWrapper(change.From),
+		"to_status":   statusWrapper(change.To),
+		"reason":      change.Reason,
+		"changed_by":  change.ChangedBy,
+	}).Suffix(`RETURNING "id", "changed_at"`).
+		RunWith(tx).QueryRowContext(ctx).
+		Scan(&change.ID, &change.ChangedAt)
+	if err != nil {
+		return change, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+	}
+	if err := s.recordEvents(ctx, tx, entity.EventUserStatusChanged, &change, change.UserID); err != nil {
+		return change, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+	}
+	if change.To == entity.Terminated {
+		if err := s.reassignReports(ctx, tx, change.UserID); err != nil {
+			return change, fmt.Errorf(errorMsg, ErrChangeStatus, err)
+		}
+	}
+	s.Logger.InfoCtx(ctx, "user status changed", slog.String("user_id", change.UserID),
+		slog.String("from", change.From.String()), slog.String("to", change.To.String()))
+	return change, nil
+}
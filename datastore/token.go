@@ -0,0 +1,61 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+)
+
+// CreateUserToken stores a token, invalidating the unused tokens of the
+// same user and purpose so only the latest one can be redeemed
+func (s *Store) CreateUserToken(ctx context.Context, t entity.UserToken) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrCreateToken, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = s.SQLBuilder.Update(userTokensSchema).
+		Set("used_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"user_id": t.UserID, "purpose": t.Purpose, "used_at": nil}).
+		RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrCreateToken, err)
+	}
+	_, err = s.SQLBuilder.Insert(userTokensSchema).SetMap(map[string]any{
+		"id":         t.ID,
+		"user_id":    t.UserID,
+		"purpose":    t.Purpose,
+		"email":      t.Email,
+		"expires_at": t.ExpiresAt,
+	}).RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrCreateToken, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf(errorMsg, ErrCreateToken, err)
+	}
+	return nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it
+func (s *Store) ConsumeUserToken(ctx context.Context, id string, purpose entity.TokenPurpose) (entity.UserToken, error) {
+	t := entity.UserToken{ID: id, Purpose: purpose}
+	err := s.SQLBuilder.Update(userTokensSchema).
+		Set("used_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": id, "purpose": purpose, "used_at": nil}).
+		Where("expires_at > now()").
+		Suffix(`RETURNING "user_id", "email", "expires_at"`).
+		QueryRowContext(ctx).
+		Scan(&t.UserID, &t.Email, &t.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.UserToken{}, ErrInvalidToken
+		}
+		return entity.UserToken{}, fmt.Errorf(errorMsg, ErrInvalidToken, err)
+	}
+	return t, nil
+}
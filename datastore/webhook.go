@@ -0,0 +1,342 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	webhookColumns  = "id, url, event_types, secret, created_by, created_at"
+	deliveryColumns = "id, webhook_id, event_id, event_type, status, attempts, next_attempt_at, last_status_code, " +
+		"last_error, created_at, delivered_at, payload"
+	// deliveriesListed bounds the deliveries listed per webhook
+	deliveriesListed = 100
+)
+
+func (s *Store) CreateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	err := s.SQLBuilder.Insert(webhooksSchema).SetMap(map[string]any{
+		"url":         webhook.URL,
+		"event_types": joinEventTypes(webhook.EventTypes),
+		"secret":      webhook.Secret,
+		"created_by":  webhook.CreatedBy,
+	}).Suffix(`RETURNING "id", "created_at"`).
+		QueryRowContext(ctx).
+		Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return entity.Webhook{}, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	s.Logger.InfoCtx(ctx, "webhook created", slog.String("webhook_id", webhook.ID))
+	return webhook, nil
+}
+
+func (s *Store) Webhooks(ctx context.Context) ([]entity.Webhook, error) {
+	rows, err := s.SQLBuilder.Select(webhookColumns).From(webhooksSchema).OrderBy("id").QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	defer s.closeRows(ctx, rows)
+	webhooks := make([]entity.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	return webhooks, nil
+}
+
+func (s *Store) WebhookByID(ctx context.Context, id string) (entity.Webhook, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.Webhook{}, ErrWebhookNotFound
+	}
+	row := s.SQLBuilder.Select(webhookColumns).From(webhooksSchema).
+		Where(squirrel.Eq{"id": id}).QueryRowContext(ctx)
+	return s.oneWebhook(row)
+}
+
+func (s *Store) UpdateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	if _, err := strconv.ParseInt(webhook.ID, 10, 64); err != nil {
+		return entity.Webhook{}, ErrWebhookNotFound
+	}
+	row := s.SQLBuilder.Update(webhooksSchema).SetMap(map[string]any{
+		"url":         webhook.URL,
+		"event_types": joinEventTypes(webhook.EventTypes),
+	}).Where(squirrel.Eq{"id": webhook.ID}).
+		Suffix("RETURNING " + webhookColumns).
+		QueryRowContext(ctx)
+	return s.oneWebhook(row)
+}
+
+// RotateWebhookSecret replaces the secret signing the deliveries of a webhook
+func (s *Store) RotateWebhookSecret(ctx context.Context, id, secret string) (entity.Webhook, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.Webhook{}, ErrWebhookNotFound
+	}
+	row := s.SQLBuilder.Update(webhooksSchema).
+		Set("secret", secret).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING " + webhookColumns).
+		QueryRowContext(ctx)
+	return s.oneWebhook(row)
+}
+
+func (s *Store) SealWebhookSecrets(ctx context.Context, seal func(secret string) (string, error)) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	plain, err := s.plainWebhookSecrets(ctx, tx)
+	if err != nil {
+		return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	for id, secret := range plain {
+		sealed, err := seal(secret)
+		if err != nil {
+			return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+		}
+		_, err = s.SQLBuilder.Update(webhooksSchema).
+			Set("secret", sealed).
+			Where(squirrel.Eq{"id": id}).
+			RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	return len(plain), nil
+}
+
+// DeleteWebhook deletes a webhook and its deliveries
+func (s *Store) DeleteWebhook(ctx context.Context, id string) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return ErrWebhookNotFound
+	}
+	res, err := s.SQLBuilder.Delete(webhooksSchema).Where(squirrel.Eq{"id": id}).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *Store) EnqueueDeliveries(ctx context.Context, event entity.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	subscribers := s.SQLBuilder.Select("id").
+		Column(squirrel.Expr("?::bigint", event.ID)).
+		Column(squirrel.Expr("?", string(event.Type))).
+		Column(squirrel.Expr("?::jsonb", string(payload))).
+		From(webhooksSchema).
+		Where(squirrel.Or{
+			squirrel.Eq{"event_types": ""},
+			squirrel.Expr("?::text = ANY(string_to_array(event_types, ' '))", string(event.Type)),
+		})
+	_, err = s.SQLBuilder.Insert(deliveriesSchema).
+		Columns("webhook_id", "event_id", "event_type", "payload").
+		Select(subscribers).
+		Suffix("ON CONFLICT (webhook_id, event_id) DO NOTHING").
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	return nil
+}
+
+// ClaimDeliveries skips the deliveries claimed by others, a delivery whose
+// attempt never records its outcome is attempted again once lease passed
+func (s *Store) ClaimDeliveries(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]entity.WebhookDelivery, error) {
+	due, args, err := squirrel.Select("id").From(deliveriesSchema).
+		Where(squirrel.Eq{"status": string(entity.DeliveryPending)}).
+		Where(squirrel.LtOrEq{"next_attempt_at": now}).
+		OrderBy("next_attempt_at", "id").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	rows, err := s.SQLBuilder.Update(deliveriesSchema).
+		Set("next_attempt_at", now.Add(lease)).
+		Where("id IN ("+due+")", args...).
+		Suffix("RETURNING " + deliveryColumns).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	return s.scanDeliveries(ctx, rows)
+}
+
+func (s *Store) RecordAttempt(ctx context.Context, delivery entity.WebhookDelivery) error {
+	changes := map[string]any{
+		"status":           string(delivery.Status),
+		"attempts":         delivery.Attempts,
+		"last_status_code": delivery.LastStatusCode,
+		"last_error":       delivery.LastError,
+		"delivered_at":     delivery.DeliveredAt,
+	}
+	if delivery.NextAttemptAt != nil {
+		changes["next_attempt_at"] = *delivery.NextAttemptAt
+	}
+	_, err := s.SQLBuilder.Update(deliveriesSchema).
+		SetMap(changes).
+		Where(squirrel.Eq{"id": delivery.ID}).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	return nil
+}
+
+// WebhookDeliveries lists the latest deliveries of a webhook, newest first
+func (s *Store) WebhookDeliveries(ctx context.Context, webhookID string, status entity.DeliveryStatus) ([]entity.WebhookDelivery, error) {
+	if _, err := s.WebhookByID(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	query := s.SQLBuilder.Select(deliveryColumns).
+		From(deliveriesSchema).
+		Where(squirrel.Eq{"webhook_id": webhookID})
+	if status != "" {
+		query = query.Where(squirrel.Eq{"status": string(status)})
+	}
+	rows, err := query.OrderBy("id DESC").Limit(deliveriesListed).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	return s.scanDeliveries(ctx, rows)
+}
+
+// ReplayDeliveries gives the dead deliveries of a webhook every attempt again
+func (s *Store) ReplayDeliveries(ctx context.Context, webhookID, deliveryID string) (int, error) {
+	if _, err := s.WebhookByID(ctx, webhookID); err != nil {
+		return 0, err
+	}
+	dead := squirrel.Eq{"webhook_id": webhookID, "status": string(entity.DeliveryDead)}
+	if deliveryID != "" {
+		if _, err := strconv.ParseInt(deliveryID, 10, 64); err != nil {
+			return 0, ErrDeliveryNotFound
+		}
+		dead["id"] = deliveryID
+	}
+	res, err := s.SQLBuilder.Update(deliveriesSchema).
+		Set("status", string(entity.DeliveryPending)).
+		Set("attempts", 0).
+		Set("next_attempt_at", squirrel.Expr("now()")).
+		Where(dead).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf(errorMsg, ErrSaveWebhook, err)
+	}
+	if n == 0 && deliveryID != "" {
+		return 0, ErrDeliveryNotFound
+	}
+	s.Logger.InfoCtx(ctx, "webhook deliveries replayed", slog.String("webhook_id", webhookID), slog.Int64("count", n))
+	return int(n), nil
+}
+
+// plainWebhookSecrets returns the secrets stored before they were sealed by
+// webhook id, their rows are locked so a secret rotated meanwhile is kept
+func (s *Store) plainWebhookSecrets(ctx context.Context, tx *sql.Tx) (map[string]string, error) {
+	rows, err := s.SQLBuilder.Select("id", "secret").From(webhooksSchema).
+		Where("left(secret, ?) = ?", len(entity.WebhookSecretPrefix), entity.WebhookSecretPrefix).
+		Suffix("FOR UPDATE").
+		RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.closeRows(ctx, rows)
+	plain := map[string]string{}
+	for rows.Next() {
+		var id, secret string
+		if err := rows.Scan(&id, &secret); err != nil {
+			return nil, err
+		}
+		plain[id] = secret
+	}
+	return plain, rows.Err()
+}
+
+func (s *Store) oneWebhook(row squirrel.RowScanner) (entity.Webhook, error) {
+	webhook, err := scanWebhook(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.Webhook{}, ErrWebhookNotFound
+		}
+		return entity.Webhook{}, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	return webhook, nil
+}
+
+func scanWebhook(row squirrel.RowScanner) (entity.Webhook, error) {
+	var (
+		webhook    entity.Webhook
+		eventTypes string
+	)
+	err := row.Scan(&webhook.ID, &webhook.URL, &eventTypes, &webhook.Secret, &webhook.CreatedBy, &webhook.CreatedAt)
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	webhook.EventTypes = make([]entity.EventType, 0)
+	for _, t := range strings.Fields(eventTypes) {
+		webhook.EventTypes = append(webhook.EventTypes, entity.EventType(t))
+	}
+	return webhook, nil
+}
+
+func (s *Store) scanDeliveries(ctx context.Context, rows *sql.Rows) ([]entity.WebhookDelivery, error) {
+	defer s.closeRows(ctx, rows)
+	deliveries := make([]entity.WebhookDelivery, 0)
+	for rows.Next() {
+		var (
+			d           entity.WebhookDelivery
+			nextAttempt time.Time
+			payload     []byte
+		)
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &nextAttempt,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt, &payload)
+		if err != nil {
+			return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+		}
+		if d.Status == entity.DeliveryPending {
+			d.NextAttemptAt = &nextAttempt
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(errorMsg, ErrFetchWebhook, err)
+	}
+	return deliveries, nil
+}
+
+func joinEventTypes(types []entity.EventType) string {
+	out := make([]string, len(types))
+	for i, t := range types {
+		out[i] = string(t)
+	}
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,122 @@
+package datastore
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimDeliveries(t *testing.T) {
+	ctx := context.Background()
+	every := newWebhook(t)
+	deleted := newWebhook(t, entity.EventUserDeleted)
+
+	event := entity.Event{ID: "1001", Type: entity.EventUserCreated, UserID: "1"}
+	// the relay may publish an event more than once
+	for i := 0; i < 2; i++ {
+		require.NoError(t, store.EnqueueDeliveries(ctx, event))
+	}
+	deliveries, err := store.WebhookDeliveries(ctx, every.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, event.ID, deliveries[0].EventID)
+	deliveries, err = store.WebhookDeliveries(ctx, deleted.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	now := time.Now()
+	claimed, err := store.ClaimDeliveries(ctx, now, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, every.ID, claimed[0].WebhookID)
+
+	claimed, err = store.ClaimDeliveries(ctx, now, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	// a claim whose attempt was never recorded is claimed again after its lease
+	claimed, err = store.ClaimDeliveries(ctx, now.Add(2*time.Minute), 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	delivery := claimed[0]
+	delivery.Status, delivery.Attempts, delivery.LastStatusCode = entity.DeliverySucceeded, 1, 204
+	delivery.NextAttemptAt, delivery.DeliveredAt = nil, &now
+	require.NoError(t, store.RecordAttempt(ctx, delivery))
+	claimed, err = store.ClaimDeliveries(ctx, now.Add(time.Hour), 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestClaimDeliveriesConcurrently claims deliveries from several
+// dispatchers at once, every delivery is claimed by exactly one of them
+func TestClaimDeliveriesConcurrently(t *testing.T) {
+	ctx := context.Background()
+	webhook := newWebhook(t)
+	const events = 20
+	for i := 0; i < events; i++ {
+		event := entity.Event{ID: strconv.Itoa(2000 + i), Type: entity.EventUserUpdated, UserID: "1"}
+		require.NoError(t, store.EnqueueDeliveries(ctx, event))
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		claimed = map[string]int{}
+	)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliveries, err := store.ClaimDeliveries(ctx, now, events, time.Minute)
+			assert.NoError(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			for _, delivery := range deliveries {
+				if delivery.WebhookID == webhook.ID {
+					claimed[delivery.ID]++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, claimed, events)
+	for id, n := range claimed {
+		assert.Equal(t, 1, n, "delivery %s claimed more than once", id)
+	}
+}
+
+func newWebhook(t *testing.T, types ...entity.EventType) entity.Webhook {
+	t.Helper()
+	webhook, err := store.CreateWebhook(context.Background(), entity.Webhook{
+		URL:        "https://example.com/hook",
+		EventTypes: types,
+		Secret:     "secret",
+	})
+	require.NoError(t, err)
+	return webhook
+}
+
+func TestSealWebhookSecrets(t *testing.T) {
+	ctx := context.Background()
+	webhook, err := store.CreateWebhook(ctx, entity.Webhook{URL: "https://example.com/plain", Secret: "whsec_plain"})
+	require.NoError(t, err)
+	seal := func(secret string) (string, error) { return "sealed " + secret, nil }
+
+	n, err := store.SealWebhookSecrets(ctx, seal)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	webhook, err = store.WebhookByID(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "sealed whsec_plain", webhook.Secret)
+
+	n, err = store.SealWebhookSecrets(ctx, seal)
+	require.NoError(t, err)
+	assert.Zero(t, n, "sealed secrets are not sealed again")
+}
@@ -0,0 +1,26 @@
+package entity
+
+import "time"
+
+// APIKey authenticates a non-interactive client, only its hash is stored.
+// Its scopes are the permissions granted to the client.
+type APIKey struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name" validate:"required,max=100"`
+	Prefix     string       `json:"prefix"`
+	Hash       string       `json:"-"`
+	Scopes     []Permission `json:"scopes" validate:"required,min=1,dive,oneof=users:read users:read_pii users:write users:delete"`
+	ExpiresAt  *time.Time   `json:"expiresAt,omitempty"`
+	CreatedBy  string       `json:"createdBy"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time   `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether the key is neither revoked nor expired at now
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
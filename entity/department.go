@@ -0,0 +1,36 @@
+package entity
+
+import "time"
+
+// Department groups users, its name is unique regardless of case. Departments
+// form a tree through their parent, e.g. divisions containing departments
+// containing teams
+type Department struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name" validate:"required,max=255"`
+	ParentID *string `json:"parentId,omitempty"`
+	// Members and Headcount are maintained by the server, they are ignored on
+	// input. Members counts the users of the department itself, Headcount
+	// also the users of every department below it
+	Members   int       `json:"members"`
+	Headcount int       `json:"headcount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DepartmentMerge moves every member of a department to Into
+type DepartmentMerge struct {
+	Into string `json:"into" validate:"required"`
+}
+
+// DepartmentParent moves a department below ParentID, a null parent makes it
+// a root
+type DepartmentParent struct {
+	ParentID *string `json:"parentId"`
+}
+
+// DepartmentNode is a department of a subtree or a chain of ancestors, Depth
+// is its distance to the department they were requested for
+type DepartmentNode struct {
+	Department
+	Depth int `json:"depth"`
+}
@@ -1,28 +1,9 @@
 package entity
 
-import "fmt"
-
-const (
-	Inactive = iota
-	Active
-	Terminated
+import (
+	"golang.org/x/exp/slog"
 )
 
-type Status int
-
-func (s Status) String() string {
-	switch s {
-	case Inactive:
-		return "I"
-	case Active:
-		return "A"
-	case Terminated:
-		return "T"
-	default:
-		return fmt.Sprintf("invalid Status %d", s)
-	}
-}
-
 // User entity represents every user in the domain
 type User struct {
 	ID         string `json:"id"`
@@ -31,5 +12,27 @@ type User struct {
 	LastName   string `json:"lastName" validate:"required"`
 	Email      string `json:"email" validate:"required,email"`
 	Department string `json:"department" validate:"required"`
-	UserStatus Status `json:"userStatus" validate:"gte=0,lte=2"`
+	UserStatus Status `json:"userStatus" validate:"status"`
+	// EmailVerified is maintained by the server, it is ignored on input
+	EmailVerified bool `json:"emailVerified"`
+	// ManagerID is changed through the manager of the user, it is ignored on input
+	ManagerID *string `json:"managerId,omitempty"`
+	// Attributes are custom fields the rules of groups may match, a user
+	// replaced without them keeps its current ones
+	Attributes map[string]string `json:"attributes,omitempty" validate:"max=50,dive,keys,max=64,endkeys,max=255"`
+}
+
+// LogValue logs the user as a group keyed like its json fields so the
+// logging pipeline can redact the personal ones
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", u.ID),
+		slog.String("userName", u.UserName),
+		slog.String("firstName", u.FirstName),
+		slog.String("lastName", u.LastName),
+		slog.String("email", u.Email),
+		slog.String("department", u.Department),
+		slog.String("userStatus", u.UserStatus.String()),
+		slog.Bool("emailVerified", u.EmailVerified),
+	)
 }
@@ -0,0 +1,27 @@
+package entity
+
+import "time"
+
+// EventType names what happened to a user
+type EventType string
+
+const (
+	EventUserCreated       EventType = "UserCreated"
+	EventUserUpdated       EventType = "UserUpdated"
+	EventUserStatusChanged EventType = "UserStatusChanged"
+	EventUserDeleted       EventType = "UserDeleted"
+)
+
+// Event is a change of a user published to downstream systems. Events are
+// delivered at least once and the events of a user in the order they
+// happened, consumers drop the ids they have seen already.
+type Event struct {
+	ID     string    `json:"id"`
+	Type   EventType `json:"type"`
+	UserID string    `json:"userId"`
+	// User is the user after the change, the deleted user for UserDeleted
+	User User `json:"user"`
+	// StatusChange is set for UserStatusChanged
+	StatusChange *StatusChange `json:"statusChange,omitempty"`
+	OccurredAt   time.Time     `json:"occurredAt"`
+}
@@ -0,0 +1,136 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("invalid group rule")
+
+// AttributePrefix prefixes the rule fields naming a custom attribute of the
+// user, e.g. attributes.location
+const AttributePrefix = "attributes."
+
+// ruleFields are the fields of User a rule may compare besides the custom
+// attributes, keyed like their json names
+var ruleFields = map[string]func(u User) string{
+	"userName":      func(u User) string { return u.UserName },
+	"department":    func(u User) string { return u.Department },
+	"userStatus":    func(u User) string { return u.UserStatus.String() },
+	"emailVerified": func(u User) string { return strconv.FormatBool(u.EmailVerified) },
+}
+
+// Group collects users for access decisions. Static members are added by
+// hand, dynamic members are the users meeting every rule of the group, e.g.
+// the rules department eq engineering and userStatus eq active for all
+// active engineers. A group without rules only has static members.
+type Group struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name" validate:"required,max=255"`
+	Description string      `json:"description" validate:"max=1000"`
+	Rules       []GroupRule `json:"rules" validate:"max=20,dive"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// GroupRule compares a field of users with Values, case insensitively. Eq
+// and ne take one value, in takes several and present none, it matches
+// users with a non-empty field.
+type GroupRule struct {
+	Field  string   `json:"field" validate:"required,max=255"`
+	Op     string   `json:"op" validate:"required,oneof=eq ne in present"`
+	Values []string `json:"values" validate:"max=100,dive,max=255"`
+}
+
+// GroupMember is a user of a group and how it became one, a user can be both
+type GroupMember struct {
+	User
+	Static  bool `json:"static"`
+	Dynamic bool `json:"dynamic"`
+}
+
+// UserGroup is a group of a user and how the user became its member
+type UserGroup struct {
+	Group
+	Static  bool `json:"static"`
+	Dynamic bool `json:"dynamic"`
+}
+
+// GroupMemberRequest adds a static member to a group
+type GroupMemberRequest struct {
+	UserID string `json:"userId" validate:"required"`
+}
+
+// Dynamic reports whether users become members of g by its rules
+func (g Group) Dynamic() bool {
+	return len(g.Rules) > 0
+}
+
+// Matches reports whether u meets every rule of g, groups without rules
+// match nobody
+func (g Group) Matches(u User) bool {
+	if !g.Dynamic() {
+		return false
+	}
+	for _, rule := range g.Rules {
+		if !rule.Matches(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns an ErrInvalidRule for the first rule of g naming an unknown
+// field or holding the wrong number of values
+func (g Group) Check() error {
+	for i, rule := range g.Rules {
+		if err := rule.Check(); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r GroupRule) Check() error {
+	if _, ok := ruleFields[r.Field]; !ok {
+		if name := strings.TrimPrefix(r.Field, AttributePrefix); name == r.Field || name == "" {
+			return fmt.Errorf("%w: unknown field %q", ErrInvalidRule, r.Field)
+		}
+	}
+	switch {
+	case (r.Op == "eq" || r.Op == "ne") && len(r.Values) != 1:
+		return fmt.Errorf("%w: %s takes one value", ErrInvalidRule, r.Op)
+	case r.Op == "in" && len(r.Values) == 0:
+		return fmt.Errorf("%w: in takes at least one value", ErrInvalidRule)
+	case r.Op == "present" && len(r.Values) != 0:
+		return fmt.Errorf("%w: present takes no value", ErrInvalidRule)
+	}
+	return nil
+}
+
+// Matches reports whether the field of u meets r
+func (r GroupRule) Matches(u User) bool {
+	value := r.value(u)
+	switch r.Op {
+	case "present":
+		return value != ""
+	case "ne":
+		return len(r.Values) == 1 && !strings.EqualFold(value, r.Values[0])
+	}
+	// eq and in
+	for _, want := range r.Values {
+		if strings.EqualFold(value, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r GroupRule) value(u User) string {
+	if field, ok := ruleFields[r.Field]; ok {
+		return field(u)
+	}
+	return u.Attributes[strings.TrimPrefix(r.Field, AttributePrefix)]
+}
@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGroupMatches(t *testing.T) {
+	engineer := User{Department: "Engineering", UserStatus: Active, Attributes: map[string]string{"location": "Berlin"}}
+	activeEngineers := []GroupRule{
+		{Field: "department", Op: "eq", Values: []string{"engineering"}},
+		{Field: "userStatus", Op: "eq", Values: []string{"active"}},
+	}
+	testCase := []struct {
+		name  string
+		rules []GroupRule
+		user  User
+		want  bool
+	}{
+		{name: "static groups match nobody", user: engineer},
+		{name: "every rule matches", rules: activeEngineers, user: engineer, want: true},
+		{name: "one rule fails", rules: activeEngineers, user: User{Department: "engineering", UserStatus: Terminated}},
+		{name: "in", rules: []GroupRule{{Field: "department", Op: "in", Values: []string{"ops", "engineering"}}}, user: engineer, want: true},
+		{name: "ne", rules: []GroupRule{{Field: "userStatus", Op: "ne", Values: []string{"terminated"}}}, user: engineer, want: true},
+		{name: "attribute", rules: []GroupRule{{Field: "attributes.location", Op: "eq", Values: []string{"berlin"}}}, user: engineer, want: true},
+		{name: "present", rules: []GroupRule{{Field: "attributes.location", Op: "present"}}, user: engineer, want: true},
+		{name: "missing attribute", rules: []GroupRule{{Field: "attributes.badge", Op: "present"}}, user: engineer},
+		{name: "bool field", rules: []GroupRule{{Field: "emailVerified", Op: "eq", Values: []string{"true"}}}, user: engineer},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			g := Group{Rules: tc.rules}
+			assert.NoError(t, g.Check())
+			assert.Equal(t, tc.want, g.Matches(tc.user))
+		})
+	}
+}
+
+func TestGroupCheck(t *testing.T) {
+	for _, rule := range []GroupRule{
+		{Field: "salary", Op: "eq", Values: []string{"1"}},
+		{Field: "attributes.", Op: "present"},
+		{Field: "department", Op: "eq"},
+		{Field: "department", Op: "eq", Values: []string{"a", "b"}},
+		{Field: "department", Op: "in"},
+		{Field: "department", Op: "present", Values: []string{"a"}},
+	} {
+		assert.ErrorIs(t, Group{Rules: []GroupRule{rule}}.Check(), ErrInvalidRule, "%+v", rule)
+	}
+}
@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+// LoginThrottle counts the recent failed logins of an account or a client
+// ip. Key is "account:<user name>" or "ip:<address>".
+type LoginThrottle struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// Locked reports whether logins are refused at now
+func (t LoginThrottle) Locked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
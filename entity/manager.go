@@ -0,0 +1,13 @@
+package entity
+
+// ManagerAssignment makes ManagerID the manager of a user, a null manager
+// removes it
+type ManagerAssignment struct {
+	ManagerID *string `json:"managerId"`
+}
+
+// OrgNode is a user of the org chart with the users reporting to them
+type OrgNode struct {
+	User
+	Reports []OrgNode `json:"reports"`
+}
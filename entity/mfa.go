@@ -0,0 +1,49 @@
+package entity
+
+import "time"
+
+// MFAEnrollment is the TOTP second factor of a user. Secret is encrypted
+// and the enrollment only protects logins once confirmed.
+type MFAEnrollment struct {
+	UserID      string     `json:"userId"`
+	Secret      string     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	// LastUsedStep is the last TOTP period a code was accepted for
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Enabled reports whether the user confirmed the enrollment
+func (e MFAEnrollment) Enabled() bool {
+	return e.ConfirmedAt != nil
+}
+
+// TOTPSetup is handed to the user once to configure an authenticator app
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFACode is a code of the authenticator app
+type MFACode struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+// RecoveryCodes are shown once, each signs in a single time without the authenticator app
+type RecoveryCodes struct {
+	Codes []string `json:"recoveryCodes"`
+}
+
+// MFAChallenge is returned by the login of users with MFA enabled and
+// redeemed with a code for a session
+type MFAChallenge struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MFALogin is the second login step, it takes an authenticator code or a recovery code
+type MFALogin struct {
+	Challenge    string `json:"challenge" validate:"required,max=128"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code,omitempty,max=32"`
+}
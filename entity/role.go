@@ -0,0 +1,48 @@
+package entity
+
+import "time"
+
+// Permission is an action a caller may perform on a resource
+type Permission string
+
+const (
+	PermUsersRead         Permission = "users:read"
+	PermUsersReadPII      Permission = "users:read_pii"
+	PermUsersWrite        Permission = "users:write"
+	PermUsersDelete       Permission = "users:delete"
+	PermRolesManage       Permission = "roles:manage"
+	PermAPIKeysManage     Permission = "api_keys:manage"
+	PermCredentialsManage Permission = "credentials:manage"
+	PermWebhooksManage    Permission = "webhooks:manage"
+)
+
+// RequiresMFA reports whether perm manages users or access, users signed in
+// with a session only hold such permissions after a second factor
+func (p Permission) RequiresMFA() bool {
+	switch p {
+	case PermUsersWrite, PermUsersDelete, PermRolesManage, PermAPIKeysManage, PermCredentialsManage, PermWebhooksManage:
+		return true
+	}
+	return false
+}
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Role groups the permissions granted to the subjects bound to it
+type Role struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// RoleBinding grants a role to an authenticated subject
+type RoleBinding struct {
+	Subject   string    `json:"subject" validate:"required,max=255"`
+	Role      string    `json:"role" validate:"required,max=50"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
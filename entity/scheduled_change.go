@@ -0,0 +1,36 @@
+package entity
+
+import "time"
+
+// ScheduledChangeRequest schedules a status change, a department move or
+// both for a user
+type ScheduledChangeRequest struct {
+	Status      *Status   `json:"status,omitempty" validate:"omitempty,status"`
+	Department  *string   `json:"department,omitempty" validate:"omitempty,min=1,max=255"`
+	EffectiveAt time.Time `json:"effectiveAt" validate:"required"`
+	Reason      string    `json:"reason" validate:"required,max=500"`
+}
+
+// ScheduledChange is a change of a user applied once EffectiveAt has
+// passed. It is pending until it is applied, cancelled or failed.
+type ScheduledChange struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId"`
+	Status      *Status   `json:"status,omitempty"`
+	Department  *string   `json:"department,omitempty"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+	Reason      string    `json:"reason"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Apply returns u with the change applied
+func (c ScheduledChange) Apply(u User) User {
+	if c.Status != nil {
+		u.UserStatus = *c.Status
+	}
+	if c.Department != nil {
+		u.Department = *c.Department
+	}
+	return u
+}
@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+// Login are the local credentials a user signs in with
+type Login struct {
+	UserName string `json:"userName" validate:"required,max=50"`
+	Password string `json:"password" validate:"required,max=128"`
+}
+
+// PasswordChange sets the local password of a user, users changing their
+// own password confirm it with their current one
+type PasswordChange struct {
+	Password        string `json:"password" validate:"required,min=12,max=128"`
+	CurrentPassword string `json:"currentPassword,omitempty" validate:"max=128"`
+}
+
+// Session is a server side login session. ID is the hash of the token
+// held by the session cookie so it can be listed without leaking it.
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userId"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"userAgent"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	// MFA is set when the user signed in with a second factor
+	MFA bool `json:"mfa"`
+}
+
+// Active reports whether the session can still authenticate requests at now
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
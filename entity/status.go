@@ -0,0 +1,178 @@
+package entity
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Status is the lifecycle state of a user, it is marshalled by name
+type Status int
+
+const (
+	Inactive Status = iota
+	Active
+	Terminated
+)
+
+var (
+	ErrInvalidStatus     = errors.New("invalid user status")
+	ErrInvalidTransition = errors.New("invalid user status transition")
+	ErrInitialStatus     = errors.New("users are created inactive or active")
+	ErrStatusNeedsReason = errors.New("the status of a user is only changed with a reason")
+)
+
+// statuses defines every status, a new one only needs its row. The name is
+// its json and text form, the code is stored in the user_status column,
+// initial tells whether users may be created with it and next lists the
+// statuses it may change to, a terminated user is final.
+var statuses = []struct {
+	status  Status
+	name    string
+	code    string
+	initial bool
+	next    []Status
+}{
+	{status: Inactive, name: "inactive", code: "I", initial: true, next: []Status{Active}},
+	{status: Active, name: "active", code: "A", initial: true, next: []Status{Inactive, Terminated}},
+	{status: Terminated, name: "terminated", code: "T"},
+}
+
+// Statuses returns every status in definition order
+func Statuses() []Status {
+	out := make([]Status, len(statuses))
+	for i, s := range statuses {
+		out[i] = s.status
+	}
+	return out
+}
+
+// Valid reports whether s is a defined status
+func (s Status) Valid() bool {
+	_, ok := s.lookup()
+	return ok
+}
+
+func (s Status) lookup() (int, bool) {
+	for i, def := range statuses {
+		if def.status == s {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s Status) String() string {
+	if i, ok := s.lookup(); ok {
+		return statuses[i].name
+	}
+	return fmt.Sprintf("invalid Status %d", s)
+}
+
+// Initial reports whether a user may be created with s
+func (s Status) Initial() bool {
+	i, ok := s.lookup()
+	return ok && statuses[i].initial
+}
+
+// CanTransition reports whether a user may change from s to to, keeping
+// the same status is always allowed
+func (s Status) CanTransition(to Status) bool {
+	i, ok := s.lookup()
+	if !ok || !to.Valid() {
+		return false
+	}
+	if s == to {
+		return true
+	}
+	for _, next := range statuses[i].next {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition returns ErrInvalidTransition unless a user may change from s to to
+func (s Status) Transition(to Status) error {
+	if !s.CanTransition(to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, s, to)
+	}
+	return nil
+}
+
+// Code returns the code of s stored in the database
+func (s Status) Code() (string, error) {
+	if i, ok := s.lookup(); ok {
+		return statuses[i].code, nil
+	}
+	return "", fmt.Errorf("%w: %d", ErrInvalidStatus, s)
+}
+
+// StatusFromCode returns the status stored as code
+func StatusFromCode(code string) (Status, error) {
+	for _, def := range statuses {
+		if def.code == code {
+			return def.status, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, code)
+}
+
+// ParseStatus returns the status named name, the legacy integers are
+// accepted too
+func ParseStatus(name string) (Status, error) {
+	for _, def := range statuses {
+		if def.name == name {
+			return def.status, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && Status(n).Valid() {
+		return Status(n), nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, name)
+}
+
+// MarshalText implements encoding.TextMarshaler, json uses it too
+func (s Status) MarshalText() ([]byte, error) {
+	i, ok := s.lookup()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidStatus, s)
+	}
+	return []byte(statuses[i].name), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (s *Status) UnmarshalText(text []byte) error {
+	status, err := ParseStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// UnmarshalJSON accepts a name or a legacy integer
+func (s *Status) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var name string
+		if err := json.Unmarshal(data, &name); err != nil {
+			return err
+		}
+		return s.UnmarshalText([]byte(name))
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidStatus, data)
+	}
+	if !Status(n).Valid() {
+		return fmt.Errorf("%w: %d", ErrInvalidStatus, n)
+	}
+	*s = Status(n)
+	return nil
+}
@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+// StatusReason is the body of the endpoints changing the status of a user
+type StatusReason struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// StatusChange records a change of the status of a user
+type StatusChange struct {
+	ID     string `json:"id"`
+	UserID string `json:"userId"`
+	From   Status `json:"from"`
+	To     Status `json:"to"`
+	// Reason is empty for changes recorded by updating the whole user, which
+	// no longer changes the status
+	Reason string `json:"reason"`
+	// ChangedBy is the subject of the caller, empty for anonymous changes
+	ChangedBy string    `json:"changedBy"`
+	ChangedAt time.Time `json:"changedAt"`
+}
@@ -0,0 +1,92 @@
+package entity
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStatusJSON(t *testing.T) {
+	testCase := []struct {
+		in      string
+		want    Status
+		wantErr bool
+	}{
+		{in: `"inactive"`, want: Inactive},
+		{in: `"active"`, want: Active},
+		{in: `"terminated"`, want: Terminated},
+		{in: `2`, want: Terminated},
+		{in: `"1"`, want: Active},
+		{in: `7`, wantErr: true},
+		{in: `"retired"`, wantErr: true},
+		{in: `true`, wantErr: true},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.in, func(t *testing.T) {
+			var got Status
+			err := json.Unmarshal([]byte(tc.in), &got)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidStatus)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestStatusDefinitions(t *testing.T) {
+	for _, status := range Statuses() {
+		b, err := json.Marshal(status)
+		require.NoError(t, err)
+		var back Status
+		require.NoError(t, json.Unmarshal(b, &back))
+		assert.Equal(t, status, back)
+
+		code, err := status.Code()
+		require.NoError(t, err)
+		fromCode, err := StatusFromCode(code)
+		require.NoError(t, err)
+		assert.Equal(t, status, fromCode)
+	}
+	_, err := json.Marshal(Status(9))
+	assert.Error(t, err)
+	_, err = StatusFromCode("X")
+	assert.ErrorIs(t, err, ErrInvalidStatus)
+}
+
+func TestStatusTransition(t *testing.T) {
+	testCase := []struct {
+		from, to Status
+		allowed  bool
+	}{
+		{from: Inactive, to: Active, allowed: true},
+		{from: Inactive, to: Inactive, allowed: true},
+		{from: Inactive, to: Terminated},
+		{from: Active, to: Inactive, allowed: true},
+		{from: Active, to: Terminated, allowed: true},
+		{from: Terminated, to: Active},
+		{from: Terminated, to: Inactive},
+		{from: Terminated, to: Terminated, allowed: true},
+		{from: Active, to: Status(9)},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.from.String()+" to "+tc.to.String(), func(t *testing.T) {
+			assert.Equal(t, tc.allowed, tc.from.CanTransition(tc.to))
+			err := tc.from.Transition(tc.to)
+			if tc.allowed {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidTransition)
+		})
+	}
+}
+
+func TestStatusInitial(t *testing.T) {
+	assert.True(t, Inactive.Initial())
+	assert.True(t, Active.Initial())
+	assert.False(t, Terminated.Initial())
+	assert.False(t, Status(9).Initial())
+}
@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+// TokenPurpose is what a single use user token may be redeemed for
+type TokenPurpose string
+
+const (
+	TokenVerifyEmail   TokenPurpose = "verify_email"
+	TokenResetPassword TokenPurpose = "reset_password"
+	// TokenMFALogin is the challenge of the second login step
+	TokenMFALogin TokenPurpose = "mfa_login"
+)
+
+// UserToken is a single use, expiring token mailed to a user. ID is the
+// hash of the token, the token itself is never stored.
+type UserToken struct {
+	ID      string
+	UserID  string
+	Purpose TokenPurpose
+	// Email is the address a verification token was sent to
+	Email     string
+	ExpiresAt time.Time
+}
+
+// TokenRedemption is the body of the verification and reset endpoints
+type TokenRedemption struct {
+	Token    string `json:"token" validate:"required,max=128"`
+	Password string `json:"password" validate:"omitempty,min=12,max=128"`
+}
+
+// PasswordResetRequest starts a password reset for the user owning Email
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
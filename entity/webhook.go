@@ -0,0 +1,63 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookSecretPrefix starts the secrets generated for webhooks, the
+// secrets sealed for storage never start with it
+const WebhookSecretPrefix = "whsec_"
+
+// Webhook subscribes a url to the events of users. Its secret signs the
+// deliveries, it is only answered when it is generated and stored sealed.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url" validate:"required,url,startswith=http,max=2048"`
+	// EventTypes are the events delivered, every event when empty
+	EventTypes []EventType `json:"eventTypes" validate:"max=4,dive,oneof=UserCreated UserUpdated UserStatusChanged UserDeleted"`
+	Secret     string      `json:"-"`
+	CreatedBy  string      `json:"createdBy"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+// Subscribes reports whether events of type t are delivered to the webhook
+func (w Webhook) Subscribes(t EventType) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, subscribed := range w.EventTypes {
+		if subscribed == t {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus is where a delivery stands, pending deliveries are retried
+// until they succeed or run out of attempts and are dead
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is an event sent to a webhook, its attempts and the
+// outcome of the last one
+type WebhookDelivery struct {
+	ID             string         `json:"id"`
+	WebhookID      string         `json:"webhookId"`
+	EventID        string         `json:"eventId"`
+	EventType      EventType      `json:"eventType"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  *time.Time     `json:"nextAttemptAt,omitempty"`
+	LastStatusCode int            `json:"lastStatusCode,omitempty"`
+	LastError      string         `json:"lastError,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	DeliveredAt    *time.Time     `json:"deliveredAt,omitempty"`
+	// Payload is the body posted, the event as json
+	Payload json.RawMessage `json:"-"`
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"os"
+	"time"
+)
+
+var ErrUnknownSink = errors.New("unknown event sink")
+
+// Sink delivers the events of users to downstream systems. An event is only
+// marked published once Publish returns nil, so a sink may see it again.
+type Sink interface {
+	Publish(ctx context.Context, event entity.Event) error
+}
+
+// FromEnv builds the sink selected by EVENT_SINK: stdout, file, http or nats
+func FromEnv() (Sink, error) {
+	timeout := 10 * time.Second
+	if v := os.Getenv("EVENT_SINK_TIMEOUT"); v != "" {
+		var err error
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid EVENT_SINK_TIMEOUT: %w", err)
+		}
+	}
+	switch kind := os.Getenv("EVENT_SINK"); kind {
+	case "", "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case "file":
+		return NewFileSink(os.Getenv("EVENT_FILE"))
+	case "http":
+		return NewHTTPSink(HTTPConfig{URL: os.Getenv("EVENT_HTTP_URL"), Timeout: timeout})
+	case "nats":
+		return NewNATSSink(NATSConfig{
+			URL:     os.Getenv("EVENT_NATS_URL"),
+			Subject: os.Getenv("EVENT_NATS_SUBJECT"),
+			Timeout: timeout,
+		})
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSink, kind)
+	}
+}
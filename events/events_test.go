@@ -0,0 +1,178 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var created = entity.Event{
+	ID:     "7",
+	Type:   entity.EventUserCreated,
+	UserID: "1",
+	User:   entity.User{ID: "1", UserName: "jdoe", UserStatus: entity.Active},
+}
+
+func TestWriterSink(t *testing.T) {
+	var b bytes.Buffer
+	sink := NewWriterSink(&b)
+	require.NoError(t, sink.Publish(context.Background(), created))
+	require.NoError(t, sink.Publish(context.Background(), created))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 2)
+	var got entity.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, created.ID, got.ID)
+	assert.Equal(t, entity.EventUserCreated, got.Type)
+	assert.Equal(t, "jdoe", got.User.UserName)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(context.Background(), created))
+	require.NoError(t, sink.Close())
+
+	// events are appended to what the file holds
+	sink, err = NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(context.Background(), created))
+	require.NoError(t, sink.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(b), `"type":"UserCreated"`))
+}
+
+func TestHTTPSink(t *testing.T) {
+	status := http.StatusAccepted
+	var got entity.Event
+	var id string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = r.Header.Get(HeaderEventID)
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := NewHTTPSink(HTTPConfig{URL: srv.URL, Timeout: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(context.Background(), created))
+	assert.Equal(t, "7", id)
+	assert.Equal(t, "jdoe", got.User.UserName)
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, sink.Publish(context.Background(), created), "only 2xx answers publish")
+
+	_, err = NewHTTPSink(HTTPConfig{URL: "localhost:8080"})
+	assert.Error(t, err)
+}
+
+// fakeNATS accepts one connection speaking enough of the nats protocol and
+// sends the subjects and payloads published to it to msgs
+func fakeNATS(t *testing.T, msgs chan<- [2]string, fail bool) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte("INFO {\"server_id\":\"fake\"}\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				_, _ = conn.Write([]byte("PONG\r\n"))
+			case fields[0] == "PUB" && fail:
+				_, _ = conn.Write([]byte("-ERR 'Permissions Violation for Publish'\r\n"))
+			case fields[0] == "PUB":
+				payload, _ := r.ReadString('\n')
+				msgs <- [2]string{fields[1], strings.TrimRight(payload, "\r\n")}
+			}
+		}
+	}()
+	return "nats://" + lis.Addr().String()
+}
+
+func TestNATSSink(t *testing.T) {
+	msgs := make(chan [2]string, 1)
+	sink, err := NewNATSSink(NATSConfig{URL: fakeNATS(t, msgs, false), Timeout: time.Second})
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Publish(context.Background(), created))
+	msg := <-msgs
+	assert.Equal(t, "users.UserCreated", msg[0])
+	assert.Contains(t, msg[1], `"userName":"jdoe"`)
+
+	failing, err := NewNATSSink(NATSConfig{URL: fakeNATS(t, msgs, true), Subject: "crm", Timeout: time.Second})
+	require.NoError(t, err)
+	defer failing.Close()
+	assert.ErrorContains(t, failing.Publish(context.Background(), created), "Permissions Violation")
+
+	_, err = NewNATSSink(NATSConfig{URL: "http://localhost:4222"})
+	assert.Error(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("EVENT_SINK", "kafka")
+	_, err := FromEnv()
+	assert.ErrorIs(t, err, ErrUnknownSink)
+
+	t.Setenv("EVENT_SINK", "")
+	sink, err := FromEnv()
+	require.NoError(t, err)
+	assert.IsType(t, &WriterSink{}, sink)
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"7"}`)
+	now := time.Unix(1700000000, 0)
+	sig := Sign("whsec_test", now.Unix(), body)
+	assert.True(t, strings.HasPrefix(sig, "sha256="))
+	assert.Len(t, sig, len("sha256=")+64)
+	ts := "1700000000"
+
+	assert.NoError(t, Verify("whsec_test", ts, sig, body, 5*time.Minute, now.Add(time.Minute)))
+	assert.ErrorIs(t, Verify("whsec_other", ts, sig, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", ts, sig, []byte(`{"id":"8"}`), 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", "1700000001", sig, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_test", ts, sig, body, 5*time.Minute, now.Add(time.Hour)), ErrInvalidSignature,
+		"a stale delivery is refused")
+}
+
+func TestMultiSink(t *testing.T) {
+	a, b := NewMemorySink(), NewMemorySink()
+	require.NoError(t, MultiSink{a, b}.Publish(context.Background(), created))
+	assert.Len(t, a.Events(), 1)
+	assert.Len(t, b.Events(), 1)
+
+	down, err := NewHTTPSink(HTTPConfig{URL: "http://127.0.0.1:1", Timeout: time.Second})
+	require.NoError(t, err)
+	c := NewMemorySink()
+	assert.Error(t, MultiSink{down, c}.Publish(context.Background(), created))
+	assert.Len(t, c.Events(), 1, "the other sinks still get the event")
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	HeaderEventID   = "X-Event-Id"
+	HeaderEventType = "X-Event-Type"
+)
+
+type HTTPConfig struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// HTTPSink posts every event as json to a url, any answer but a 2xx fails
+// the event and it is posted again later. The event id is sent in the
+// X-Event-Id header so the receiver can drop repeated events.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(cfg HTTPConfig) (*HTTPSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("the event url must be an absolute http or https url")
+	}
+	return &HTTPSink{url: cfg.URL, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, event entity.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, event.ID)
+	req.Header.Set(HeaderEventType, string(event.Type))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// drain the body so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("event %s was answered with %s", event.ID, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderWebhookID        = "X-Webhook-Id"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+	signaturePrefix        = "sha256="
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign signs the body of a delivery sent at timestamp, unix seconds. The
+// signature is the hex HMAC-SHA256 of "timestamp.body" keyed by the secret of
+// the webhook, prefixed by sha256=.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a delivery the way a
+// receiver should, deliveries signed more than tolerance away from now are
+// refused so a captured one can't be replayed
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) ||
+		!hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// MultiSink publishes every event to each of its sinks. An event failing on
+// one of them is published again to all, which sinks tolerate.
+type MultiSink []Sink
+
+func (m MultiSink) Publish(ctx context.Context, event entity.Event) error {
+	var first error
+	for _, sink := range m {
+		if err := sink.Publish(ctx, event); err != nil && first == nil {
+			first = fmt.Errorf("event %s: %w", event.ID, err)
+		}
+	}
+	return first
+}
@@ -0,0 +1,63 @@
+package graph
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/graph-gophers/dataloader/v7"
+)
+
+type loadersKey struct{}
+
+// loaders batch the repository reads of one request, e.g. the users of
+// every department listed by a query are fetched with a single query
+type loaders struct {
+	// users loads users by id, unknown ids load nil
+	users *dataloader.Loader[string, *entity.User]
+	// departments loads the users of departments by name
+	departments *dataloader.Loader[string, []entity.User]
+}
+
+func withLoaders(ctx context.Context, repo datastore.UserRepository) context.Context {
+	l := &loaders{
+		users: dataloader.NewBatchedLoader(func(ctx context.Context, ids []string) []*dataloader.Result[*entity.User] {
+			results := make([]*dataloader.Result[*entity.User], len(ids))
+			found, err := repo.GetByIDs(ctx, ids)
+			byID := make(map[string]*entity.User, len(found))
+			for _, cus := range found.GetExportedCustomers() {
+				u := cus.User
+				byID[u.ID] = &u
+			}
+			for i, id := range ids {
+				results[i] = &dataloader.Result[*entity.User]{Data: byID[id], Error: err}
+			}
+			return results
+		}),
+		departments: dataloader.NewBatchedLoader(func(ctx context.Context, names []string) []*dataloader.Result[[]entity.User] {
+			results := make([]*dataloader.Result[[]entity.User], len(names))
+			byName, err := usersByDepartment(ctx, repo)
+			for i, name := range names {
+				results[i] = &dataloader.Result[[]entity.User]{Data: byName[name], Error: err}
+			}
+			return results
+		}),
+	}
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// usersByDepartment groups every user by department, ordered by id
+func usersByDepartment(ctx context.Context, repo datastore.UserRepository) (map[string][]entity.User, error) {
+	all, err := repo.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string][]entity.User)
+	for _, cus := range all.GetExportedCustomers() {
+		byName[cus.User.Department] = append(byName[cus.User.Department], cus.User)
+	}
+	return byName, nil
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"github.com/ellis90/assessment-bg/entity"
+	"strings"
+)
+
+// Department groups the users sharing a department name
+type Department struct {
+	Name string `json:"name"`
+}
+
+// Matches reports whether u, whose status is status, satisfies the filter.
+// A nil filter matches every user.
+func (f *UserFilter) Matches(u entity.User, status UserStatus) bool {
+	if f == nil {
+		return true
+	}
+	if f.Department != nil && !strings.EqualFold(*f.Department, u.Department) {
+		return false
+	}
+	if f.Status != nil && *f.Status != status {
+		return false
+	}
+	if f.Search != nil {
+		search := strings.ToLower(*f.Search)
+		for _, s := range []string{u.UserName, u.FirstName, u.LastName} {
+			if strings.Contains(strings.ToLower(s), search) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package model
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ellis90/assessment-bg/entity"
+)
+
+type CreateUserInput struct {
+	UserName   string      `json:"userName"`
+	FirstName  string      `json:"firstName"`
+	LastName   string      `json:"lastName"`
+	Email      string      `json:"email"`
+	Department string      `json:"department"`
+	Status     *UserStatus `json:"status,omitempty"`
+}
+
+type PageInfo struct {
+	// the cursor to pass as after to fetch the next page
+	EndCursor   *string `json:"endCursor,omitempty"`
+	HasNextPage bool    `json:"hasNextPage"`
+}
+
+// omitted fields keep their value
+type UpdateUserInput struct {
+	ID         string      `json:"id"`
+	UserName   *string     `json:"userName,omitempty"`
+	FirstName  *string     `json:"firstName,omitempty"`
+	LastName   *string     `json:"lastName,omitempty"`
+	Email      *string     `json:"email,omitempty"`
+	Department *string     `json:"department,omitempty"`
+	Status     *UserStatus `json:"status,omitempty"`
+}
+
+type UserConnection struct {
+	Nodes      []*entity.User `json:"nodes"`
+	TotalCount int            `json:"totalCount"`
+	PageInfo   *PageInfo      `json:"pageInfo"`
+}
+
+type UserFilter struct {
+	Department *string     `json:"department,omitempty"`
+	Status     *UserStatus `json:"status,omitempty"`
+	// matched case insensitively against the user name, first and last name
+	Search *string `json:"search,omitempty"`
+}
+
+type UserStatus string
+
+const (
+	UserStatusInactive   UserStatus = "INACTIVE"
+	UserStatusActive     UserStatus = "ACTIVE"
+	UserStatusTerminated UserStatus = "TERMINATED"
+)
+
+var AllUserStatus = []UserStatus{
+	UserStatusInactive,
+	UserStatusActive,
+	UserStatusTerminated,
+}
+
+func (e UserStatus) IsValid() bool {
+	switch e {
+	case UserStatusInactive, UserStatusActive, UserStatusTerminated:
+		return true
+	}
+	return false
+}
+
+func (e UserStatus) String() string {
+	return string(e)
+}
+
+func (e *UserStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = UserStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid UserStatus", str)
+	}
+	return nil
+}
+
+func (e UserStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -0,0 +1,270 @@
+package graph
+
+//go:generate go run github.com/99designs/gqlgen generate
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	dsmodel "github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/graph/model"
+	"github.com/ellis90/assessment-bg/service"
+	"github.com/labstack/echo/v4"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"golang.org/x/exp/slog"
+	"os"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	// departmentsEstimate is the number of departments assumed by the
+	// complexity of the departments query
+	departmentsEstimate = 20
+	// DefaultComplexityLimit is the complexity limit used when none is configured
+	DefaultComplexityLimit = 1000
+
+	// error codes of the extensions of graphql errors
+	codeForbidden = "FORBIDDEN"
+	codeNotFound  = "NOT_FOUND"
+	codeBadInput  = "BAD_USER_INPUT"
+	codeConflict  = "CONFLICT"
+	codeInternal  = "INTERNAL_SERVER_ERROR"
+)
+
+var statuses = []model.UserStatus{
+	entity.Inactive:   model.UserStatusInactive,
+	entity.Active:     model.UserStatusActive,
+	entity.Terminated: model.UserStatusTerminated,
+}
+
+type Configuration func(r *Resolver) error
+
+// Resolver resolves the graphql schema with the repository and the
+// validation of the REST user routes
+type Resolver struct {
+	userRepo datastore.UserRepository
+	logger   *slog.Logger
+	mail     *service.AccountMailer
+}
+
+func NewResolver(cfgs ...Configuration) (*Resolver, error) {
+	r := &Resolver{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func WithUserRepository(ur datastore.UserRepository, err error) Configuration {
+	return func(r *Resolver) error {
+		if err != nil {
+			return err
+		}
+		r.userRepo = ur
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the resolvers
+func WithLogger(logger *slog.Logger) Configuration {
+	return func(r *Resolver) error {
+		r.logger = logger
+		return nil
+	}
+}
+
+// WithAccountMailer sends a verification email for new addresses
+func WithAccountMailer(am *service.AccountMailer) Configuration {
+	return func(r *Resolver) error {
+		r.mail = am
+		return nil
+	}
+}
+
+// ComplexityLimitFromEnv reads GRAPHQL_COMPLEXITY_LIMIT, DefaultComplexityLimit
+// when it is unset
+func ComplexityLimitFromEnv() (int, error) {
+	v := os.Getenv("GRAPHQL_COMPLEXITY_LIMIT")
+	if v == "" {
+		return DefaultComplexityLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GRAPHQL_COMPLEXITY_LIMIT: %w", err)
+	}
+	return limit, nil
+}
+
+// Server serves the schema on /graphql
+type Server struct {
+	resolver *Resolver
+	handler  *handler.Server
+}
+
+// NewServer serves the schema of r, rejecting operations whose complexity
+// exceeds complexityLimit. Every field costs one and the lists of users
+// multiply the cost of their fields by the requested page size.
+func NewServer(r *Resolver, complexityLimit int) *Server {
+	cfg := Config{Resolvers: r}
+	cfg.Complexity.Query.Users = func(child int, _ *model.UserFilter, first *int, _ *string) int {
+		return pageSize(first) * child
+	}
+	cfg.Complexity.Department.Users = func(child int, first *int, _ *string) int {
+		return pageSize(first) * child
+	}
+	cfg.Complexity.Query.Departments = func(child int) int {
+		return departmentsEstimate * child
+	}
+	h := handler.New(NewExecutableSchema(cfg))
+	h.AddTransport(transport.GET{})
+	h.AddTransport(transport.POST{})
+	h.Use(extension.Introspection{})
+	h.Use(extension.FixedComplexityLimit(complexityLimit))
+	return &Server{resolver: r, handler: h}
+}
+
+// Handle runs the graphql operation of the request with the authenticated
+// principal and new dataloaders
+func (s *Server) Handle(c echo.Context) error {
+	req := c.Request()
+	ctx := req.Context()
+	if p, ok := auth.PrincipalFrom(c); ok {
+		ctx = auth.WithPrincipal(ctx, p)
+	}
+	ctx = withLoaders(ctx, s.resolver.userRepo)
+	s.handler.ServeHTTP(c.Response(), req.WithContext(ctx))
+	return nil
+}
+
+func gqlError(ctx context.Context, code string, err error) *gqlerror.Error {
+	return &gqlerror.Error{
+		Path:       graphql.GetPath(ctx),
+		Message:    err.Error(),
+		Extensions: map[string]any{"code": code},
+	}
+}
+
+// can reports whether the caller was granted perm
+func can(ctx context.Context, perm entity.Permission) bool {
+	p, ok := auth.PrincipalFromContext(ctx)
+	return ok && p.Has(perm)
+}
+
+// requirePermission fails the field when the caller was not granted perm
+func requirePermission(ctx context.Context, perm entity.Permission) error {
+	if !can(ctx, perm) {
+		return gqlError(ctx, codeForbidden, fmt.Errorf("%w: %s", auth.ErrForbidden, perm))
+	}
+	return nil
+}
+
+// storeError maps the errors of the user repository to graphql errors
+func (r *Resolver) storeError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrCustomerNotFound):
+		return gqlError(ctx, codeNotFound, err)
+	case errors.Is(err, datastore.ErrCustomerExists):
+		return gqlError(ctx, codeConflict, datastore.ErrCustomerExists)
+	case errors.Is(err, entity.ErrInvalidTransition), errors.Is(err, entity.ErrStatusNeedsReason):
+		return gqlError(ctx, codeConflict, err)
+	case errors.Is(err, entity.ErrInitialStatus):
+		return gqlError(ctx, codeBadInput, err)
+	case errors.Is(err, datastore.ErrDepartmentNotFound):
+		return gqlError(ctx, codeBadInput, err)
+	}
+	r.logger.ErrorCtx(ctx, "graphql request failed", slog.Any("error", err))
+	return gqlError(ctx, codeInternal, err)
+}
+
+// sendVerification mails a verification link to the address of cus, a
+// failure is logged as the user can request another link
+func (r *Resolver) sendVerification(ctx context.Context, cus dsmodel.Customer) {
+	if r.mail == nil {
+		return
+	}
+	if err := r.mail.SendVerification(ctx, cus.GetExportedCustomer().User); err != nil {
+		r.logger.ErrorCtx(ctx, "failed to send verification email", slog.Any("error", err))
+	}
+}
+
+func statusOf(s entity.Status) model.UserStatus {
+	if int(s) < 0 || int(s) >= len(statuses) {
+		return ""
+	}
+	return statuses[s]
+}
+
+func statusFrom(s model.UserStatus) entity.Status {
+	for i, status := range statuses {
+		if status == s {
+			return entity.Status(i)
+		}
+	}
+	return entity.Status(-1)
+}
+
+func pageSize(first *int) int {
+	switch {
+	case first == nil:
+		return defaultPageSize
+	case *first < 1:
+		return 1
+	case *first > maxPageSize:
+		return maxPageSize
+	}
+	return *first
+}
+
+// cursor encodes the offset following an element of a list
+func cursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// page returns the users following the cursor after
+func page(ctx context.Context, users []entity.User, first *int, after *string) (*model.UserConnection, error) {
+	if first != nil && *first < 0 {
+		return nil, gqlError(ctx, codeBadInput, errors.New("first must not be negative"))
+	}
+	offset := 0
+	if after != nil && *after != "" {
+		b, err := base64.RawURLEncoding.DecodeString(*after)
+		if err == nil {
+			offset, err = strconv.Atoi(string(b))
+		}
+		if err != nil || offset < 0 {
+			return nil, gqlError(ctx, codeBadInput, errors.New("invalid cursor"))
+		}
+	}
+	size := pageSize(first)
+	if first != nil && *first == 0 {
+		size = 0
+	}
+	conn := &model.UserConnection{
+		Nodes:      []*entity.User{},
+		TotalCount: len(users),
+		PageInfo:   &model.PageInfo{},
+	}
+	for i := offset; i < len(users) && len(conn.Nodes) < size; i++ {
+		u := users[i]
+		conn.Nodes = append(conn.Nodes, &u)
+	}
+	end := offset + len(conn.Nodes)
+	if len(conn.Nodes) > 0 {
+		c := cursor(end)
+		conn.PageInfo.EndCursor = &c
+	}
+	conn.PageInfo.HasNextPage = end < len(users)
+	return conn, nil
+}
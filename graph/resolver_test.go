@@ -0,0 +1,385 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	dsmodel "github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeUsers is an in-memory user repository counting its reads
+type fakeUsers struct {
+	mu        sync.Mutex
+	users     []entity.User
+	gets      int
+	idBatches [][]string
+}
+
+func (f *fakeUsers) Create(_ context.Context, cus dsmodel.Customer) (dsmodel.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := cus.GetExportedCustomer().User
+	if !u.UserStatus.Initial() {
+		return dsmodel.Customer{}, entity.ErrInitialStatus
+	}
+	for _, existing := range f.users {
+		if existing.UserName == u.UserName {
+			return dsmodel.Customer{}, datastore.ErrCustomerExists
+		}
+	}
+	u.ID = strconv.Itoa(len(f.users) + 1)
+	f.users = append(f.users, u)
+	return dsmodel.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Update(_ context.Context, cus dsmodel.Customer) (dsmodel.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := cus.GetExportedCustomer().User
+	for i := range f.users {
+		if f.users[i].ID == u.ID {
+			if f.users[i].UserStatus != u.UserStatus {
+				return dsmodel.Customer{}, entity.ErrStatusNeedsReason
+			}
+			f.users[i] = u
+		}
+	}
+	return dsmodel.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Get(_ context.Context) (dsmodel.Customers, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	var out dsmodel.Customers
+	for _, u := range f.users {
+		u := u
+		out = append(out, dsmodel.AddCustomer(&u))
+	}
+	return out, nil
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id string) (dsmodel.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.ID == id {
+			u := u
+			return dsmodel.AddCustomer(&u), nil
+		}
+	}
+	return dsmodel.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) GetByIDs(_ context.Context, ids []string) (dsmodel.Customers, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.idBatches = append(f.idBatches, ids)
+	var out dsmodel.Customers
+	for _, u := range f.users {
+		for _, id := range ids {
+			if u.ID == id {
+				u := u
+				out = append(out, dsmodel.AddCustomer(&u))
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeUsers) GetByEmail(context.Context, string) (dsmodel.Customer, error) {
+	return dsmodel.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) ChangeStatus(context.Context, entity.StatusChange) (dsmodel.Customer, error) {
+	return dsmodel.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) StatusHistory(context.Context, string) ([]entity.StatusChange, error) {
+	return nil, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) MarkEmailVerified(context.Context, string, string) error { return nil }
+
+func (f *fakeUsers) Delete(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, u := range f.users {
+		if u.ID == id {
+			f.users = append(f.users[:i], f.users[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func newTestUsers() *fakeUsers {
+	users := &fakeUsers{}
+	for i, u := range []struct{ name, department string }{
+		{"ada", "Engineering"},
+		{"grace", "Engineering"},
+		{"alan", "Research"},
+		{"edsger", "Research"},
+		{"barbara", "Sales"},
+	} {
+		users.users = append(users.users, entity.User{
+			ID:         strconv.Itoa(i + 1),
+			UserName:   u.name,
+			FirstName:  u.name,
+			LastName:   "Doe",
+			Email:      u.name + "@example.com",
+			Department: u.department,
+			UserStatus: entity.Active,
+		})
+	}
+	users.users[4].UserStatus = entity.Inactive
+	return users
+}
+
+type gqlResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// run executes query as a caller granted perms
+func run(t *testing.T, s *Server, query string, perms ...entity.Permission) gqlResponse {
+	body, err := json.Marshal(map[string]string{"query": query})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{Subject: "test", Method: auth.MethodAPIKey, Permissions: perms})
+	require.NoError(t, s.Handle(c))
+
+	var res gqlResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res), rec.Body.String())
+	return res
+}
+
+func newTestServer(t *testing.T, users *fakeUsers) *Server {
+	r, err := NewResolver(
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard))),
+		WithUserRepository(users, nil),
+	)
+	require.NoError(t, err)
+	return NewServer(r, DefaultComplexityLimit)
+}
+
+func errorCode(res gqlResponse) string {
+	if len(res.Errors) == 0 {
+		return ""
+	}
+	code, _ := res.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func TestQueries(t *testing.T) {
+	s := newTestServer(t, newTestUsers())
+	testCase := []struct {
+		name  string
+		query string
+		perms []entity.Permission
+		want  string
+		code  string
+	}{
+		{
+			name:  "user",
+			query: `{ user(id: "1") { userName email status department { name } } }`,
+			perms: []entity.Permission{entity.PermUsersRead, entity.PermUsersReadPII},
+			want:  `{"user":{"userName":"ada","email":"ada@example.com","status":"ACTIVE","department":{"name":"Engineering"}}}`,
+		},
+		{
+			name:  "email requires users:read_pii",
+			query: `{ user(id: "1") { email } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"user":{"email":null}}`,
+		},
+		{
+			name:  "unknown user",
+			query: `{ user(id: "42") { id } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"user":null}`,
+		},
+		{
+			name:  "forbidden",
+			query: `{ user(id: "1") { id } }`,
+			code:  codeForbidden,
+		},
+		{
+			name:  "filter",
+			query: `{ users(filter: {department: "research", search: "ED"}) { totalCount nodes { userName } } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"users":{"totalCount":1,"nodes":[{"userName":"edsger"}]}}`,
+		},
+		{
+			name:  "status filter",
+			query: `{ users(filter: {status: INACTIVE}) { nodes { userName } } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"users":{"nodes":[{"userName":"barbara"}]}}`,
+		},
+		{
+			name:  "first page",
+			query: `{ users(first: 2) { totalCount nodes { id } pageInfo { endCursor hasNextPage } } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"users":{"totalCount":5,"nodes":[{"id":"1"},{"id":"2"}],"pageInfo":{"endCursor":"` + cursor(2) + `","hasNextPage":true}}}`,
+		},
+		{
+			name:  "last page",
+			query: `{ users(first: 2, after: "` + cursor(4) + `") { nodes { id } pageInfo { hasNextPage } } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"users":{"nodes":[{"id":"5"}],"pageInfo":{"hasNextPage":false}}}`,
+		},
+		{
+			name:  "invalid cursor",
+			query: `{ users(after: "!") { totalCount } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			code:  codeBadInput,
+		},
+		{
+			name:  "departments",
+			query: `{ departments { name headcount users(first: 1) { nodes { userName } } } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want: `{"departments":[` +
+				`{"name":"Engineering","headcount":2,"users":{"nodes":[{"userName":"ada"}]}},` +
+				`{"name":"Research","headcount":2,"users":{"nodes":[{"userName":"alan"}]}},` +
+				`{"name":"Sales","headcount":1,"users":{"nodes":[{"userName":"barbara"}]}}]}`,
+		},
+		{
+			name:  "unknown department",
+			query: `{ department(name: "Legal") { name } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			want:  `{"department":null}`,
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			res := run(t, s, tc.query, tc.perms...)
+			assert.Equal(t, tc.code, errorCode(res), res.Errors)
+			if tc.want != "" {
+				got, err := json.Marshal(res.Data)
+				require.NoError(t, err)
+				assert.JSONEq(t, tc.want, string(got))
+			}
+		})
+	}
+}
+
+func TestDataloaderBatching(t *testing.T) {
+	users := newTestUsers()
+	s := newTestServer(t, users)
+
+	res := run(t, s, `{ a: user(id: "1") { id } b: user(id: "3") { id } c: user(id: "42") { id } }`, entity.PermUsersRead)
+	require.Empty(t, res.Errors)
+	require.Len(t, users.idBatches, 1, "the users are fetched with one query")
+	assert.ElementsMatch(t, []string{"1", "3", "42"}, users.idBatches[0])
+
+	res = run(t, s, `{ departments { headcount users(first: 5) { totalCount } } }`, entity.PermUsersRead)
+	require.Empty(t, res.Errors)
+	assert.Equal(t, 1, users.gets, "the departments reuse the users of the departments query")
+}
+
+func TestMutations(t *testing.T) {
+	users := newTestUsers()
+	s := newTestServer(t, users)
+	write := []entity.Permission{entity.PermUsersWrite, entity.PermUsersReadPII}
+
+	res := run(t, s, `mutation { createUser(input: {userName: "linus", firstName: "Linus", lastName: "T", email: "linus@example.com", department: "Engineering"}) { id email status emailVerified } }`, write...)
+	require.Empty(t, res.Errors)
+	assert.Equal(t, map[string]any{"id": "6", "email": "linus@example.com", "status": "ACTIVE", "emailVerified": false}, res.Data["createUser"])
+
+	res = run(t, s, `mutation { updateUser(input: {id: "6", department: "Research", status: ACTIVE}) { userName department { name } status } }`, write...)
+	require.Empty(t, res.Errors)
+	assert.Equal(t, map[string]any{"userName": "linus", "department": map[string]any{"name": "Research"}, "status": "ACTIVE"}, res.Data["updateUser"])
+
+	testCase := []struct {
+		name  string
+		query string
+		perms []entity.Permission
+		code  string
+	}{
+		{
+			name:  "create requires users:write",
+			query: `mutation { createUser(input: {userName: "x", firstName: "x", lastName: "x", email: "x@example.com", department: "x"}) { id } }`,
+			perms: []entity.Permission{entity.PermUsersRead},
+			code:  codeForbidden,
+		},
+		{
+			name:  "invalid email",
+			query: `mutation { createUser(input: {userName: "x", firstName: "x", lastName: "x", email: "nope", department: "x"}) { id } }`,
+			perms: write,
+			code:  codeBadInput,
+		},
+		{
+			name:  "duplicate",
+			query: `mutation { createUser(input: {userName: "ada", firstName: "x", lastName: "x", email: "x@example.com", department: "x"}) { id } }`,
+			perms: write,
+			code:  codeConflict,
+		},
+		{
+			name:  "create terminated",
+			query: `mutation { createUser(input: {userName: "x", firstName: "x", lastName: "x", email: "x@example.com", department: "x", status: TERMINATED}) { id } }`,
+			perms: write,
+			code:  codeBadInput,
+		},
+		{
+			name:  "update does not change the status",
+			query: `mutation { updateUser(input: {id: "6", status: TERMINATED}) { id } }`,
+			perms: write,
+			code:  codeConflict,
+		},
+		{
+			name:  "update unknown user",
+			query: `mutation { updateUser(input: {id: "42", department: "x"}) { id } }`,
+			perms: write,
+			code:  codeNotFound,
+		},
+		{
+			name:  "delete requires users:delete",
+			query: `mutation { deleteUser(id: "1") }`,
+			perms: write,
+			code:  codeForbidden,
+		},
+		{
+			name:  "delete unknown user",
+			query: `mutation { deleteUser(id: "42") }`,
+			perms: []entity.Permission{entity.PermUsersDelete},
+			code:  codeNotFound,
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.code, errorCode(run(t, s, tc.query, tc.perms...)))
+		})
+	}
+
+	res = run(t, s, `mutation { deleteUser(id: "6") }`, entity.PermUsersDelete)
+	require.Empty(t, res.Errors)
+	assert.Equal(t, "6", res.Data["deleteUser"])
+}
+
+func TestComplexityLimit(t *testing.T) {
+	s := newTestServer(t, newTestUsers())
+	query := `{ users(first: 200) { nodes { id userName firstName lastName email status emailVerified department { name } } } }`
+	res := run(t, s, query, entity.PermUsersRead)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, "COMPLEXITY_LIMIT_EXCEEDED", errorCode(res))
+
+	res = run(t, s, `{ users(first: 20) { nodes { id userName } } }`, entity.PermUsersRead)
+	assert.Empty(t, res.Errors)
+}
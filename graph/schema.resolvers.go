@@ -0,0 +1,221 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.31
+
+import (
+	"context"
+	"sort"
+
+	dsmodel "github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/graph/model"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+)
+
+// Headcount is the resolver for the headcount field.
+func (r *departmentResolver) Headcount(ctx context.Context, obj *model.Department) (int, error) {
+	users, err := loadersFrom(ctx).departments.Load(ctx, obj.Name)()
+	if err != nil {
+		return 0, r.storeError(ctx, err)
+	}
+	return len(users), nil
+}
+
+// Users is the resolver for the users field.
+func (r *departmentResolver) Users(ctx context.Context, obj *model.Department, first *int, after *string) (*model.UserConnection, error) {
+	users, err := loadersFrom(ctx).departments.Load(ctx, obj.Name)()
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	return page(ctx, users, first, after)
+}
+
+// CreateUser is the resolver for the createUser field.
+func (r *mutationResolver) CreateUser(ctx context.Context, input model.CreateUserInput) (*entity.User, error) {
+	if err := requirePermission(ctx, entity.PermUsersWrite); err != nil {
+		return nil, err
+	}
+	user := &entity.User{
+		UserName:   input.UserName,
+		FirstName:  input.FirstName,
+		LastName:   input.LastName,
+		Email:      input.Email,
+		Department: input.Department,
+		UserStatus: entity.Active,
+	}
+	if input.Status != nil {
+		user.UserStatus = statusFrom(*input.Status)
+	}
+	cus, err := dsmodel.NewCustomer(user)
+	if err != nil {
+		return nil, gqlError(ctx, codeBadInput, err)
+	}
+	out, err := r.userRepo.Create(ctx, cus)
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	ctx = custom_slog.WithUserID(ctx, out.GetID())
+	r.logger.InfoCtx(ctx, "user created")
+	r.sendVerification(ctx, out)
+	created := out.GetExportedCustomer().User
+	return &created, nil
+}
+
+// UpdateUser is the resolver for the updateUser field.
+func (r *mutationResolver) UpdateUser(ctx context.Context, input model.UpdateUserInput) (*entity.User, error) {
+	if err := requirePermission(ctx, entity.PermUsersWrite); err != nil {
+		return nil, err
+	}
+	ctx = custom_slog.WithUserID(ctx, input.ID)
+	current, err := r.userRepo.GetByID(ctx, input.ID)
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	user := current.GetExportedCustomer().User
+	for field, value := range map[*string]*string{
+		&user.UserName:   input.UserName,
+		&user.FirstName:  input.FirstName,
+		&user.LastName:   input.LastName,
+		&user.Email:      input.Email,
+		&user.Department: input.Department,
+	} {
+		if value != nil {
+			*field = *value
+		}
+	}
+	if input.Status != nil {
+		user.UserStatus = statusFrom(*input.Status)
+	}
+	user.EmailVerified = current.GetEmailVerified() && current.GetEmail() == user.Email
+	cus, err := dsmodel.NewCustomer(&user)
+	if err != nil {
+		return nil, gqlError(ctx, codeBadInput, err)
+	}
+	out, err := r.userRepo.Update(ctx, cus)
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	r.logger.InfoCtx(ctx, "user updated")
+	if current.GetEmail() != out.GetEmail() {
+		r.sendVerification(ctx, out)
+	}
+	updated := out.GetExportedCustomer().User
+	return &updated, nil
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, id string) (string, error) {
+	if err := requirePermission(ctx, entity.PermUsersDelete); err != nil {
+		return "", err
+	}
+	ctx = custom_slog.WithUserID(ctx, id)
+	if _, err := r.userRepo.GetByID(ctx, id); err != nil {
+		return "", r.storeError(ctx, err)
+	}
+	if err := r.userRepo.Delete(ctx, id); err != nil {
+		return "", r.storeError(ctx, err)
+	}
+	r.logger.InfoCtx(ctx, "user deleted")
+	return id, nil
+}
+
+// User is the resolver for the user field.
+func (r *queryResolver) User(ctx context.Context, id string) (*entity.User, error) {
+	if err := requirePermission(ctx, entity.PermUsersRead); err != nil {
+		return nil, err
+	}
+	user, err := loadersFrom(ctx).users.Load(ctx, id)()
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	return user, nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, filter *model.UserFilter, first *int, after *string) (*model.UserConnection, error) {
+	if err := requirePermission(ctx, entity.PermUsersRead); err != nil {
+		return nil, err
+	}
+	all, err := r.userRepo.Get(ctx)
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	users := make([]entity.User, 0, len(all))
+	for _, cus := range all.GetExportedCustomers() {
+		if filter.Matches(cus.User, statusOf(cus.User.UserStatus)) {
+			users = append(users, cus.User)
+		}
+	}
+	return page(ctx, users, first, after)
+}
+
+// Departments is the resolver for the departments field.
+func (r *queryResolver) Departments(ctx context.Context) ([]*model.Department, error) {
+	if err := requirePermission(ctx, entity.PermUsersRead); err != nil {
+		return nil, err
+	}
+	byName, err := usersByDepartment(ctx, r.userRepo)
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	departments := make([]*model.Department, 0, len(byName))
+	for name, users := range byName {
+		// the users are known, resolving the department fields needs no query
+		loadersFrom(ctx).departments.Prime(ctx, name, users)
+		departments = append(departments, &model.Department{Name: name})
+	}
+	sort.Slice(departments, func(i, j int) bool { return departments[i].Name < departments[j].Name })
+	return departments, nil
+}
+
+// Department is the resolver for the department field.
+func (r *queryResolver) Department(ctx context.Context, name string) (*model.Department, error) {
+	if err := requirePermission(ctx, entity.PermUsersRead); err != nil {
+		return nil, err
+	}
+	users, err := loadersFrom(ctx).departments.Load(ctx, name)()
+	if err != nil {
+		return nil, r.storeError(ctx, err)
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &model.Department{Name: name}, nil
+}
+
+// Email is the resolver for the email field.
+func (r *userResolver) Email(ctx context.Context, obj *entity.User) (*string, error) {
+	if !can(ctx, entity.PermUsersReadPII) {
+		return nil, nil
+	}
+	return &obj.Email, nil
+}
+
+// Department is the resolver for the department field.
+func (r *userResolver) Department(ctx context.Context, obj *entity.User) (*model.Department, error) {
+	return &model.Department{Name: obj.Department}, nil
+}
+
+// Status is the resolver for the status field.
+func (r *userResolver) Status(ctx context.Context, obj *entity.User) (model.UserStatus, error) {
+	return statusOf(obj.UserStatus), nil
+}
+
+// Department returns DepartmentResolver implementation.
+func (r *Resolver) Department() DepartmentResolver { return &departmentResolver{r} }
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// User returns UserResolver implementation.
+func (r *Resolver) User() UserResolver { return &userResolver{r} }
+
+type departmentResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+var ErrUnknownMailer = errors.New("unknown mailer")
+
+// Message is a plain text email
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer selected by MAILER: smtp, file or memory
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	switch kind := os.Getenv("MAILER"); kind {
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}), nil
+	case "file":
+		return NewFileMailer(os.Getenv("MAIL_DIR"), from)
+	case "", "memory":
+		return NewMemoryMailer(from), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMailer, kind)
+	}
+}
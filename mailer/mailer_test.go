@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type templateData struct {
+	FirstName string
+	UserName  string
+	Email     string
+	Link      string
+	ExpiresAt time.Time
+}
+
+func TestRender(t *testing.T) {
+	data := templateData{
+		FirstName: "john",
+		UserName:  "willi",
+		Email:     "john@example.com",
+		Link:      "http://localhost/auth/email/verify?token=abc",
+		ExpiresAt: time.Date(2030, 1, 2, 3, 4, 0, 0, time.UTC),
+	}
+	testCase := []struct {
+		name     string
+		template string
+		subject  string
+	}{
+		{name: "verify email", template: TemplateVerifyEmail, subject: "Verify your email address"},
+		{name: "reset password", template: TemplateResetPassword, subject: "Reset your password"},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := Render(tc.template, data.Email, data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.subject, msg.Subject)
+			assert.Equal(t, data.Email, msg.To)
+			assert.Contains(t, msg.Body, "Hello john,")
+			assert.Contains(t, msg.Body, data.Link)
+			assert.Contains(t, msg.Body, "2030-01-02 03:04 UTC")
+		})
+	}
+
+	_, err := Render("unknown", data.Email, data)
+	assert.Error(t, err)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer("no-reply@example.com")
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "body"}))
+
+	msgs := m.Messages()
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "no-reply@example.com", msgs[0].From)
+		assert.Equal(t, "a@example.com", msgs[0].To)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir, "no-reply@example.com")
+	require.NoError(t, err)
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "body"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		raw, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(raw), "From: no-reply@example.com\r\nTo: a@example.com\r\n"))
+		assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\nbody"))
+	}
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+	"text/template"
+)
+
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplateResetPassword = "reset_password"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+
+// Render builds a message to "to" from a template. The first line of the
+// rendered template is the subject, the rest the body.
+func Render(name, to string, data any) (Message, error) {
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, name+".tmpl", data); err != nil {
+		return Message{}, err
+	}
+	subject, body, _ := strings.Cut(b.String(), "\n")
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject),
+		Body:    strings.TrimLeft(body, "\n"),
+	}, nil
+}
@@ -0,0 +1,44 @@
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"net/http"
+	"sync"
+)
+
+// assets holds the swagger ui initializer pointing at /openapi.json
+//
+//go:embed assets
+var assets embed.FS
+
+var (
+	specJSONOnce sync.Once
+	specJSON     []byte
+	specJSONErr  error
+)
+
+// ServeSpec answers the document as json
+func ServeSpec(c echo.Context) error {
+	specJSONOnce.Do(func() {
+		specJSON, specJSONErr = json.Marshal(Spec())
+	})
+	if specJSONErr != nil {
+		return specJSONErr
+	}
+	return c.JSONBlob(http.StatusOK, specJSON)
+}
+
+// ServeSwaggerUI serves the swagger ui files below /docs/
+func ServeSwaggerUI(c echo.Context) error {
+	name, files := c.Param("*"), swaggerFiles.FS
+	switch name {
+	case "":
+		name = "."
+	case "swagger-initializer.js":
+		files = echo.MustSubFS(assets, "assets")
+	}
+	return echo.StaticFileHandler(name, files)(c)
+}
@@ -0,0 +1,204 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/scim"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpec(t *testing.T) {
+	doc := Spec()
+	require.NoError(t, doc.Validate(context.Background()))
+
+	user := doc.Components.Schemas["User"].Value
+	assert.ElementsMatch(t, []string{"userName", "firstName", "lastName", "email", "department"}, user.Required)
+	assert.Equal(t, "email", user.Properties["email"].Value.Format)
+	status := user.Properties["userStatus"].Value
+	require.Len(t, status.AnyOf, 2)
+	assert.Equal(t, []any{"inactive", "active", "terminated"}, status.AnyOf[0].Value.Enum)
+	assert.Equal(t, []any{0.0, 1.0, 2.0}, status.AnyOf[1].Value.Enum)
+
+	key := doc.Components.Schemas["APIKey"].Value
+	assert.NotContains(t, key.Properties, "hash")
+	scopes := key.Properties["scopes"].Value
+	assert.Equal(t, uint64(1), scopes.MinItems)
+	assert.Equal(t, []any{"users:read", "users:read_pii", "users:write", "users:delete"}, scopes.Items.Value.Enum)
+	// the embedded api key is promoted
+	issued := doc.Components.Schemas["IssuedAPIKey"].Value
+	assert.Contains(t, issued.Properties, "scopes")
+	assert.Contains(t, issued.Properties, "key")
+
+	code := doc.Components.Schemas["MFACode"].Value.Properties["code"].Value
+	assert.Equal(t, uint64(6), code.MinLength)
+	assert.Equal(t, uint64(6), *code.MaxLength)
+	assert.Equal(t, "^[0-9]+$", code.Pattern)
+
+	create := doc.Paths.Find("/user").Post
+	assert.Equal(t, "#/components/schemas/User", create.RequestBody.Value.Content.Get("application/json").Schema.Ref)
+	assert.NotNil(t, doc.Paths.Find("/auth/login").Post.Security)
+	assert.Nil(t, create.Security)
+}
+
+func newTestValidator(t *testing.T, buf *bytes.Buffer) *echo.Echo {
+	t.Helper()
+	validate, err := NewValidator(ValidationConfig{
+		Requests:  true,
+		Responses: true,
+		Logger:    custom_slog.New(buf, slog.LevelDebug, nil),
+	})
+	require.NoError(t, err)
+	e := echo.New()
+	e.Binder = &utils.CustomBinder{}
+	e.Use(validate)
+	e.POST("/user", func(c echo.Context) error {
+		user := new(entity.User)
+		if err := c.Bind(user); err != nil {
+			return utils.JSON(c, "bind", http.StatusBadRequest, err)
+		}
+		if user.Department == "broken" {
+			// the status is not one of the documented ones
+			user.UserStatus = 9
+		}
+		return utils.JSON(c, "successful", http.StatusCreated, model.ExportCustomer{User: *user})
+	})
+	e.PATCH("/scim/v2/Users/:id", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, scim.MediaType)
+		return c.JSON(http.StatusOK, scim.User{Schemas: []string{scim.SchemaUser}, ID: c.Param("id"), UserName: "jdoe"})
+	})
+	e.GET("/undocumented", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+	return e
+}
+
+func TestValidator(t *testing.T) {
+	valid := `{"userName":"jdoe","firstName":"John","lastName":"Doe","email":"jdoe@example.com","department":"eng","userStatus":1}`
+	testCase := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		errContains string
+		logged      string
+	}{
+		{name: "valid request", method: http.MethodPost, path: "/user", body: valid, status: http.StatusCreated},
+		{
+			name:   "missing required field",
+			method: http.MethodPost, path: "/user",
+			body:        `{"userName":"jdoe","firstName":"John","lastName":"Doe","email":"jdoe@example.com"}`,
+			status:      http.StatusBadRequest,
+			errContains: `property "department" is missing`,
+		},
+		{
+			name:   "named status",
+			method: http.MethodPost, path: "/user",
+			body:   strings.Replace(valid, `"userStatus":1`, `"userStatus":"terminated"`, 1),
+			status: http.StatusCreated,
+		},
+		{
+			name:   "status out of range",
+			method: http.MethodPost, path: "/user",
+			body:        strings.Replace(valid, `"userStatus":1`, `"userStatus":7`, 1),
+			status:      http.StatusBadRequest,
+			errContains: `"/userStatus"`,
+		},
+		{
+			name:   "wrong type",
+			method: http.MethodPost, path: "/user",
+			body:        strings.Replace(valid, `"eng"`, `42`, 1),
+			status:      http.StatusBadRequest,
+			errContains: `"/department"`,
+		},
+		{
+			name:   "undocumented response",
+			method: http.MethodPost, path: "/user",
+			body:   strings.Replace(valid, `"eng"`, `"broken"`, 1),
+			status: http.StatusCreated,
+			logged: "response does not match the openapi document",
+		},
+		{
+			name:   "xml request",
+			method: http.MethodPost, path: "/user",
+			contentType: echo.MIMEApplicationXML,
+			body:        `<user><userName>jdoe</userName><firstName>John</firstName><lastName>Doe</lastName><email>jdoe@example.com</email><department>eng</department><userStatus>1</userStatus></user>`,
+			status:      http.StatusCreated,
+		},
+		{
+			name:   "scim media type",
+			method: http.MethodPatch, path: "/scim/v2/Users/7",
+			contentType: scim.MediaType,
+			body:        `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"active","value":"False"}]}`,
+			status:      http.StatusOK,
+		},
+		{name: "undocumented route", method: http.MethodGet, path: "/undocumented", status: http.StatusOK},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			e := newTestValidator(t, buf)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.contentType == "" {
+				tc.contentType = echo.MIMEApplicationJSON
+			}
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.errContains != "" {
+				res := make(map[string]any)
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+				assert.Contains(t, res["errors"], tc.errContains)
+				// the messages leave the submitted values out
+				assert.NotContains(t, res["errors"], "jdoe@example.com")
+			}
+			if tc.logged != "" {
+				assert.Contains(t, buf.String(), tc.logged)
+			} else {
+				assert.NotContains(t, buf.String(), "response does not match")
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	e := echo.New()
+	e.GET("/openapi.json", ServeSpec)
+	e.GET("/docs/*", ServeSwaggerUI)
+	testCase := []struct {
+		path     string
+		status   int
+		contains string
+	}{
+		{path: "/openapi.json", status: http.StatusOK, contains: `"openapi":"3.0.3"`},
+		{path: "/docs/", status: http.StatusOK, contains: `<div id="swagger-ui">`},
+		{path: "/docs/swagger-initializer.js", status: http.StatusOK, contains: `url: "/openapi.json"`},
+		{path: "/docs/swagger-ui-bundle.js", status: http.StatusOK, contains: "SwaggerUIBundle"},
+		{path: "/docs/missing.js", status: http.StatusNotFound},
+		{path: "/docs/../spec.go", status: http.StatusNotFound},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.contains)
+		})
+	}
+}
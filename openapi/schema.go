@@ -0,0 +1,241 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/scim"
+	"github.com/getkin/kin-openapi/openapi3"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const componentsPrefix = "#/components/schemas/"
+
+// overrides are the schemas of the types whose json encoding is not
+// derived from their go type
+var overrides = map[reflect.Type]func() *openapi3.Schema{
+	reflect.TypeOf(time.Time{}): openapi3.NewDateTimeSchema,
+	reflect.TypeOf(json.RawMessage{}): func() *openapi3.Schema {
+		return &openapi3.Schema{Nullable: true}
+	},
+	reflect.TypeOf(entity.Status(0)): func() *openapi3.Schema {
+		// enum values are compared with the decoded json, numbers decode to float64
+		var names, numbers []any
+		var legend []string
+		for _, status := range entity.Statuses() {
+			names = append(names, status.String())
+			numbers = append(numbers, float64(status))
+			legend = append(legend, fmt.Sprintf("%d %s", status, status))
+		}
+		s := openapi3.NewAnyOfSchema(openapi3.NewStringSchema().WithEnum(names...), openapi3.NewIntegerSchema().WithEnum(numbers...))
+		s.Description = "Named, the legacy numbers are accepted and returned by the v1 routes: " + strings.Join(legend, ", ")
+		return s
+	},
+	reflect.TypeOf(entity.Permission("")): func() *openapi3.Schema {
+		var enum []any
+		for _, p := range []entity.Permission{
+			entity.PermUsersRead, entity.PermUsersReadPII, entity.PermUsersWrite, entity.PermUsersDelete,
+			entity.PermRolesManage, entity.PermAPIKeysManage, entity.PermCredentialsManage,
+			entity.PermWebhooksManage,
+		} {
+			enum = append(enum, string(p))
+		}
+		return openapi3.NewStringSchema().WithEnum(enum...)
+	},
+	reflect.TypeOf(scim.Bool(false)): func() *openapi3.Schema {
+		return openapi3.NewAnyOfSchema(openapi3.NewBoolSchema(), openapi3.NewStringSchema().WithEnum("True", "False"))
+	},
+}
+
+// schemas derives the schemas of go types from their json tags and the
+// validate tags the handlers check requests with. Structs are stored as
+// components and referenced.
+type schemas struct {
+	components openapi3.Schemas
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: make(openapi3.Schemas)}
+}
+
+// of returns the schema of the type of v
+func (s *schemas) of(v any) *openapi3.SchemaRef {
+	return s.ref(reflect.TypeOf(v))
+}
+
+func (s *schemas) ref(t reflect.Type) *openapi3.SchemaRef {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if override, ok := overrides[t]; ok {
+		return override().NewRef()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := s.components[name]; !ok {
+			object := openapi3.NewObjectSchema()
+			s.components[name] = object.NewRef()
+			s.fields(object, t)
+		}
+		return openapi3.NewSchemaRef(componentsPrefix+name, s.components[name].Value)
+	case reflect.Slice, reflect.Array:
+		// nil slices are encoded as null
+		array := openapi3.NewArraySchema().WithNullable()
+		array.Items = s.ref(t.Elem())
+		return array.NewRef()
+	case reflect.Map:
+		object := openapi3.NewObjectSchema().WithNullable()
+		object.AdditionalProperties = openapi3.AdditionalProperties{Schema: s.ref(t.Elem())}
+		return object.NewRef()
+	case reflect.String:
+		return openapi3.NewStringSchema().NewRef()
+	case reflect.Bool:
+		return openapi3.NewBoolSchema().NewRef()
+	case reflect.Int64, reflect.Uint64:
+		return openapi3.NewInt64Schema().NewRef()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return openapi3.NewIntegerSchema().NewRef()
+	case reflect.Float32, reflect.Float64:
+		return openapi3.NewFloat64Schema().NewRef()
+	}
+	// interfaces hold any value
+	return (&openapi3.Schema{Nullable: true}).NewRef()
+}
+
+// fields adds the json fields of the struct t to object, the fields of
+// embedded structs are promoted like encoding/json does
+func (s *schemas) fields(object *openapi3.Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			s.fields(object, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop := s.ref(f.Type)
+		rules := f.Tag.Get("validate")
+		if prop.Ref == "" {
+			constrain(prop.Value, rules)
+			// pointers encode as null when they are unset
+			if f.Type.Kind() == reflect.Pointer {
+				prop.Value.Nullable = true
+			}
+		}
+		if isRequired(rules) {
+			object.Required = append(object.Required, name)
+		}
+		object.Properties[name] = prop
+	}
+}
+
+func componentName(t reflect.Type) string {
+	name := exported(t.Name())
+	switch t.PkgPath() {
+	case reflect.TypeOf(entity.User{}).PkgPath(), reflect.TypeOf(schemas{}).PkgPath():
+		return name
+	}
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	return exported(pkg) + name
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// isRequired reports whether the validate rules of a field require it
+func isRequired(rules string) bool {
+	for _, rule := range strings.Split(rules, ",") {
+		switch rule {
+		case "required":
+			return true
+		case "dive":
+			return false
+		}
+	}
+	return false
+}
+
+// constrain translates the validate rules of a field to its schema, the
+// rules following dive apply to the items of an array
+func constrain(schema *openapi3.Schema, rules string) {
+	if rules == "" {
+		return
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "dive" {
+			if schema.Items == nil || schema.Items.Ref != "" {
+				return
+			}
+			schema = schema.Items.Value
+			continue
+		}
+		key, param, _ := strings.Cut(rule, "=")
+		switch key {
+		case "email":
+			schema.Format = "email"
+		case "numeric":
+			schema.Pattern = "^[0-9]+$"
+		case "oneof":
+			var enum []any
+			for _, v := range strings.Fields(param) {
+				enum = append(enum, v)
+			}
+			schema.Enum = enum
+		case "len":
+			bound(schema, param, true, true)
+		case "min", "gte":
+			bound(schema, param, true, false)
+		case "max", "lte":
+			bound(schema, param, false, true)
+		}
+	}
+}
+
+// bound sets the minimum and/or maximum of the length of strings, the
+// number of items of arrays or the value of numbers
+func bound(schema *openapi3.Schema, param string, min, max bool) {
+	n, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return
+	}
+	switch schema.Type {
+	case openapi3.TypeString:
+		if min {
+			schema.MinLength = n
+		}
+		if max {
+			schema.MaxLength = openapi3.Uint64Ptr(n)
+		}
+	case openapi3.TypeArray:
+		if min {
+			schema.MinItems = n
+		}
+		if max {
+			schema.MaxItems = openapi3.Uint64Ptr(n)
+		}
+	case openapi3.TypeInteger, openapi3.TypeNumber:
+		if min {
+			schema.Min = openapi3.Float64Ptr(float64(n))
+		}
+		if max {
+			schema.Max = openapi3.Float64Ptr(float64(n))
+		}
+	}
+}
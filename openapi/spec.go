@@ -0,0 +1,868 @@
+// Package openapi describes the http api in an OpenAPI 3 document, serves
+// it with Swagger UI and validates requests and responses against it.
+package openapi
+
+import (
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/scim"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/getkin/kin-openapi/openapi3"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	tagUsers       = "users"
+	tagDepartments = "departments"
+	tagGroups      = "groups"
+	tagAuth        = "auth"
+	tagSCIM        = "scim"
+	tagGraphQL     = "graphql"
+	tagAdmin       = "admin"
+	tagMeta        = "meta"
+
+	securityBearer  = "bearer"
+	securityAPIKey  = "apiKey"
+	securitySession = "session"
+)
+
+// errorResponse is the body utils.JSON answers failures with, errors
+// raised by echo itself only carry the message
+type errorResponse struct {
+	Message   string `json:"message" validate:"required"`
+	Errors    string `json:"errors"`
+	Status    string `json:"status"`
+	RequestID string `json:"requestId"`
+}
+
+// issuedAPIKey is the response carrying the plaintext of a new api key
+type issuedAPIKey struct {
+	entity.APIKey
+	Key string `json:"key"`
+}
+
+// issuedWebhook is the response carrying the secret of a webhook
+type issuedWebhook struct {
+	entity.Webhook
+	Secret string `json:"secret"`
+}
+
+type replayedDeliveries struct {
+	Replayed int `json:"replayed"`
+}
+
+// graphQLRequest is the body of graphql operations
+type graphQLRequest struct {
+	Query         string         `json:"query" validate:"required"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+var (
+	specOnce sync.Once
+	spec     *openapi3.T
+)
+
+// Spec returns the document of the api served by router.Router
+func Spec() *openapi3.T {
+	specOnce.Do(func() {
+		spec = newDocument().build()
+	})
+	return spec
+}
+
+type document struct {
+	doc     *openapi3.T
+	schemas *schemas
+	// v is the api version of the operations added, the zero version adds
+	// unversioned routes with the v1 envelope
+	v version
+}
+
+// version is an api version of the /user, /departments, /groups, /auth and /admin routes
+type version struct {
+	// prefix is the path prefix of its routes
+	prefix string
+	// id prefixes the operation ids
+	id string
+	// envelope is the version of the envelope of utils.JSON
+	envelope   int
+	deprecated bool
+}
+
+// versions are the versions of the routes, the unversioned ones alias v1
+var versions = []version{
+	{envelope: 1, deprecated: true},
+	{prefix: "/v1", id: "v1", envelope: 1, deprecated: true},
+	{prefix: "/v2", id: "v2", envelope: 2},
+}
+
+// at returns a builder adding the operations of version v
+func (d document) at(v version) *document {
+	d.v = v
+	return &d
+}
+
+func newDocument() *document {
+	return &document{
+		doc: &openapi3.T{
+			OpenAPI: "3.0.3",
+			Info: &openapi3.Info{
+				Title:       "assessment-bg user api",
+				Description: "Users, their credentials and access. The /user, /departments, /groups, /auth and /admin routes are versioned: /v1 and the unversioned aliases wrap their responses in a message, status and data envelope and are deprecated, /v2 wraps them in a data or error envelope.",
+				Version:     "1.0.0",
+			},
+			Servers: openapi3.Servers{{URL: "/"}},
+			Paths:   openapi3.Paths{},
+			Components: &openapi3.Components{
+				SecuritySchemes: openapi3.SecuritySchemes{
+					securityBearer: &openapi3.SecuritySchemeRef{Value: openapi3.NewJWTSecurityScheme()},
+					securityAPIKey: &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().
+						WithType("apiKey").WithIn(openapi3.ParameterInHeader).WithName(auth.HeaderAPIKey)},
+					securitySession: &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().
+						WithType("apiKey").WithIn(openapi3.ParameterInCookie).WithName(auth.SessionCookie)},
+				},
+			},
+			Security: *openapi3.NewSecurityRequirements().With(
+				openapi3.NewSecurityRequirement().Authenticate(securityBearer)).With(
+				openapi3.NewSecurityRequirement().Authenticate(securityAPIKey)).With(
+				openapi3.NewSecurityRequirement().Authenticate(securitySession)),
+		},
+		schemas: newSchemas(),
+	}
+}
+
+// operation builds the operation of one route
+type operation struct {
+	d  *document
+	op *openapi3.Operation
+}
+
+func (d *document) add(method, path, id, summary, tag string) *operation {
+	path = d.v.prefix + path
+	if d.v.id != "" {
+		id = d.v.id + exported(id)
+	}
+	op := &openapi3.Operation{
+		OperationID: id,
+		Summary:     summary,
+		Tags:        []string{tag},
+		Responses:   openapi3.Responses{},
+		Deprecated:  d.v.deprecated,
+	}
+	for _, name := range pathParams(path) {
+		op.AddParameter(openapi3.NewPathParameter(name).WithSchema(openapi3.NewStringSchema()))
+	}
+	item := d.doc.Paths[path]
+	if item == nil {
+		item = &openapi3.PathItem{}
+		d.doc.Paths[path] = item
+	}
+	item.SetOperation(method, op)
+	if d.v.deprecated {
+		o := &operation{d: d, op: op}
+		return o.describe("Deprecated in favor of /v2, the responses carry Deprecation and Sunset headers once they are dated.")
+	}
+	return &operation{d: d, op: op}
+}
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+func pathParams(path string) []string {
+	var names []string
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// public marks an operation callable without credentials
+func (o *operation) public() *operation {
+	o.op.Security = openapi3.NewSecurityRequirements()
+	return o
+}
+
+// requires documents the permission checked by the route
+func (o *operation) requires(perm entity.Permission) *operation {
+	o.op.Description = strings.TrimSpace(fmt.Sprintf("%s Requires the %s permission.", o.op.Description, perm))
+	return o
+}
+
+func (o *operation) describe(description string) *operation {
+	o.op.Description = strings.TrimSpace(description + " " + o.op.Description)
+	return o
+}
+
+func (o *operation) query(name string, schema *openapi3.Schema, required bool) *operation {
+	p := openapi3.NewQueryParameter(name).WithSchema(schema)
+	p.Required = required
+	o.op.AddParameter(p)
+	return o
+}
+
+// negotiatedMediaTypes are the media types utils.JSON renders and
+// utils.CustomBinder decodes besides text/csv, which only renders collections
+var negotiatedMediaTypes = []string{"application/json", "application/xml", "application/yaml", "application/msgpack"}
+
+// body documents the request body decoded into v, json unless the routes
+// are versioned and bound by utils.CustomBinder
+func (o *operation) body(v any, required bool, mediaTypes ...string) *operation {
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"application/json"}
+		if o.d.v.envelope != 0 {
+			mediaTypes = negotiatedMediaTypes
+		}
+	}
+	schema := o.d.schemas.of(v)
+	o.op.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().
+		WithRequired(required).
+		WithSchemaRef(schema, mediaTypes)}
+	return o
+}
+
+// returns documents a response with a body of mediaType
+func (o *operation) returns(status int, mediaType string, schema *openapi3.SchemaRef) *operation {
+	res := openapi3.NewResponse().WithDescription(http.StatusText(status))
+	if schema != nil {
+		res.Content = openapi3.NewContentWithSchemaRef(schema, []string{mediaType})
+	}
+	o.op.AddResponse(status, res)
+	return o
+}
+
+// negotiated documents a response of utils.JSON in every media type it
+// renders, collections are also rendered as csv
+func (o *operation) negotiated(status int, schema *openapi3.SchemaRef, collection bool) *operation {
+	content := openapi3.NewContentWithSchemaRef(schema, negotiatedMediaTypes)
+	if collection {
+		content["text/csv"] = openapi3.NewMediaType().WithSchema(openapi3.NewStringSchema())
+	}
+	o.op.AddResponse(status, openapi3.NewResponse().WithDescription(http.StatusText(status)).WithContent(content))
+	if o.op.Responses.Get(http.StatusNotAcceptable) == nil {
+		o.fails(http.StatusNotAcceptable)
+	}
+	return o
+}
+
+func isCollection(data any) bool {
+	kind := reflect.TypeOf(data).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// ok documents a response wrapped in the envelope of utils.JSON, a nil
+// data is encoded as null
+func (o *operation) ok(status int, data any) *operation {
+	if o.d.v.envelope >= 2 {
+		return o.okV2(status, data)
+	}
+	schema := openapi3.NewObjectSchema().
+		WithProperty("message", openapi3.NewStringSchema()).
+		WithProperty("status", openapi3.NewStringSchema())
+	schema.Required = []string{"message", "status", "data"}
+	if data == nil {
+		schema.WithProperty("data", &openapi3.Schema{Nullable: true})
+	} else {
+		schema.WithPropertyRef("data", o.d.schemas.of(data))
+	}
+	return o.negotiated(status, schema.NewRef(), data != nil && isCollection(data))
+}
+
+// okV2 documents a response wrapped in the v2 envelope of utils.JSON
+func (o *operation) okV2(status int, data any) *operation {
+	schema := openapi3.NewObjectSchema()
+	schema.Required = []string{"data"}
+	if data == nil {
+		schema.WithProperty("data", &openapi3.Schema{Nullable: true})
+	} else {
+		schema.WithPropertyRef("data", o.d.schemas.of(data))
+	}
+	return o.negotiated(status, schema.NewRef(), data != nil && isCollection(data))
+}
+
+// fails documents the failures answered by utils.JSON, they are never
+// rendered as csv
+func (o *operation) fails(statuses ...int) *operation {
+	schema := o.d.schemas.of(errorResponse{})
+	if o.d.v.envelope >= 2 {
+		schema = o.d.schemas.of(utils.ErrorV2{})
+	}
+	for _, status := range statuses {
+		o.op.AddResponse(status, openapi3.NewResponse().
+			WithDescription(http.StatusText(status)).
+			WithContent(openapi3.NewContentWithSchemaRef(schema, negotiatedMediaTypes)))
+	}
+	return o
+}
+
+// scim documents a scim response, failures are answered with scim errors
+func (o *operation) scim(status int, v any) *operation {
+	if v != nil {
+		o.returns(status, scim.MediaType, o.d.schemas.of(v))
+	} else {
+		o.returns(status, "", nil)
+	}
+	return o
+}
+
+func (o *operation) scimFails(statuses ...int) *operation {
+	schema := o.d.schemas.of(scim.Error{})
+	for _, status := range statuses {
+		o.returns(status, scim.MediaType, schema)
+	}
+	return o
+}
+
+// scimList is a list response of resources of the type of v
+func (d *document) scimList(v any) *openapi3.SchemaRef {
+	resources := openapi3.NewArraySchema()
+	resources.Items = d.schemas.of(v)
+	list := &openapi3.Schema{AllOf: openapi3.SchemaRefs{
+		d.schemas.of(scim.ListResponse{}),
+		openapi3.NewObjectSchema().WithProperty("Resources", resources).NewRef(),
+	}}
+	return list.NewRef()
+}
+
+func (d *document) build() *openapi3.T {
+	d.meta()
+	for _, v := range versions {
+		d.at(v).users()
+		d.at(v).departments()
+		d.at(v).groups()
+		d.at(v).auth()
+		d.at(v).admin()
+	}
+	d.scim()
+	d.graphql()
+	d.doc.Components.Schemas = d.schemas.components
+	return d.doc
+}
+
+func (d *document) meta() {
+	message := openapi3.NewObjectSchema().WithProperty("message", openapi3.NewStringSchema())
+	d.add(http.MethodGet, "/", "health", "Report that the server is running", tagMeta).public().
+		returns(http.StatusOK, "application/json", message.NewRef())
+	d.add(http.MethodGet, "/openapi.json", "openAPI", "This document", tagMeta).public().
+		returns(http.StatusOK, "application/json", openapi3.NewObjectSchema().NewRef())
+	d.add(http.MethodGet, "/docs/{path}", "swaggerUI", "Swagger UI, open /docs/", tagMeta).public().
+		returns(http.StatusOK, "", nil).
+		returns(http.StatusNotFound, "", nil)
+}
+
+func (d *document) users() {
+	authFails := []int{http.StatusUnauthorized, http.StatusForbidden}
+	if d.v.envelope >= 2 {
+		d.usersV2(authFails)
+	} else {
+		d.add(http.MethodPost, "/user", "createUser", "Create a user", tagUsers).
+			requires(entity.PermUsersWrite).
+			describe("A verification email is sent to the address of the user. The department must exist, see /departments. Users are created inactive or active.").
+			body(entity.User{}, true).
+			ok(http.StatusCreated, model.ExportCustomer{}).
+			fails(append(authFails, http.StatusBadRequest)...)
+		d.add(http.MethodGet, "/user", "listUsers", "List the users", tagUsers).
+			requires(entity.PermUsersRead).
+			describe("Emails are empty unless the caller holds the users:read_pii permission.").
+			ok(http.StatusOK, model.ExportCustomers{}).
+			fails(append(authFails, http.StatusBadRequest)...)
+		d.add(http.MethodPut, "/user", "updateUser", "Replace the user with the id of the body", tagUsers).
+			requires(entity.PermUsersWrite).
+			describe("The status is kept, a different one is answered with 409.").
+			body(entity.User{}, true).
+			ok(http.StatusOK, model.ExportCustomer{}).
+			fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)...)
+		d.add(http.MethodDelete, "/user/{id}", "deleteUser", "Delete a user", tagUsers).
+			requires(entity.PermUsersDelete).
+			ok(http.StatusOK, nil).
+			fails(append(authFails, http.StatusBadRequest)...)
+	}
+
+	d.statuses(authFails)
+	d.managers(authFails)
+
+	self := "Users manage their own, managing others requires the credentials:manage permission."
+	d.add(http.MethodPut, "/user/{id}/password", "setPassword", "Set the password of a user", tagUsers).
+		describe(self+" Users replacing their password confirm it with currentPassword, a wrong one is answered with 403. "+
+			"Every session of the user is revoked.").
+		body(entity.PasswordChange{}, true).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/user/{id}/sessions", "listSessions", "List the sessions of a user", tagUsers).
+		describe(self).
+		ok(http.StatusOK, []entity.Session{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/user/{id}/sessions", "revokeSessions", "Revoke every session of a user", tagUsers).
+		describe(self).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/user/{id}/sessions/{sid}", "revokeSession", "Revoke a session of a user", tagUsers).
+		describe(self).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/user/{id}/email/verification", "resendVerification", "Send a new verification email", tagUsers).
+		describe(self+" The users:write permission also allows it.").
+		ok(http.StatusAccepted, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+
+	mfa := "Users only manage their own second factor."
+	d.add(http.MethodPost, "/user/{id}/mfa/totp", "enrollTOTP", "Start the enrollment of an authenticator app", tagUsers).
+		describe(mfa).
+		ok(http.StatusCreated, entity.TOTPSetup{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/user/{id}/mfa/totp/confirm", "confirmTOTP", "Confirm the enrollment with a first code", tagUsers).
+		describe(mfa+" The recovery codes are only returned once.").
+		body(entity.MFACode{}, true).
+		ok(http.StatusOK, entity.RecoveryCodes{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/user/{id}/mfa/recovery-codes", "regenerateRecoveryCodes", "Replace the recovery codes", tagUsers).
+		describe(mfa).
+		body(entity.MFACode{}, true).
+		ok(http.StatusOK, entity.RecoveryCodes{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...)
+}
+
+// statuses move users along the transitions of entity.Status
+func (d *document) statuses(authFails []int) {
+	var user any = model.ExportCustomer{}
+	if d.v.envelope >= 2 {
+		user = entity.User{}
+	}
+	transitions := "Inactive users can be activated, active users deactivated or terminated, terminated users are final. " +
+		"Changes not allowed from the current status are answered with 409, the change is recorded in the status history. " +
+		"Terminated users hand their reports to their own manager."
+	for _, change := range []struct{ path, id, summary string }{
+		{path: "activate", id: "activateUser", summary: "Activate a user"},
+		{path: "deactivate", id: "deactivateUser", summary: "Deactivate a user"},
+		{path: "terminate", id: "terminateUser", summary: "Terminate a user"},
+	} {
+		d.add(http.MethodPost, "/user/{id}/"+change.path, change.id, change.summary, tagUsers).
+			requires(entity.PermUsersWrite).
+			describe(transitions).
+			body(entity.StatusReason{}, true).
+			ok(http.StatusOK, user).
+			fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	}
+	d.add(http.MethodGet, "/user/{id}/status-history", "getStatusHistory", "List the status changes of a user", tagUsers).
+		requires(entity.PermUsersRead).
+		describe("Oldest first. Changes made by replacing the user have no reason.").
+		ok(http.StatusOK, []entity.StatusChange{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/user/{id}/scheduled-changes", "scheduleChange", "Schedule a status change or department move", tagUsers).
+		requires(entity.PermUsersWrite).
+		describe("A status, a department or both are applied once effectiveAt, a future time, has passed. "+
+			"A status the current one can't change to is answered with 409, "+
+			"a status change the transitions no longer allow then is marked failed.").
+		body(entity.ScheduledChangeRequest{}, true).
+		ok(http.StatusCreated, entity.ScheduledChange{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+}
+
+// managers keep who reports to whom, they are shared by the versions
+func (d *document) managers(authFails []int) {
+	var user any = model.ExportCustomer{}
+	if d.v.envelope >= 2 {
+		user = entity.User{}
+	}
+	pii := "Emails are empty unless the caller holds the users:read_pii permission."
+	d.add(http.MethodPut, "/user/{id}/manager", "setManager", "Set the manager of a user", tagUsers).
+		requires(entity.PermUsersWrite).
+		describe("A null managerId removes the manager. An unknown manager is answered with 400, "+
+			"a terminated manager or one reporting to the user with 409.").
+		body(entity.ManagerAssignment{}, true).
+		ok(http.StatusOK, user).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/user/{id}/reports", "listDirectReports", "List the users managed by a user", tagUsers).
+		requires(entity.PermUsersRead).
+		describe(pii).
+		ok(http.StatusOK, []entity.User{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/user/{id}/reporting-chain", "getReportingChain", "List the managers above a user", tagUsers).
+		requires(entity.PermUsersRead).
+		describe("From the manager of the user up to a user without a manager. "+pii).
+		ok(http.StatusOK, []entity.User{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/user/org-chart", "getOrgChart", "The org chart", tagUsers).
+		requires(entity.PermUsersRead).
+		describe("The users without a manager with the users reporting to them nested in reports. "+pii).
+		ok(http.StatusOK, []entity.OrgNode{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+}
+
+// usersV2 take the id from the path
+func (d *document) usersV2(authFails []int) {
+	d.add(http.MethodPost, "/user", "createUser", "Create a user", tagUsers).
+		requires(entity.PermUsersWrite).
+		describe("A verification email is sent to the address of the user. The department must exist, see /departments. Users are created inactive or active.").
+		body(entity.User{}, true).
+		ok(http.StatusCreated, entity.User{}).
+		fails(append(authFails, http.StatusBadRequest)...)
+	d.add(http.MethodGet, "/user", "listUsers", "List the users", tagUsers).
+		requires(entity.PermUsersRead).
+		describe("Emails are empty unless the caller holds the users:read_pii permission.").
+		ok(http.StatusOK, []entity.User{}).
+		fails(append(authFails, http.StatusBadRequest)...)
+	d.add(http.MethodGet, "/user/{id}", "getUser", "Get a user", tagUsers).
+		requires(entity.PermUsersRead).
+		describe("The email is empty unless the caller holds the users:read_pii permission.").
+		ok(http.StatusOK, entity.User{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound)...)
+	d.add(http.MethodPut, "/user/{id}", "updateUser", "Replace a user", tagUsers).
+		requires(entity.PermUsersWrite).
+		describe("The id of the body is optional and must match the path. The status is kept, a different one is answered with 409.").
+		body(entity.User{}, true).
+		ok(http.StatusOK, entity.User{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)...)
+	d.add(http.MethodDelete, "/user/{id}", "deleteUser", "Delete a user", tagUsers).
+		requires(entity.PermUsersDelete).
+		returns(http.StatusNoContent, "", nil).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound)...)
+}
+
+func (d *document) departments() {
+	authFails := []int{http.StatusUnauthorized, http.StatusForbidden}
+	d.add(http.MethodGet, "/departments", "listDepartments", "List the departments", tagDepartments).
+		requires(entity.PermUsersRead).
+		ok(http.StatusOK, []entity.Department{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/departments/{id}", "getDepartment", "Get a department", tagDepartments).
+		requires(entity.PermUsersRead).
+		ok(http.StatusOK, entity.Department{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/departments", "createDepartment", "Create a department", tagDepartments).
+		requires(entity.PermUsersWrite).
+		describe("Names are unique regardless of case. Users reference their department by name. An unknown parentId is answered with 400.").
+		body(entity.Department{}, true).
+		ok(http.StatusCreated, entity.Department{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodPut, "/departments/{id}", "renameDepartment", "Rename a department", tagDepartments).
+		requires(entity.PermUsersWrite).
+		describe("Its members move with it.").
+		body(entity.Department{}, true).
+		ok(http.StatusOK, entity.Department{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodPut, "/departments/{id}/parent", "moveDepartment", "Move a department below another", tagDepartments).
+		requires(entity.PermUsersWrite).
+		describe("Everything below it moves with it, a null parentId makes it a root. Moving a department below itself is answered with 409.").
+		body(entity.DepartmentParent{}, true).
+		ok(http.StatusOK, entity.Department{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/departments/{id}/subtree", "departmentSubtree", "List a department and the departments below it", tagDepartments).
+		requires(entity.PermUsersRead).
+		describe("Ordered by depth then name, the department itself has depth 0. Headcounts include the users of every department below.").
+		ok(http.StatusOK, []entity.DepartmentNode{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/departments/{id}/ancestors", "departmentAncestors", "List the departments above a department", tagDepartments).
+		requires(entity.PermUsersRead).
+		describe("From its parent at depth 1 up to its root.").
+		ok(http.StatusOK, []entity.DepartmentNode{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/departments/{id}/users", "departmentUsers", "List the users of a department and the departments below it", tagDepartments).
+		requires(entity.PermUsersRead).
+		describe("Emails are hidden without the users:read_pii permission.").
+		ok(http.StatusOK, []entity.User{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/departments/{id}/merge", "mergeDepartment", "Merge a department into another", tagDepartments).
+		requires(entity.PermUsersWrite).
+		describe("Its members, scheduled moves and the departments below it move to the department into at once, then it is deleted. The merged department is returned. Merging into a department below it is answered with 409.").
+		body(entity.DepartmentMerge{}, true).
+		ok(http.StatusOK, entity.Department{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/departments/{id}", "deleteDepartment", "Delete a department", tagDepartments).
+		requires(entity.PermUsersDelete).
+		describe("Departments with members, departments below them or scheduled moves are merged instead.").
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+}
+
+func (d *document) groups() {
+	authFails := []int{http.StatusUnauthorized, http.StatusForbidden}
+	rules := "A user is a dynamic member of a group meeting every one of its rules, rules compare userName, department, userStatus, " +
+		"emailVerified or attributes.<key> without regard to case. Dynamic members are recomputed whenever a user, a group or a department changes."
+	d.add(http.MethodGet, "/groups", "listGroups", "List the groups", tagGroups).
+		requires(entity.PermUsersRead).
+		ok(http.StatusOK, []entity.Group{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/groups/{id}", "getGroup", "Get a group", tagGroups).
+		requires(entity.PermUsersRead).
+		ok(http.StatusOK, entity.Group{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/groups", "createGroup", "Create a group", tagGroups).
+		requires(entity.PermUsersWrite).
+		describe("Names are unique regardless of case. "+rules).
+		body(entity.Group{}, true).
+		ok(http.StatusCreated, entity.Group{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodPut, "/groups/{id}", "updateGroup", "Replace a group", tagGroups).
+		requires(entity.PermUsersWrite).
+		describe("Its static members are kept, its dynamic members are recomputed from the new rules.").
+		body(entity.Group{}, true).
+		ok(http.StatusOK, entity.Group{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/groups/{id}", "deleteGroup", "Delete a group", tagGroups).
+		requires(entity.PermUsersDelete).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/groups/{id}/members", "listGroupMembers", "List the members of a group", tagGroups).
+		requires(entity.PermUsersRead).
+		describe("Static members were added by hand, dynamic members meet the rules of the group, a user can be both. "+
+			"Emails are hidden without the users:read_pii permission.").
+		ok(http.StatusOK, []entity.GroupMember{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/groups/{id}/members", "addGroupMember", "Add a static member to a group", tagGroups).
+		requires(entity.PermUsersWrite).
+		describe("An unknown user is answered with 400.").
+		body(entity.GroupMemberRequest{}, true).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/groups/{id}/members/{userId}", "removeGroupMember", "Remove a static member from a group", tagGroups).
+		requires(entity.PermUsersWrite).
+		describe("A user meeting the rules of the group stays a dynamic member.").
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/user/{id}/groups", "listUserGroups", "List the groups of a user", tagUsers).
+		requires(entity.PermUsersRead).
+		ok(http.StatusOK, []entity.UserGroup{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+}
+
+func (d *document) auth() {
+	d.add(http.MethodPost, "/auth/login", "login", "Sign in with a user name and password", tagAuth).public().
+		describe("Sets the session cookie, users with a second factor get a challenge to redeem at /auth/login/mfa instead.").
+		body(entity.Login{}, true).
+		ok(http.StatusOK, entity.Session{}).
+		ok(http.StatusAccepted, entity.MFAChallenge{}).
+		fails(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError)
+	d.add(http.MethodPost, "/auth/login/mfa", "loginMFA", "Redeem a login challenge with a second factor", tagAuth).public().
+		describe("Sets the session cookie.").
+		body(entity.MFALogin{}, true).
+		ok(http.StatusOK, entity.Session{}).
+		fails(http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError)
+	d.add(http.MethodPost, "/auth/logout", "logout", "Revoke the current session", tagAuth).
+		ok(http.StatusOK, nil).
+		fails(http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError)
+	d.add(http.MethodPost, "/auth/password/forgot", "forgotPassword", "Mail a password reset link", tagAuth).public().
+		describe("Always accepted so it cannot be used to find out which addresses exist.").
+		body(entity.PasswordResetRequest{}, true).
+		ok(http.StatusAccepted, nil).
+		fails(http.StatusBadRequest)
+	d.add(http.MethodPost, "/auth/password/reset", "resetPassword", "Redeem a reset token for a new password", tagAuth).public().
+		body(entity.TokenRedemption{}, true).
+		ok(http.StatusOK, nil).
+		fails(http.StatusBadRequest, http.StatusInternalServerError)
+	d.add(http.MethodGet, "/auth/email/verify", "verifyEmailLink", "Redeem the verification link of an email", tagAuth).public().
+		query("token", openapi3.NewStringSchema().WithMaxLength(128), true).
+		ok(http.StatusOK, nil).
+		fails(http.StatusBadRequest, http.StatusInternalServerError)
+	d.add(http.MethodPost, "/auth/email/verify", "verifyEmail", "Redeem a verification token", tagAuth).public().
+		describe("The token is read from the token query parameter or the body.").
+		query("token", openapi3.NewStringSchema().WithMaxLength(128), false).
+		body(entity.TokenRedemption{}, false).
+		ok(http.StatusOK, nil).
+		fails(http.StatusBadRequest, http.StatusInternalServerError)
+}
+
+func (d *document) scim() {
+	authFails := []int{http.StatusUnauthorized, http.StatusForbidden}
+	d.add(http.MethodGet, "/scim/v2/ServiceProviderConfig", "scimServiceProviderConfig", "SCIM service provider configuration", tagSCIM).
+		scim(http.StatusOK, scim.ServiceProviderConfig{}).
+		fails(authFails...)
+	d.add(http.MethodGet, "/scim/v2/ResourceTypes", "scimResourceTypes", "SCIM resource types", tagSCIM).
+		returns(http.StatusOK, scim.MediaType, d.scimList(scim.ResourceType{})).
+		fails(authFails...)
+	d.add(http.MethodGet, "/scim/v2/ResourceTypes/{id}", "scimResourceType", "SCIM resource type", tagSCIM).
+		scim(http.StatusOK, scim.ResourceType{}).
+		scimFails(http.StatusNotFound).
+		fails(authFails...)
+	d.add(http.MethodGet, "/scim/v2/Schemas", "scimSchemas", "SCIM schemas", tagSCIM).
+		returns(http.StatusOK, scim.MediaType, d.scimList(scim.Schema{})).
+		fails(authFails...)
+	d.add(http.MethodGet, "/scim/v2/Schemas/{id}", "scimSchema", "SCIM schema", tagSCIM).
+		scim(http.StatusOK, scim.Schema{}).
+		scimFails(http.StatusNotFound).
+		fails(authFails...)
+
+	// the scim handlers decode bodies of any content type
+	mediaTypes := []string{scim.MediaType, "application/json"}
+	d.add(http.MethodGet, "/scim/v2/Users", "scimListUsers", "List SCIM users", tagSCIM).
+		requires(entity.PermUsersRead).
+		query("filter", openapi3.NewStringSchema(), false).
+		query("startIndex", openapi3.NewIntegerSchema(), false).
+		query("count", openapi3.NewIntegerSchema(), false).
+		returns(http.StatusOK, scim.MediaType, d.scimList(scim.User{})).
+		scimFails(http.StatusBadRequest, http.StatusInternalServerError).
+		fails(authFails...)
+	d.add(http.MethodGet, "/scim/v2/Users/{id}", "scimGetUser", "Get a SCIM user", tagSCIM).
+		requires(entity.PermUsersRead).
+		scim(http.StatusOK, scim.User{}).
+		scimFails(http.StatusNotFound, http.StatusInternalServerError).
+		fails(authFails...)
+	d.add(http.MethodPost, "/scim/v2/Users", "scimCreateUser", "Provision a user", tagSCIM).
+		requires(entity.PermUsersWrite).
+		body(scim.User{}, true, mediaTypes...).
+		scim(http.StatusCreated, scim.User{}).
+		scimFails(http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError).
+		fails(authFails...)
+	d.add(http.MethodPut, "/scim/v2/Users/{id}", "scimReplaceUser", "Replace a SCIM user", tagSCIM).
+		requires(entity.PermUsersWrite).
+		body(scim.User{}, true, mediaTypes...).
+		scim(http.StatusOK, scim.User{}).
+		scimFails(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError).
+		fails(authFails...)
+	d.add(http.MethodPatch, "/scim/v2/Users/{id}", "scimPatchUser", "Patch a SCIM user", tagSCIM).
+		requires(entity.PermUsersWrite).
+		body(scim.PatchRequest{}, true, mediaTypes...).
+		scim(http.StatusOK, scim.User{}).
+		scimFails(http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError).
+		fails(authFails...)
+	d.add(http.MethodDelete, "/scim/v2/Users/{id}", "scimDeleteUser", "Deprovision a user", tagSCIM).
+		requires(entity.PermUsersDelete).
+		scim(http.StatusNoContent, nil).
+		scimFails(http.StatusNotFound, http.StatusInternalServerError).
+		fails(authFails...)
+}
+
+func (d *document) graphql() {
+	result := d.schemas.of(graphql.Response{})
+	description := "Runs a query of the graphql schema served by the graph package, fields check the permissions of the caller."
+	d.add(http.MethodGet, "/graphql", "graphqlQuery", "Run a graphql query", tagGraphQL).
+		describe(description).
+		query("query", openapi3.NewStringSchema(), true).
+		query("operationName", openapi3.NewStringSchema(), false).
+		query("variables", openapi3.NewStringSchema(), false).
+		returns(http.StatusOK, "application/json", result).
+		returns(http.StatusUnprocessableEntity, "application/json", result).
+		fails(http.StatusUnauthorized)
+	d.add(http.MethodPost, "/graphql", "graphql", "Run a graphql operation", tagGraphQL).
+		describe(description).
+		body(graphQLRequest{}, true).
+		returns(http.StatusOK, "application/json", result).
+		returns(http.StatusUnprocessableEntity, "application/json", result).
+		fails(http.StatusUnauthorized)
+}
+
+func (d *document) admin() {
+	authFails := []int{http.StatusUnauthorized, http.StatusForbidden}
+	d.add(http.MethodGet, "/admin/roles", "listRoles", "List the roles", tagAdmin).
+		requires(entity.PermRolesManage).
+		ok(http.StatusOK, []entity.Role{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/admin/role-bindings", "listRoleBindings", "List the role bindings", tagAdmin).
+		requires(entity.PermRolesManage).
+		query("subject", openapi3.NewStringSchema(), false).
+		ok(http.StatusOK, []entity.RoleBinding{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/admin/role-bindings", "bindRole", "Grant a role to a subject", tagAdmin).
+		requires(entity.PermRolesManage).
+		body(entity.RoleBinding{}, true).
+		ok(http.StatusCreated, entity.RoleBinding{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/admin/role-bindings/{subject}/{role}", "unbindRole", "Revoke a role of a subject", tagAdmin).
+		requires(entity.PermRolesManage).
+		describe("The subject is path escaped, admins cannot revoke their own admin role.").
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...)
+
+	d.add(http.MethodPost, "/admin/api-keys", "createAPIKey", "Issue an api key", tagAdmin).
+		requires(entity.PermAPIKeysManage).
+		describe("The plaintext key is only returned once.").
+		body(entity.APIKey{}, true).
+		ok(http.StatusCreated, issuedAPIKey{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/admin/api-keys", "listAPIKeys", "List the api keys", tagAdmin).
+		requires(entity.PermAPIKeysManage).
+		ok(http.StatusOK, []entity.APIKey{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/admin/api-keys/{id}/rotate", "rotateAPIKey", "Replace the secret of an api key", tagAdmin).
+		requires(entity.PermAPIKeysManage).
+		ok(http.StatusOK, issuedAPIKey{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/admin/api-keys/{id}", "revokeAPIKey", "Revoke an api key", tagAdmin).
+		requires(entity.PermAPIKeysManage).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+
+	d.add(http.MethodDelete, "/admin/users/{id}/mfa", "resetMFA", "Remove the second factor of a user", tagAdmin).
+		requires(entity.PermCredentialsManage).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/admin/login-lockouts", "listLockouts", "List the locked accounts and ips", tagAdmin).
+		requires(entity.PermCredentialsManage).
+		ok(http.StatusOK, []entity.LoginThrottle{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/admin/users/{id}/lockout", "unlockUser", "Unlock the account of a user", tagAdmin).
+		requires(entity.PermCredentialsManage).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/admin/login-lockouts/ip/{ip}", "unlockIP", "Unlock a client ip", tagAdmin).
+		requires(entity.PermCredentialsManage).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+
+	d.add(http.MethodPost, "/admin/webhooks", "createWebhook", "Subscribe a url to the events of users", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		describe("Every event type is delivered when eventTypes is empty. The deliveries are posted with the "+
+			"X-Webhook-Timestamp header, unix seconds, and the X-Webhook-Signature header, sha256= followed by the "+
+			"hex HMAC-SHA256 of the timestamp, a dot and the body keyed by the secret. The secret is only returned once.").
+		body(entity.Webhook{}, true).
+		ok(http.StatusCreated, issuedWebhook{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/admin/webhooks", "listWebhooks", "List the webhooks", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		ok(http.StatusOK, []entity.Webhook{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/admin/webhooks/{id}", "getWebhook", "Get a webhook", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		ok(http.StatusOK, entity.Webhook{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPut, "/admin/webhooks/{id}", "updateWebhook", "Replace the url and event types of a webhook", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		describe("The secret is kept.").
+		body(entity.Webhook{}, true).
+		ok(http.StatusOK, entity.Webhook{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/admin/webhooks/{id}", "deleteWebhook", "Delete a webhook and its deliveries", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/admin/webhooks/{id}/rotate-secret", "rotateWebhookSecret", "Replace the secret of a webhook", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		describe("The pending deliveries are signed with the new secret.").
+		ok(http.StatusOK, issuedWebhook{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodGet, "/admin/webhooks/{id}/deliveries", "listWebhookDeliveries", "List the deliveries of a webhook", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		describe("The latest 100 first, of the status when it is set. Failed deliveries are retried with an "+
+			"exponential backoff until they run out of attempts and are dead.").
+		query("status", openapi3.NewStringSchema().WithEnum(string(entity.DeliveryPending),
+			string(entity.DeliverySucceeded), string(entity.DeliveryDead)), false).
+		ok(http.StatusOK, []entity.WebhookDelivery{}).
+		fails(append(authFails, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/admin/webhooks/{id}/deliveries/replay", "replayWebhookDeliveries", "Replay the dead deliveries of a webhook", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		describe("The dead deliveries are pending again with every attempt.").
+		ok(http.StatusOK, replayedDeliveries{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+	d.add(http.MethodPost, "/admin/webhooks/{id}/deliveries/{deliveryId}/replay", "replayWebhookDelivery", "Replay a dead delivery", tagAdmin).
+		requires(entity.PermWebhooksManage).
+		describe("Only dead deliveries are replayed.").
+		ok(http.StatusOK, replayedDeliveries{}).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+
+	d.add(http.MethodGet, "/admin/scheduled-changes", "listScheduledChanges", "List the pending scheduled changes", tagAdmin).
+		requires(entity.PermUsersWrite).
+		describe("The earliest first, of the user of userId when it is set.").
+		query("userId", openapi3.NewStringSchema(), false).
+		ok(http.StatusOK, []entity.ScheduledChange{}).
+		fails(append(authFails, http.StatusInternalServerError)...)
+	d.add(http.MethodDelete, "/admin/scheduled-changes/{id}", "cancelScheduledChange", "Cancel a pending scheduled change", tagAdmin).
+		requires(entity.PermUsersWrite).
+		ok(http.StatusOK, nil).
+		fails(append(authFails, http.StatusNotFound, http.StatusInternalServerError)...)
+}
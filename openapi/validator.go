@@ -0,0 +1,153 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ellis90/assessment-bg/scim"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	openapi3filter.RegisterBodyDecoder(scim.MediaType, func(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (any, error) {
+		var v any
+		err := json.NewDecoder(body).Decode(&v)
+		return v, err
+	})
+}
+
+// ValidationConfig selects what the validator checks against the document
+type ValidationConfig struct {
+	// Requests rejects the requests not matching the document with a 400
+	Requests bool
+	// Responses logs the responses not matching the document, they are
+	// still sent to the client
+	Responses bool
+	Logger    *slog.Logger
+}
+
+// ValidationConfigFromEnv reads OPENAPI_VALIDATE_REQUESTS and OPENAPI_VALIDATE_RESPONSES
+func ValidationConfigFromEnv() ValidationConfig {
+	requests, _ := strconv.ParseBool(os.Getenv("OPENAPI_VALIDATE_REQUESTS"))
+	responses, _ := strconv.ParseBool(os.Getenv("OPENAPI_VALIDATE_RESPONSES"))
+	return ValidationConfig{Requests: requests, Responses: responses, Logger: slog.Default()}
+}
+
+// Enabled reports whether anything is validated
+func (cfg ValidationConfig) Enabled() bool {
+	return cfg.Requests || cfg.Responses
+}
+
+// NewValidator returns a middleware validating the requests and responses
+// of the routes of the document. Routes missing from it are not checked.
+// Credentials are left to the authentication middlewares.
+func NewValidator(cfg ValidationConfig) (echo.MiddlewareFunc, error) {
+	router, err := gorillamux.NewRouter(Spec())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		ExcludeRequestBody: !cfg.Requests,
+	}
+	// the default messages dump the schema and the value, which may be personal data
+	options.WithCustomSchemaErrorFunc(schemaError)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			route, params, err := router.FindRoute(req)
+			if err != nil {
+				return next(c)
+			}
+			input := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: params,
+				Route:      route,
+				Options:    withoutBody(options, req.Header),
+			}
+			if cfg.Requests {
+				if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+					cfg.Logger.WarnCtx(req.Context(), "request does not match the openapi document", slog.Any("error", err))
+					return utils.JSON(c, "validation", http.StatusBadRequest, err)
+				}
+			}
+			if !cfg.Responses {
+				return next(c)
+			}
+
+			res := c.Response()
+			rec := &recorder{ResponseWriter: res.Writer}
+			res.Writer = rec
+			defer func() { res.Writer = rec.ResponseWriter }()
+			if err := next(c); err != nil {
+				return err
+			}
+			err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 res.Status,
+				Header:                 res.Header(),
+				Body:                   io.NopCloser(&rec.body),
+				Options:                withoutBody(options, res.Header()),
+			})
+			if err != nil {
+				cfg.Logger.ErrorCtx(req.Context(), "response does not match the openapi document",
+					slog.Int("status", res.Status), slog.Any("error", err))
+			}
+			return nil
+		}
+	}, nil
+}
+
+// withoutBody skips the bodies which are not json, the schemas describe the
+// json encoding the other media types are converted from
+func withoutBody(options *openapi3filter.Options, header http.Header) *openapi3filter.Options {
+	mediaType, _, _ := mime.ParseMediaType(header.Get(echo.HeaderContentType))
+	if mediaType == "" || mediaType == echo.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json") {
+		return options
+	}
+	skip := *options
+	skip.ExcludeRequestBody, skip.ExcludeResponseBody = true, true
+	return &skip
+}
+
+func schemaError(err *openapi3.SchemaError) string {
+	reason := err.Reason
+	if err.Origin != nil {
+		reason = err.Origin.Error()
+	}
+	if reason == "" {
+		reason = fmt.Sprintf("doesn't match %q", err.SchemaField)
+	}
+	return fmt.Sprintf("%q: %s", "/"+strings.Join(err.JSONPointer(), "/"), reason)
+}
+
+// recorder copies the body written to the client
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
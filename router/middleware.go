@@ -0,0 +1,130 @@
+package router
+
+import (
+	"fmt"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// requestID reuses the X-Request-ID sent by the client when it is valid
+// or generates a new one, then exposes it in the echo context and the
+// response headers
+func requestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := custom_slog.RequestID(c.Request().Header.Get(echo.HeaderXRequestID))
+		c.Set(custom_slog.RequestIDKey, id)
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		return next(c)
+	}
+}
+
+// logFields stores the request id, route template and target user id in
+// the request context so every log line of the request carries them
+func logFields(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := custom_slog.WithFields(req.Context(), custom_slog.Fields{
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+			Route:     c.Path(),
+			UserID:    c.Param("id"),
+		})
+		c.SetRequest(req.WithContext(ctx))
+		return next(c)
+	}
+}
+
+// accessLog writes one entry per request once the response is written
+func accessLog(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			req := c.Request()
+			res := c.Response()
+			level := slog.LevelInfo
+			switch {
+			case res.Status >= 500:
+				level = slog.LevelError
+			case res.Status >= 400:
+				level = slog.LevelWarn
+			}
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.Int("status", res.Status),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int64("bytes_in", req.ContentLength),
+				slog.Int64("bytes_out", res.Size),
+				slog.String("client_ip", c.RealIP()),
+			}
+			if err != nil {
+				attrs = append(attrs, slog.Any("error", err))
+			}
+			logger.LogAttrs(req.Context(), level, "access", attrs...)
+			return err
+		}
+	}
+}
+
+// Deprecation announces the retirement of an api version, see RFC 9745 and
+// RFC 8594
+type Deprecation struct {
+	// At is when the version was deprecated, zero with a Sunset only flags it
+	// as deprecated
+	At time.Time
+	// Sunset is when the version stops answering, zero leaves it out
+	Sunset time.Time
+	// Successor is the path prefix of the version replacing it
+	Successor string
+}
+
+// V1DeprecationFromEnv reads the RFC 3339 times API_V1_DEPRECATED_AT and
+// API_V1_SUNSET, unset or invalid times are left out of the headers
+func V1DeprecationFromEnv() Deprecation {
+	at, _ := time.Parse(time.RFC3339, os.Getenv("API_V1_DEPRECATED_AT"))
+	sunset, _ := time.Parse(time.RFC3339, os.Getenv("API_V1_SUNSET"))
+	return Deprecation{At: at, Sunset: sunset, Successor: "/v2"}
+}
+
+// deprecated sets the Deprecation, Sunset and Link headers of every response,
+// none of them until At or Sunset is set
+func deprecated(d Deprecation) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if d.At.IsZero() && d.Sunset.IsZero() {
+				return next(c)
+			}
+			h := c.Response().Header()
+			if d.At.IsZero() {
+				h.Set("Deprecation", "true")
+			} else {
+				h.Set("Deprecation", fmt.Sprintf("@%d", d.At.Unix()))
+			}
+			if !d.Sunset.IsZero() {
+				h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.Successor != "" {
+				h.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.Successor))
+			}
+			return next(c)
+		}
+	}
+}
+
+// apiVersion selects the response envelope of the routes of a version
+func apiVersion(version int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(utils.APIVersionKey, version)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestEcho(buf *bytes.Buffer) *echo.Echo {
+	e := echo.New()
+	e.Use(requestID)
+	e.Use(logFields)
+	e.Use(accessLog(custom_slog.New(buf, slog.LevelDebug, nil)))
+	e.GET("/user/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get(custom_slog.RequestIDKey).(string))
+	})
+	return e
+}
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	testCase := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "reuses valid id", incoming: "abc-123", reused: true},
+		{name: "generates missing id", incoming: ""},
+		{name: "replaces unsafe id", incoming: "bad id\nforged=1"},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			e := newTestEcho(buf)
+			req := httptest.NewRequest(http.MethodGet, "/user/7", nil)
+			req.Header.Set(echo.HeaderXRequestID, tc.incoming)
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(echo.HeaderXRequestID)
+			assert.NotEmpty(t, id)
+			assert.Equal(t, id, rec.Body.String())
+			if tc.reused {
+				assert.Equal(t, tc.incoming, id)
+			} else {
+				assert.NotEqual(t, tc.incoming, id)
+			}
+
+			entry := make(map[string]any)
+			if assert.NoError(t, json.NewDecoder(buf).Decode(&entry)) {
+				assert.Equal(t, "access", entry["msg"])
+				assert.Equal(t, id, entry[custom_slog.RequestIDKey])
+				assert.Equal(t, "/user/:id", entry[custom_slog.RouteKey])
+				assert.Equal(t, http.MethodGet, entry["method"])
+				assert.EqualValues(t, http.StatusOK, entry["status"])
+				assert.EqualValues(t, len(id), entry["bytes_out"])
+			}
+		})
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	at := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	testCase := []struct {
+		name        string
+		deprecation Deprecation
+		want        http.Header
+	}{
+		{
+			name:        "unconfigured",
+			deprecation: Deprecation{Successor: "/v2"},
+			want:        http.Header{"Deprecation": nil, "Link": nil},
+		},
+		{
+			name:        "undated with sunset",
+			deprecation: Deprecation{Sunset: at, Successor: "/v2"},
+			want: http.Header{
+				"Deprecation": {"true"},
+				"Sunset":      {"Mon, 01 Jun 2026 00:00:00 GMT"},
+				"Link":        {`</v2>; rel="successor-version"`},
+			},
+		},
+		{
+			name:        "dated with sunset",
+			deprecation: Deprecation{At: at, Sunset: at.AddDate(0, 6, 0), Successor: "/v2"},
+			want: http.Header{
+				"Deprecation": {"@1780272000"},
+				"Sunset":      {"Tue, 01 Dec 2026 00:00:00 GMT"},
+				"Link":        {`</v2>; rel="successor-version"`},
+			},
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/v1/user", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, deprecated(tc.deprecation))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/user", nil))
+			for key := range tc.want {
+				assert.Equal(t, tc.want.Values(key), rec.Header().Values(key), key)
+			}
+			assert.Equal(t, tc.want.Get("Sunset"), rec.Header().Get("Sunset"))
+		})
+	}
+}
+
+func TestV1DeprecationFromEnv(t *testing.T) {
+	t.Setenv("API_V1_DEPRECATED_AT", "2026-06-01T00:00:00Z")
+	t.Setenv("API_V1_SUNSET", "not a time")
+	d := V1DeprecationFromEnv()
+	assert.Equal(t, int64(1780272000), d.At.Unix())
+	assert.True(t, d.Sunset.IsZero())
+	assert.Equal(t, "/v2", d.Successor)
+}
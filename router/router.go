@@ -1,24 +1,236 @@
 package router
 
 import (
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/graph"
+	"github.com/ellis90/assessment-bg/openapi"
 	"github.com/ellis90/assessment-bg/service"
 	"github.com/ellis90/assessment-bg/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/exp/slog"
 	"net/http"
 )
 
-func Router(cs *service.CustomerService) *echo.Echo {
+// Config holds the logger and the security middlewares of the api routes
+type Config struct {
+	Logger *slog.Logger
+	// Authenticate identifies the caller of protected routes
+	Authenticate echo.MiddlewareFunc
+	// Authorize loads the permissions of the authenticated caller
+	Authorize echo.MiddlewareFunc
+	// Validate checks requests and responses against the openapi document,
+	// nil disables it
+	Validate echo.MiddlewareFunc
+	// V1Deprecation dates the retirement of /v1 and the unversioned routes
+	// aliasing it, /v2 is their successor
+	V1Deprecation Deprecation
+}
+
+// Services are the handlers mounted by Router
+type Services struct {
+	Customer   *service.CustomerService
+	Department *service.DepartmentService
+	Group      *service.GroupService
+	Role       *service.RoleService
+	APIKey     *service.APIKeyService
+	Webhook    *service.WebhookService
+	Auth       *service.AuthService
+	SCIM       *service.SCIMService
+	GraphQL    *graph.Server
+}
+
+func Router(svc Services, cfg Config) *echo.Echo {
+	ss, gs := svc.SCIM, svc.GraphQL
 	e := echo.New()
+	e.Use(requestID)
+	e.Use(logFields)
+	e.Use(accessLog(cfg.Logger))
 	e.Use(middleware.Recover())
+	if cfg.Validate != nil {
+		e.Use(cfg.Validate)
+	}
 	e.Binder = &utils.CustomBinder{}
+	// the client ip throttles logins, X-Forwarded-For is only trusted from
+	// proxies on loopback and private networks
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
 	e.GET("/", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{"message": "server running successfully"})
 	})
-	userRoute := e.Group("/user")
-	userRoute.POST("", cs.Create)
-	userRoute.GET("", cs.FetchAll)
-	userRoute.PUT("", cs.Update)
-	userRoute.DELETE("/:id", cs.DeleteById)
+	e.GET("/openapi.json", openapi.ServeSpec)
+	e.GET("/docs/*", openapi.ServeSwaggerUI)
+	// /v1 keeps the original contract, the unversioned routes alias it
+	v1 := cfg.V1Deprecation
+	if v1.Successor == "" {
+		v1.Successor = "/v2"
+	}
+	for _, prefix := range []string{"", "/v1"} {
+		version := deprecated(v1)
+		userRoutes(e.Group(prefix+"/user", version, cfg.Authenticate, cfg.Authorize), svc)
+		departmentRoutes(e.Group(prefix+"/departments", version, cfg.Authenticate, cfg.Authorize), svc)
+		groupRoutes(e.Group(prefix+"/groups", version, cfg.Authenticate, cfg.Authorize), svc)
+		authRoutes(e.Group(prefix+"/auth", version), svc, cfg)
+		adminRoutes(e.Group(prefix+"/admin", version, cfg.Authenticate, cfg.Authorize), svc)
+	}
+	v2 := apiVersion(2)
+	userRoutesV2(e.Group("/v2/user", v2, cfg.Authenticate, cfg.Authorize), svc)
+	departmentRoutes(e.Group("/v2/departments", v2, cfg.Authenticate, cfg.Authorize), svc)
+	groupRoutes(e.Group("/v2/groups", v2, cfg.Authenticate, cfg.Authorize), svc)
+	authRoutes(e.Group("/v2/auth", v2), svc, cfg)
+	adminRoutes(e.Group("/v2/admin", v2, cfg.Authenticate, cfg.Authorize), svc)
+
+	scimRoute := e.Group("/scim/v2", cfg.Authenticate, cfg.Authorize)
+	scimRoute.GET("/ServiceProviderConfig", ss.ServiceProviderConfig)
+	scimRoute.GET("/ResourceTypes", ss.ResourceTypes)
+	scimRoute.GET("/ResourceTypes/:id", ss.ResourceType)
+	scimRoute.GET("/Schemas", ss.Schemas)
+	scimRoute.GET("/Schemas/:id", ss.Schema)
+	scimRoute.GET("/Users", ss.ListUsers, auth.Require(entity.PermUsersRead))
+	scimRoute.GET("/Users/:id", ss.GetUser, auth.Require(entity.PermUsersRead))
+	scimRoute.POST("/Users", ss.CreateUser, auth.Require(entity.PermUsersWrite))
+	scimRoute.PUT("/Users/:id", ss.ReplaceUser, auth.Require(entity.PermUsersWrite))
+	scimRoute.PATCH("/Users/:id", ss.PatchUser, auth.Require(entity.PermUsersWrite))
+	scimRoute.DELETE("/Users/:id", ss.DeleteUser, auth.Require(entity.PermUsersDelete))
+
+	// the resolvers check the permissions of the fields they resolve
+	graphRoute := e.Group("/graphql", cfg.Authenticate, cfg.Authorize)
+	graphRoute.GET("", gs.Handle)
+	graphRoute.POST("", gs.Handle)
+
 	return e
 }
+
+func userRoutes(g *echo.Group, svc Services) {
+	cs := svc.Customer
+	g.POST("", cs.Create, auth.Require(entity.PermUsersWrite))
+	g.GET("", cs.FetchAll, auth.Require(entity.PermUsersRead))
+	g.PUT("", cs.Update, auth.Require(entity.PermUsersWrite))
+	g.DELETE("/:id", cs.DeleteById, auth.Require(entity.PermUsersDelete))
+	statusRoutes(g, svc)
+	managerRoutes(g, svc)
+	g.GET("/:id/groups", svc.Group.UserGroups, auth.Require(entity.PermUsersRead))
+	accountRoutes(g, svc)
+}
+
+// userRoutesV2 take the id from the path and name the statuses
+func userRoutesV2(g *echo.Group, svc Services) {
+	cs := svc.Customer
+	g.POST("", cs.CreateV2, auth.Require(entity.PermUsersWrite))
+	g.GET("", cs.FetchAllV2, auth.Require(entity.PermUsersRead))
+	g.GET("/:id", cs.GetV2, auth.Require(entity.PermUsersRead))
+	g.PUT("/:id", cs.UpdateV2, auth.Require(entity.PermUsersWrite))
+	g.DELETE("/:id", cs.DeleteV2, auth.Require(entity.PermUsersDelete))
+	statusRoutes(g, svc)
+	managerRoutes(g, svc)
+	g.GET("/:id/groups", svc.Group.UserGroups, auth.Require(entity.PermUsersRead))
+	accountRoutes(g, svc)
+}
+
+// statusRoutes move users along the transitions of entity.Status, they are
+// shared by the versions
+func statusRoutes(g *echo.Group, svc Services) {
+	cs := svc.Customer
+	g.POST("/:id/activate", cs.Activate, auth.Require(entity.PermUsersWrite))
+	g.POST("/:id/deactivate", cs.Deactivate, auth.Require(entity.PermUsersWrite))
+	g.POST("/:id/terminate", cs.Terminate, auth.Require(entity.PermUsersWrite))
+	g.GET("/:id/status-history", cs.StatusHistory, auth.Require(entity.PermUsersRead))
+	g.POST("/:id/scheduled-changes", cs.ScheduleChange, auth.Require(entity.PermUsersWrite))
+}
+
+// managerRoutes keep who reports to whom, they are shared by the versions
+func managerRoutes(g *echo.Group, svc Services) {
+	cs := svc.Customer
+	g.GET("/org-chart", cs.OrgChart, auth.Require(entity.PermUsersRead))
+	g.PUT("/:id/manager", cs.SetManager, auth.Require(entity.PermUsersWrite))
+	g.GET("/:id/reports", cs.DirectReports, auth.Require(entity.PermUsersRead))
+	g.GET("/:id/reporting-chain", cs.ReportingChain, auth.Require(entity.PermUsersRead))
+}
+
+// accountRoutes manage the credentials of a user, they are shared by the
+// versions
+func accountRoutes(g *echo.Group, svc Services) {
+	as := svc.Auth
+	g.PUT("/:id/password", as.SetPassword)
+	g.GET("/:id/sessions", as.ListSessions)
+	g.DELETE("/:id/sessions", as.RevokeSession)
+	g.DELETE("/:id/sessions/:sid", as.RevokeSession)
+	g.POST("/:id/email/verification", as.ResendVerification)
+	g.POST("/:id/mfa/totp", as.EnrollTOTP)
+	g.POST("/:id/mfa/totp/confirm", as.ConfirmTOTP)
+	g.POST("/:id/mfa/recovery-codes", as.RegenerateRecoveryCodes)
+}
+
+func departmentRoutes(g *echo.Group, svc Services) {
+	ds := svc.Department
+	g.GET("", ds.List, auth.Require(entity.PermUsersRead))
+	g.GET("/:id", ds.Get, auth.Require(entity.PermUsersRead))
+	g.POST("", ds.Create, auth.Require(entity.PermUsersWrite))
+	g.PUT("/:id", ds.Rename, auth.Require(entity.PermUsersWrite))
+	g.GET("/:id/subtree", ds.Subtree, auth.Require(entity.PermUsersRead))
+	g.GET("/:id/ancestors", ds.Ancestors, auth.Require(entity.PermUsersRead))
+	g.GET("/:id/users", ds.Users, auth.Require(entity.PermUsersRead))
+	g.PUT("/:id/parent", ds.Move, auth.Require(entity.PermUsersWrite))
+	g.POST("/:id/merge", ds.Merge, auth.Require(entity.PermUsersWrite))
+	g.DELETE("/:id", ds.Delete, auth.Require(entity.PermUsersDelete))
+}
+
+func groupRoutes(g *echo.Group, svc Services) {
+	gs := svc.Group
+	g.GET("", gs.List, auth.Require(entity.PermUsersRead))
+	g.GET("/:id", gs.Get, auth.Require(entity.PermUsersRead))
+	g.POST("", gs.Create, auth.Require(entity.PermUsersWrite))
+	g.PUT("/:id", gs.Update, auth.Require(entity.PermUsersWrite))
+	g.DELETE("/:id", gs.Delete, auth.Require(entity.PermUsersDelete))
+	g.GET("/:id/members", gs.Members, auth.Require(entity.PermUsersRead))
+	g.POST("/:id/members", gs.AddMember, auth.Require(entity.PermUsersWrite))
+	g.DELETE("/:id/members/:userId", gs.RemoveMember, auth.Require(entity.PermUsersWrite))
+}
+
+func authRoutes(g *echo.Group, svc Services, cfg Config) {
+	as := svc.Auth
+	g.POST("/login", as.Login)
+	g.POST("/login/mfa", as.LoginMFA)
+	g.POST("/logout", as.Logout, cfg.Authenticate)
+	g.POST("/password/forgot", as.ForgotPassword)
+	g.POST("/password/reset", as.ResetPassword)
+	g.GET("/email/verify", as.VerifyEmail)
+	g.POST("/email/verify", as.VerifyEmail)
+}
+
+func adminRoutes(g *echo.Group, svc Services) {
+	rs, ks, as := svc.Role, svc.APIKey, svc.Auth
+	manageRoles := auth.Require(entity.PermRolesManage)
+	g.GET("/roles", rs.ListRoles, manageRoles)
+	g.GET("/role-bindings", rs.ListBindings, manageRoles)
+	g.POST("/role-bindings", rs.Bind, manageRoles)
+	g.DELETE("/role-bindings/:subject/:role", rs.Unbind, manageRoles)
+
+	manageKeys := auth.Require(entity.PermAPIKeysManage)
+	g.POST("/api-keys", ks.Create, manageKeys)
+	g.GET("/api-keys", ks.List, manageKeys)
+	g.POST("/api-keys/:id/rotate", ks.Rotate, manageKeys)
+	g.DELETE("/api-keys/:id", ks.Revoke, manageKeys)
+
+	manageCredentials := auth.Require(entity.PermCredentialsManage)
+	g.DELETE("/users/:id/mfa", as.ResetMFA, manageCredentials)
+	g.GET("/login-lockouts", as.ListLockouts, manageCredentials)
+	g.DELETE("/users/:id/lockout", as.UnlockUser, manageCredentials)
+	g.DELETE("/login-lockouts/ip/:ip", as.UnlockIP, manageCredentials)
+
+	ws := svc.Webhook
+	manageWebhooks := auth.Require(entity.PermWebhooksManage)
+	g.POST("/webhooks", ws.Create, manageWebhooks)
+	g.GET("/webhooks", ws.List, manageWebhooks)
+	g.GET("/webhooks/:id", ws.Get, manageWebhooks)
+	g.PUT("/webhooks/:id", ws.Update, manageWebhooks)
+	g.DELETE("/webhooks/:id", ws.Delete, manageWebhooks)
+	g.POST("/webhooks/:id/rotate-secret", ws.RotateSecret, manageWebhooks)
+	g.GET("/webhooks/:id/deliveries", ws.Deliveries, manageWebhooks)
+	g.POST("/webhooks/:id/deliveries/replay", ws.Replay, manageWebhooks)
+	g.POST("/webhooks/:id/deliveries/:deliveryId/replay", ws.Replay, manageWebhooks)
+
+	cs := svc.Customer
+	g.GET("/scheduled-changes", cs.ListScheduledChanges, auth.Require(entity.PermUsersWrite))
+	g.DELETE("/scheduled-changes/:id", cs.CancelScheduledChange, auth.Require(entity.PermUsersWrite))
+}
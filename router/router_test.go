@@ -0,0 +1,286 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/openapi"
+	"github.com/ellis90/assessment-bg/service"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var echoParam = regexp.MustCompile(`:([^/]+)`)
+
+// TestRoutesDocumented checks every route of Router has an operation in
+// the openapi document and every operation a route
+func TestRoutesDocumented(t *testing.T) {
+	e := Router(Services{}, Config{Logger: slog.Default()})
+	doc := openapi.Spec()
+	// groups with middlewares route their unmatched paths to NotFoundHandler
+	notFound := runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+
+	routed := make(map[string]bool)
+	for _, r := range e.Routes() {
+		if r.Name == notFound {
+			continue
+		}
+		path := echoParam.ReplaceAllString(r.Path, "{$1}")
+		path = strings.Replace(path, "*", "{path}", 1)
+		routed[r.Method+" "+path] = true
+		item := doc.Paths.Find(path)
+		if assert.NotNil(t, item, "%s %s is not documented", r.Method, r.Path) {
+			assert.NotNil(t, item.GetOperation(r.Method), "%s %s is not documented", r.Method, r.Path)
+		}
+	}
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			assert.True(t, routed[method+" "+path], "%s %s is documented but not routed", method, path)
+		}
+	}
+}
+
+// fakeUsers keeps the users TestVersions routes to
+type fakeUsers struct {
+	users  map[string]entity.User
+	nextID int
+}
+
+func (f *fakeUsers) Create(_ context.Context, cus model.Customer) (model.Customer, error) {
+	u := cus.GetExportedCustomer().User
+	f.nextID++
+	u.ID = strconv.Itoa(f.nextID)
+	f.users[u.ID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Update(_ context.Context, cus model.Customer) (model.Customer, error) {
+	u := cus.GetExportedCustomer().User
+	if _, ok := f.users[u.ID]; !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	f.users[u.ID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Get(_ context.Context) (model.Customers, error) {
+	var out model.Customers
+	for _, u := range f.users {
+		u := u
+		out = append(out, model.AddCustomer(&u))
+	}
+	return out, nil
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id string) (model.Customer, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) GetByIDs(context.Context, []string) (model.Customers, error) { return nil, nil }
+
+func (f *fakeUsers) GetByEmail(context.Context, string) (model.Customer, error) {
+	return model.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) MarkEmailVerified(context.Context, string, string) error { return nil }
+
+func (f *fakeUsers) Delete(_ context.Context, id string) error {
+	delete(f.users, id)
+	return nil
+}
+
+func (f *fakeUsers) ChangeStatus(context.Context, entity.StatusChange) (model.Customer, error) {
+	return model.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) StatusHistory(context.Context, string) ([]entity.StatusChange, error) {
+	return nil, datastore.ErrCustomerNotFound
+}
+
+// asAdmin authenticates every request as a caller holding every users permission
+func asAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auth.SetPrincipal(c, auth.Principal{Subject: "admin", Permissions: []entity.Permission{
+			entity.PermUsersRead, entity.PermUsersReadPII, entity.PermUsersWrite, entity.PermUsersDelete,
+		}})
+		return next(c)
+	}
+}
+
+// newVersionedRouter routes callers holding every users permission to a
+// store with the active user 1
+func newVersionedRouter(t *testing.T) *echo.Echo {
+	t.Helper()
+	users := &fakeUsers{users: map[string]entity.User{
+		"1": {ID: "1", UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", Department: "eng", UserStatus: entity.Active},
+	}, nextID: 1}
+	cs, err := service.NewCustomerServices(service.WithCustomerRepository(users, nil))
+	require.NoError(t, err)
+	noop := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	return Router(Services{Customer: cs}, Config{
+		Logger:        slog.Default(),
+		Authenticate:  asAdmin,
+		Authorize:     noop,
+		V1Deprecation: Deprecation{Sunset: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	})
+}
+
+func TestVersions(t *testing.T) {
+	user := `{"userName":"jroe","firstName":"Jane","lastName":"Roe","email":"jroe@example.com","department":"ops","userStatus":%s}`
+	testCase := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		status     int
+		deprecated bool
+		// want are top level json fields of the response
+		want map[string]any
+	}{
+		{
+			name:   "unversioned aliases v1",
+			method: http.MethodGet, path: "/user",
+			status: http.StatusOK, deprecated: true,
+			want: map[string]any{"message": "successful", "status": "OK"},
+		},
+		{
+			name:   "v1 keeps numeric statuses",
+			method: http.MethodPost, path: "/v1/user",
+			body:   fmt.Sprintf(user, "1"),
+			status: http.StatusCreated, deprecated: true,
+			want: map[string]any{"message": "successful", "data": map[string]any{"User": map[string]any{
+				"id": "2", "userName": "jroe", "firstName": "Jane", "lastName": "Roe", "email": "jroe@example.com",
+				"department": "ops", "userStatus": 1.0, "emailVerified": false,
+			}}},
+		},
+		{
+			name:   "v1 error envelope",
+			method: http.MethodPut, path: "/v1/user",
+			body:   `{"id":"9","userName":"x","firstName":"x","lastName":"x","email":"x@example.com","department":"x"}`,
+			status: http.StatusNotFound, deprecated: true,
+			want: map[string]any{"message": "failed to update user", "status": "Not Found"},
+		},
+		{
+			name:   "v2 names statuses",
+			method: http.MethodPost, path: "/v2/user",
+			body:   fmt.Sprintf(user, `"inactive"`),
+			status: http.StatusCreated,
+			want: map[string]any{"data": map[string]any{
+				"id": "2", "userName": "jroe", "firstName": "Jane", "lastName": "Roe", "email": "jroe@example.com",
+				"department": "ops", "userStatus": "inactive", "emailVerified": false,
+			}},
+		},
+		{
+			name:   "v2 accepts legacy numeric statuses",
+			method: http.MethodPost, path: "/v2/user",
+			body:   fmt.Sprintf(user, "1"),
+			status: http.StatusCreated,
+			want: map[string]any{"data": map[string]any{
+				"id": "2", "userName": "jroe", "firstName": "Jane", "lastName": "Roe", "email": "jroe@example.com",
+				"department": "ops", "userStatus": "active", "emailVerified": false,
+			}},
+		},
+		{
+			name:   "v2 rejects unknown statuses",
+			method: http.MethodPost, path: "/v2/user",
+			body:   fmt.Sprintf(user, `"retired"`),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "v2 gets by id",
+			method: http.MethodGet, path: "/v2/user/1",
+			status: http.StatusOK,
+			want: map[string]any{"data": map[string]any{
+				"id": "1", "userName": "jdoe", "firstName": "John", "lastName": "Doe", "email": "jdoe@example.com",
+				"department": "eng", "userStatus": "active", "emailVerified": false,
+			}},
+		},
+		{
+			name:   "v2 takes the id from the path",
+			method: http.MethodPut, path: "/v2/user/1",
+			body:   fmt.Sprintf(user, `"active"`),
+			status: http.StatusOK,
+			want: map[string]any{"data": map[string]any{
+				"id": "1", "userName": "jroe", "firstName": "Jane", "lastName": "Roe", "email": "jroe@example.com",
+				"department": "ops", "userStatus": "active", "emailVerified": false,
+			}},
+		},
+		{
+			name:   "v2 rejects a mismatched body id",
+			method: http.MethodPut, path: "/v2/user/1",
+			body:   `{"id":"2","userName":"x","firstName":"x","lastName":"x","email":"x@example.com","department":"x"}`,
+			status: http.StatusBadRequest,
+			want: map[string]any{"error": map[string]any{
+				"code": "bad_request", "message": service.ErrIDMismatch.Error(), "requestId": "req-1",
+			}},
+		},
+		{
+			name:   "v2 error envelope",
+			method: http.MethodGet, path: "/v2/user/9",
+			status: http.StatusNotFound,
+			want: map[string]any{"error": map[string]any{
+				"code": "not_found", "message": datastore.ErrCustomerNotFound.Error(), "requestId": "req-1",
+			}},
+		},
+		{name: "v2 deletes without content", method: http.MethodDelete, path: "/v2/user/1", status: http.StatusNoContent},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newVersionedRouter(t)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderXRequestID, "req-1")
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if tc.deprecated {
+				assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+				assert.Equal(t, "Fri, 01 Jan 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+				assert.Equal(t, `</v2>; rel="successor-version"`, rec.Header().Get("Link"))
+			} else {
+				assert.Empty(t, rec.Header().Get("Deprecation"))
+			}
+			if tc.want == nil {
+				return
+			}
+			res := make(map[string]any)
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			for key, want := range tc.want {
+				assert.Equal(t, want, res[key], key)
+			}
+		})
+	}
+}
+
+// TestAdminPermissions checks the admin routes are refused to callers
+// holding the users permissions only
+func TestAdminPermissions(t *testing.T) {
+	noop := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	e := Router(Services{}, Config{Logger: slog.Default(), Authenticate: asAdmin, Authorize: noop})
+	for _, path := range []string{"/v2/admin/webhooks", "/v2/admin/roles", "/v2/admin/api-keys", "/v2/admin/login-lockouts"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code, path)
+	}
+}
@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"sync"
+)
+
+// watchBuffer is the number of events a watcher may fall behind before it
+// is disconnected
+const watchBuffer = 64
+
+type EventType int
+
+const (
+	UserCreated EventType = iota + 1
+	UserUpdated
+	UserDeleted
+)
+
+// UserEvent is a change of a user, only the id of deleted users is set
+type UserEvent struct {
+	Type EventType
+	User entity.User
+}
+
+// UserEvents fans the user changes made through this process out to the
+// watchers of the grpc api
+type UserEvents struct {
+	mu       sync.Mutex
+	watchers map[chan UserEvent]struct{}
+}
+
+func NewUserEvents() *UserEvents {
+	return &UserEvents{watchers: make(map[chan UserEvent]struct{})}
+}
+
+// Subscribe returns a channel receiving the events published from now on
+// and a function ending the subscription. The channel is closed when the
+// watcher falls behind or unsubscribes.
+func (ue *UserEvents) Subscribe() (<-chan UserEvent, func()) {
+	ch := make(chan UserEvent, watchBuffer)
+	ue.mu.Lock()
+	ue.watchers[ch] = struct{}{}
+	ue.mu.Unlock()
+	return ch, func() { ue.remove(ch) }
+}
+
+func (ue *UserEvents) remove(ch chan UserEvent) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+	if _, ok := ue.watchers[ch]; ok {
+		delete(ue.watchers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends e to every watcher without blocking, slow watchers are
+// dropped
+func (ue *UserEvents) Publish(e UserEvent) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+	for ch := range ue.watchers {
+		select {
+		case ch <- e:
+		default:
+			delete(ue.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Repository wraps repo so its successful writes are published
+func (ue *UserEvents) Repository(repo datastore.UserRepository) datastore.UserRepository {
+	return &publishingRepository{UserRepository: repo, events: ue}
+}
+
+type publishingRepository struct {
+	datastore.UserRepository
+	events *UserEvents
+}
+
+func (r *publishingRepository) Create(ctx context.Context, user model.Customer) (model.Customer, error) {
+	out, err := r.UserRepository.Create(ctx, user)
+	if err == nil {
+		r.events.Publish(UserEvent{Type: UserCreated, User: out.GetExportedCustomer().User})
+	}
+	return out, err
+}
+
+func (r *publishingRepository) Update(ctx context.Context, user model.Customer) (model.Customer, error) {
+	out, err := r.UserRepository.Update(ctx, user)
+	if err == nil {
+		r.events.Publish(UserEvent{Type: UserUpdated, User: out.GetExportedCustomer().User})
+	}
+	return out, err
+}
+
+func (r *publishingRepository) ChangeStatus(ctx context.Context, change entity.StatusChange) (model.Customer, error) {
+	out, err := r.UserRepository.ChangeStatus(ctx, change)
+	if err == nil {
+		r.events.Publish(UserEvent{Type: UserUpdated, User: out.GetExportedCustomer().User})
+	}
+	return out, err
+}
+
+func (r *publishingRepository) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	if err := r.UserRepository.MarkEmailVerified(ctx, userID, email); err != nil {
+		return err
+	}
+	if out, err := r.UserRepository.GetByID(ctx, userID); err == nil {
+		r.events.Publish(UserEvent{Type: UserUpdated, User: out.GetExportedCustomer().User})
+	}
+	return nil
+}
+
+func (r *publishingRepository) Delete(ctx context.Context, id string) error {
+	if err := r.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.events.Publish(UserEvent{Type: UserDeleted, User: entity.User{ID: id}})
+	return nil
+}
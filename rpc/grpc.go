@@ -0,0 +1,206 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/proto/userpb"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+	"time"
+)
+
+const metadataRequestID = "x-request-id"
+
+// methodPermissions are the permissions required by the rpc methods, they
+// match the REST routes
+var methodPermissions = map[string]entity.Permission{
+	userpb.UserService_Create_FullMethodName: entity.PermUsersWrite,
+	userpb.UserService_Get_FullMethodName:    entity.PermUsersRead,
+	userpb.UserService_List_FullMethodName:   entity.PermUsersRead,
+	userpb.UserService_Update_FullMethodName: entity.PermUsersWrite,
+	userpb.UserService_Delete_FullMethodName: entity.PermUsersDelete,
+	userpb.UserService_Watch_FullMethodName:  entity.PermUsersRead,
+}
+
+// Config holds the logger and the authentication of the grpc api
+type Config struct {
+	Logger     *slog.Logger
+	APIKeys    *auth.APIKeyAuthenticator
+	JWT        *auth.JWTAuthenticator
+	Authorizer *auth.Authorizer
+}
+
+// NewServer returns a grpc server serving us and the reflection service
+func NewServer(us *UserServer, cfg Config) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+				ctx = logFields(ctx, info.FullMethod)
+				start := time.Now()
+				defer func() {
+					if r := recover(); r != nil {
+						err = recovered(ctx, cfg.Logger, r)
+					}
+					accessLog(ctx, cfg.Logger, info.FullMethod, start, err)
+				}()
+				if ctx, err = cfg.authenticate(ctx, info.FullMethod); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			},
+		),
+		grpc.ChainStreamInterceptor(
+			func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+				ctx := logFields(ss.Context(), info.FullMethod)
+				start := time.Now()
+				defer func() {
+					if r := recover(); r != nil {
+						err = recovered(ctx, cfg.Logger, r)
+					}
+					accessLog(ctx, cfg.Logger, info.FullMethod, start, err)
+				}()
+				if ctx, err = cfg.authenticate(ctx, info.FullMethod); err != nil {
+					return err
+				}
+				return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+			},
+		),
+	)
+	userpb.RegisterUserServiceServer(s, us)
+	reflection.Register(s)
+	return s
+}
+
+// AddrFromEnv is the address the grpc api listens on, the port GRPC_PORT or
+// 9091 on every interface
+func AddrFromEnv() string {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "9091"
+	}
+	return ":" + port
+}
+
+// Shutdown stops s from accepting calls and waits for the running ones.
+// Watch streams last as long as their clients, those still open when ctx is
+// done are cut off.
+func Shutdown(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+		<-stopped
+	}
+}
+
+// serverStream replaces the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// logFields reuses the x-request-id sent by the client when it is valid or
+// generates a new one, returns it in the response headers and stores it
+// with the method in the context of the call
+func logFields(ctx context.Context, method string) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(metadataRequestID); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	id = custom_slog.RequestID(id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(metadataRequestID, id))
+	return custom_slog.WithFields(ctx, custom_slog.Fields{RequestID: id, Route: method})
+}
+
+func recovered(ctx context.Context, logger *slog.Logger, r any) error {
+	logger.ErrorCtx(ctx, "rpc handler panicked", slog.Any("panic", r))
+	return status.Error(codes.Internal, "internal error")
+}
+
+// accessLog writes one entry per call once it returns
+func accessLog(ctx context.Context, logger *slog.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("client_ip", p.Addr.String()))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	logger.LogAttrs(ctx, level, "access", attrs...)
+}
+
+// authenticate authenticates the x-api-key or bearer authorization metadata
+// of the call and checks the permission of the method. The reflection
+// service is public like the discovery routes of the REST api.
+func (cfg Config) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if strings.HasPrefix(method, "/grpc.reflection.") {
+		return ctx, nil
+	}
+	perm, ok := methodPermissions[method]
+	if !ok {
+		return ctx, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var (
+		p   auth.Principal
+		err error
+	)
+	key, token := first(md, strings.ToLower(auth.HeaderAPIKey)), first(md, "authorization")
+	switch {
+	case key != "" && cfg.APIKeys != nil:
+		p, err = cfg.APIKeys.Authenticate(ctx, key)
+	case strings.HasPrefix(token, "Bearer ") && cfg.JWT != nil:
+		p, err = cfg.JWT.Authenticate(strings.TrimPrefix(token, "Bearer "))
+	default:
+		err = auth.ErrMissingCredentials
+	}
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if p, err = cfg.Authorizer.Authorize(ctx, p); err != nil {
+		return ctx, status.Error(codes.Internal, err.Error())
+	}
+	if !p.Has(perm) {
+		return ctx, status.Error(codes.PermissionDenied, fmt.Sprintf("%v: %s", auth.ErrForbidden, perm))
+	}
+	return auth.WithPrincipal(ctx, p), nil
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,327 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/proto/userpb"
+	"github.com/ellis90/assessment-bg/service"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type UserConfiguration func(us *UserServer) error
+
+// UserServer serves the userpb.UserService with the repository and the
+// validation of the REST user routes
+type UserServer struct {
+	userpb.UnimplementedUserServiceServer
+	userRepo datastore.UserRepository
+	logger   *slog.Logger
+	mail     *service.AccountMailer
+	events   *UserEvents
+}
+
+func NewUserServer(cfgs ...UserConfiguration) (*UserServer, error) {
+	us := &UserServer{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(us); err != nil {
+			return nil, err
+		}
+	}
+	return us, nil
+}
+
+func WithUserRepository(ur datastore.UserRepository, err error) UserConfiguration {
+	return func(us *UserServer) error {
+		if err != nil {
+			return err
+		}
+		us.userRepo = ur
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the rpc handlers
+func WithLogger(logger *slog.Logger) UserConfiguration {
+	return func(us *UserServer) error {
+		us.logger = logger
+		return nil
+	}
+}
+
+// WithAccountMailer sends a verification email for new addresses
+func WithAccountMailer(am *service.AccountMailer) UserConfiguration {
+	return func(us *UserServer) error {
+		us.mail = am
+		return nil
+	}
+}
+
+// WithUserEvents streams the events of ue to the Watch calls
+func WithUserEvents(ue *UserEvents) UserConfiguration {
+	return func(us *UserServer) error {
+		us.events = ue
+		return nil
+	}
+}
+
+// statusFor maps the errors of the user repository to grpc statuses
+func (us *UserServer) statusFor(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrCustomerNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, datastore.ErrCustomerExists):
+		return status.Error(codes.AlreadyExists, datastore.ErrCustomerExists.Error())
+	case errors.Is(err, entity.ErrInvalidTransition), errors.Is(err, entity.ErrStatusNeedsReason):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, entity.ErrInitialStatus):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, datastore.ErrDepartmentNotFound):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	us.logger.ErrorCtx(ctx, "rpc request failed", slog.Any("error", err))
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toProto(u entity.User) *userpb.User {
+	return &userpb.User{
+		Id:            u.ID,
+		UserName:      u.UserName,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		Email:         u.Email,
+		Department:    u.Department,
+		UserStatus:    statuses[u.UserStatus],
+		EmailVerified: u.EmailVerified,
+	}
+}
+
+// statuses pairs the entity statuses with their protobuf values, the
+// unspecified value has no entity status
+var statuses = map[entity.Status]userpb.UserStatus{
+	entity.Inactive:   userpb.UserStatus_USER_STATUS_INACTIVE,
+	entity.Active:     userpb.UserStatus_USER_STATUS_ACTIVE,
+	entity.Terminated: userpb.UserStatus_USER_STATUS_TERMINATED,
+}
+
+// fromProto converts u, an unspecified status is replaced by unspecified
+func fromProto(u *userpb.User, unspecified entity.Status) (entity.User, error) {
+	out := entity.User{
+		ID:         u.GetId(),
+		UserName:   u.GetUserName(),
+		FirstName:  u.GetFirstName(),
+		LastName:   u.GetLastName(),
+		Email:      u.GetEmail(),
+		Department: u.GetDepartment(),
+		UserStatus: unspecified,
+	}
+	if u.GetUserStatus() == userpb.UserStatus_USER_STATUS_UNSPECIFIED {
+		return out, nil
+	}
+	for s, ps := range statuses {
+		if ps == u.GetUserStatus() {
+			out.UserStatus = s
+			return out, nil
+		}
+	}
+	return out, fmt.Errorf("%w: %d", entity.ErrInvalidStatus, u.GetUserStatus())
+}
+
+// user converts u for the caller, hiding the email from callers without
+// the users:read_pii permission
+func user(ctx context.Context, u entity.User) *userpb.User {
+	if p, ok := auth.PrincipalFromContext(ctx); !ok || !p.Has(entity.PermUsersReadPII) {
+		u.Email = ""
+	}
+	return toProto(u)
+}
+
+// sendVerification mails a verification link to the address of cus, a
+// failure is logged as the user can request another link
+func (us *UserServer) sendVerification(ctx context.Context, cus model.Customer) {
+	if us.mail == nil {
+		return
+	}
+	if err := us.mail.SendVerification(ctx, cus.GetExportedCustomer().User); err != nil {
+		us.logger.ErrorCtx(ctx, "failed to send verification email", slog.Any("error", err))
+	}
+}
+
+// pageToken encodes the offset of the next page
+func pageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func parsePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	return offset, nil
+}
+
+// handlers
+
+func (us *UserServer) Create(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	if req.GetUser() == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+	u, err := fromProto(req.GetUser(), entity.Active)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	cus, err := model.NewCustomer(&u)
+	if err != nil {
+		us.logger.WarnCtx(ctx, "invalid user", slog.Any("error", err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	out, err := us.userRepo.Create(ctx, cus)
+	if err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	ctx = custom_slog.WithUserID(ctx, out.GetID())
+	us.logger.InfoCtx(ctx, "user created")
+	us.sendVerification(ctx, out)
+	return user(ctx, out.GetExportedCustomer().User), nil
+}
+
+func (us *UserServer) Get(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	ctx = custom_slog.WithUserID(ctx, req.GetId())
+	cus, err := us.userRepo.GetByID(ctx, req.GetId())
+	if err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	return user(ctx, cus.GetExportedCustomer().User), nil
+}
+
+// List returns the page of users starting at the offset of the page token
+func (us *UserServer) List(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	offset, err := parsePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	size := int(req.GetPageSize())
+	switch {
+	case size < 0:
+		return nil, status.Error(codes.InvalidArgument, "page size must not be negative")
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+	all, err := us.userRepo.Get(ctx)
+	if err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	res := &userpb.ListUsersResponse{TotalSize: int32(len(all))}
+	for i := offset; i < len(all) && len(res.Users) < size; i++ {
+		res.Users = append(res.Users, user(ctx, all[i].GetExportedCustomer().User))
+	}
+	if next := offset + size; next < len(all) {
+		res.NextPageToken = pageToken(next)
+	}
+	return res, nil
+}
+
+func (us *UserServer) Update(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	if req.GetUser() == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+	ctx = custom_slog.WithUserID(ctx, req.GetUser().GetId())
+	current, err := us.userRepo.GetByID(ctx, req.GetUser().GetId())
+	if err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	// an unspecified status keeps the current one
+	u, err := fromProto(req.GetUser(), current.GetUserStatus())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	cus, err := model.NewCustomer(&u)
+	if err != nil {
+		us.logger.WarnCtx(ctx, "invalid user", slog.Any("error", err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	u.EmailVerified = current.GetEmailVerified() && current.GetEmail() == u.Email
+	out, err := us.userRepo.Update(ctx, cus)
+	if err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	us.logger.InfoCtx(ctx, "user updated")
+	if current.GetEmail() != out.GetEmail() {
+		us.sendVerification(ctx, out)
+	}
+	return user(ctx, out.GetExportedCustomer().User), nil
+}
+
+func (us *UserServer) Delete(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	ctx = custom_slog.WithUserID(ctx, req.GetId())
+	if _, err := us.userRepo.GetByID(ctx, req.GetId()); err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	if err := us.userRepo.Delete(ctx, req.GetId()); err != nil {
+		return nil, us.statusFor(ctx, err)
+	}
+	us.logger.InfoCtx(ctx, "user deleted")
+	return &userpb.DeleteUserResponse{}, nil
+}
+
+// Watch streams the user events until the client cancels, watchers that
+// fall behind are ended with ResourceExhausted and should watch again with
+// include_existing
+func (us *UserServer) Watch(req *userpb.WatchUsersRequest, stream userpb.UserService_WatchServer) error {
+	if us.events == nil {
+		return status.Error(codes.Unimplemented, "watching users is not enabled")
+	}
+	ctx := stream.Context()
+	// subscribe first so no change is lost while the existing users are sent
+	events, unsubscribe := us.events.Subscribe()
+	defer unsubscribe()
+	if req.GetIncludeExisting() {
+		all, err := us.userRepo.Get(ctx)
+		if err != nil {
+			return us.statusFor(ctx, err)
+		}
+		for _, cus := range all.GetExportedCustomers() {
+			e := &userpb.UserEvent{Type: userpb.UserEvent_TYPE_CREATED, User: user(ctx, cus.User)}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind")
+			}
+			out := &userpb.UserEvent{Type: userpb.UserEvent_Type(e.Type), User: user(ctx, e.User)}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+	}
+}
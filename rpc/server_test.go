@@ -0,0 +1,362 @@
+package rpc
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/proto/userpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeUsers struct {
+	mu     sync.Mutex
+	users  map[string]entity.User
+	nextID int
+}
+
+func (f *fakeUsers) Create(_ context.Context, cus model.Customer) (model.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := cus.GetExportedCustomer().User
+	if !u.UserStatus.Initial() {
+		return model.Customer{}, entity.ErrInitialStatus
+	}
+	for _, existing := range f.users {
+		if existing.UserName == u.UserName {
+			return model.Customer{}, datastore.ErrCustomerExists
+		}
+	}
+	f.nextID++
+	u.ID = strconv.Itoa(f.nextID)
+	f.users[u.ID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Update(_ context.Context, cus model.Customer) (model.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := cus.GetExportedCustomer().User
+	if f.users[u.ID].UserStatus != u.UserStatus {
+		return model.Customer{}, entity.ErrStatusNeedsReason
+	}
+	f.users[u.ID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Get(_ context.Context) (model.Customers, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out model.Customers
+	for _, u := range f.users {
+		u := u
+		out = append(out, model.AddCustomer(&u))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i].GetID())
+		b, _ := strconv.Atoi(out[j].GetID())
+		return a < b
+	})
+	return out, nil
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id string) (model.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[id]
+	if !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) GetByIDs(ctx context.Context, ids []string) (model.Customers, error) {
+	var out model.Customers
+	for _, id := range ids {
+		if cus, err := f.GetByID(ctx, id); err == nil {
+			out = append(out, cus)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeUsers) GetByEmail(_ context.Context, _ string) (model.Customer, error) {
+	return model.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) MarkEmailVerified(_ context.Context, _, _ string) error { return nil }
+
+func (f *fakeUsers) ChangeStatus(context.Context, entity.StatusChange) (model.Customer, error) {
+	return model.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) StatusHistory(context.Context, string) ([]entity.StatusChange, error) {
+	return nil, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) Delete(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.users, id)
+	return nil
+}
+
+type fakeAPIKeys map[string]entity.APIKey
+
+func (f fakeAPIKeys) APIKeyByPrefix(_ context.Context, prefix string) (entity.APIKey, error) {
+	key, ok := f[prefix]
+	if !ok {
+		return entity.APIKey{}, auth.ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+func (f fakeAPIKeys) TouchAPIKey(context.Context, string, time.Time) error { return nil }
+
+// issue returns a plaintext key granted scopes
+func (f fakeAPIKeys) issue(t *testing.T, scopes ...entity.Permission) string {
+	plaintext, prefix, hash, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	f[prefix] = entity.APIKey{ID: prefix, Prefix: prefix, Hash: hash, Scopes: scopes}
+	return plaintext
+}
+
+// newTestClient serves a user server over an in-memory connection
+func newTestClient(t *testing.T) (userpb.UserServiceClient, fakeAPIKeys, *fakeUsers) {
+	logger := slog.New(slog.NewTextHandler(io.Discard))
+	users := &fakeUsers{users: map[string]entity.User{}}
+	keys := fakeAPIKeys{}
+	events := NewUserEvents()
+	us, err := NewUserServer(
+		WithLogger(logger),
+		WithUserRepository(events.Repository(users), nil),
+		WithUserEvents(events),
+	)
+	require.NoError(t, err)
+	s := NewServer(us, Config{
+		Logger:     logger,
+		APIKeys:    auth.NewAPIKeyAuthenticator(keys, logger),
+		Authorizer: auth.NewAuthorizer(nil),
+	})
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return userpb.NewUserServiceClient(conn), keys, users
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
+func testUser(name string) *userpb.User {
+	return &userpb.User{
+		UserName:   name,
+		FirstName:  "Ada",
+		LastName:   "Lovelace",
+		Email:      name + "@example.com",
+		Department: "Engineering",
+		UserStatus: userpb.UserStatus_USER_STATUS_ACTIVE,
+	}
+}
+
+func TestUserService(t *testing.T) {
+	client, keys, _ := newTestClient(t)
+	admin := withKey(keys.issue(t, entity.PermUsersRead, entity.PermUsersReadPII, entity.PermUsersWrite, entity.PermUsersDelete))
+	reader := withKey(keys.issue(t, entity.PermUsersRead))
+
+	created, err := client.Create(admin, &userpb.CreateUserRequest{User: testUser("ada")})
+	require.NoError(t, err)
+	assert.Equal(t, "1", created.GetId())
+	assert.Equal(t, "ada@example.com", created.GetEmail())
+
+	invalid := testUser("bob")
+	invalid.Email = "not an email"
+	updated := testUser("ada")
+	updated.Id, updated.Department = created.GetId(), "Research"
+	missing := testUser("ada")
+	missing.Id = "42"
+	deactivated := testUser("ada")
+	deactivated.Id, deactivated.UserStatus = created.GetId(), userpb.UserStatus_USER_STATUS_INACTIVE
+	terminated := testUser("eve")
+	terminated.UserStatus = userpb.UserStatus_USER_STATUS_TERMINATED
+	unknown := testUser("eve")
+	unknown.UserStatus = userpb.UserStatus(9)
+	unspecified := testUser("ada")
+	unspecified.Id, unspecified.Department = created.GetId(), "Research"
+	unspecified.UserStatus = userpb.UserStatus_USER_STATUS_UNSPECIFIED
+
+	testCase := []struct {
+		name string
+		call func() (any, error)
+		code codes.Code
+	}{
+		{name: "missing credentials", code: codes.Unauthenticated, call: func() (any, error) {
+			return client.Get(context.Background(), &userpb.GetUserRequest{Id: created.GetId()})
+		}},
+		{name: "invalid key", code: codes.Unauthenticated, call: func() (any, error) {
+			return client.Get(withKey("ibg_nope_nope"), &userpb.GetUserRequest{Id: created.GetId()})
+		}},
+		{name: "missing scope", code: codes.PermissionDenied, call: func() (any, error) {
+			return client.Delete(reader, &userpb.DeleteUserRequest{Id: created.GetId()})
+		}},
+		{name: "get", code: codes.OK, call: func() (any, error) {
+			return client.Get(reader, &userpb.GetUserRequest{Id: created.GetId()})
+		}},
+		{name: "get missing", code: codes.NotFound, call: func() (any, error) {
+			return client.Get(reader, &userpb.GetUserRequest{Id: "42"})
+		}},
+		{name: "create invalid", code: codes.InvalidArgument, call: func() (any, error) {
+			return client.Create(admin, &userpb.CreateUserRequest{User: invalid})
+		}},
+		{name: "create without user", code: codes.InvalidArgument, call: func() (any, error) {
+			return client.Create(admin, &userpb.CreateUserRequest{})
+		}},
+		{name: "create duplicate", code: codes.AlreadyExists, call: func() (any, error) {
+			return client.Create(admin, &userpb.CreateUserRequest{User: testUser("ada")})
+		}},
+		{name: "update", code: codes.OK, call: func() (any, error) {
+			return client.Update(admin, &userpb.UpdateUserRequest{User: updated})
+		}},
+		{name: "create terminated", code: codes.InvalidArgument, call: func() (any, error) {
+			return client.Create(admin, &userpb.CreateUserRequest{User: terminated})
+		}},
+		{name: "create unknown status", code: codes.InvalidArgument, call: func() (any, error) {
+			return client.Create(admin, &userpb.CreateUserRequest{User: unknown})
+		}},
+		{name: "update does not change the status", code: codes.FailedPrecondition, call: func() (any, error) {
+			return client.Update(admin, &userpb.UpdateUserRequest{User: deactivated})
+		}},
+		{name: "update keeps an unspecified status", code: codes.OK, call: func() (any, error) {
+			return client.Update(admin, &userpb.UpdateUserRequest{User: unspecified})
+		}},
+		{name: "update missing", code: codes.NotFound, call: func() (any, error) {
+			return client.Update(admin, &userpb.UpdateUserRequest{User: missing})
+		}},
+		{name: "delete missing", code: codes.NotFound, call: func() (any, error) {
+			return client.Delete(admin, &userpb.DeleteUserRequest{Id: "42"})
+		}},
+		{name: "invalid page token", code: codes.InvalidArgument, call: func() (any, error) {
+			return client.List(reader, &userpb.ListUsersRequest{PageToken: "!"})
+		}},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.call()
+			assert.Equal(t, tc.code, status.Code(err), err)
+		})
+	}
+
+	got, err := client.Get(reader, &userpb.GetUserRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	assert.Equal(t, "Research", got.GetDepartment())
+	assert.Equal(t, userpb.UserStatus_USER_STATUS_ACTIVE, got.GetUserStatus())
+	assert.Empty(t, got.GetEmail(), "email requires users:read_pii")
+
+	_, err = client.Delete(admin, &userpb.DeleteUserRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	_, err = client.Get(reader, &userpb.GetUserRequest{Id: created.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestList(t *testing.T) {
+	client, keys, _ := newTestClient(t)
+	ctx := withKey(keys.issue(t, entity.PermUsersRead, entity.PermUsersWrite))
+	for i := 0; i < 5; i++ {
+		_, err := client.Create(ctx, &userpb.CreateUserRequest{User: testUser("user" + strconv.Itoa(i))})
+		require.NoError(t, err)
+	}
+
+	var ids []string
+	token := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		res, err := client.List(ctx, &userpb.ListUsersRequest{PageSize: 2, PageToken: token})
+		require.NoError(t, err)
+		assert.EqualValues(t, 5, res.GetTotalSize())
+		for _, u := range res.GetUsers() {
+			ids = append(ids, u.GetId())
+		}
+		if token = res.GetNextPageToken(); token == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, ids)
+}
+
+func TestWatch(t *testing.T) {
+	client, keys, _ := newTestClient(t)
+	ctx := withKey(keys.issue(t, entity.PermUsersRead, entity.PermUsersWrite, entity.PermUsersDelete))
+	existing, err := client.Create(ctx, &userpb.CreateUserRequest{User: testUser("ada")})
+	require.NoError(t, err)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &userpb.WatchUsersRequest{IncludeExisting: true})
+	require.NoError(t, err)
+	e, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, userpb.UserEvent_TYPE_CREATED, e.GetType())
+	assert.Equal(t, existing.GetId(), e.GetUser().GetId())
+
+	created, err := client.Create(ctx, &userpb.CreateUserRequest{User: testUser("bob")})
+	require.NoError(t, err)
+	_, err = client.Delete(ctx, &userpb.DeleteUserRequest{Id: existing.GetId()})
+	require.NoError(t, err)
+
+	want := []struct {
+		typ userpb.UserEvent_Type
+		id  string
+	}{
+		{userpb.UserEvent_TYPE_CREATED, created.GetId()},
+		{userpb.UserEvent_TYPE_DELETED, existing.GetId()},
+	}
+	for _, w := range want {
+		e, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, w.typ, e.GetType())
+		assert.Equal(t, w.id, e.GetUser().GetId())
+		assert.Empty(t, e.GetUser().GetEmail())
+	}
+}
+
+func TestUserEventsDropsSlowWatchers(t *testing.T) {
+	ue := NewUserEvents()
+	slow, _ := ue.Subscribe()
+	fast, unsubscribe := ue.Subscribe()
+	defer unsubscribe()
+	for i := 0; i <= watchBuffer; i++ {
+		ue.Publish(UserEvent{Type: UserUpdated})
+		<-fast
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	assert.Equal(t, watchBuffer, n, "the slow watcher is closed once its buffer is full")
+
+	ue.Publish(UserEvent{Type: UserDeleted})
+	assert.Equal(t, UserDeleted, (<-fast).Type)
+}
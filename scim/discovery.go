@@ -0,0 +1,159 @@
+package scim
+
+// Discovery resources, see RFC 7643 sections 5 to 7. baseURL is the url of
+// the /scim/v2 root.
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupport            `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+func NewServiceProviderConfig(baseURL string) ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas: []string{SchemaSPConfig},
+		Patch:   supported{Supported: true},
+		Filter:  filterSupport{Supported: true, MaxResults: MaxResults},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "a bearer jwt or an X-API-Key header",
+			Primary:     true,
+		}},
+		Meta: Meta{ResourceType: "ServiceProviderConfig", Location: baseURL + "/ServiceProviderConfig"},
+	}
+}
+
+type ResourceType struct {
+	Schemas          []string          `json:"schemas"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Endpoint         string            `json:"endpoint"`
+	Description      string            `json:"description"`
+	Schema           string            `json:"schema"`
+	SchemaExtensions []schemaExtension `json:"schemaExtensions"`
+	Meta             Meta              `json:"meta"`
+}
+
+type schemaExtension struct {
+	Schema   string `json:"schema"`
+	Required bool   `json:"required"`
+}
+
+func NewUserResourceType(baseURL string) ResourceType {
+	return ResourceType{
+		Schemas:          []string{SchemaResourceType},
+		ID:               "User",
+		Name:             "User",
+		Endpoint:         "/Users",
+		Description:      "User Account",
+		Schema:           SchemaUser,
+		SchemaExtensions: []schemaExtension{{Schema: SchemaEnterpriseUser, Required: true}},
+		Meta:             Meta{ResourceType: "ResourceType", Location: baseURL + "/ResourceTypes/User"},
+	}
+}
+
+type Schema struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []Attribute `json:"attributes"`
+	Meta        Meta        `json:"meta"`
+}
+
+type Attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Description   string      `json:"description"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []Attribute `json:"subAttributes,omitempty"`
+}
+
+func attribute(name, typ, description string, required bool) Attribute {
+	return Attribute{
+		Name:        name,
+		Type:        typ,
+		Description: description,
+		Required:    required,
+		Mutability:  "readWrite",
+		Returned:    "default",
+		Uniqueness:  "none",
+	}
+}
+
+// Schemas returns the schemas of the user resource, restricted to the
+// attributes mapped onto entity.User
+func Schemas(baseURL string) []Schema {
+	userName := attribute("userName", "string", "Unique identifier of the user, used to sign in", true)
+	userName.Uniqueness = "server"
+	emails := attribute("emails", "complex", "Email addresses of the user, the primary one is stored", true)
+	emails.MultiValued = true
+	emails.SubAttributes = []Attribute{
+		attribute("value", "string", "Email address", true),
+		attribute("type", "string", "Label of the address, e.g. work", false),
+		attribute("primary", "boolean", "Whether this is the primary address", false),
+	}
+	name := attribute("name", "complex", "Components of the name of the user", true)
+	name.SubAttributes = []Attribute{
+		attribute("givenName", "string", "First name", true),
+		attribute("familyName", "string", "Last name", true),
+		attribute("formatted", "string", "Full name, read only", false),
+	}
+	name.SubAttributes[2].Mutability = "readOnly"
+	displayName := attribute("displayName", "string", "Full name, read only", false)
+	displayName.Mutability = "readOnly"
+	active := attribute("active", "boolean", "Whether the user is active, false makes active users inactive", false)
+
+	return []Schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaUser,
+			Name:        "User",
+			Description: "User Account",
+			Attributes:  []Attribute{userName, name, displayName, emails, active},
+			Meta:        Meta{ResourceType: "Schema", Location: baseURL + "/Schemas/" + SchemaUser},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaEnterpriseUser,
+			Name:        "EnterpriseUser",
+			Description: "Enterprise User",
+			Attributes:  []Attribute{attribute("department", "string", "Department of the user", true)},
+			Meta:        Meta{ResourceType: "Schema", Location: baseURL + "/Schemas/" + SchemaEnterpriseUser},
+		},
+	}
+}
@@ -0,0 +1,433 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter is a parsed filter expression, see RFC 7644 section 3.4.2.2
+type Filter interface {
+	match(doc map[string]any) bool
+}
+
+// ParseFilter parses a filter such as
+//
+//	userName eq "bjensen" and (emails[type eq "work"] pr or not (active eq true))
+func ParseFilter(s string) (Filter, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, p.peek().text)
+	}
+	return f, nil
+}
+
+// Match reports whether u satisfies f
+func Match(f Filter, u User) (bool, error) {
+	doc, err := u.document()
+	if err != nil {
+		return false, err
+	}
+	return f.match(doc), nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokLBracket, text: "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokRBracket, text: "]"})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			var v string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &v); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: v})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[j])); j++ {
+			}
+			tokens = append(tokens, token{kind: tokWord, text: s[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) keyword(k string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, k) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if p.next().kind != kind {
+		return fmt.Errorf("%w: expected %q", ErrInvalidFilter, text)
+	}
+	return nil
+}
+
+func (p *parser) or() (Filter, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("or") {
+		var right Filter
+		if right, err = p.and(); err == nil {
+			left = orFilter{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (Filter, error) {
+	left, err := p.not()
+	for err == nil && p.keyword("and") {
+		var right Filter
+		if right, err = p.not(); err == nil {
+			left = andFilter{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) not() (Filter, error) {
+	if !p.keyword("not") {
+		return p.atom()
+	}
+	if err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	return notFilter{f}, p.expect(tokRParen, ")")
+}
+
+func (p *parser) atom() (Filter, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(tokRParen, ")")
+	case tokWord:
+	default:
+		return nil, fmt.Errorf("%w: expected an attribute, got %q", ErrInvalidFilter, t.text)
+	}
+	attr := t.text
+	if p.peek().kind == tokLBracket {
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return valuePathFilter{attr: attr, filter: inner}, nil
+	}
+	op := p.next()
+	if op.kind != tokWord {
+		return nil, fmt.Errorf("%w: expected an operator after %q", ErrInvalidFilter, attr)
+	}
+	switch o := strings.ToLower(op.text); o {
+	case "pr":
+		return presentFilter{attr: attr}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return compareFilter{attr: attr, op: o, value: v}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, op.text)
+	}
+}
+
+// value parses a comparison value: a string, a number, true, false or null
+func (p *parser) value() (any, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return t.text, nil
+	case tokWord:
+		var v any
+		if err := json.Unmarshal([]byte(strings.ToLower(t.text)), &v); err != nil {
+			return nil, fmt.Errorf("%w: invalid value %q", ErrInvalidFilter, t.text)
+		}
+		if _, ok := v.(string); ok {
+			return nil, fmt.Errorf("%w: invalid value %q", ErrInvalidFilter, t.text)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%w: expected a value", ErrInvalidFilter)
+	}
+}
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) match(doc map[string]any) bool { return f.left.match(doc) || f.right.match(doc) }
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) match(doc map[string]any) bool { return f.left.match(doc) && f.right.match(doc) }
+
+type notFilter struct{ filter Filter }
+
+func (f notFilter) match(doc map[string]any) bool { return !f.filter.match(doc) }
+
+type presentFilter struct{ attr string }
+
+func (f presentFilter) match(doc map[string]any) bool {
+	for _, v := range resolve(doc, f.attr) {
+		if !empty(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuePathFilter matches documents with an element of a multi-valued
+// attribute matching filter, e.g. emails[type eq "work"]
+type valuePathFilter struct {
+	attr   string
+	filter Filter
+}
+
+func (f valuePathFilter) match(doc map[string]any) bool {
+	for _, v := range resolve(doc, f.attr) {
+		if m, ok := v.(map[string]any); ok && f.filter.match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+type compareFilter struct {
+	attr  string
+	op    string
+	value any
+}
+
+func (f compareFilter) match(doc map[string]any) bool {
+	values := resolve(doc, f.attr)
+	if f.value == nil {
+		// "eq null" matches missing attributes
+		present := presentFilter{attr: f.attr}.match(doc)
+		return (f.op == "eq") != present
+	}
+	for _, v := range values {
+		// complex values compare their value sub-attribute, "emails eq x"
+		// is "emails.value eq x"
+		if m, ok := v.(map[string]any); ok {
+			v = m["value"]
+		}
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return f.op == "ne" && len(values) == 0
+}
+
+// compare applies op to an attribute value and a comparison value. Strings
+// are compared case insensitively as every supported attribute has
+// caseExact false.
+func compare(v any, op string, want any) bool {
+	switch w := want.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return op == "ne"
+		}
+		s, w = strings.ToLower(s), strings.ToLower(w)
+		switch op {
+		case "eq":
+			return s == w
+		case "ne":
+			return s != w
+		case "co":
+			return strings.Contains(s, w)
+		case "sw":
+			return strings.HasPrefix(s, w)
+		case "ew":
+			return strings.HasSuffix(s, w)
+		case "gt":
+			return s > w
+		case "ge":
+			return s >= w
+		case "lt":
+			return s < w
+		case "le":
+			return s <= w
+		}
+	case float64:
+		n, ok := v.(float64)
+		if !ok {
+			return op == "ne"
+		}
+		switch op {
+		case "eq":
+			return n == w
+		case "ne":
+			return n != w
+		case "gt":
+			return n > w
+		case "ge":
+			return n >= w
+		case "lt":
+			return n < w
+		case "le":
+			return n <= w
+		}
+	case bool:
+		b, ok := v.(bool)
+		switch op {
+		case "eq":
+			return ok && b == w
+		case "ne":
+			return !ok || b != w
+		}
+	}
+	return false
+}
+
+// resolve returns the values of an attribute path in doc. Paths are case
+// insensitive, may be prefixed with a schema urn and flatten multi-valued
+// attributes, so "emails.value" returns every address.
+func resolve(doc map[string]any, path string) []any {
+	current := []any{doc}
+	for _, name := range splitPath(path) {
+		var next []any
+		for _, c := range current {
+			m, ok := c.(map[string]any)
+			if !ok {
+				continue
+			}
+			v, ok := lookup(m, name)
+			if !ok {
+				continue
+			}
+			if list, ok := v.([]any); ok {
+				next = append(next, list...)
+			} else {
+				next = append(next, v)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// splitPath splits an attribute path into attribute names. A schema urn
+// prefix is kept as the first name, core user attributes may omit it.
+func splitPath(path string) []string {
+	lower := strings.ToLower(path)
+	for _, urn := range []string{SchemaEnterpriseUser, SchemaUser} {
+		if strings.HasPrefix(lower, strings.ToLower(urn)+":") {
+			rest := strings.Split(path[len(urn)+1:], ".")
+			if urn == SchemaUser {
+				return rest
+			}
+			return append([]string{urn}, rest...)
+		}
+	}
+	return strings.Split(path, ".")
+}
+
+// lookup returns the value of the case insensitive key name of m
+func lookup(m map[string]any, name string) (any, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func empty(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	case []any:
+		return len(x) == 0
+	case map[string]any:
+		return len(x) == 0
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// readOnly are the attributes a patch may not modify
+var readOnly = map[string]bool{"id": true, "meta": true, "schemas": true}
+
+// patchPath is a parsed patch path: attr, optionally filtered with
+// filter and narrowed to the sub-attribute sub, e.g.
+// emails[type eq "work"].value
+type patchPath struct {
+	attr   []string
+	filter Filter
+	sub    string
+}
+
+func parsePatchPath(path string) (patchPath, error) {
+	var p patchPath
+	attr := path
+	if open := strings.IndexByte(path, '['); open >= 0 {
+		end := strings.LastIndexByte(path, ']')
+		if end < open {
+			return p, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		f, err := ParseFilter(path[open+1 : end])
+		if err != nil {
+			return p, fmt.Errorf("%w: %q: %v", ErrInvalidPath, path, err)
+		}
+		p.filter = f
+		attr = path[:open]
+		rest := path[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return p, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+			}
+			p.sub = rest[1:]
+		}
+	}
+	p.attr = splitPath(attr)
+	for _, name := range p.attr {
+		if name == "" {
+			return p, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+	}
+	if readOnly[strings.ToLower(p.attr[0])] {
+		return p, fmt.Errorf("%w: %s", ErrMutability, p.attr[0])
+	}
+	return p, nil
+}
+
+// Apply applies the operations of p to u, see RFC 7644 section 3.5.2
+func (p PatchRequest) Apply(u User) (User, error) {
+	doc, err := u.document()
+	if err != nil {
+		return User{}, err
+	}
+	for _, op := range p.Operations {
+		var value any
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return User{}, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+		}
+		kind := strings.ToLower(op.Op)
+		if op.Path == "" {
+			// without a path the value holds the attributes to add or replace
+			attrs, ok := value.(map[string]any)
+			if !ok {
+				return User{}, fmt.Errorf("%w: %s without a path requires an object", ErrInvalidValue, op.Op)
+			}
+			for k, v := range attrs {
+				if readOnly[strings.ToLower(k)] {
+					// clients echo read only attributes of the resource
+					continue
+				}
+				if err := applyPath(doc, kind, k, v); err != nil {
+					return User{}, err
+				}
+			}
+			continue
+		}
+		if err := applyPath(doc, kind, op.Path, value); err != nil {
+			return User{}, err
+		}
+	}
+	out, err := userFromDocument(doc)
+	if err != nil {
+		return User{}, err
+	}
+	out.ID, out.Meta, out.Schemas = u.ID, u.Meta, u.Schemas
+	return out, nil
+}
+
+func applyPath(doc map[string]any, op, path string, value any) error {
+	if op != "remove" && strings.EqualFold(path, SchemaEnterpriseUser) {
+		// {"urn:...:enterprise:2.0:User": {"department": "x"}}
+		attrs, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%w: %s requires an object", ErrInvalidValue, path)
+		}
+		for k, v := range attrs {
+			if err := applyPath(doc, op, SchemaEnterpriseUser+":"+k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	p, err := parsePatchPath(path)
+	if err != nil {
+		return err
+	}
+	parent := doc
+	for _, name := range p.attr[:len(p.attr)-1] {
+		child, ok := lookup(parent, name)
+		m, isMap := child.(map[string]any)
+		switch {
+		case ok && isMap:
+		case op == "remove":
+			return nil
+		default:
+			m = make(map[string]any)
+			parent[keyOf(parent, name)] = m
+		}
+		parent = m
+	}
+	key := keyOf(parent, p.attr[len(p.attr)-1])
+	if p.filter != nil {
+		return applyFiltered(parent, key, op, p, value)
+	}
+	switch op {
+	case "remove":
+		delete(parent, key)
+	case "add":
+		current, _ := parent[key].([]any)
+		if list, ok := value.([]any); ok && current != nil {
+			parent[key] = append(current, list...)
+			return nil
+		}
+		parent[key] = merge(parent[key], value)
+	case "replace":
+		parent[key] = merge(parent[key], value)
+	}
+	return nil
+}
+
+// applyFiltered applies op to the elements of the multi-valued attribute
+// key matching the filter of p
+func applyFiltered(parent map[string]any, key, op string, p patchPath, value any) error {
+	list, _ := parent[key].([]any)
+	matched := false
+	kept := list[:0:0]
+	for _, v := range list {
+		elem, ok := v.(map[string]any)
+		if !ok || !p.filter.match(elem) {
+			kept = append(kept, v)
+			continue
+		}
+		matched = true
+		switch {
+		case op == "remove" && p.sub == "":
+			continue
+		case op == "remove":
+			delete(elem, keyOf(elem, p.sub))
+		case p.sub == "":
+			vm, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%w: %s requires an object", ErrInvalidValue, key)
+			}
+			elem = merge(elem, vm).(map[string]any)
+		default:
+			elem[keyOf(elem, p.sub)] = value
+		}
+		kept = append(kept, elem)
+	}
+	if !matched {
+		// emails[type eq "work"].value creates the work address when missing
+		cmp, ok := p.filter.(compareFilter)
+		if op == "remove" || !ok || cmp.op != "eq" {
+			return fmt.Errorf("%w: %s", ErrNoTarget, key)
+		}
+		elem := map[string]any{cmp.attr: cmp.value}
+		if p.sub == "" {
+			vm, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%w: %s requires an object", ErrInvalidValue, key)
+			}
+			elem = merge(elem, vm).(map[string]any)
+		} else {
+			elem[p.sub] = value
+		}
+		kept = append(kept, elem)
+	}
+	parent[key] = kept
+	return nil
+}
+
+// merge returns value, merged into current when both are objects so a
+// replace only changes the sub-attributes it names
+func merge(current, value any) any {
+	cm, ok := current.(map[string]any)
+	vm, ok2 := value.(map[string]any)
+	if !ok || !ok2 {
+		return value
+	}
+	for k, v := range vm {
+		cm[keyOf(cm, k)] = v
+	}
+	return cm
+}
+
+// keyOf returns the key of m matching name case insensitively, or name
+func keyOf(m map[string]any, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
@@ -0,0 +1,167 @@
+// Package scim implements the protocol side of SCIM 2.0 user provisioning,
+// see RFC 7643 and RFC 7644: resources, filters and patch operations.
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaType = "application/scim+json"
+
+	SchemaUser           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaEnterpriseUser = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SchemaListResponse   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp        = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError          = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaSPConfig       = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType   = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema         = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+
+	// MaxResults is the largest page returned by a list request
+	MaxResults = 200
+)
+
+// scimType values of errors, see RFC 7644 section 3.12
+const (
+	TypeInvalidFilter = "invalidFilter"
+	TypeInvalidPath   = "invalidPath"
+	TypeInvalidValue  = "invalidValue"
+	TypeInvalidSyntax = "invalidSyntax"
+	TypeMutability    = "mutability"
+	TypeNoTarget      = "noTarget"
+	TypeUniqueness    = "uniqueness"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrInvalidValue  = errors.New("invalid value")
+	ErrInvalidSyntax = errors.New("invalid request")
+	ErrMutability    = errors.New("attribute is read only")
+	ErrNoTarget      = errors.New("path matched no value")
+)
+
+// Error is the body of error responses
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError returns the response to err, the scimType is derived from the
+// errors of this package
+func NewError(status int, err error) Error {
+	e := Error{Schemas: []string{SchemaError}, Status: strconv.Itoa(status), Detail: err.Error()}
+	for _, t := range []struct {
+		err      error
+		scimType string
+	}{
+		{ErrInvalidFilter, TypeInvalidFilter},
+		{ErrInvalidPath, TypeInvalidPath},
+		{ErrInvalidValue, TypeInvalidValue},
+		{ErrInvalidSyntax, TypeInvalidSyntax},
+		{ErrMutability, TypeMutability},
+		{ErrNoTarget, TypeNoTarget},
+	} {
+		if errors.Is(err, t.err) {
+			e.ScimType = t.scimType
+			break
+		}
+	}
+	return e
+}
+
+// ListResponse is a page of resources
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// Page returns the page of resources starting at the 1-based startIndex
+// with at most count resources
+func Page[T any](resources []T, startIndex, count int) ListResponse {
+	res := ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		Resources:    []any{},
+	}
+	for i := startIndex - 1; i < len(resources) && len(res.Resources) < count; i++ {
+		res.Resources = append(res.Resources, resources[i])
+	}
+	res.ItemsPerPage = len(res.Resources)
+	return res
+}
+
+// Pagination parses the startIndex and count query parameters, invalid
+// values fall back to the first page of MaxResults resources
+func Pagination(startIndex, count string) (int, int) {
+	start, err := strconv.Atoi(startIndex)
+	if err != nil || start < 1 {
+		start = 1
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n > MaxResults {
+		n = MaxResults
+	}
+	if n < 0 {
+		n = 0
+	}
+	return start, n
+}
+
+// PatchRequest is the body of a PATCH request
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Validate checks the message schema and the operations of a patch request
+func (p PatchRequest) Validate() error {
+	if len(p.Schemas) != 1 || p.Schemas[0] != SchemaPatchOp {
+		return fmt.Errorf("%w: schemas must be [%q]", ErrInvalidSyntax, SchemaPatchOp)
+	}
+	if len(p.Operations) == 0 {
+		return fmt.Errorf("%w: no operations", ErrInvalidSyntax)
+	}
+	for _, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if len(op.Value) == 0 {
+				return fmt.Errorf("%w: %s requires a value", ErrInvalidValue, op.Op)
+			}
+		case "remove":
+			if op.Path == "" {
+				return fmt.Errorf("%w: remove requires a path", ErrNoTarget)
+			}
+		default:
+			return fmt.Errorf("%w: unknown op %q", ErrInvalidSyntax, op.Op)
+		}
+	}
+	return nil
+}
+
+// StatusFor is the http status of the errors of this package
+func StatusFor(err error) int {
+	if errors.Is(err, ErrInvalidFilter) || errors.Is(err, ErrInvalidPath) || errors.Is(err, ErrInvalidValue) ||
+		errors.Is(err, ErrInvalidSyntax) || errors.Is(err, ErrMutability) || errors.Is(err, ErrNoTarget) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
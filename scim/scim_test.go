@@ -0,0 +1,229 @@
+package scim
+
+import (
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var testUser = entity.User{
+	ID:         "7",
+	UserName:   "bjensen",
+	FirstName:  "Barbara",
+	LastName:   "Jensen",
+	Email:      "bjensen@example.com",
+	Department: "Engineering",
+	UserStatus: entity.Active,
+}
+
+func TestFilter(t *testing.T) {
+	u := FromUser(testUser, "")
+	testCase := []struct {
+		filter string
+		match  bool
+		err    bool
+	}{
+		{filter: `userName eq "bjensen"`, match: true},
+		{filter: `USERNAME eq "BJensen"`, match: true},
+		{filter: `userName ne "bjensen"`},
+		{filter: `userName sw "bj"`, match: true},
+		{filter: `userName ew "sen"`, match: true},
+		{filter: `name.familyName co "ens"`, match: true},
+		{filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, match: true},
+		{filter: `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department eq "engineering"`, match: true},
+		{filter: `emails eq "bjensen@example.com"`, match: true},
+		{filter: `emails.value ew "@example.com"`, match: true},
+		{filter: `emails[type eq "work" and value co "@example"]`, match: true},
+		{filter: `emails[type eq "home"]`},
+		{filter: `active eq true`, match: true},
+		{filter: `active eq false`},
+		{filter: `title pr`},
+		{filter: `title eq null`, match: true},
+		{filter: `userName pr and not (active eq false)`, match: true},
+		{filter: `userName eq "x" or userName eq "bjensen"`, match: true},
+		{filter: `userName eq "x" or userName eq "y" and active eq true`},
+		{filter: `(userName eq "x" or userName eq "bjensen") and active eq true`, match: true},
+		{filter: `userName gt "a" and userName lt "c"`, match: true},
+		{filter: `userName eq "bjensen`, err: true},
+		{filter: `userName eq bjensen`, err: true},
+		{filter: `userName xx "bjensen"`, err: true},
+		{filter: `(userName eq "bjensen"`, err: true},
+		{filter: `userName eq "bjensen" extra`, err: true},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.filter, func(t *testing.T) {
+			f, err := ParseFilter(tc.filter)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrInvalidFilter)
+				return
+			}
+			require.NoError(t, err)
+			ok, err := Match(f, u)
+			require.NoError(t, err)
+			assert.Equal(t, tc.match, ok)
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	op := func(kind, path, value string) PatchOperation {
+		return PatchOperation{Op: kind, Path: path, Value: json.RawMessage(value)}
+	}
+	testCase := []struct {
+		name string
+		ops  []PatchOperation
+		want func(u *entity.User)
+		err  error
+	}{
+		{
+			name: "replace attribute",
+			ops:  []PatchOperation{op("replace", "userName", `"barbara"`)},
+			want: func(u *entity.User) { u.UserName = "barbara" },
+		},
+		{
+			name: "replace sub-attribute",
+			ops:  []PatchOperation{op("Replace", "name.givenName", `"Babs"`)},
+			want: func(u *entity.User) { u.FirstName = "Babs" },
+		},
+		{
+			name: "deactivate with string boolean",
+			ops:  []PatchOperation{op("replace", "active", `"False"`)},
+			want: func(u *entity.User) { u.UserStatus = entity.Inactive },
+		},
+		{
+			name: "replace without path",
+			ops:  []PatchOperation{op("replace", "", `{"active": false, "name.familyName": "Doe", "id": "8"}`)},
+			want: func(u *entity.User) {
+				u.UserStatus = entity.Inactive
+				u.LastName = "Doe"
+			},
+		},
+		{
+			name: "replace filtered email",
+			ops:  []PatchOperation{op("replace", `emails[type eq "work"].value`, `"babs@example.com"`)},
+			want: func(u *entity.User) { u.Email = "babs@example.com" },
+		},
+		{
+			name: "replace enterprise attribute",
+			ops:  []PatchOperation{op("replace", SchemaEnterpriseUser+":department", `"Sales"`)},
+			want: func(u *entity.User) { u.Department = "Sales" },
+		},
+		{
+			name: "replace enterprise extension",
+			ops:  []PatchOperation{op("add", SchemaEnterpriseUser, `{"department": "Sales"}`)},
+			want: func(u *entity.User) { u.Department = "Sales" },
+		},
+		{
+			name: "remove email",
+			ops:  []PatchOperation{op("remove", `emails[type eq "work"]`, ``)},
+			want: func(u *entity.User) { u.Email = "" },
+		},
+		{
+			name: "remove missing filtered email",
+			ops:  []PatchOperation{op("remove", `emails[type eq "home"]`, ``)},
+			err:  ErrNoTarget,
+		},
+		{
+			name: "read only attribute",
+			ops:  []PatchOperation{op("replace", "id", `"8"`)},
+			err:  ErrMutability,
+		},
+		{
+			name: "invalid path",
+			ops:  []PatchOperation{op("replace", `emails[type eq].value`, `"x"`)},
+			err:  ErrInvalidPath,
+		},
+		{
+			name: "invalid value",
+			ops:  []PatchOperation{op("replace", "active", `"maybe"`)},
+			err:  ErrInvalidValue,
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := PatchRequest{Schemas: []string{SchemaPatchOp}, Operations: tc.ops}
+			require.NoError(t, req.Validate())
+			patched, err := req.Apply(FromUser(testUser, ""))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			want := testUser
+			tc.want(&want)
+			assert.Equal(t, want, patched.ToUser(testUser))
+		})
+	}
+}
+
+func TestPatchValidate(t *testing.T) {
+	testCase := []struct {
+		name string
+		req  PatchRequest
+		err  error
+	}{
+		{name: "missing schema", req: PatchRequest{Operations: []PatchOperation{{Op: "remove", Path: "title"}}}, err: ErrInvalidSyntax},
+		{name: "no operations", req: PatchRequest{Schemas: []string{SchemaPatchOp}}, err: ErrInvalidSyntax},
+		{name: "unknown op", req: PatchRequest{Schemas: []string{SchemaPatchOp}, Operations: []PatchOperation{{Op: "move", Path: "title"}}}, err: ErrInvalidSyntax},
+		{name: "remove without path", req: PatchRequest{Schemas: []string{SchemaPatchOp}, Operations: []PatchOperation{{Op: "remove"}}}, err: ErrNoTarget},
+		{name: "replace without value", req: PatchRequest{Schemas: []string{SchemaPatchOp}, Operations: []PatchOperation{{Op: "replace", Path: "title"}}}, err: ErrInvalidValue},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.req.Validate()
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, 400, StatusFor(err))
+		})
+	}
+}
+
+func TestToUserStatus(t *testing.T) {
+	active, inactive := Bool(true), Bool(false)
+	testCase := []struct {
+		name    string
+		current entity.Status
+		active  *Bool
+		want    entity.Status
+	}{
+		{name: "deactivate", current: entity.Active, active: &inactive, want: entity.Inactive},
+		{name: "activate", current: entity.Inactive, active: &active, want: entity.Active},
+		{name: "terminated stays terminated", current: entity.Terminated, active: &inactive, want: entity.Terminated},
+		{name: "missing keeps status", current: entity.Inactive, want: entity.Inactive},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			current := testUser
+			current.UserStatus = tc.current
+			u := FromUser(current, "")
+			u.Active = tc.active
+			assert.Equal(t, tc.want, u.ToUser(current).UserStatus)
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	testCase := []struct {
+		name       string
+		startIndex string
+		count      string
+		want       []any
+	}{
+		{name: "defaults", want: []any{1, 2, 3, 4, 5}},
+		{name: "second page", startIndex: "3", count: "2", want: []any{3, 4}},
+		{name: "past the end", startIndex: "9", count: "2", want: []any{}},
+		{name: "count only", count: "0", want: []any{}},
+		{name: "invalid start", startIndex: "-1", count: "1", want: []any{1}},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			startIndex, count := Pagination(tc.startIndex, tc.count)
+			res := Page(items, startIndex, count)
+			assert.Equal(t, 5, res.TotalResults)
+			assert.Equal(t, tc.want, res.Resources)
+			assert.Equal(t, len(tc.want), res.ItemsPerPage)
+		})
+	}
+}
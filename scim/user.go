@@ -0,0 +1,158 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"strings"
+)
+
+// User is the SCIM representation of entity.User
+type User struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *Name           `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Emails      []Email         `json:"emails,omitempty"`
+	Active      *Bool           `json:"active,omitempty"`
+	Enterprise  *EnterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta        *Meta           `json:"meta,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type EnterpriseUser struct {
+	Department string `json:"department,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// Bool is a boolean that also accepts the "True" and "False" strings some
+// identity providers send
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch x := v.(type) {
+	case bool:
+		*b = Bool(x)
+	case string:
+		switch strings.ToLower(x) {
+		case "true":
+			*b = true
+		case "false":
+			*b = false
+		default:
+			return fmt.Errorf("%w: %q is not a boolean", ErrInvalidValue, x)
+		}
+	default:
+		return fmt.Errorf("%w: %s is not a boolean", ErrInvalidValue, data)
+	}
+	return nil
+}
+
+// FromUser returns the SCIM representation of u, location is the url of the resource
+func FromUser(u entity.User, location string) User {
+	active := Bool(u.UserStatus == entity.Active)
+	out := User{
+		Schemas:  []string{SchemaUser, SchemaEnterpriseUser},
+		ID:       u.ID,
+		UserName: u.UserName,
+		Name: &Name{
+			Formatted:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		},
+		DisplayName: strings.TrimSpace(u.FirstName + " " + u.LastName),
+		Active:      &active,
+		Enterprise:  &EnterpriseUser{Department: u.Department},
+		Meta:        &Meta{ResourceType: "User", Location: location},
+	}
+	if u.Email != "" {
+		out.Emails = []Email{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	return out
+}
+
+// ToUser maps the attributes of u onto current, the user being replaced.
+// active=false makes active users inactive and keeps terminated users
+// terminated, a missing active keeps the current status.
+func (u User) ToUser(current entity.User) entity.User {
+	out := current
+	out.UserName = u.UserName
+	out.FirstName, out.LastName = "", ""
+	if u.Name != nil {
+		out.FirstName = u.Name.GivenName
+		out.LastName = u.Name.FamilyName
+	}
+	out.Email = u.primaryEmail()
+	out.Department = ""
+	if u.Enterprise != nil {
+		out.Department = u.Enterprise.Department
+	}
+	switch {
+	case u.Active == nil:
+	case bool(*u.Active):
+		out.UserStatus = entity.Active
+	case current.UserStatus != entity.Terminated:
+		out.UserStatus = entity.Inactive
+	}
+	return out
+}
+
+// primaryEmail is the primary address, else the first work address, else the first one
+func (u User) primaryEmail() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	for _, e := range u.Emails {
+		if strings.EqualFold(e.Type, "work") {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// document returns u as the generic json document filters and patches work on
+func (u User) document() (map[string]any, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]any)
+	return doc, json.Unmarshal(b, &doc)
+}
+
+func userFromDocument(doc map[string]any) (User, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return User{}, err
+	}
+	var u User
+	if err := json.Unmarshal(b, &u); err != nil {
+		return User{}, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return u, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/mailer"
+	"golang.org/x/exp/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// AccountMailer issues single use tokens and mails them to users for the
+// email verification and password reset flows
+type AccountMailer struct {
+	tokens  datastore.TokenRepository
+	mailer  mailer.Mailer
+	baseURL string
+	logger  *slog.Logger
+}
+
+// NewAccountMailer builds links to baseURL, APP_BASE_URL when empty
+func NewAccountMailer(tokens datastore.TokenRepository, m mailer.Mailer, baseURL string, logger *slog.Logger) *AccountMailer {
+	if baseURL == "" {
+		baseURL = os.Getenv("APP_BASE_URL")
+	}
+	return &AccountMailer{
+		tokens:  tokens,
+		mailer:  m,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		logger:  logger,
+	}
+}
+
+type accountMailData struct {
+	entity.User
+	Link      string
+	ExpiresAt time.Time
+}
+
+func (am *AccountMailer) send(ctx context.Context, user entity.User, purpose entity.TokenPurpose, ttl time.Duration, path, template string) error {
+	token, id, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(ttl)
+	err = am.tokens.CreateUserToken(ctx, entity.UserToken{
+		ID:        id,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := mailer.Render(template, user.Email, accountMailData{
+		User:      user,
+		Link:      am.baseURL + path + "?token=" + url.QueryEscape(token),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	if err := am.mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+	am.logger.InfoCtx(ctx, "account email sent", slog.String("template", template))
+	return nil
+}
+
+// SendVerification mails a link verifying the current address of user
+func (am *AccountMailer) SendVerification(ctx context.Context, user entity.User) error {
+	return am.send(ctx, user, entity.TokenVerifyEmail, verifyEmailTTL, "/auth/email/verify", mailer.TemplateVerifyEmail)
+}
+
+// SendPasswordReset mails a link to choose a new password
+func (am *AccountMailer) SendPasswordReset(ctx context.Context, user entity.User) error {
+	return am.send(ctx, user, entity.TokenResetPassword, resetPasswordTTL, "/auth/password/reset", mailer.TemplateResetPassword)
+}
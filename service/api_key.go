@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+var ErrExpiryInPast = errors.New("expiresAt must be in the future")
+
+type APIKeyConfiguration func(ks *APIKeyService) error
+
+// APIKeyService issues and revokes the API keys of service clients
+type APIKeyService struct {
+	keyRepo datastore.APIKeyRepository
+	logger  *slog.Logger
+}
+
+// issuedAPIKey is the only response carrying the plaintext key
+type issuedAPIKey struct {
+	entity.APIKey
+	Key string `json:"key"`
+}
+
+func NewAPIKeyServices(cfgs ...APIKeyConfiguration) (*APIKeyService, error) {
+	ks := &APIKeyService{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(ks); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+func WithAPIKeyRepository(kr datastore.APIKeyRepository, err error) APIKeyConfiguration {
+	return func(ks *APIKeyService) error {
+		if err != nil {
+			return err
+		}
+		ks.keyRepo = kr
+		return nil
+	}
+}
+
+// WithAPIKeyLogger sets the logger used by the api key handlers
+func WithAPIKeyLogger(logger *slog.Logger) APIKeyConfiguration {
+	return func(ks *APIKeyService) error {
+		ks.logger = logger
+		return nil
+	}
+}
+
+// handlers
+
+func (ks *APIKeyService) Create(ctx echo.Context) error {
+	key := new(entity.APIKey)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(key); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(key); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, ErrExpiryInPast)
+	}
+	plaintext, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return utils.JSON(ctx, "create api key for", http.StatusInternalServerError, err)
+	}
+	key.Prefix, key.Hash = prefix, hash
+	key.CreatedBy = auth.Subject(ctx)
+	out, err := ks.keyRepo.CreateAPIKey(rctx, *key)
+	if err != nil {
+		ks.logger.ErrorCtx(rctx, "failed to create api key", slog.Any("error", err))
+		return utils.JSON(ctx, "create api key for", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusCreated, issuedAPIKey{APIKey: out, Key: plaintext})
+}
+
+func (ks *APIKeyService) List(ctx echo.Context) error {
+	keys, err := ks.keyRepo.APIKeys(ctx.Request().Context())
+	if err != nil {
+		return utils.JSON(ctx, "fetch api keys of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, keys)
+}
+
+func (ks *APIKeyService) Rotate(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	plaintext, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return utils.JSON(ctx, "rotate api key of", http.StatusInternalServerError, err)
+	}
+	out, err := ks.keyRepo.RotateAPIKey(rctx, ctx.Param("id"), prefix, hash)
+	if err != nil {
+		if errors.Is(err, datastore.ErrAPIKeyNotFound) {
+			return utils.JSON(ctx, "rotate api key of", http.StatusNotFound, err)
+		}
+		ks.logger.ErrorCtx(rctx, "failed to rotate api key", slog.Any("error", err))
+		return utils.JSON(ctx, "rotate api key of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, issuedAPIKey{APIKey: out, Key: plaintext})
+}
+
+func (ks *APIKeyService) Revoke(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	if err := ks.keyRepo.RevokeAPIKey(rctx, ctx.Param("id")); err != nil {
+		if errors.Is(err, datastore.ErrAPIKeyNotFound) {
+			return utils.JSON(ctx, "revoke api key of", http.StatusNotFound, err)
+		}
+		ks.logger.ErrorCtx(rctx, "failed to revoke api key", slog.Any("error", err))
+		return utils.JSON(ctx, "revoke api key of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+var (
+	ErrUserNotActive        = errors.New("user is not active")
+	ErrNotSignedIn          = errors.New("not signed in with a session")
+	ErrPasswordRequired     = errors.New("password is required")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrWrongPassword        = errors.New("the current password is wrong")
+)
+
+type AuthConfiguration func(as *AuthService) error
+
+// AuthService signs users in and out with local credentials
+type AuthService struct {
+	userRepo    datastore.UserRepository
+	credRepo    datastore.CredentialRepository
+	sessionRepo datastore.SessionRepository
+	tokenRepo   datastore.TokenRepository
+	mfaRepo     datastore.MFARepository
+	totp        *auth.TOTP
+	limiter     *auth.LoginLimiter
+	lockoutRepo datastore.LockoutRepository
+	sessions    *auth.SessionManager
+	mail        *AccountMailer
+	logger      *slog.Logger
+}
+
+func NewAuthServices(cfgs ...AuthConfiguration) (*AuthService, error) {
+	as := &AuthService{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(as); err != nil {
+			return nil, err
+		}
+	}
+	return as, nil
+}
+
+// WithAuthRepository sets the stores of credentials and sessions
+func WithAuthRepository(cr datastore.CredentialRepository, sr datastore.SessionRepository, err error) AuthConfiguration {
+	return func(as *AuthService) error {
+		if err != nil {
+			return err
+		}
+		as.credRepo = cr
+		as.sessionRepo = sr
+		return nil
+	}
+}
+
+// WithAccountFlows enables the email verification and password reset flows
+func WithAccountFlows(ur datastore.UserRepository, tr datastore.TokenRepository, am *AccountMailer) AuthConfiguration {
+	return func(as *AuthService) error {
+		as.userRepo = ur
+		as.tokenRepo = tr
+		as.mail = am
+		return nil
+	}
+}
+
+func WithSessionManager(sm *auth.SessionManager) AuthConfiguration {
+	return func(as *AuthService) error {
+		as.sessions = sm
+		return nil
+	}
+}
+
+// WithAuthLogger sets the logger used by the auth handlers
+func WithAuthLogger(logger *slog.Logger) AuthConfiguration {
+	return func(as *AuthService) error {
+		as.logger = logger
+		return nil
+	}
+}
+
+// canManage reports whether the caller may manage the credentials and
+// sessions of userID, users always manage their own
+func canManage(ctx echo.Context, userID string) bool {
+	if p, ok := auth.PrincipalFrom(ctx); ok && p.UserID == userID {
+		return true
+	}
+	return auth.Can(ctx, entity.PermCredentialsManage)
+}
+
+// handlers
+
+func (as *AuthService) Login(ctx echo.Context) error {
+	login := new(entity.Login)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(login); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(login); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	if locked, err := as.lockedOut(ctx, login.UserName); locked {
+		return err
+	}
+	user, hash, err := as.credRepo.CredentialsByUserName(rctx, login.UserName)
+	if err != nil {
+		auth.VerifyNoPassword(login.Password)
+		if !errors.Is(err, datastore.ErrCredentialsNotFound) {
+			as.logger.ErrorCtx(rctx, "failed to fetch credentials", slog.Any("error", err))
+			return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+		}
+		as.loginFailed(ctx, login.UserName, "unknown user")
+		return utils.JSON(ctx, "sign in", http.StatusUnauthorized, auth.ErrInvalidPassword)
+	}
+	rctx = custom_slog.WithUserID(rctx, user.ID)
+	ctx.SetRequest(ctx.Request().WithContext(rctx))
+	ok, err := auth.VerifyPassword(login.Password, hash)
+	if err != nil || !ok {
+		as.loginFailed(ctx, login.UserName, "wrong password")
+		return utils.JSON(ctx, "sign in", http.StatusUnauthorized, auth.ErrInvalidPassword)
+	}
+	if user.UserStatus != entity.Active {
+		as.logger.WarnCtx(rctx, "login refused", slog.String("reason", "user not active"), slog.String("status", user.UserStatus.String()))
+		return utils.JSON(ctx, "sign in", http.StatusForbidden, ErrUserNotActive)
+	}
+	mfa, err := as.mfaEnabled(ctx, user.ID)
+	if err != nil {
+		as.logger.ErrorCtx(rctx, "failed to fetch mfa enrollment", slog.Any("error", err))
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	if mfa {
+		return as.challengeMFA(ctx, user.ID)
+	}
+	as.loginSucceeded(ctx, user.UserName)
+	return as.startSession(ctx, user.ID, false)
+}
+
+// startSession stores a new session for userID and sets its cookie, mfa
+// tells whether the user signed in with a second factor
+func (as *AuthService) startSession(ctx echo.Context, userID string, mfa bool) error {
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), userID)
+	token, sess, err := as.sessions.NewSession(userID, ctx.RealIP(), ctx.Request().UserAgent())
+	if err != nil {
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	sess.MFA = mfa
+	sess, err = as.sessionRepo.CreateSession(rctx, sess)
+	if err != nil {
+		as.logger.ErrorCtx(rctx, "failed to create session", slog.Any("error", err))
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	ctx.SetCookie(as.sessions.Cookie(token, sess.ExpiresAt))
+	as.logger.InfoCtx(rctx, "user signed in")
+	return utils.JSON(ctx, Successful, http.StatusOK, sess)
+}
+
+func (as *AuthService) Logout(ctx echo.Context) error {
+	p, ok := auth.PrincipalFrom(ctx)
+	if !ok || p.Method != auth.MethodSession {
+		return utils.JSON(ctx, "sign out", http.StatusBadRequest, ErrNotSignedIn)
+	}
+	rctx := ctx.Request().Context()
+	if err := as.sessionRepo.RevokeSession(rctx, p.UserID, p.SessionID); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to revoke session", slog.Any("error", err))
+		return utils.JSON(ctx, "sign out", http.StatusInternalServerError, err)
+	}
+	ctx.SetCookie(as.sessions.ClearCookie())
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+func (as *AuthService) SetPassword(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if !canManage(ctx, id) {
+		return utils.JSON(ctx, "set password of", http.StatusForbidden, auth.ErrForbidden)
+	}
+	change := new(entity.PasswordChange)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(change); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(change); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	// credential managers reset passwords, users confirm their current one
+	if !auth.Can(ctx, entity.PermCredentialsManage) {
+		if err := as.verifyCurrentPassword(rctx, id, change.CurrentPassword); err != nil {
+			if errors.Is(err, ErrWrongPassword) {
+				return utils.JSON(ctx, "set password of", http.StatusForbidden, err)
+			}
+			as.logger.ErrorCtx(rctx, "failed to fetch credentials", slog.Any("error", err))
+			return utils.JSON(ctx, "set password of", http.StatusInternalServerError, err)
+		}
+	}
+	hash, err := auth.HashPassword(change.Password)
+	if err != nil {
+		return utils.JSON(ctx, "set password of", http.StatusInternalServerError, err)
+	}
+	if err := as.credRepo.SetPassword(rctx, id, hash); err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "set password of", http.StatusNotFound, err)
+		}
+		as.logger.ErrorCtx(rctx, "failed to set password", slog.Any("error", err))
+		return utils.JSON(ctx, "set password of", http.StatusInternalServerError, err)
+	}
+	// a new password signs the user out everywhere
+	if err := as.sessionRepo.RevokeSession(rctx, id, ""); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to revoke sessions", slog.Any("error", err))
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// verifyCurrentPassword returns ErrWrongPassword unless password is the
+// current one of the user, users without one set their first freely
+func (as *AuthService) verifyCurrentPassword(ctx context.Context, userID, password string) error {
+	hash, err := as.credRepo.PasswordHash(ctx, userID)
+	if errors.Is(err, datastore.ErrCredentialsNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ok, err := auth.VerifyPassword(password, hash); err != nil || !ok {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+func (as *AuthService) ListSessions(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if !canManage(ctx, id) {
+		return utils.JSON(ctx, "fetch sessions of", http.StatusForbidden, auth.ErrForbidden)
+	}
+	sessions, err := as.sessionRepo.UserSessions(ctx.Request().Context(), id)
+	if err != nil {
+		return utils.JSON(ctx, "fetch sessions of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, sessions)
+}
+
+// RevokeSession revokes one session of a user, or all of them without a session id
+func (as *AuthService) RevokeSession(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if !canManage(ctx, id) {
+		return utils.JSON(ctx, "revoke session of", http.StatusForbidden, auth.ErrForbidden)
+	}
+	rctx := ctx.Request().Context()
+	if err := as.sessionRepo.RevokeSession(rctx, id, ctx.Param("sid")); err != nil {
+		if errors.Is(err, datastore.ErrSessionNotFound) {
+			return utils.JSON(ctx, "revoke session of", http.StatusNotFound, err)
+		}
+		as.logger.ErrorCtx(rctx, "failed to revoke session", slog.Any("error", err))
+		return utils.JSON(ctx, "revoke session of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// ForgotPassword mails a reset link to the owner of an address. It always
+// answers 202 so it cannot be used to find out which addresses exist.
+func (as *AuthService) ForgotPassword(ctx echo.Context) error {
+	req := new(entity.PasswordResetRequest)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	cus, err := as.userRepo.GetByEmail(rctx, req.Email)
+	switch {
+	case err == nil && cus.GetExportedCustomer().User.UserStatus == entity.Active:
+		rctx = custom_slog.WithUserID(rctx, cus.GetID())
+		if err := as.mail.SendPasswordReset(rctx, cus.GetExportedCustomer().User); err != nil {
+			as.logger.ErrorCtx(rctx, "failed to send password reset", slog.Any("error", err))
+		}
+	case err != nil && !errors.Is(err, datastore.ErrCustomerNotFound):
+		as.logger.ErrorCtx(rctx, "failed to fetch user", slog.Any("error", err))
+	}
+	return utils.JSON(ctx, Successful, http.StatusAccepted, nil)
+}
+
+// ResetPassword redeems a reset token for a new password
+func (as *AuthService) ResetPassword(ctx echo.Context) error {
+	req := new(entity.TokenRedemption)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	if req.Password == "" {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, ErrPasswordRequired)
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return utils.JSON(ctx, "reset password of", http.StatusInternalServerError, err)
+	}
+	t, err := as.tokenRepo.ConsumeUserToken(rctx, auth.HashToken(req.Token), entity.TokenResetPassword)
+	if err != nil {
+		return as.tokenError(ctx, "reset password of", err)
+	}
+	rctx = custom_slog.WithUserID(rctx, t.UserID)
+	if err := as.credRepo.SetPassword(rctx, t.UserID, hash); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to set password", slog.Any("error", err))
+		return utils.JSON(ctx, "reset password of", http.StatusInternalServerError, err)
+	}
+	if err := as.sessionRepo.RevokeSession(rctx, t.UserID, ""); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to revoke sessions", slog.Any("error", err))
+	}
+	as.logger.InfoCtx(rctx, "password reset")
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// VerifyEmail redeems a verification token sent in the body or, for links
+// opened from the email, in the token query parameter
+func (as *AuthService) VerifyEmail(ctx echo.Context) error {
+	req := &entity.TokenRedemption{Token: ctx.QueryParam("token")}
+	rctx := ctx.Request().Context()
+	if req.Token == "" {
+		if err := ctx.Bind(req); err != nil {
+			return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+		}
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	t, err := as.tokenRepo.ConsumeUserToken(rctx, auth.HashToken(req.Token), entity.TokenVerifyEmail)
+	if err != nil {
+		return as.tokenError(ctx, "verify email of", err)
+	}
+	rctx = custom_slog.WithUserID(rctx, t.UserID)
+	if err := as.userRepo.MarkEmailVerified(rctx, t.UserID, t.Email); err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			// the address changed since the token was sent
+			return utils.JSON(ctx, "verify email of", http.StatusBadRequest, datastore.ErrInvalidToken)
+		}
+		as.logger.ErrorCtx(rctx, "failed to verify email", slog.Any("error", err))
+		return utils.JSON(ctx, "verify email of", http.StatusInternalServerError, err)
+	}
+	as.logger.InfoCtx(rctx, "email verified")
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// ResendVerification mails a new verification link to a user
+func (as *AuthService) ResendVerification(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if !canManage(ctx, id) && !auth.Can(ctx, entity.PermUsersWrite) {
+		return utils.JSON(ctx, "send verification to", http.StatusForbidden, auth.ErrForbidden)
+	}
+	rctx := ctx.Request().Context()
+	cus, err := as.userRepo.GetByID(rctx, id)
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "send verification to", http.StatusNotFound, err)
+		}
+		return utils.JSON(ctx, "send verification to", http.StatusInternalServerError, err)
+	}
+	if cus.GetEmailVerified() {
+		return utils.JSON(ctx, "send verification to", http.StatusConflict, ErrEmailAlreadyVerified)
+	}
+	if err := as.mail.SendVerification(rctx, cus.GetExportedCustomer().User); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to send verification email", slog.Any("error", err))
+		return utils.JSON(ctx, "send verification to", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusAccepted, nil)
+}
+
+func (as *AuthService) tokenError(ctx echo.Context, msg string, err error) error {
+	if errors.Is(err, datastore.ErrInvalidToken) {
+		return utils.JSON(ctx, msg, http.StatusBadRequest, datastore.ErrInvalidToken)
+	}
+	as.logger.ErrorCtx(ctx.Request().Context(), "failed to redeem token", slog.Any("error", err))
+	return utils.JSON(ctx, msg, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// fakeCredentials keeps the password hashes of the users by id
+type fakeCredentials struct {
+	hashes  map[string]string
+	revoked []string
+}
+
+func (f *fakeCredentials) SetPassword(_ context.Context, userID, hash string) error {
+	f.hashes[userID] = hash
+	return nil
+}
+
+func (f *fakeCredentials) PasswordHash(_ context.Context, userID string) (string, error) {
+	hash, ok := f.hashes[userID]
+	if !ok {
+		return "", datastore.ErrCredentialsNotFound
+	}
+	return hash, nil
+}
+
+func (f *fakeCredentials) CredentialsByUserName(context.Context, string) (entity.User, string, error) {
+	return entity.User{}, "", datastore.ErrCredentialsNotFound
+}
+
+func (f *fakeCredentials) CreateSession(_ context.Context, sess entity.Session) (entity.Session, error) {
+	return sess, nil
+}
+
+func (f *fakeCredentials) SessionByID(context.Context, string) (entity.Session, entity.Status, error) {
+	return entity.Session{}, entity.Inactive, datastore.ErrSessionNotFound
+}
+
+func (f *fakeCredentials) UserSessions(context.Context, string) ([]entity.Session, error) {
+	return []entity.Session{}, nil
+}
+
+func (f *fakeCredentials) RevokeSession(_ context.Context, userID, _ string) error {
+	f.revoked = append(f.revoked, userID)
+	return nil
+}
+
+func TestSetPassword(t *testing.T) {
+	current, err := auth.HashPassword("current password")
+	require.NoError(t, err)
+	creds := &fakeCredentials{hashes: map[string]string{"1": current}}
+	as, err := NewAuthServices(WithAuthRepository(creds, creds, nil))
+	require.NoError(t, err)
+	// users own their password and the credential managers reset any
+	owner := auth.Principal{Subject: "jdoe", UserID: "1"}
+	manager := auth.Principal{Subject: "admin", Permissions: []entity.Permission{entity.PermCredentialsManage}}
+
+	testCase := []struct {
+		name   string
+		as     auth.Principal
+		id     string
+		body   string
+		status int
+	}{
+		{name: "users confirm their current password", as: owner, id: "1", body: `{"password":"a new password"}`, status: http.StatusForbidden},
+		{name: "users give their current password", as: owner, id: "1", body: `{"password":"a new password","currentPassword":"wrong password"}`, status: http.StatusForbidden},
+		{name: "users don't set the password of others", as: owner, id: "2", body: `{"password":"a new password","currentPassword":"current password"}`, status: http.StatusForbidden},
+		{name: "users change their password", as: owner, id: "1", body: `{"password":"a new password","currentPassword":"current password"}`, status: http.StatusOK},
+		{name: "managers reset passwords", as: manager, id: "1", body: `{"password":"a reset password"}`, status: http.StatusOK},
+	}
+	for _, tc := range testCase {
+		before := creds.hashes[tc.id]
+		rec := serve(as.SetPassword, tc.as, http.MethodPut, "/v2/user/"+tc.id+"/password", tc.body, "id", tc.id)
+		require.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+		if tc.status != http.StatusOK {
+			assert.Equal(t, before, creds.hashes[tc.id], tc.name)
+		} else {
+			assert.NotEqual(t, before, creds.hashes[tc.id], tc.name)
+		}
+	}
+	ok, err := auth.VerifyPassword("a reset password", creds.hashes["1"])
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"1", "1"}, creds.revoked)
+}
@@ -1,13 +1,17 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/ellis90/assessment-bg/auth"
 	"github.com/ellis90/assessment-bg/datastore"
 	"github.com/ellis90/assessment-bg/datastore/model"
 	"github.com/ellis90/assessment-bg/entity"
 	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slog"
 	"net/http"
 )
 
@@ -16,11 +20,15 @@ const Successful = "successful"
 type CustomerConfiguration func(us *CustomerService) error
 
 type CustomerService struct {
-	userRepo datastore.UserRepository
+	userRepo    datastore.UserRepository
+	changesRepo datastore.ScheduledChangeRepository
+	managerRepo datastore.ManagerRepository
+	logger      *slog.Logger
+	mail        *AccountMailer
 }
 
 func NewCustomerServices(cfgs ...CustomerConfiguration) (*CustomerService, error) {
-	cs := &CustomerService{}
+	cs := &CustomerService{logger: slog.Default()}
 	for _, cfg := range cfgs {
 		if err := cfg(cs); err != nil {
 			return nil, err
@@ -39,7 +47,45 @@ func WithCustomerRepository(ur datastore.UserRepository, err error) CustomerConf
 	}
 }
 
-func WithPGXConfiguration(logger *logrus.Logger, src string) CustomerConfiguration {
+// WithScheduledChangeRepository stores the changes scheduled for a later time
+func WithScheduledChangeRepository(cr datastore.ScheduledChangeRepository, err error) CustomerConfiguration {
+	return func(us *CustomerService) error {
+		if err != nil {
+			return err
+		}
+		us.changesRepo = cr
+		return nil
+	}
+}
+
+// WithManagerRepository stores who reports to whom
+func WithManagerRepository(mr datastore.ManagerRepository, err error) CustomerConfiguration {
+	return func(us *CustomerService) error {
+		if err != nil {
+			return err
+		}
+		us.managerRepo = mr
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the service handlers
+func WithLogger(logger *slog.Logger) CustomerConfiguration {
+	return func(us *CustomerService) error {
+		us.logger = logger
+		return nil
+	}
+}
+
+// WithAccountMailer sends a verification email for new addresses
+func WithAccountMailer(am *AccountMailer) CustomerConfiguration {
+	return func(us *CustomerService) error {
+		us.mail = am
+		return nil
+	}
+}
+
+func WithPGXConfiguration(logger *slog.Logger, src string) CustomerConfiguration {
 	db, err := datastore.NewStore(logger, src)
 	return WithCustomerRepository(db, err)
 }
@@ -48,52 +94,100 @@ func WithPGXConfiguration(logger *logrus.Logger, src string) CustomerConfigurati
 
 func (cs *CustomerService) Create(ctx echo.Context) error {
 	user := new(entity.User)
-	logrus.Info("entry Binding")
+	rctx := ctx.Request().Context()
 	if err := ctx.Bind(user); err != nil {
+		cs.logger.WarnCtx(rctx, "failed to bind user", slog.Any("error", err))
 		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
 	}
-	logrus.Info(user, "user gotten")
+	user.EmailVerified = false
 	cus, err := model.NewCustomer(user)
 	if err != nil {
+		cs.logger.WarnCtx(rctx, "invalid user", slog.Any("error", err))
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	out, err := cs.userRepo.Create(rctx, cus)
+	if errors.Is(err, entity.ErrInitialStatus) {
 		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
 	}
-	logrus.Info(cus, "new customer gotten")
-	out, err := cs.userRepo.Create(cus)
 	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to create user", slog.Any("error", err))
 		return utils.JSON(ctx, "save", http.StatusBadRequest, err)
 	}
+	rctx = custom_slog.WithUserID(rctx, out.GetID())
+	cs.logger.InfoCtx(rctx, "user created")
+	cs.sendVerification(rctx, out)
 	return utils.JSON(ctx, Successful, http.StatusCreated, out.GetExportedCustomer())
 }
 
 func (cs *CustomerService) Update(ctx echo.Context) error {
 	user := new(entity.User)
+	rctx := ctx.Request().Context()
 	if err := ctx.Bind(user); err != nil {
+		cs.logger.WarnCtx(rctx, "failed to bind user", slog.Any("error", err))
 		return utils.JSON(ctx, "user", http.StatusBadRequest, err)
 	}
+	rctx = custom_slog.WithUserID(rctx, user.ID)
 	cus, err := model.NewCustomer(user)
 	if err != nil {
+		cs.logger.WarnCtx(rctx, "invalid user", slog.Any("error", err))
 		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
 	}
-	out, err := cs.userRepo.Update(cus)
+	current, err := cs.userRepo.GetByID(rctx, cus.GetID())
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "update", http.StatusNotFound, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to fetch user", slog.Any("error", err))
+		return utils.JSON(ctx, "update", http.StatusBadRequest, err)
+	}
+	user.EmailVerified = current.GetEmailVerified() && current.GetEmail() == user.Email
+	out, err := cs.userRepo.Update(rctx, cus)
+	if errors.Is(err, entity.ErrStatusNeedsReason) {
+		return utils.JSON(ctx, "update", http.StatusConflict, err)
+	}
 	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to update user", slog.Any("error", err))
 		return utils.JSON(ctx, "update", http.StatusBadRequest, err)
 	}
+	if current.GetEmail() != out.GetEmail() {
+		cs.sendVerification(rctx, out)
+	}
 	return utils.JSON(ctx, Successful, http.StatusOK, out.GetExportedCustomer())
 }
 
+// sendVerification mails a verification link to the address of cus, a
+// failure is logged as the user can request another link
+func (cs *CustomerService) sendVerification(ctx context.Context, cus model.Customer) {
+	if cs.mail == nil {
+		return
+	}
+	if err := cs.mail.SendVerification(ctx, cus.GetExportedCustomer().User); err != nil {
+		cs.logger.ErrorCtx(ctx, "failed to send verification email", slog.Any("error", err))
+	}
+}
+
 func (cs *CustomerService) FetchAll(ctx echo.Context) error {
-	allCus, err := cs.userRepo.Get()
-	logrus.Info(allCus, "get all customer gotten")
+	rctx := ctx.Request().Context()
+	allCus, err := cs.userRepo.Get(rctx)
 	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to fetch users", slog.Any("error", err))
 		return utils.JSON(ctx, "fetch all", http.StatusBadRequest, err)
 	}
-	return utils.JSON(ctx, Successful, http.StatusOK, allCus.GetExportedCustomers())
+	exported := allCus.GetExportedCustomers()
+	if !auth.Can(ctx, entity.PermUsersReadPII) {
+		for i := range exported {
+			exported[i].User.Email = ""
+		}
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, exported)
 }
 
 func (cs *CustomerService) DeleteById(ctx echo.Context) error {
 	id := ctx.Param("id")
-	err := cs.userRepo.Delete(id)
+	rctx := ctx.Request().Context()
+	err := cs.userRepo.Delete(rctx, id)
 	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to delete user", slog.Any("error", err))
 		return utils.JSON(ctx, fmt.Sprintf("delete %s", id), http.StatusBadRequest, err)
 	}
 	return utils.JSON(ctx, Successful, http.StatusOK, nil)
@@ -1,15 +1,18 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
 	"github.com/labstack/echo/v4"
 	_ "github.com/lib/pq"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
-	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -19,31 +22,32 @@ import (
 )
 
 var (
-	log *logrus.Logger
+	log *slog.Logger
 	cs  *CustomerService
 )
 
+// fatal logs err and exits, the container is left to expire
+func fatal(msg string, err error) {
+	log.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
 func TestMain(m *testing.M) {
 	code := 0
 	defer func() {
 		os.Exit(code)
 	}()
 
-	log = logrus.New()
-	log.Formatter = &logrus.TextFormatter{
-		TimestampFormat: time.RFC3339,
-		FullTimestamp:   true,
-		ForceColors:     true,
-	}
+	log = slog.New(slog.NewTextHandler(os.Stderr))
 
 	pool, err := dockertest.NewPool("")
 	if err != nil {
-		log.WithError(err).Fatal("Could not connect to docker")
+		fatal("Could not connect to docker", err)
 	}
 
 	err = pool.Client.Ping()
 	if err != nil {
-		log.Fatalf("Could not connect to Docker: %s", err)
+		fatal("Could not connect to Docker", err)
 	}
 
 	src := map[string]string{
@@ -69,36 +73,36 @@ func TestMain(m *testing.M) {
 		})
 
 	if err != nil {
-		log.WithError(err).Fatal("could not start postgres container")
+		fatal("could not start postgres container", err)
 	}
 
 	defer func() {
 		err = pool.Purge(resource)
 		if err != nil {
-			log.WithError(err).Error("Could not purge resource")
+			log.Error("Could not purge resource", slog.Any("error", err))
 		}
 	}()
 
 	// Tell docker to hard kill the container in 120 seconds
 	if err := resource.Expire(120); err != nil {
-		log.WithError(err)
+		log.Error("Could not expire resource", slog.Any("error", err))
 	}
 
 	logWaiter, err := pool.Client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
 		Container:    resource.Container.ID,
-		OutputStream: log.Writer(),
-		ErrorStream:  log.Writer(),
+		OutputStream: os.Stderr,
+		ErrorStream:  os.Stderr,
 		Stderr:       true,
 		Stdout:       true,
 		Stream:       true,
 	})
 	if err != nil {
-		log.WithError(err).Fatal("could not connect to postgres container log output")
+		fatal("could not connect to postgres container log output", err)
 	}
 	defer func() {
 		err = logWaiter.Close()
 		if err != nil {
-			log.WithError(err).Error("Could not wait for container log to close")
+			log.Error("Could not wait for container log to close", slog.Any("error", err))
 		}
 	}()
 
@@ -111,15 +115,19 @@ func TestMain(m *testing.M) {
 		}
 		return db.Ping()
 	}); err != nil {
-		log.WithError(err).Fatal("Could not connect to postgres server")
+		fatal("Could not connect to postgres server", err)
 	}
 
-	var dbErr error
+	store, dbErr := datastore.NewStore(slog.Default(), link)
 	cs, dbErr = NewCustomerServices(
-		WithPGXConfiguration(logrus.New(), link),
+		WithCustomerRepository(store, dbErr),
 	)
 	if dbErr != nil {
-		log.WithError(dbErr).Fatal("could not connect postgres container")
+		fatal("could not connect postgres container", dbErr)
+	}
+	// users reference an existing department
+	if _, err := store.CreateDepartment(context.Background(), entity.Department{Name: "computer"}); err != nil {
+		fatal("could not create department", err)
 	}
 
 	code = m.Run()
@@ -142,7 +150,7 @@ func TestCreateUser(t *testing.T) {
 					"lastName": "peter",
 					"email": "john@gmaily.com",
 					"department": "computer",
-					"userStatus": 2
+					"userStatus": 1
 				}`,
 			message:  "successful",
 			response: make(map[string]any),
@@ -204,9 +212,9 @@ func TestCreateUser(t *testing.T) {
 			// Assertions
 			if assert.NoError(t, cs.Create(ctx)) {
 				assert.Equal(t, tc.code, rec.Code)
-				log.Println(rec.Body.String())
+				t.Log(rec.Body.String())
 				if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&tc.response)) {
-					log.Println(tc.response)
+					t.Log(tc.response)
 					assert.Equal(t, tc.response["message"], tc.message)
 				}
 			}
@@ -238,7 +246,7 @@ func TestGetUser(t *testing.T) {
 			// Assertions
 			if assert.NoError(t, cs.FetchAll(ctx)) {
 				assert.Equal(t, tc.code, rec.Code)
-				log.Println(rec.Body.String())
+				t.Log(rec.Body.String())
 			}
 		})
 	}
@@ -326,9 +334,9 @@ func TestUpdateUser(t *testing.T) {
 			// Assertions
 			if assert.NoError(t, cs.Update(ctx)) {
 				assert.Equal(t, tc.code, rec.Code)
-				log.Println(rec.Body.String())
+				t.Log(rec.Body.String())
 				if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&tc.response)) {
-					log.Println(tc.response)
+					t.Log(tc.response)
 					assert.Equal(t, tc.response["message"], tc.message)
 				}
 			}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+// ErrIDMismatch is returned when the id of the body differs from the path
+var ErrIDMismatch = errors.New("the id of the body does not match the path")
+
+// CreateV2 creates the user of the body
+func (cs *CustomerService) CreateV2(ctx echo.Context) error {
+	user := new(entity.User)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(user); err != nil {
+		cs.logger.WarnCtx(rctx, "failed to bind user", slog.Any("error", err))
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	user.EmailVerified = false
+	cus, err := model.NewCustomer(user)
+	if err != nil {
+		cs.logger.WarnCtx(rctx, "invalid user", slog.Any("error", err))
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	out, err := cs.userRepo.Create(rctx, cus)
+	if errors.Is(err, entity.ErrInitialStatus) {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to create user", slog.Any("error", err))
+		return utils.JSON(ctx, "save", http.StatusBadRequest, err)
+	}
+	rctx = custom_slog.WithUserID(rctx, out.GetID())
+	cs.logger.InfoCtx(rctx, "user created")
+	cs.sendVerification(rctx, out)
+	return utils.JSON(ctx, Successful, http.StatusCreated, out.GetExportedCustomer().User)
+}
+
+// FetchAllV2 lists the users, the emails are hidden without users:read_pii
+func (cs *CustomerService) FetchAllV2(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	allCus, err := cs.userRepo.Get(rctx)
+	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to fetch users", slog.Any("error", err))
+		return utils.JSON(ctx, "fetch all", http.StatusBadRequest, err)
+	}
+	pii := auth.Can(ctx, entity.PermUsersReadPII)
+	users := make([]entity.User, 0, len(allCus))
+	for _, cus := range allCus {
+		user := cus.GetExportedCustomer().User
+		if !pii {
+			user.Email = ""
+		}
+		users = append(users, user)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, users)
+}
+
+// GetV2 answers the user of the path
+func (cs *CustomerService) GetV2(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	cus, err := cs.userRepo.GetByID(rctx, ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "fetch", http.StatusNotFound, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to fetch user", slog.Any("error", err))
+		return utils.JSON(ctx, "fetch", http.StatusBadRequest, err)
+	}
+	user := cus.GetExportedCustomer().User
+	if !auth.Can(ctx, entity.PermUsersReadPII) {
+		user.Email = ""
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, user)
+}
+
+// UpdateV2 replaces the user of the path, the id of the body is optional
+func (cs *CustomerService) UpdateV2(ctx echo.Context) error {
+	id := ctx.Param("id")
+	user := new(entity.User)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(user); err != nil {
+		cs.logger.WarnCtx(rctx, "failed to bind user", slog.Any("error", err))
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if user.ID != "" && user.ID != id {
+		return utils.JSON(ctx, "update", http.StatusBadRequest, ErrIDMismatch)
+	}
+	user.ID = id
+	cus, err := model.NewCustomer(user)
+	if err != nil {
+		cs.logger.WarnCtx(rctx, "invalid user", slog.Any("error", err))
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	current, err := cs.userRepo.GetByID(rctx, id)
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "update", http.StatusNotFound, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to fetch user", slog.Any("error", err))
+		return utils.JSON(ctx, "update", http.StatusBadRequest, err)
+	}
+	user.EmailVerified = current.GetEmailVerified() && current.GetEmail() == user.Email
+	out, err := cs.userRepo.Update(rctx, cus)
+	if errors.Is(err, entity.ErrStatusNeedsReason) {
+		return utils.JSON(ctx, "update", http.StatusConflict, err)
+	}
+	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to update user", slog.Any("error", err))
+		return utils.JSON(ctx, "update", http.StatusBadRequest, err)
+	}
+	if current.GetEmail() != out.GetEmail() {
+		cs.sendVerification(rctx, out)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, out.GetExportedCustomer().User)
+}
+
+// DeleteV2 deletes the user of the path and answers 204
+func (cs *CustomerService) DeleteV2(ctx echo.Context) error {
+	id := ctx.Param("id")
+	rctx := ctx.Request().Context()
+	if _, err := cs.userRepo.GetByID(rctx, id); err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "delete", http.StatusNotFound, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to fetch user", slog.Any("error", err))
+		return utils.JSON(ctx, "delete", http.StatusBadRequest, err)
+	}
+	if err := cs.userRepo.Delete(rctx, id); err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to delete user", slog.Any("error", err))
+		return utils.JSON(ctx, "delete", http.StatusBadRequest, err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+type DepartmentConfiguration func(ds *DepartmentService) error
+
+// DepartmentService manages the departments users belong to
+type DepartmentService struct {
+	departmentRepo datastore.DepartmentRepository
+	changed        func(context.Context)
+	logger         *slog.Logger
+}
+
+func NewDepartmentServices(cfgs ...DepartmentConfiguration) (*DepartmentService, error) {
+	ds := &DepartmentService{changed: func(context.Context) {}, logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(ds); err != nil {
+			return nil, err
+		}
+	}
+	return ds, nil
+}
+
+func WithDepartmentRepository(dr datastore.DepartmentRepository, err error) DepartmentConfiguration {
+	return func(ds *DepartmentService) error {
+		if err != nil {
+			return err
+		}
+		ds.departmentRepo = dr
+		return nil
+	}
+}
+
+// WithDepartmentLogger sets the logger used by the department handlers
+func WithDepartmentLogger(logger *slog.Logger) DepartmentConfiguration {
+	return func(ds *DepartmentService) error {
+		ds.logger = logger
+		return nil
+	}
+}
+
+// WithDepartmentsChangedListener calls changed after a department is renamed
+// or merged, changing the departments of its members at once
+func WithDepartmentsChangedListener(changed func(context.Context)) DepartmentConfiguration {
+	return func(ds *DepartmentService) error {
+		ds.changed = changed
+		return nil
+	}
+}
+
+// handlers
+
+func (ds *DepartmentService) List(ctx echo.Context) error {
+	departments, err := ds.departmentRepo.Departments(ctx.Request().Context())
+	if err != nil {
+		return ds.fail(ctx, "fetch departments of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, departments)
+}
+
+func (ds *DepartmentService) Get(ctx echo.Context) error {
+	department, err := ds.departmentRepo.DepartmentByID(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ds.fail(ctx, "fetch department of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, department)
+}
+
+func (ds *DepartmentService) Create(ctx echo.Context) error {
+	req := new(entity.Department)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	department, err := ds.departmentRepo.CreateDepartment(ctx.Request().Context(), *req)
+	if err != nil {
+		return ds.fail(ctx, "create department of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusCreated, department)
+}
+
+// Rename renames the department of the path, its members keep belonging to it
+func (ds *DepartmentService) Rename(ctx echo.Context) error {
+	req := new(entity.Department)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	department, err := ds.departmentRepo.RenameDepartment(ctx.Request().Context(), ctx.Param("id"), req.Name)
+	if err != nil {
+		return ds.fail(ctx, "rename department of", err)
+	}
+	ds.changed(ctx.Request().Context())
+	return utils.JSON(ctx, Successful, http.StatusOK, department)
+}
+
+// Move moves the department of the path and everything below it under the
+// parent of the body, a null parent makes it a root
+func (ds *DepartmentService) Move(ctx echo.Context) error {
+	req := new(entity.DepartmentParent)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	department, err := ds.departmentRepo.MoveDepartment(ctx.Request().Context(), ctx.Param("id"), req.ParentID)
+	if err != nil {
+		return ds.fail(ctx, "move department of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, department)
+}
+
+// Subtree answers the department of the path and every department below it
+// with their headcounts
+func (ds *DepartmentService) Subtree(ctx echo.Context) error {
+	nodes, err := ds.departmentRepo.DepartmentSubtree(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ds.fail(ctx, "fetch subtree of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nodes)
+}
+
+// Ancestors answers the departments above the department of the path, from
+// its parent up to its root
+func (ds *DepartmentService) Ancestors(ctx echo.Context) error {
+	nodes, err := ds.departmentRepo.DepartmentAncestors(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ds.fail(ctx, "fetch ancestors of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nodes)
+}
+
+// Users answers the users of the department of the path and of every
+// department below it, the emails are hidden without users:read_pii
+func (ds *DepartmentService) Users(ctx echo.Context) error {
+	users, err := ds.departmentRepo.DepartmentUsers(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ds.fail(ctx, "fetch users of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, hidePII(ctx, users))
+}
+
+// Merge moves every member and every department below the department of the
+// path to the department of the body in one transaction and deletes it
+func (ds *DepartmentService) Merge(ctx echo.Context) error {
+	req := new(entity.DepartmentMerge)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	department, err := ds.departmentRepo.MergeDepartment(ctx.Request().Context(), ctx.Param("id"), req.Into)
+	if err != nil {
+		return ds.fail(ctx, "merge department of", err)
+	}
+	ds.changed(ctx.Request().Context())
+	return utils.JSON(ctx, Successful, http.StatusOK, department)
+}
+
+// Delete deletes the department of the path, departments with members are
+// merged instead
+func (ds *DepartmentService) Delete(ctx echo.Context) error {
+	if err := ds.departmentRepo.DeleteDepartment(ctx.Request().Context(), ctx.Param("id")); err != nil {
+		return ds.fail(ctx, "delete department of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// fail answers the errors of the department repository
+func (ds *DepartmentService) fail(ctx echo.Context, msg string, err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrDepartmentNotFound):
+		return utils.JSON(ctx, msg, http.StatusNotFound, err)
+	case errors.Is(err, datastore.ErrDepartmentExists), errors.Is(err, datastore.ErrDepartmentInUse),
+		errors.Is(err, datastore.ErrDepartmentCycle):
+		return utils.JSON(ctx, msg, http.StatusConflict, err)
+	case errors.Is(err, datastore.ErrMergeIntoItself), errors.Is(err, datastore.ErrParentNotFound):
+		return utils.JSON(ctx, msg, http.StatusBadRequest, err)
+	}
+	ds.logger.ErrorCtx(ctx.Request().Context(), "department request failed", slog.Any("error", err))
+	return utils.JSON(ctx, msg, http.StatusInternalServerError, err)
+}
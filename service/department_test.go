@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeDepartments keeps departments by id, members are counted from fakeUsers
+type fakeDepartments struct {
+	users       *fakeUsers
+	departments map[string]entity.Department
+	nextID      int
+}
+
+// department counts the members of id and the users of its subtree
+func (f *fakeDepartments) department(id string) entity.Department {
+	department := f.departments[id]
+	for _, below := range f.subtree(id) {
+		for _, user := range f.users.users {
+			if strings.EqualFold(user.Department, f.departments[below].Name) {
+				department.Headcount++
+				if below == id {
+					department.Members++
+				}
+			}
+		}
+	}
+	return department
+}
+
+// subtree lists id and the ids below it
+func (f *fakeDepartments) subtree(id string) []string {
+	ids := []string{id}
+	for other, department := range f.departments {
+		if department.ParentID != nil && *department.ParentID == id {
+			ids = append(ids, f.subtree(other)...)
+		}
+	}
+	return ids
+}
+
+func (f *fakeDepartments) depth(id string) int {
+	depth := 0
+	for parent := f.departments[id].ParentID; parent != nil; parent = f.departments[*parent].ParentID {
+		depth++
+	}
+	return depth
+}
+
+func (f *fakeDepartments) named(name string) (string, bool) {
+	for id, existing := range f.departments {
+		if strings.EqualFold(existing.Name, name) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (f *fakeDepartments) move(from, to string) {
+	for userID, user := range f.users.users {
+		if strings.EqualFold(user.Department, from) {
+			user.Department = to
+			f.users.users[userID] = user
+		}
+	}
+}
+
+func (f *fakeDepartments) CreateDepartment(_ context.Context, department entity.Department) (entity.Department, error) {
+	if _, ok := f.named(department.Name); ok {
+		return entity.Department{}, datastore.ErrDepartmentExists
+	}
+	if department.ParentID != nil {
+		if _, ok := f.departments[*department.ParentID]; !ok {
+			return entity.Department{}, datastore.ErrParentNotFound
+		}
+	}
+	f.nextID++
+	department.ID = strconv.Itoa(f.nextID)
+	f.departments[department.ID] = department
+	return f.department(department.ID), nil
+}
+
+func (f *fakeDepartments) Departments(context.Context) ([]entity.Department, error) {
+	out := make([]entity.Department, 0, len(f.departments))
+	for id := 1; id <= f.nextID; id++ {
+		if _, ok := f.departments[strconv.Itoa(id)]; ok {
+			out = append(out, f.department(strconv.Itoa(id)))
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDepartments) DepartmentByID(_ context.Context, id string) (entity.Department, error) {
+	if _, ok := f.departments[id]; !ok {
+		return entity.Department{}, datastore.ErrDepartmentNotFound
+	}
+	return f.department(id), nil
+}
+
+func (f *fakeDepartments) RenameDepartment(_ context.Context, id, name string) (entity.Department, error) {
+	department, ok := f.departments[id]
+	if !ok {
+		return entity.Department{}, datastore.ErrDepartmentNotFound
+	}
+	if other, ok := f.named(name); ok && other != id {
+		return entity.Department{}, datastore.ErrDepartmentExists
+	}
+	f.move(department.Name, name)
+	department.Name = name
+	f.departments[id] = department
+	return f.department(id), nil
+}
+
+func (f *fakeDepartments) MergeDepartment(_ context.Context, id, into string) (entity.Department, error) {
+	if id == into {
+		return entity.Department{}, datastore.ErrMergeIntoItself
+	}
+	from, ok := f.departments[id]
+	if !ok {
+		return entity.Department{}, datastore.ErrDepartmentNotFound
+	}
+	if _, ok := f.departments[into]; !ok {
+		return entity.Department{}, datastore.ErrDepartmentNotFound
+	}
+	for _, below := range f.subtree(id) {
+		if below == into {
+			return entity.Department{}, datastore.ErrDepartmentCycle
+		}
+	}
+	f.move(from.Name, f.departments[into].Name)
+	for other, department := range f.departments {
+		if department.ParentID != nil && *department.ParentID == id {
+			department.ParentID = &into
+			f.departments[other] = department
+		}
+	}
+	delete(f.departments, id)
+	return f.department(into), nil
+}
+
+func (f *fakeDepartments) DeleteDepartment(_ context.Context, id string) error {
+	if _, ok := f.departments[id]; !ok {
+		return datastore.ErrDepartmentNotFound
+	}
+	if f.department(id).Headcount > 0 || len(f.subtree(id)) > 1 {
+		return datastore.ErrDepartmentInUse
+	}
+	delete(f.departments, id)
+	return nil
+}
+
+func (f *fakeDepartments) MoveDepartment(_ context.Context, id string, parentID *string) (entity.Department, error) {
+	department, ok := f.departments[id]
+	if !ok {
+		return entity.Department{}, datastore.ErrDepartmentNotFound
+	}
+	if parentID != nil {
+		if _, ok := f.departments[*parentID]; !ok {
+			return entity.Department{}, datastore.ErrParentNotFound
+		}
+		for _, below := range f.subtree(id) {
+			if below == *parentID {
+				return entity.Department{}, datastore.ErrDepartmentCycle
+			}
+		}
+	}
+	department.ParentID = parentID
+	f.departments[id] = department
+	return f.department(id), nil
+}
+
+func (f *fakeDepartments) DepartmentSubtree(_ context.Context, id string) ([]entity.DepartmentNode, error) {
+	if _, ok := f.departments[id]; !ok {
+		return nil, datastore.ErrDepartmentNotFound
+	}
+	var nodes []entity.DepartmentNode
+	for _, below := range f.subtree(id) {
+		nodes = append(nodes, entity.DepartmentNode{Department: f.department(below), Depth: f.depth(below) - f.depth(id)})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Depth != nodes[j].Depth {
+			return nodes[i].Depth < nodes[j].Depth
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes, nil
+}
+
+func (f *fakeDepartments) DepartmentAncestors(_ context.Context, id string) ([]entity.DepartmentNode, error) {
+	if _, ok := f.departments[id]; !ok {
+		return nil, datastore.ErrDepartmentNotFound
+	}
+	nodes := make([]entity.DepartmentNode, 0)
+	for parent := f.departments[id].ParentID; parent != nil; parent = f.departments[*parent].ParentID {
+		nodes = append(nodes, entity.DepartmentNode{Department: f.department(*parent), Depth: len(nodes) + 1})
+	}
+	return nodes, nil
+}
+
+func (f *fakeDepartments) DepartmentUsers(_ context.Context, id string) ([]entity.User, error) {
+	if _, ok := f.departments[id]; !ok {
+		return nil, datastore.ErrDepartmentNotFound
+	}
+	users := make([]entity.User, 0)
+	for _, below := range f.subtree(id) {
+		for _, user := range f.users.users {
+			if strings.EqualFold(user.Department, f.departments[below].Name) {
+				users = append(users, user)
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// newDepartments serves the department eng holding the user 1
+func newDepartments(t *testing.T) (*DepartmentService, *fakeUsers) {
+	t.Helper()
+	users := newFakeUsers(jdoe)
+	departments := &fakeDepartments{users: users, departments: map[string]entity.Department{
+		"1": {ID: "1", Name: "eng"},
+	}, nextID: 1}
+	ds, err := NewDepartmentServices(WithDepartmentRepository(departments, nil))
+	require.NoError(t, err)
+	return ds, users
+}
+
+func TestDepartments(t *testing.T) {
+	ds, users := newDepartments(t)
+	testCase := []struct {
+		name    string
+		handler func(*DepartmentService, echo.Context) error
+		method  string
+		id      string
+		body    string
+		status  int
+	}{
+		{name: "needs a name", handler: (*DepartmentService).Create, method: http.MethodPost, body: `{}`, status: http.StatusBadRequest},
+		{name: "creates", handler: (*DepartmentService).Create, method: http.MethodPost, body: `{"name":"ops"}`, status: http.StatusCreated},
+		{name: "names are unique ignoring case", handler: (*DepartmentService).Create, method: http.MethodPost, body: `{"name":"OPS"}`, status: http.StatusConflict},
+		{name: "unknown department", handler: (*DepartmentService).Get, method: http.MethodGet, id: "9", status: http.StatusNotFound},
+		{name: "cannot delete a department with members", handler: (*DepartmentService).Delete, method: http.MethodDelete, id: "1", status: http.StatusConflict},
+		{name: "cannot merge into itself", handler: (*DepartmentService).Merge, method: http.MethodPost, id: "1", body: `{"into":"1"}`, status: http.StatusBadRequest},
+		{name: "cannot rename to an existing name", handler: (*DepartmentService).Rename, method: http.MethodPut, id: "1", body: `{"name":"ops"}`, status: http.StatusConflict},
+		{name: "renames", handler: (*DepartmentService).Rename, method: http.MethodPut, id: "1", body: `{"name":"engineering"}`, status: http.StatusOK},
+		{name: "merges", handler: (*DepartmentService).Merge, method: http.MethodPost, id: "1", body: `{"into":"2"}`, status: http.StatusOK},
+		{name: "merged departments are gone", handler: (*DepartmentService).Get, method: http.MethodGet, id: "1", status: http.StatusNotFound},
+	}
+	for _, tc := range testCase {
+		h := func(c echo.Context) error { return tc.handler(ds, c) }
+		rec := serve(h, admin, tc.method, "/v2/departments/"+tc.id, tc.body, "id", tc.id)
+		assert.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+	}
+
+	rec := serve(ds.List, admin, http.MethodGet, "/v2/departments", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res struct {
+		Data []entity.Department `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Data, 1)
+	assert.Equal(t, "ops", res.Data[0].Name)
+	assert.Equal(t, 1, res.Data[0].Members)
+	assert.Equal(t, "ops", users.users["1"].Department, "members move with the merge")
+}
+
+func TestDepartmentHierarchy(t *testing.T) {
+	ds, _ := newDepartments(t)
+	// r&d (2) contains eng (1) which contains platform (3)
+	require.Equal(t, http.StatusCreated, serve(ds.Create, admin, http.MethodPost, "/v2/departments", `{"name":"r&d"}`).Code)
+	require.Equal(t, http.StatusCreated, serve(ds.Create, admin, http.MethodPost, "/v2/departments", `{"name":"platform","parentId":"1"}`).Code)
+
+	testCase := []struct {
+		name    string
+		handler func(*DepartmentService, echo.Context) error
+		method  string
+		id      string
+		body    string
+		status  int
+	}{
+		{name: "unknown parent", handler: (*DepartmentService).Create, method: http.MethodPost, body: `{"name":"qa","parentId":"9"}`, status: http.StatusBadRequest},
+		{name: "moves under a parent", handler: (*DepartmentService).Move, method: http.MethodPut, id: "1", body: `{"parentId":"2"}`, status: http.StatusOK},
+		{name: "cannot parent itself", handler: (*DepartmentService).Move, method: http.MethodPut, id: "1", body: `{"parentId":"1"}`, status: http.StatusConflict},
+		{name: "cannot move below its subtree", handler: (*DepartmentService).Move, method: http.MethodPut, id: "2", body: `{"parentId":"3"}`, status: http.StatusConflict},
+		{name: "cannot merge into its subtree", handler: (*DepartmentService).Merge, method: http.MethodPost, id: "2", body: `{"into":"3"}`, status: http.StatusConflict},
+		{name: "cannot delete a department with departments below", handler: (*DepartmentService).Delete, method: http.MethodDelete, id: "1", status: http.StatusConflict},
+		{name: "unknown subtree", handler: (*DepartmentService).Subtree, method: http.MethodGet, id: "9", status: http.StatusNotFound},
+	}
+	for _, tc := range testCase {
+		h := func(c echo.Context) error { return tc.handler(ds, c) }
+		rec := serve(h, admin, tc.method, "/v2/departments/"+tc.id, tc.body, "id", tc.id)
+		assert.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+	}
+
+	rec := serve(ds.Subtree, admin, http.MethodGet, "/v2/departments/2/subtree", "", "id", "2")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var nodes struct {
+		Data []entity.DepartmentNode `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &nodes))
+	require.Len(t, nodes.Data, 3)
+	for i, want := range []struct {
+		name                      string
+		depth, members, headcount int
+	}{{"r&d", 0, 0, 1}, {"eng", 1, 1, 1}, {"platform", 2, 0, 0}} {
+		assert.Equal(t, want.name, nodes.Data[i].Name)
+		assert.Equal(t, want.depth, nodes.Data[i].Depth, want.name)
+		assert.Equal(t, want.members, nodes.Data[i].Members, want.name)
+		assert.Equal(t, want.headcount, nodes.Data[i].Headcount, want.name)
+	}
+
+	rec = serve(ds.Ancestors, admin, http.MethodGet, "/v2/departments/3/ancestors", "", "id", "3")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &nodes))
+	require.Len(t, nodes.Data, 2)
+	assert.Equal(t, "eng", nodes.Data[0].Name)
+	assert.Equal(t, "r&d", nodes.Data[1].Name)
+	assert.Equal(t, 2, nodes.Data[1].Depth)
+
+	rec = serve(ds.Users, admin, http.MethodGet, "/v2/departments/2/users", "", "id", "2")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var members struct {
+		Data []entity.User `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &members))
+	require.Len(t, members.Data, 1)
+	assert.Equal(t, "jdoe", members.Data[0].UserName)
+
+	require.Equal(t, http.StatusOK, serve(ds.Move, admin, http.MethodPut, "/v2/departments/1/parent", `{"parentId":null}`, "id", "1").Code)
+	rec = serve(ds.Ancestors, admin, http.MethodGet, "/v2/departments/1/ancestors", "", "id", "1")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &nodes))
+	assert.Empty(t, nodes.Data, "roots have no ancestors")
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// durationFromEnv parses the duration of the variable key, ok is false when
+// it is unset
+func durationFromEnv(key string) (d time.Duration, ok bool, err error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false, nil
+	}
+	if d, err = time.ParseDuration(v); err != nil {
+		return 0, false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, true, nil
+}
+
+// intFromEnv parses the integer of the variable key, ok is false when it is
+// unset
+func intFromEnv(key string) (n int, ok bool, err error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false, nil
+	}
+	if n, err = strconv.Atoi(v); err != nil {
+		return 0, false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, true, nil
+}
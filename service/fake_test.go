@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+)
+
+// admin holds every users permission
+var admin = auth.Principal{Subject: "admin", Permissions: []entity.Permission{
+	entity.PermUsersRead, entity.PermUsersReadPII, entity.PermUsersWrite, entity.PermUsersDelete,
+}}
+
+// serve calls h as p with a v2 request of body to target, params are the
+// names and values of the path parameters in turn
+func serve(h echo.HandlerFunc, p auth.Principal, method, target, body string, params ...string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.Binder = &utils.CustomBinder{}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names, values = append(names, params[i]), append(values, params[i+1])
+	}
+	ctx.SetParamNames(names...)
+	ctx.SetParamValues(values...)
+	ctx.Set(utils.APIVersionKey, 2)
+	auth.SetPrincipal(ctx, p)
+	if err := h(ctx); err != nil {
+		e.HTTPErrorHandler(err, ctx)
+	}
+	return rec
+}
+
+// the users the tests start from
+var (
+	jdoe = entity.User{ID: "1", UserName: "jdoe", FirstName: "John", LastName: "Doe", Email: "jdoe@example.com", Department: "eng", UserStatus: entity.Active}
+	xdoe = entity.User{ID: "3", UserName: "xdoe", FirstName: "Xavier", LastName: "Doe", Email: "xdoe@example.com", Department: "eng", UserStatus: entity.Terminated}
+)
+
+// fakeUsers keeps users by id and their status changes like the datastore
+// does, the other fakes of the package keep their data for these users
+type fakeUsers struct {
+	users   map[string]entity.User
+	nextID  int
+	history []entity.StatusChange
+}
+
+func newFakeUsers(users ...entity.User) *fakeUsers {
+	f := &fakeUsers{users: map[string]entity.User{}}
+	for _, u := range users {
+		f.users[u.ID] = u
+	}
+	return f
+}
+
+func (f *fakeUsers) Create(_ context.Context, cus model.Customer) (model.Customer, error) {
+	u := cus.GetExportedCustomer().User
+	if !u.UserStatus.Initial() {
+		return model.Customer{}, entity.ErrInitialStatus
+	}
+	for f.nextID++; f.users[strconv.Itoa(f.nextID)].ID != ""; f.nextID++ {
+	}
+	u.ID = strconv.Itoa(f.nextID)
+	u.ManagerID = nil
+	f.users[u.ID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Update(_ context.Context, cus model.Customer) (model.Customer, error) {
+	u := cus.GetExportedCustomer().User
+	current, ok := f.users[u.ID]
+	if !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	if current.UserStatus != u.UserStatus {
+		return model.Customer{}, entity.ErrStatusNeedsReason
+	}
+	u.ManagerID = current.ManagerID
+	f.users[u.ID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) Get(_ context.Context) (model.Customers, error) {
+	var out model.Customers
+	for _, u := range f.users {
+		u := u
+		out = append(out, model.AddCustomer(&u))
+	}
+	return out, nil
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id string) (model.Customer, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) GetByIDs(_ context.Context, ids []string) (model.Customers, error) {
+	var out model.Customers
+	for _, id := range ids {
+		if u, ok := f.users[id]; ok {
+			out = append(out, model.AddCustomer(&u))
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeUsers) GetByEmail(context.Context, string) (model.Customer, error) {
+	return model.Customer{}, datastore.ErrCustomerNotFound
+}
+
+func (f *fakeUsers) MarkEmailVerified(context.Context, string, string) error { return nil }
+
+func (f *fakeUsers) Delete(_ context.Context, id string) error {
+	delete(f.users, id)
+	return nil
+}
+
+func (f *fakeUsers) ChangeStatus(_ context.Context, change entity.StatusChange) (model.Customer, error) {
+	u, ok := f.users[change.UserID]
+	if !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	change.From = u.UserStatus
+	if err := change.From.Transition(change.To); err != nil {
+		return model.Customer{}, err
+	}
+	u.UserStatus = change.To
+	f.users[u.ID] = u
+	if change.To == entity.Terminated && change.From != entity.Terminated {
+		// terminated managers hand their reports to their own manager
+		for id, report := range f.users {
+			if report.ManagerID != nil && *report.ManagerID == u.ID {
+				report.ManagerID = u.ManagerID
+				f.users[id] = report
+			}
+		}
+	}
+	change.ID = strconv.Itoa(len(f.history) + 1)
+	f.history = append(f.history, change)
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeUsers) StatusHistory(_ context.Context, userID string) ([]entity.StatusChange, error) {
+	if _, ok := f.users[userID]; !ok {
+		return nil, datastore.ErrCustomerNotFound
+	}
+	out := make([]entity.StatusChange, 0)
+	for _, change := range f.history {
+		if change.UserID == userID {
+			out = append(out, change)
+		}
+	}
+	return out, nil
+}
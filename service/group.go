@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+type GroupConfiguration func(gs *GroupService) error
+
+// GroupService manages groups and keeps their dynamic members in line with
+// their rules. The rules are evaluated here rather than in the datastore so
+// they can match any field of entity.User.
+type GroupService struct {
+	groupRepo datastore.GroupRepository
+	// userRepo lists the users a changed group is evaluated against
+	userRepo datastore.UserRepository
+	logger   *slog.Logger
+}
+
+func NewGroupServices(cfgs ...GroupConfiguration) (*GroupService, error) {
+	gs := &GroupService{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(gs); err != nil {
+			return nil, err
+		}
+	}
+	return gs, nil
+}
+
+func WithGroupRepository(gr datastore.GroupRepository, err error) GroupConfiguration {
+	return func(gs *GroupService) error {
+		if err != nil {
+			return err
+		}
+		gs.groupRepo = gr
+		return nil
+	}
+}
+
+// WithGroupUserRepository sets the users the rules of groups are evaluated
+// against, it should not be the repository returned by Repository
+func WithGroupUserRepository(ur datastore.UserRepository, err error) GroupConfiguration {
+	return func(gs *GroupService) error {
+		if err != nil {
+			return err
+		}
+		gs.userRepo = ur
+		return nil
+	}
+}
+
+// WithGroupLogger sets the logger used by the group handlers
+func WithGroupLogger(logger *slog.Logger) GroupConfiguration {
+	return func(gs *GroupService) error {
+		gs.logger = logger
+		return nil
+	}
+}
+
+// handlers
+
+func (gs *GroupService) List(ctx echo.Context) error {
+	groups, err := gs.groupRepo.Groups(ctx.Request().Context())
+	if err != nil {
+		return gs.fail(ctx, "fetch groups of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, groups)
+}
+
+func (gs *GroupService) Get(ctx echo.Context) error {
+	group, err := gs.groupRepo.GroupByID(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return gs.fail(ctx, "fetch group of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, group)
+}
+
+// Create creates the group of the body and adds the users meeting its rules
+func (gs *GroupService) Create(ctx echo.Context) error {
+	req := new(entity.Group)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := validateGroup(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	group, err := gs.groupRepo.CreateGroup(ctx.Request().Context(), *req)
+	if err != nil {
+		return gs.fail(ctx, "create group of", err)
+	}
+	if err := gs.RefreshGroup(ctx.Request().Context(), group); err != nil {
+		return gs.fail(ctx, "create group of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusCreated, group)
+}
+
+// Update replaces the group of the path and recomputes its dynamic members
+func (gs *GroupService) Update(ctx echo.Context) error {
+	req := new(entity.Group)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := validateGroup(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	req.ID = ctx.Param("id")
+	group, err := gs.groupRepo.UpdateGroup(ctx.Request().Context(), *req)
+	if err != nil {
+		return gs.fail(ctx, "update group of", err)
+	}
+	if err := gs.RefreshGroup(ctx.Request().Context(), group); err != nil {
+		return gs.fail(ctx, "update group of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, group)
+}
+
+func (gs *GroupService) Delete(ctx echo.Context) error {
+	if err := gs.groupRepo.DeleteGroup(ctx.Request().Context(), ctx.Param("id")); err != nil {
+		return gs.fail(ctx, "delete group of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// Members lists the static and dynamic members of the group of the path,
+// the emails are hidden without users:read_pii
+func (gs *GroupService) Members(ctx echo.Context) error {
+	members, err := gs.groupRepo.GroupMembers(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return gs.fail(ctx, "fetch members of", err)
+	}
+	users := make([]entity.User, len(members))
+	for i, m := range members {
+		users[i] = m.User
+	}
+	for i, u := range hidePII(ctx, users) {
+		members[i].User = u
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, members)
+}
+
+// AddMember adds the user of the body to the group of the path by hand
+func (gs *GroupService) AddMember(ctx echo.Context) error {
+	req := new(entity.GroupMemberRequest)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	err := gs.groupRepo.AddStaticMember(ctx.Request().Context(), ctx.Param("id"), req.UserID)
+	if errors.Is(err, datastore.ErrCustomerNotFound) {
+		// the user is named by the body
+		return utils.JSON(ctx, "add member of", http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return gs.fail(ctx, "add member of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// RemoveMember removes a user added by hand, users meeting the rules of the
+// group stay members
+func (gs *GroupService) RemoveMember(ctx echo.Context) error {
+	err := gs.groupRepo.RemoveStaticMember(ctx.Request().Context(), ctx.Param("id"), ctx.Param("userId"))
+	if err != nil {
+		return gs.fail(ctx, "remove member of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// UserGroups lists the groups of the user of the path
+func (gs *GroupService) UserGroups(ctx echo.Context) error {
+	groups, err := gs.groupRepo.UserGroups(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return gs.fail(ctx, "fetch groups of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, groups)
+}
+
+// validateGroup checks the validate tags and the rules of a group
+func validateGroup(req *entity.Group) error {
+	if err := model.Validate(req); err != nil {
+		return err
+	}
+	return req.Check()
+}
+
+// fail answers the errors of the group repository
+func (gs *GroupService) fail(ctx echo.Context, msg string, err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrGroupNotFound), errors.Is(err, datastore.ErrGroupMemberNotFound),
+		errors.Is(err, datastore.ErrCustomerNotFound):
+		return utils.JSON(ctx, msg, http.StatusNotFound, err)
+	case errors.Is(err, datastore.ErrGroupExists):
+		return utils.JSON(ctx, msg, http.StatusConflict, err)
+	}
+	gs.logger.ErrorCtx(ctx.Request().Context(), "group request failed", slog.Any("error", err))
+	return utils.JSON(ctx, msg, http.StatusInternalServerError, err)
+}
+
+// membership
+
+// RefreshUser makes u a dynamic member of exactly the groups whose rules it
+// meets, it is called after every change of a user
+func (gs *GroupService) RefreshUser(ctx context.Context, u entity.User) error {
+	groups, err := gs.groupRepo.Groups(ctx)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0)
+	for _, g := range groups {
+		if g.Matches(u) {
+			ids = append(ids, g.ID)
+		}
+	}
+	return gs.groupRepo.SetUserDynamicGroups(ctx, u.ID, ids)
+}
+
+// RefreshGroup makes exactly the users meeting the rules of g its dynamic
+// members, it is called after every change of a group
+func (gs *GroupService) RefreshGroup(ctx context.Context, g entity.Group) error {
+	ids := make([]string, 0)
+	if g.Dynamic() {
+		users, err := gs.userRepo.Get(ctx)
+		if err != nil {
+			return err
+		}
+		for _, cus := range users {
+			if u := cus.GetExportedCustomer().User; g.Matches(u) {
+				ids = append(ids, u.ID)
+			}
+		}
+	}
+	return gs.groupRepo.SetGroupDynamicMembers(ctx, g.ID, ids)
+}
+
+// RefreshAll recomputes the dynamic members of every group, for changes of
+// many users at once such as renaming a department
+func (gs *GroupService) RefreshAll(ctx context.Context) error {
+	groups, err := gs.groupRepo.Groups(ctx)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := gs.RefreshGroup(ctx, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshed logs the failures of recomputing the groups of a user, the
+// change of the user itself has been saved already
+func (gs *GroupService) refreshed(ctx context.Context, u entity.User) {
+	if err := gs.RefreshUser(ctx, u); err != nil {
+		gs.logger.ErrorCtx(ctx, "failed to recompute the groups of a user",
+			slog.String("user_id", u.ID), slog.Any("error", err))
+	}
+}
+
+// Repository wraps repo so the groups of the users it changes are
+// recomputed, every api changing users goes through it
+func (gs *GroupService) Repository(repo datastore.UserRepository) datastore.UserRepository {
+	return &membershipRepository{UserRepository: repo, groups: gs}
+}
+
+type membershipRepository struct {
+	datastore.UserRepository
+	groups *GroupService
+}
+
+func (r *membershipRepository) Create(ctx context.Context, user model.Customer) (model.Customer, error) {
+	out, err := r.UserRepository.Create(ctx, user)
+	if err == nil {
+		r.groups.refreshed(ctx, out.GetExportedCustomer().User)
+	}
+	return out, err
+}
+
+func (r *membershipRepository) Update(ctx context.Context, user model.Customer) (model.Customer, error) {
+	out, err := r.UserRepository.Update(ctx, user)
+	if err == nil {
+		r.groups.refreshed(ctx, out.GetExportedCustomer().User)
+	}
+	return out, err
+}
+
+func (r *membershipRepository) ChangeStatus(ctx context.Context, change entity.StatusChange) (model.Customer, error) {
+	out, err := r.UserRepository.ChangeStatus(ctx, change)
+	if err == nil {
+		r.groups.refreshed(ctx, out.GetExportedCustomer().User)
+	}
+	return out, err
+}
+
+func (r *membershipRepository) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	if err := r.UserRepository.MarkEmailVerified(ctx, userID, email); err != nil {
+		return err
+	}
+	if out, err := r.UserRepository.GetByID(ctx, userID); err == nil {
+		r.groups.refreshed(ctx, out.GetExportedCustomer().User)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeGroups keeps groups and whether the users of fakeUsers are their
+// static or dynamic members
+type fakeGroups struct {
+	users   *fakeUsers
+	groups  map[string]entity.Group
+	members map[string]map[string]*entity.GroupMember
+	nextID  int
+}
+
+func (f *fakeGroups) CreateGroup(_ context.Context, group entity.Group) (entity.Group, error) {
+	for _, g := range f.groups {
+		if strings.EqualFold(g.Name, group.Name) {
+			return entity.Group{}, datastore.ErrGroupExists
+		}
+	}
+	f.nextID++
+	group.ID = strconv.Itoa(f.nextID)
+	f.groups[group.ID] = group
+	f.members[group.ID] = map[string]*entity.GroupMember{}
+	return group, nil
+}
+
+func (f *fakeGroups) Groups(context.Context) ([]entity.Group, error) {
+	groups := make([]entity.Group, 0, len(f.groups))
+	for _, g := range f.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (f *fakeGroups) GroupByID(_ context.Context, id string) (entity.Group, error) {
+	g, ok := f.groups[id]
+	if !ok {
+		return entity.Group{}, datastore.ErrGroupNotFound
+	}
+	return g, nil
+}
+
+func (f *fakeGroups) UpdateGroup(_ context.Context, group entity.Group) (entity.Group, error) {
+	current, ok := f.groups[group.ID]
+	if !ok {
+		return entity.Group{}, datastore.ErrGroupNotFound
+	}
+	group.CreatedAt = current.CreatedAt
+	f.groups[group.ID] = group
+	return group, nil
+}
+
+func (f *fakeGroups) DeleteGroup(_ context.Context, id string) error {
+	if _, ok := f.groups[id]; !ok {
+		return datastore.ErrGroupNotFound
+	}
+	delete(f.groups, id)
+	delete(f.members, id)
+	return nil
+}
+
+func (f *fakeGroups) AddStaticMember(_ context.Context, groupID, userID string) error {
+	if _, ok := f.groups[groupID]; !ok {
+		return datastore.ErrGroupNotFound
+	}
+	if _, ok := f.users.users[userID]; !ok {
+		return datastore.ErrCustomerNotFound
+	}
+	f.member(groupID, userID).Static = true
+	return nil
+}
+
+func (f *fakeGroups) RemoveStaticMember(_ context.Context, groupID, userID string) error {
+	if _, ok := f.groups[groupID]; !ok {
+		return datastore.ErrGroupNotFound
+	}
+	m, ok := f.members[groupID][userID]
+	if !ok || !m.Static {
+		return datastore.ErrGroupMemberNotFound
+	}
+	m.Static = false
+	f.prune(groupID, userID)
+	return nil
+}
+
+func (f *fakeGroups) GroupMembers(_ context.Context, groupID string) ([]entity.GroupMember, error) {
+	if _, ok := f.groups[groupID]; !ok {
+		return nil, datastore.ErrGroupNotFound
+	}
+	members := make([]entity.GroupMember, 0)
+	for id, m := range f.members[groupID] {
+		members = append(members, entity.GroupMember{User: f.users.users[id], Static: m.Static, Dynamic: m.Dynamic})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members, nil
+}
+
+func (f *fakeGroups) UserGroups(_ context.Context, userID string) ([]entity.UserGroup, error) {
+	if _, ok := f.users.users[userID]; !ok {
+		return nil, datastore.ErrCustomerNotFound
+	}
+	groups := make([]entity.UserGroup, 0)
+	for id, members := range f.members {
+		if m, ok := members[userID]; ok {
+			groups = append(groups, entity.UserGroup{Group: f.groups[id], Static: m.Static, Dynamic: m.Dynamic})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (f *fakeGroups) SetUserDynamicGroups(_ context.Context, userID string, groupIDs []string) error {
+	for groupID := range f.groups {
+		f.setDynamic(groupID, userID, contains(groupIDs, groupID))
+	}
+	return nil
+}
+
+func (f *fakeGroups) SetGroupDynamicMembers(_ context.Context, groupID string, userIDs []string) error {
+	for userID := range f.users.users {
+		f.setDynamic(groupID, userID, contains(userIDs, userID))
+	}
+	return nil
+}
+
+func (f *fakeGroups) setDynamic(groupID, userID string, dynamic bool) {
+	f.member(groupID, userID).Dynamic = dynamic
+	f.prune(groupID, userID)
+}
+
+func (f *fakeGroups) member(groupID, userID string) *entity.GroupMember {
+	m, ok := f.members[groupID][userID]
+	if !ok {
+		m = &entity.GroupMember{}
+		f.members[groupID][userID] = m
+	}
+	return m
+}
+
+// prune drops the users neither static nor dynamic members
+func (f *fakeGroups) prune(groupID, userID string) {
+	if m := f.members[groupID][userID]; !m.Static && !m.Dynamic {
+		delete(f.members[groupID], userID)
+	}
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGroups(t *testing.T) {
+	jroe := entity.User{ID: "2", UserName: "jroe", FirstName: "Jane", LastName: "Roe", Email: "jroe@example.com", Department: "ops", UserStatus: entity.Active,
+		Attributes: map[string]string{"location": "Berlin"}}
+	users := newFakeUsers(jdoe, jroe)
+	groups := &fakeGroups{users: users, groups: map[string]entity.Group{}, members: map[string]map[string]*entity.GroupMember{}}
+	gs, err := NewGroupServices(
+		WithGroupRepository(groups, nil),
+		WithGroupUserRepository(users, nil),
+	)
+	require.NoError(t, err)
+	svc, err := NewCustomerServices(WithCustomerRepository(gs.Repository(users), nil))
+	require.NoError(t, err)
+	members := func(groupID string) map[string]entity.GroupMember {
+		rec := serve(gs.Members, admin, http.MethodGet, "/v2/groups/"+groupID+"/members", "", "id", groupID)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res struct {
+			Data []entity.GroupMember `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		out := map[string]entity.GroupMember{}
+		for _, m := range res.Data {
+			out[m.ID] = m
+		}
+		return out
+	}
+	update := func(groupID, body string) int {
+		return serve(gs.Update, admin, http.MethodPut, "/v2/groups/"+groupID, body, "id", groupID).Code
+	}
+
+	// berlin engineers (1) is dynamic, admins (2) is static
+	rec := serve(gs.Create, admin, http.MethodPost, "/v2/groups", `{"name":"berlin engineers","rules":[`+
+		`{"field":"department","op":"eq","values":["ENG"]},{"field":"attributes.location","op":"eq","values":["berlin"]}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Equal(t, http.StatusCreated, serve(gs.Create, admin, http.MethodPost, "/v2/groups", `{"name":"admins"}`).Code)
+
+	testCase := []struct {
+		name    string
+		handler func(*GroupService, echo.Context) error
+		method  string
+		params  []string
+		body    string
+		status  int
+	}{
+		{name: "needs a name", handler: (*GroupService).Create, method: http.MethodPost, body: `{}`, status: http.StatusBadRequest},
+		{name: "names are unique ignoring case", handler: (*GroupService).Create, method: http.MethodPost, body: `{"name":"ADMINS"}`, status: http.StatusConflict},
+		{name: "unknown field", handler: (*GroupService).Create, method: http.MethodPost, body: `{"name":"x","rules":[{"field":"salary","op":"eq","values":["1"]}]}`, status: http.StatusBadRequest},
+		{name: "unknown op", handler: (*GroupService).Create, method: http.MethodPost, body: `{"name":"x","rules":[{"field":"department","op":"gt","values":["1"]}]}`, status: http.StatusBadRequest},
+		{name: "badly formed body", handler: (*GroupService).Create, method: http.MethodPost, body: `{"name":`, status: http.StatusBadRequest},
+		{name: "unknown group", handler: (*GroupService).Get, method: http.MethodGet, params: []string{"id", "9"}, status: http.StatusNotFound},
+		{name: "rejected rules are not stored", handler: (*GroupService).Update, method: http.MethodPut, params: []string{"id", "2"}, body: `{"name":"x","rules":[{"field":"salary","op":"eq","values":["1"]}]}`, status: http.StatusBadRequest},
+		{name: "badly formed update", handler: (*GroupService).Update, method: http.MethodPut, params: []string{"id", "2"}, body: `{"name":`, status: http.StatusBadRequest},
+		{name: "unknown member", handler: (*GroupService).AddMember, method: http.MethodPost, params: []string{"id", "2"}, body: `{"userId":"9"}`, status: http.StatusBadRequest},
+		{name: "adds a static member", handler: (*GroupService).AddMember, method: http.MethodPost, params: []string{"id", "2"}, body: `{"userId":"1"}`, status: http.StatusOK},
+		{name: "only static members are removed", handler: (*GroupService).RemoveMember, method: http.MethodDelete, params: []string{"id", "1", "userId", "2"}, status: http.StatusNotFound},
+		{name: "unknown user", handler: (*GroupService).UserGroups, method: http.MethodGet, params: []string{"id", "9"}, status: http.StatusNotFound},
+	}
+	for _, tc := range testCase {
+		h := func(c echo.Context) error { return tc.handler(gs, c) }
+		rec := serve(h, admin, tc.method, "/v2/groups", tc.body, tc.params...)
+		assert.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+	}
+	assert.Len(t, groups.groups, 2, "rejected bodies create nothing")
+	assert.Equal(t, entity.Group{ID: "2", Name: "admins"}, groups.groups["2"], "rejected bodies update nothing")
+	assert.Empty(t, members("1"), "nobody in eng is in berlin yet")
+	assert.True(t, members("2")["1"].Static)
+
+	// moving jroe to eng makes them a dynamic member
+	body := `{"userName":"jroe","firstName":"Jane","lastName":"Roe","email":"jroe@example.com","department":"eng","userStatus":"active","attributes":{"location":"Berlin"}}`
+	require.Equal(t, http.StatusOK, serve(svc.UpdateV2, admin, http.MethodPut, "/v2/user/2", body, "id", "2").Code)
+	got := members("1")
+	require.Len(t, got, 1)
+	assert.True(t, got["2"].Dynamic)
+	assert.False(t, got["2"].Static)
+
+	rec = serve(gs.UserGroups, admin, http.MethodGet, "/v2/user/2/groups", "", "id", "2")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"name":"berlin engineers"`)
+
+	// rules are evaluated again when they change
+	require.Equal(t, http.StatusOK, update("1", `{"name":"engineers","rules":[{"field":"department","op":"eq","values":["eng"]}]}`))
+	assert.Len(t, members("1"), 2)
+
+	// a static member meeting the rules stays one when removed by hand
+	require.Equal(t, http.StatusOK, serve(gs.AddMember, admin, http.MethodPost, "/v2/groups/1/members", `{"userId":"1"}`, "id", "1").Code)
+	require.Equal(t, http.StatusOK, serve(gs.RemoveMember, admin, http.MethodDelete, "/v2/groups/1/members/1", "", "id", "1", "userId", "1").Code)
+	assert.True(t, members("1")["1"].Dynamic)
+
+	require.Equal(t, http.StatusOK, serve(svc.Terminate, admin, http.MethodPost, "/v2/user/1/terminate", `{"reason":"left"}`, "id", "1").Code)
+	require.Equal(t, http.StatusOK, update("1", `{"name":"engineers","rules":[{"field":"userStatus","op":"ne","values":["terminated"]}]}`))
+	assert.Len(t, members("1"), 1, "terminated users are left out")
+	require.Equal(t, http.StatusOK, serve(gs.Delete, admin, http.MethodDelete, "/v2/groups/1", "", "id", "1").Code)
+	assert.Equal(t, http.StatusNotFound, serve(gs.Members, admin, http.MethodGet, "/v2/groups/1/members", "", "id", "1").Code)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithLoginLimiter throttles failed logins, repo backs the admin endpoints
+func WithLoginLimiter(l *auth.LoginLimiter, repo datastore.LockoutRepository) AuthConfiguration {
+	return func(as *AuthService) error {
+		as.limiter = l
+		as.lockoutRepo = repo
+		return nil
+	}
+}
+
+// lockedOut answers 429 when the account or the client ip is locked out
+func (as *AuthService) lockedOut(ctx echo.Context, userName string) (bool, error) {
+	if as.limiter == nil {
+		return false, nil
+	}
+	rctx := ctx.Request().Context()
+	until, err := as.limiter.LockedUntil(rctx, userName, ctx.RealIP())
+	if err != nil {
+		as.logger.ErrorCtx(rctx, "failed to check login lockout", slog.Any("error", err))
+		return true, utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	if until.IsZero() {
+		return false, nil
+	}
+	as.logger.WarnCtx(rctx, "login refused", slog.String("reason", "locked out"), slog.Time("locked_until", until))
+	retry := math.Ceil(time.Until(until).Seconds())
+	ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(retry)))
+	return true, utils.JSON(ctx, "sign in", http.StatusTooManyRequests, auth.ErrLoginLocked)
+}
+
+// loginFailed records a failed login and writes an audit event for every
+// lockout it causes
+func (as *AuthService) loginFailed(ctx echo.Context, userName, reason string) {
+	rctx := ctx.Request().Context()
+	as.logger.WarnCtx(rctx, "login failed", slog.String("reason", reason))
+	if as.limiter == nil {
+		return
+	}
+	lockouts, err := as.limiter.Failure(rctx, userName, ctx.RealIP())
+	if err != nil {
+		as.logger.ErrorCtx(rctx, "failed to record failed login", slog.Any("error", err))
+	}
+	for _, l := range lockouts {
+		kind, value, _ := strings.Cut(l.Key, ":")
+		attrs := []any{
+			slog.String("audit", "login.lockout"),
+			slog.String("throttle", kind),
+			slog.Int("failures", l.Failures),
+			slog.Time("locked_until", l.Until),
+		}
+		// the user name is personal data, an account is logged by the
+		// user id rctx carries and an unknown name by the hash of its key
+		switch {
+		case kind != "account":
+			attrs = append(attrs, slog.String("ip", value))
+		case custom_slog.FieldsFromContext(rctx).UserID == "":
+			attrs = append(attrs, slog.String("throttle_key", auth.HashToken(l.Key)))
+		}
+		as.logger.WarnCtx(rctx, "login locked out", attrs...)
+	}
+}
+
+// loginSucceeded forgets the failed logins of the account
+func (as *AuthService) loginSucceeded(ctx echo.Context, userName string) {
+	if as.limiter == nil {
+		return
+	}
+	rctx := ctx.Request().Context()
+	if err := as.limiter.Success(rctx, userName); err != nil && !errors.Is(err, datastore.ErrLoginThrottleNotFound) {
+		as.logger.ErrorCtx(rctx, "failed to clear failed logins", slog.Any("error", err))
+	}
+}
+
+// handlers
+
+// ListLockouts lists the accounts and client ips currently locked out
+func (as *AuthService) ListLockouts(ctx echo.Context) error {
+	lockouts, err := as.lockoutRepo.LockedLogins(ctx.Request().Context())
+	if err != nil {
+		return utils.JSON(ctx, "fetch lockouts", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, lockouts)
+}
+
+// UnlockUser lifts the lockout of a user and forgets its failed logins
+func (as *AuthService) UnlockUser(ctx echo.Context) error {
+	id := ctx.Param("id")
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), id)
+	cus, err := as.userRepo.GetByID(rctx, id)
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "unlock", http.StatusNotFound, err)
+		}
+		return utils.JSON(ctx, "unlock", http.StatusInternalServerError, err)
+	}
+	return as.unlock(ctx, auth.AccountKey(cus.GetUserName()))
+}
+
+// UnlockIP lifts the lockout of a client ip and forgets its failed logins
+func (as *AuthService) UnlockIP(ctx echo.Context) error {
+	return as.unlock(ctx, auth.IPKey(ctx.Param("ip")))
+}
+
+func (as *AuthService) unlock(ctx echo.Context, key string) error {
+	rctx := ctx.Request().Context()
+	if err := as.lockoutRepo.ClearLoginFailures(rctx, key); err != nil {
+		if errors.Is(err, datastore.ErrLoginThrottleNotFound) {
+			return utils.JSON(ctx, "unlock", http.StatusNotFound, err)
+		}
+		as.logger.ErrorCtx(rctx, "failed to unlock login", slog.Any("error", err))
+		return utils.JSON(ctx, "unlock", http.StatusInternalServerError, err)
+	}
+	kind, _, _ := strings.Cut(key, ":")
+	as.logger.InfoCtx(rctx, "login unlocked",
+		slog.String("audit", "login.unlock"),
+		slog.String("throttle", kind),
+		slog.String("admin", auth.Subject(ctx)),
+	)
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
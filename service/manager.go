@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+// SetManager makes the manager of the body the manager of the user of the
+// path, a null manager removes it
+func (cs *CustomerService) SetManager(ctx echo.Context) error {
+	id := ctx.Param("id")
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), id)
+	req := new(entity.ManagerAssignment)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	out, err := cs.managerRepo.SetManager(rctx, id, req.ManagerID)
+	if err != nil {
+		return cs.managerFailed(ctx, "set manager of", err)
+	}
+	// the routes are shared by the versions, v1 keeps its user wrapper
+	if utils.APIVersion(ctx) >= 2 {
+		return utils.JSON(ctx, Successful, http.StatusOK, out.GetExportedCustomer().User)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, out.GetExportedCustomer())
+}
+
+// DirectReports lists the users managed by the user of the path
+func (cs *CustomerService) DirectReports(ctx echo.Context) error {
+	users, err := cs.managerRepo.DirectReports(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return cs.managerFailed(ctx, "fetch reports of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, hidePII(ctx, users))
+}
+
+// ReportingChain lists the managers of the user of the path from its own
+// up to the top of the org chart
+func (cs *CustomerService) ReportingChain(ctx echo.Context) error {
+	users, err := cs.managerRepo.ReportingChain(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return cs.managerFailed(ctx, "fetch reporting chain of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, hidePII(ctx, users))
+}
+
+// OrgChart answers the users without a manager with everyone reporting to
+// them below
+func (cs *CustomerService) OrgChart(ctx echo.Context) error {
+	chart, err := cs.managerRepo.OrgChart(ctx.Request().Context())
+	if err != nil {
+		return cs.managerFailed(ctx, "fetch org chart of", err)
+	}
+	if !auth.Can(ctx, entity.PermUsersReadPII) {
+		var hide func(nodes []entity.OrgNode)
+		hide = func(nodes []entity.OrgNode) {
+			for i := range nodes {
+				nodes[i].Email = ""
+				hide(nodes[i].Reports)
+			}
+		}
+		hide(chart)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, chart)
+}
+
+// managerFailed answers the errors of the manager repository
+func (cs *CustomerService) managerFailed(ctx echo.Context, msg string, err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrCustomerNotFound):
+		return utils.JSON(ctx, msg, http.StatusNotFound, err)
+	case errors.Is(err, datastore.ErrManagerNotFound):
+		return utils.JSON(ctx, msg, http.StatusBadRequest, err)
+	case errors.Is(err, datastore.ErrManagerCycle), errors.Is(err, datastore.ErrManagerTerminated):
+		return utils.JSON(ctx, msg, http.StatusConflict, err)
+	}
+	cs.logger.ErrorCtx(ctx.Request().Context(), "manager request failed", slog.Any("error", err))
+	return utils.JSON(ctx, msg, http.StatusInternalServerError, err)
+}
+
+// hidePII hides the emails of users without users:read_pii
+func hidePII(ctx echo.Context, users []entity.User) []entity.User {
+	if !auth.Can(ctx, entity.PermUsersReadPII) {
+		for i := range users {
+			users[i].Email = ""
+		}
+	}
+	return users
+}
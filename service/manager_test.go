@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+// fakeManagers keeps the managers of the users of fakeUsers
+type fakeManagers struct {
+	users *fakeUsers
+}
+
+func (f *fakeManagers) SetManager(_ context.Context, userID string, managerID *string) (model.Customer, error) {
+	u, ok := f.users.users[userID]
+	if !ok {
+		return model.Customer{}, datastore.ErrCustomerNotFound
+	}
+	if managerID != nil {
+		manager, ok := f.users.users[*managerID]
+		if !ok {
+			return model.Customer{}, datastore.ErrManagerNotFound
+		}
+		if manager.UserStatus == entity.Terminated {
+			return model.Customer{}, datastore.ErrManagerTerminated
+		}
+		for id := managerID; id != nil; id = f.users.users[*id].ManagerID {
+			if *id == userID {
+				return model.Customer{}, datastore.ErrManagerCycle
+			}
+		}
+	}
+	u.ManagerID = managerID
+	f.users.users[userID] = u
+	return model.AddCustomer(&u), nil
+}
+
+func (f *fakeManagers) DirectReports(_ context.Context, userID string) ([]entity.User, error) {
+	if _, ok := f.users.users[userID]; !ok {
+		return nil, datastore.ErrCustomerNotFound
+	}
+	return f.reports(&userID), nil
+}
+
+func (f *fakeManagers) ReportingChain(_ context.Context, userID string) ([]entity.User, error) {
+	u, ok := f.users.users[userID]
+	if !ok {
+		return nil, datastore.ErrCustomerNotFound
+	}
+	chain := make([]entity.User, 0)
+	for id := u.ManagerID; id != nil; id = f.users.users[*id].ManagerID {
+		chain = append(chain, f.users.users[*id])
+	}
+	return chain, nil
+}
+
+func (f *fakeManagers) OrgChart(context.Context) ([]entity.OrgNode, error) {
+	var tree func(managerID *string) []entity.OrgNode
+	tree = func(managerID *string) []entity.OrgNode {
+		nodes := make([]entity.OrgNode, 0)
+		for _, u := range f.reports(managerID) {
+			nodes = append(nodes, entity.OrgNode{User: u, Reports: tree(&u.ID)})
+		}
+		return nodes
+	}
+	return tree(nil), nil
+}
+
+// reports lists the users managed by managerID ordered by id, nil lists the
+// users without a manager
+func (f *fakeManagers) reports(managerID *string) []entity.User {
+	out := make([]entity.User, 0)
+	for _, u := range f.users.users {
+		if (managerID == nil && u.ManagerID == nil) || (managerID != nil && u.ManagerID != nil && *u.ManagerID == *managerID) {
+			out = append(out, u)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func TestManagers(t *testing.T) {
+	jroe, jsmith := jdoe, jdoe
+	jroe.ID, jroe.UserName = "2", "jroe"
+	jsmith.ID, jsmith.UserName = "4", "jsmith"
+	users := newFakeUsers(jdoe, jroe, xdoe, jsmith)
+	svc, err := NewCustomerServices(
+		WithCustomerRepository(users, nil),
+		WithManagerRepository(&fakeManagers{users: users}, nil),
+	)
+	require.NoError(t, err)
+	setManager := func(id, body string) *httptest.ResponseRecorder {
+		return serve(svc.SetManager, admin, http.MethodPut, "/v2/user/"+id+"/manager", body, "id", id)
+	}
+	list := func(h echo.HandlerFunc, id string) []string {
+		rec := serve(h, admin, http.MethodGet, "/v2/user/"+id, "", "id", id)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res struct {
+			Data []entity.User `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		ids := make([]string, 0, len(res.Data))
+		for _, u := range res.Data {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+	// jdoe (1) manages jroe (2) who manages jsmith (4), xdoe (3) is terminated
+	require.Equal(t, http.StatusOK, setManager("2", `{"managerId":"1"}`).Code)
+	rec := setManager("4", `{"managerId":"2"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"managerId":"2"`)
+
+	testCase := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+	}{
+		{name: "cannot report to a report", id: "1", body: `{"managerId":"4"}`, status: http.StatusConflict},
+		{name: "cannot report to themselves", id: "1", body: `{"managerId":"1"}`, status: http.StatusConflict},
+		{name: "terminated users manage nobody", id: "1", body: `{"managerId":"3"}`, status: http.StatusConflict},
+		{name: "unknown manager", id: "1", body: `{"managerId":"9"}`, status: http.StatusBadRequest},
+		{name: "unknown user", id: "9", body: `{"managerId":"1"}`, status: http.StatusNotFound},
+	}
+	for _, tc := range testCase {
+		rec := setManager(tc.id, tc.body)
+		assert.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+	}
+
+	assert.Equal(t, []string{"2"}, list(svc.DirectReports, "1"))
+	assert.Equal(t, []string{"2", "1"}, list(svc.ReportingChain, "4"))
+	assert.Empty(t, list(svc.ReportingChain, "1"))
+
+	rec = serve(svc.OrgChart, admin, http.MethodGet, "/v2/user/org-chart", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var chart struct {
+		Data []entity.OrgNode `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &chart))
+	require.Len(t, chart.Data, 2)
+	assert.Equal(t, "1", chart.Data[0].ID)
+	require.Len(t, chart.Data[0].Reports, 1)
+	require.Len(t, chart.Data[0].Reports[0].Reports, 1)
+	assert.Equal(t, "4", chart.Data[0].Reports[0].Reports[0].ID)
+	assert.Equal(t, "3", chart.Data[1].ID)
+
+	rec = serve(svc.Terminate, admin, http.MethodPost, "/v2/user/2/terminate", `{"reason":"left the company"}`, "id", "2")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	// the terminated manager keeps reporting to its own manager
+	assert.Equal(t, []string{"2", "4"}, list(svc.DirectReports, "1"), "the reports of terminated managers move up")
+	assert.Empty(t, list(svc.DirectReports, "2"))
+}
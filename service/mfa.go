@@ -0,0 +1,280 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var ErrMFASelfOnly = errors.New("users can only enroll their own second factor")
+
+// WithMFA enables TOTP enrollment and the second login step
+func WithMFA(mr datastore.MFARepository, totp *auth.TOTP) AuthConfiguration {
+	return func(as *AuthService) error {
+		as.mfaRepo = mr
+		as.totp = totp
+		return nil
+	}
+}
+
+// mfaEnabled reports whether logins of userID need a second factor
+func (as *AuthService) mfaEnabled(ctx echo.Context, userID string) (bool, error) {
+	if as.mfaRepo == nil {
+		return false, nil
+	}
+	e, err := as.mfaRepo.MFAEnrollment(ctx.Request().Context(), userID)
+	if errors.Is(err, datastore.ErrMFANotFound) {
+		return false, nil
+	}
+	return e.Enabled(), err
+}
+
+// challengeMFA answers a valid password of a user with MFA enabled with a
+// challenge to redeem with a code at /auth/login/mfa
+func (as *AuthService) challengeMFA(ctx echo.Context, userID string) error {
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), userID)
+	token, id, err := auth.NewToken()
+	if err != nil {
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	challenge := entity.MFAChallenge{Challenge: token, ExpiresAt: time.Now().Add(mfaChallengeTTL)}
+	err = as.tokenRepo.CreateUserToken(rctx, entity.UserToken{
+		ID:        id,
+		UserID:    userID,
+		Purpose:   entity.TokenMFALogin,
+		ExpiresAt: challenge.ExpiresAt,
+	})
+	if err != nil {
+		as.logger.ErrorCtx(rctx, "failed to create mfa challenge", slog.Any("error", err))
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	as.logger.InfoCtx(rctx, "mfa challenge issued")
+	return utils.JSON(ctx, Successful, http.StatusAccepted, challenge)
+}
+
+// verifyTOTP checks a code against the enrollment e and records its
+// period so it cannot be used again
+func (as *AuthService) verifyTOTP(ctx echo.Context, e entity.MFAEnrollment, code string) error {
+	secret, err := as.totp.Open(e.Secret)
+	if err != nil {
+		return err
+	}
+	step, err := as.totp.Verify(secret, code, e.LastUsedStep)
+	if err != nil {
+		return err
+	}
+	if err := as.mfaRepo.UseTOTPStep(ctx.Request().Context(), e.UserID, step); err != nil {
+		if errors.Is(err, datastore.ErrMFACodeUsed) {
+			return auth.ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// handlers
+
+// LoginMFA is the second login step, it redeems a challenge with a code of
+// the authenticator app or a recovery code. A challenge is redeemed once so
+// a wrong code requires signing in with the password again.
+func (as *AuthService) LoginMFA(ctx echo.Context) error {
+	req := new(entity.MFALogin)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	t, err := as.tokenRepo.ConsumeUserToken(rctx, auth.HashToken(req.Challenge), entity.TokenMFALogin)
+	if err != nil {
+		return as.tokenError(ctx, "sign in", err)
+	}
+	rctx = custom_slog.WithUserID(rctx, t.UserID)
+	ctx.SetRequest(ctx.Request().WithContext(rctx))
+	cus, err := as.userRepo.GetByID(rctx, t.UserID)
+	if err != nil {
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	// the user may have been deactivated since the challenge was issued
+	if status := cus.GetUserStatus(); status != entity.Active {
+		as.logger.WarnCtx(rctx, "login refused", slog.String("reason", "user not active"), slog.String("status", status.String()))
+		return utils.JSON(ctx, "sign in", http.StatusForbidden, ErrUserNotActive)
+	}
+	e, err := as.mfaRepo.MFAEnrollment(rctx, t.UserID)
+	if err != nil {
+		if errors.Is(err, datastore.ErrMFANotFound) {
+			// reset since the challenge was issued
+			return utils.JSON(ctx, "sign in", http.StatusBadRequest, datastore.ErrInvalidToken)
+		}
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	if req.Code != "" {
+		err = as.verifyTOTP(ctx, e, req.Code)
+	} else {
+		err = as.mfaRepo.UseRecoveryCode(rctx, t.UserID, auth.HashRecoveryCode(req.RecoveryCode))
+	}
+	switch {
+	case errors.Is(err, auth.ErrInvalidMFACode), errors.Is(err, datastore.ErrInvalidRecoveryCode):
+		as.loginFailed(ctx, cus.GetUserName(), "wrong mfa code")
+		return utils.JSON(ctx, "sign in", http.StatusUnauthorized, err)
+	case err != nil:
+		as.logger.ErrorCtx(rctx, "failed to verify mfa code", slog.Any("error", err))
+		return utils.JSON(ctx, "sign in", http.StatusInternalServerError, err)
+	}
+	as.loginSucceeded(ctx, cus.GetUserName())
+	return as.startSession(ctx, t.UserID, true)
+}
+
+// EnrollTOTP starts the enrollment of the caller with a new secret, it
+// replaces an unconfirmed enrollment
+func (as *AuthService) EnrollTOTP(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if p, _ := auth.PrincipalFrom(ctx); p.UserID != id {
+		return utils.JSON(ctx, "enroll mfa of", http.StatusForbidden, ErrMFASelfOnly)
+	}
+	rctx := ctx.Request().Context()
+	cus, err := as.userRepo.GetByID(rctx, id)
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "enroll mfa of", http.StatusNotFound, err)
+		}
+		return utils.JSON(ctx, "enroll mfa of", http.StatusInternalServerError, err)
+	}
+	secret, err := as.totp.NewSecret()
+	if err != nil {
+		return utils.JSON(ctx, "enroll mfa of", http.StatusInternalServerError, err)
+	}
+	sealed, err := as.totp.Seal(secret)
+	if err != nil {
+		return utils.JSON(ctx, "enroll mfa of", http.StatusInternalServerError, err)
+	}
+	if err := as.mfaRepo.SaveTOTP(rctx, id, sealed); err != nil {
+		if errors.Is(err, datastore.ErrMFAAlreadyEnabled) {
+			return utils.JSON(ctx, "enroll mfa of", http.StatusConflict, err)
+		}
+		as.logger.ErrorCtx(rctx, "failed to save mfa enrollment", slog.Any("error", err))
+		return utils.JSON(ctx, "enroll mfa of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusCreated, entity.TOTPSetup{
+		Secret: secret,
+		URI:    as.totp.URI(cus.GetUserName(), secret),
+	})
+}
+
+// ConfirmTOTP enables the enrollment of the caller with a first code and
+// returns its recovery codes
+func (as *AuthService) ConfirmTOTP(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if p, _ := auth.PrincipalFrom(ctx); p.UserID != id {
+		return utils.JSON(ctx, "confirm mfa of", http.StatusForbidden, ErrMFASelfOnly)
+	}
+	req := new(entity.MFACode)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	e, err := as.mfaRepo.MFAEnrollment(rctx, id)
+	switch {
+	case errors.Is(err, datastore.ErrMFANotFound):
+		return utils.JSON(ctx, "confirm mfa of", http.StatusNotFound, err)
+	case err != nil:
+		return utils.JSON(ctx, "confirm mfa of", http.StatusInternalServerError, err)
+	case e.Enabled():
+		return utils.JSON(ctx, "confirm mfa of", http.StatusConflict, datastore.ErrMFAAlreadyEnabled)
+	}
+	secret, err := as.totp.Open(e.Secret)
+	if err != nil {
+		return utils.JSON(ctx, "confirm mfa of", http.StatusInternalServerError, err)
+	}
+	step, err := as.totp.Verify(secret, req.Code, e.LastUsedStep)
+	if err != nil {
+		return utils.JSON(ctx, "confirm mfa of", http.StatusBadRequest, err)
+	}
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return utils.JSON(ctx, "confirm mfa of", http.StatusInternalServerError, err)
+	}
+	if err := as.mfaRepo.ConfirmTOTP(rctx, id, step, hashes); err != nil {
+		if errors.Is(err, datastore.ErrMFAAlreadyEnabled) {
+			return utils.JSON(ctx, "confirm mfa of", http.StatusConflict, err)
+		}
+		as.logger.ErrorCtx(rctx, "failed to confirm mfa enrollment", slog.Any("error", err))
+		return utils.JSON(ctx, "confirm mfa of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, entity.RecoveryCodes{Codes: codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the caller, it
+// takes a current code of the authenticator app
+func (as *AuthService) RegenerateRecoveryCodes(ctx echo.Context) error {
+	id := ctx.Param("id")
+	if p, _ := auth.PrincipalFrom(ctx); p.UserID != id {
+		return utils.JSON(ctx, "regenerate recovery codes of", http.StatusForbidden, ErrMFASelfOnly)
+	}
+	req := new(entity.MFACode)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	e, err := as.mfaRepo.MFAEnrollment(rctx, id)
+	switch {
+	case errors.Is(err, datastore.ErrMFANotFound) || err == nil && !e.Enabled():
+		return utils.JSON(ctx, "regenerate recovery codes of", http.StatusNotFound, datastore.ErrMFANotFound)
+	case err != nil:
+		return utils.JSON(ctx, "regenerate recovery codes of", http.StatusInternalServerError, err)
+	}
+	if err := as.verifyTOTP(ctx, e, req.Code); err != nil {
+		if errors.Is(err, auth.ErrInvalidMFACode) {
+			return utils.JSON(ctx, "regenerate recovery codes of", http.StatusBadRequest, err)
+		}
+		return utils.JSON(ctx, "regenerate recovery codes of", http.StatusInternalServerError, err)
+	}
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return utils.JSON(ctx, "regenerate recovery codes of", http.StatusInternalServerError, err)
+	}
+	if err := as.mfaRepo.ReplaceRecoveryCodes(rctx, id, hashes); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to replace recovery codes", slog.Any("error", err))
+		return utils.JSON(ctx, "regenerate recovery codes of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, entity.RecoveryCodes{Codes: codes})
+}
+
+// ResetMFA removes the second factor of a user who lost it and signs them
+// out everywhere, they sign in with their password and enroll again
+func (as *AuthService) ResetMFA(ctx echo.Context) error {
+	id := ctx.Param("id")
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), id)
+	if err := as.mfaRepo.DeleteMFA(rctx, id); err != nil {
+		if errors.Is(err, datastore.ErrMFANotFound) {
+			return utils.JSON(ctx, "reset mfa of", http.StatusNotFound, err)
+		}
+		as.logger.ErrorCtx(rctx, "failed to reset mfa", slog.Any("error", err))
+		return utils.JSON(ctx, "reset mfa of", http.StatusInternalServerError, err)
+	}
+	if err := as.sessionRepo.RevokeSession(rctx, id, ""); err != nil {
+		as.logger.ErrorCtx(rctx, "failed to revoke sessions", slog.Any("error", err))
+	}
+	as.logger.InfoCtx(rctx, "mfa reset by admin", slog.String("admin", auth.Subject(ctx)))
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/events"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+const (
+	DefaultRelayInterval = 5 * time.Second
+	relayBatch           = 100
+)
+
+type RelayConfiguration func(or *OutboxRelay) error
+
+// OutboxRelay publishes the events the datastore records with the changes of
+// users to a sink. Events failing to publish are retried on the next tick
+// and hold back the later events of their user. The events are claimed before
+// they are published and instances leave the users claimed by others alone,
+// so every instance can run one.
+type OutboxRelay struct {
+	outboxRepo datastore.OutboxRepository
+	sink       events.Sink
+	logger     *slog.Logger
+	interval   time.Duration
+}
+
+func NewOutboxRelay(cfgs ...RelayConfiguration) (*OutboxRelay, error) {
+	or := &OutboxRelay{
+		logger:   slog.Default(),
+		interval: DefaultRelayInterval,
+	}
+	for _, cfg := range cfgs {
+		if err := cfg(or); err != nil {
+			return nil, err
+		}
+	}
+	if or.sink == nil {
+		return nil, errors.New("the outbox relay needs a sink")
+	}
+	return or, nil
+}
+
+func WithRelayRepository(repo datastore.OutboxRepository, err error) RelayConfiguration {
+	return func(or *OutboxRelay) error {
+		if err != nil {
+			return err
+		}
+		or.outboxRepo = repo
+		return nil
+	}
+}
+
+// WithRelaySink sets where the events are published
+func WithRelaySink(sink events.Sink, err error) RelayConfiguration {
+	return func(or *OutboxRelay) error {
+		if err != nil {
+			return err
+		}
+		or.sink = sink
+		return nil
+	}
+}
+
+func WithRelayLogger(logger *slog.Logger) RelayConfiguration {
+	return func(or *OutboxRelay) error {
+		or.logger = logger
+		return nil
+	}
+}
+
+// WithRelayInterval sets how often unpublished events are looked for
+func WithRelayInterval(interval time.Duration) RelayConfiguration {
+	return func(or *OutboxRelay) error {
+		if interval <= 0 {
+			return errors.New("the relay interval must be positive")
+		}
+		or.interval = interval
+		return nil
+	}
+}
+
+// WithRelayIntervalFromEnv sets the interval to OUTBOX_RELAY_INTERVAL when it
+// is set
+func WithRelayIntervalFromEnv() RelayConfiguration {
+	return func(or *OutboxRelay) error {
+		interval, ok, err := durationFromEnv("OUTBOX_RELAY_INTERVAL")
+		if err != nil || !ok {
+			return err
+		}
+		return WithRelayInterval(interval)(or)
+	}
+}
+
+// Run publishes the recorded events every interval until ctx is done
+func (or *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(or.interval)
+	defer ticker.Stop()
+	for {
+		or.PublishPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending publishes the events recorded so far and returns how many
+// were published, it stops at the first batch not published in full
+func (or *OutboxRelay) PublishPending(ctx context.Context) int {
+	published := 0
+	for {
+		n, err := or.outboxRepo.PublishEvents(ctx, relayBatch, func(event entity.Event) error {
+			return or.sink.Publish(ctx, event)
+		})
+		if err != nil {
+			or.logger.ErrorCtx(ctx, "failed to publish events", slog.Any("error", err))
+			return published
+		}
+		published += n
+		if n < relayBatch {
+			return published
+		}
+	}
+}
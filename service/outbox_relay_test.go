@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+// fakeOutbox holds back the later events of a user after one fails and
+// passes the events of the other users instead like the datastore does
+type fakeOutbox struct {
+	pending []entity.Event
+}
+
+func (f *fakeOutbox) PublishEvents(_ context.Context, limit int, publish func(entity.Event) error) (int, error) {
+	published, attempted := 0, 0
+	held := map[string]bool{}
+	var left []entity.Event
+	for _, event := range f.pending {
+		if attempted == limit || held[event.UserID] {
+			left = append(left, event)
+			continue
+		}
+		attempted++
+		if err := publish(event); err != nil {
+			held[event.UserID] = true
+			left = append(left, event)
+			continue
+		}
+		published++
+	}
+	f.pending = left
+	return published, nil
+}
+
+// failingSink fails the events of one user and passes the others to a
+// memory sink
+type failingSink struct {
+	*events.MemorySink
+	userID string
+}
+
+func (s failingSink) Publish(ctx context.Context, event entity.Event) error {
+	if event.UserID == s.userID {
+		return errors.New("unavailable")
+	}
+	return s.MemorySink.Publish(ctx, event)
+}
+
+func TestOutboxRelayPublishPending(t *testing.T) {
+	outbox := &fakeOutbox{}
+	for i := 1; i <= relayBatch+5; i++ {
+		outbox.pending = append(outbox.pending, entity.Event{
+			ID:     strconv.Itoa(i),
+			Type:   entity.EventUserUpdated,
+			UserID: strconv.Itoa(i % 3),
+		})
+	}
+	sink := failingSink{MemorySink: events.NewMemorySink(), userID: "2"}
+	relay, err := NewOutboxRelay(WithRelayRepository(outbox, nil), WithRelaySink(sink, nil))
+	require.NoError(t, err)
+
+	published := relay.PublishPending(context.Background())
+	got := sink.Events()
+	assert.Equal(t, len(got), published)
+	for i := 1; i < len(got); i++ {
+		if got[i-1].UserID == got[i].UserID {
+			a, _ := strconv.Atoi(got[i-1].ID)
+			b, _ := strconv.Atoi(got[i].ID)
+			assert.Less(t, a, b, "the events of a user stay in order")
+		}
+	}
+	for _, event := range got {
+		assert.NotEqual(t, "2", event.UserID)
+	}
+	for _, event := range outbox.pending {
+		assert.Equal(t, "2", event.UserID, "only the events of the failing user are left")
+	}
+
+	sink.userID = ""
+	relay, err = NewOutboxRelay(WithRelayRepository(outbox, nil), WithRelaySink(sink, nil))
+	require.NoError(t, err)
+	relay.PublishPending(context.Background())
+	assert.Empty(t, outbox.pending, "held events are published once the sink recovers")
+}
+
+func TestNewOutboxRelay(t *testing.T) {
+	_, err := NewOutboxRelay(WithRelayRepository(&fakeOutbox{}, nil))
+	assert.Error(t, err, "a sink is required")
+	_, err = NewOutboxRelay(WithRelaySink(events.NewMemorySink(), nil), WithRelayInterval(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/url"
+)
+
+var ErrSelfDemotion = errors.New("admins cannot revoke their own admin role")
+
+type RoleConfiguration func(rs *RoleService) error
+
+// RoleService manages the role bindings used for authorization
+type RoleService struct {
+	roleRepo datastore.RoleRepository
+	logger   *slog.Logger
+}
+
+func NewRoleServices(cfgs ...RoleConfiguration) (*RoleService, error) {
+	rs := &RoleService{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(rs); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+func WithRoleRepository(rr datastore.RoleRepository, err error) RoleConfiguration {
+	return func(rs *RoleService) error {
+		if err != nil {
+			return err
+		}
+		rs.roleRepo = rr
+		return nil
+	}
+}
+
+// WithRoleLogger sets the logger used by the role handlers
+func WithRoleLogger(logger *slog.Logger) RoleConfiguration {
+	return func(rs *RoleService) error {
+		rs.logger = logger
+		return nil
+	}
+}
+
+// handlers
+
+func (rs *RoleService) ListRoles(ctx echo.Context) error {
+	roles, err := rs.roleRepo.Roles(ctx.Request().Context())
+	if err != nil {
+		return utils.JSON(ctx, "fetch roles of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, roles)
+}
+
+func (rs *RoleService) ListBindings(ctx echo.Context) error {
+	bindings, err := rs.roleRepo.RoleBindings(ctx.Request().Context(), ctx.QueryParam("subject"))
+	if err != nil {
+		return utils.JSON(ctx, "fetch role bindings of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, bindings)
+}
+
+func (rs *RoleService) Bind(ctx echo.Context) error {
+	rb := new(entity.RoleBinding)
+	rctx := ctx.Request().Context()
+	if err := ctx.Bind(rb); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(rb); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	rb.CreatedBy = auth.Subject(ctx)
+	out, err := rs.roleRepo.BindRole(rctx, *rb)
+	if err != nil {
+		if errors.Is(err, datastore.ErrRoleNotFound) {
+			return utils.JSON(ctx, "bind role to", http.StatusBadRequest, err)
+		}
+		rs.logger.ErrorCtx(rctx, "failed to bind role", slog.Any("error", err))
+		return utils.JSON(ctx, "bind role to", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusCreated, out)
+}
+
+func (rs *RoleService) Unbind(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	subject, err := url.PathUnescape(ctx.Param("subject"))
+	if err != nil {
+		return utils.JSON(ctx, "unbind role of", http.StatusBadRequest, err)
+	}
+	if subject == auth.Subject(ctx) && ctx.Param("role") == entity.RoleAdmin {
+		return utils.JSON(ctx, "unbind role of", http.StatusBadRequest, ErrSelfDemotion)
+	}
+	err = rs.roleRepo.UnbindRole(rctx, subject, ctx.Param("role"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrRoleBindingNotFound) {
+			return utils.JSON(ctx, "unbind role of", http.StatusNotFound, err)
+		}
+		rs.logger.ErrorCtx(rctx, "failed to unbind role", slog.Any("error", err))
+		return utils.JSON(ctx, "unbind role of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrEmptyChange        = errors.New("a scheduled change needs a status or a department")
+	ErrEffectiveInThePast = errors.New("the effective time of a scheduled change must be in the future")
+)
+
+// ScheduleChange schedules a status change or department move of the user
+// of the path, it is applied by the ChangeScheduler at its effective time
+func (cs *CustomerService) ScheduleChange(ctx echo.Context) error {
+	id := ctx.Param("id")
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), id)
+	req := new(entity.ScheduledChangeRequest)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	if req.Status == nil && req.Department == nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, ErrEmptyChange)
+	}
+	if !req.EffectiveAt.After(time.Now()) {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, ErrEffectiveInThePast)
+	}
+	if req.Status != nil {
+		// checked again by the scheduler, the status may change in between
+		current, err := cs.userRepo.GetByID(rctx, id)
+		if err != nil {
+			if errors.Is(err, datastore.ErrCustomerNotFound) {
+				return utils.JSON(ctx, "schedule change of", http.StatusNotFound, err)
+			}
+			cs.logger.ErrorCtx(rctx, "failed to fetch user", slog.Any("error", err))
+			return utils.JSON(ctx, "schedule change of", http.StatusInternalServerError, err)
+		}
+		if err := current.GetUserStatus().Transition(*req.Status); err != nil {
+			return utils.JSON(ctx, "schedule change of", http.StatusConflict, err)
+		}
+	}
+	change, err := cs.changesRepo.ScheduleChange(rctx, entity.ScheduledChange{
+		UserID:      id,
+		Status:      req.Status,
+		Department:  req.Department,
+		EffectiveAt: req.EffectiveAt,
+		Reason:      req.Reason,
+		CreatedBy:   auth.Subject(ctx),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, datastore.ErrCustomerNotFound):
+			return utils.JSON(ctx, "schedule change of", http.StatusNotFound, err)
+		case errors.Is(err, datastore.ErrDepartmentNotFound):
+			return utils.JSON(ctx, "schedule change of", http.StatusBadRequest, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to schedule change", slog.Any("error", err))
+		return utils.JSON(ctx, "schedule change of", http.StatusInternalServerError, err)
+	}
+	cs.logger.InfoCtx(rctx, "change scheduled", slog.String("id", change.ID), slog.Time("effective_at", change.EffectiveAt))
+	return utils.JSON(ctx, Successful, http.StatusCreated, change)
+}
+
+// ListScheduledChanges lists the pending changes, of the user of the userId
+// query parameter if set
+func (cs *CustomerService) ListScheduledChanges(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	changes, err := cs.changesRepo.ScheduledChanges(rctx, ctx.QueryParam("userId"))
+	if err != nil {
+		cs.logger.ErrorCtx(rctx, "failed to fetch scheduled changes", slog.Any("error", err))
+		return utils.JSON(ctx, "fetch scheduled changes of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, changes)
+}
+
+// CancelScheduledChange cancels the pending change of the path
+func (cs *CustomerService) CancelScheduledChange(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	if err := cs.changesRepo.CancelScheduledChange(rctx, ctx.Param("id"), auth.Subject(ctx)); err != nil {
+		if errors.Is(err, datastore.ErrPendingChangeNotFound) {
+			return utils.JSON(ctx, "cancel scheduled change of", http.StatusNotFound, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to cancel scheduled change", slog.Any("error", err))
+		return utils.JSON(ctx, "cancel scheduled change of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeChanges keeps the pending changes of the users of fakeUsers
+type fakeChanges struct {
+	users   *fakeUsers
+	pending []entity.ScheduledChange
+	nextID  int
+}
+
+func (f *fakeChanges) ScheduleChange(_ context.Context, change entity.ScheduledChange) (entity.ScheduledChange, error) {
+	if _, ok := f.users.users[change.UserID]; !ok {
+		return entity.ScheduledChange{}, datastore.ErrCustomerNotFound
+	}
+	f.nextID++
+	change.ID = strconv.Itoa(f.nextID)
+	f.pending = append(f.pending, change)
+	return change, nil
+}
+
+func (f *fakeChanges) ScheduledChanges(_ context.Context, userID string) ([]entity.ScheduledChange, error) {
+	out := make([]entity.ScheduledChange, 0)
+	for _, change := range f.pending {
+		if userID == "" || change.UserID == userID {
+			out = append(out, change)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeChanges) CancelScheduledChange(_ context.Context, id, _ string) error {
+	for i, change := range f.pending {
+		if change.ID == id {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			return nil
+		}
+	}
+	return datastore.ErrPendingChangeNotFound
+}
+
+func (f *fakeChanges) DueScheduledChanges(context.Context, time.Time, int) ([]entity.ScheduledChange, error) {
+	return nil, nil
+}
+
+func (f *fakeChanges) ApplyScheduledChange(context.Context, string) (entity.User, error) {
+	return entity.User{}, datastore.ErrPendingChangeNotFound
+}
+
+func TestScheduledChanges(t *testing.T) {
+	users := newFakeUsers(jdoe, xdoe)
+	svc, err := NewCustomerServices(
+		WithCustomerRepository(users, nil),
+		WithScheduledChangeRepository(&fakeChanges{users: users}, nil),
+	)
+	require.NoError(t, err)
+	future := time.Now().Add(14 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	testCase := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+	}{
+		{name: "needs a change", id: "1", body: `{"effectiveAt":"` + future + `","reason":"reorg"}`, status: http.StatusBadRequest},
+		{name: "needs a reason", id: "1", body: `{"department":"ops","effectiveAt":"` + future + `"}`, status: http.StatusBadRequest},
+		{name: "needs a future time", id: "1", body: `{"department":"ops","effectiveAt":"` + past + `","reason":"reorg"}`, status: http.StatusBadRequest},
+		{name: "rejects unknown statuses", id: "1", body: `{"status":"retired","effectiveAt":"` + future + `","reason":"x"}`, status: http.StatusBadRequest},
+		{name: "unknown user", id: "9", body: `{"department":"ops","effectiveAt":"` + future + `","reason":"reorg"}`, status: http.StatusNotFound},
+		{name: "rejects invalid transitions", id: "3", body: `{"status":"active","effectiveAt":"` + future + `","reason":"rehired"}`, status: http.StatusConflict},
+		{name: "schedules a termination", id: "1", body: `{"status":"terminated","effectiveAt":"` + future + `","reason":"notice given"}`, status: http.StatusCreated},
+		{name: "schedules a move", id: "1", body: `{"department":"ops","effectiveAt":"` + future + `","reason":"reorg"}`, status: http.StatusCreated},
+	}
+	for _, tc := range testCase {
+		rec := serve(svc.ScheduleChange, admin, http.MethodPost, "/v2/user/"+tc.id+"/scheduled-changes", tc.body, "id", tc.id)
+		assert.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+	}
+
+	rec := serve(svc.ListScheduledChanges, admin, http.MethodGet, "/v2/admin/scheduled-changes?userId=1", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res struct {
+		Data []entity.ScheduledChange `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Data, 2)
+	require.NotNil(t, res.Data[0].Status)
+	assert.Equal(t, entity.Terminated, *res.Data[0].Status)
+	assert.Equal(t, "admin", res.Data[0].CreatedBy)
+	require.NotNil(t, res.Data[1].Department)
+	assert.Equal(t, "ops", *res.Data[1].Department)
+
+	cancel := func() int {
+		path := "/v2/admin/scheduled-changes/" + res.Data[0].ID
+		return serve(svc.CancelScheduledChange, admin, http.MethodDelete, path, "", "id", res.Data[0].ID).Code
+	}
+	assert.Equal(t, http.StatusOK, cancel())
+	assert.Equal(t, http.StatusNotFound, cancel())
+}
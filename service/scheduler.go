@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+const (
+	DefaultSchedulerInterval = time.Minute
+	schedulerBatch           = 100
+)
+
+type SchedulerConfiguration func(cs *ChangeScheduler) error
+
+// ChangeScheduler applies the scheduled changes whose effective time has
+// passed. Changes are locked while they are applied, so every instance of
+// the api can run one.
+type ChangeScheduler struct {
+	changesRepo datastore.ScheduledChangeRepository
+	logger      *slog.Logger
+	interval    time.Duration
+	applied     func(context.Context, entity.User)
+	now         func() time.Time
+}
+
+func NewChangeScheduler(cfgs ...SchedulerConfiguration) (*ChangeScheduler, error) {
+	cs := &ChangeScheduler{
+		logger:   slog.Default(),
+		interval: DefaultSchedulerInterval,
+		applied:  func(context.Context, entity.User) {},
+		now:      time.Now,
+	}
+	for _, cfg := range cfgs {
+		if err := cfg(cs); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+func WithSchedulerRepository(cr datastore.ScheduledChangeRepository, err error) SchedulerConfiguration {
+	return func(cs *ChangeScheduler) error {
+		if err != nil {
+			return err
+		}
+		cs.changesRepo = cr
+		return nil
+	}
+}
+
+func WithSchedulerLogger(logger *slog.Logger) SchedulerConfiguration {
+	return func(cs *ChangeScheduler) error {
+		cs.logger = logger
+		return nil
+	}
+}
+
+// WithSchedulerInterval sets how often due changes are looked for, a
+// change is applied up to interval after its effective time
+func WithSchedulerInterval(interval time.Duration) SchedulerConfiguration {
+	return func(cs *ChangeScheduler) error {
+		if interval <= 0 {
+			return errors.New("the scheduler interval must be positive")
+		}
+		cs.interval = interval
+		return nil
+	}
+}
+
+// WithSchedulerIntervalFromEnv sets the interval to SCHEDULED_CHANGES_INTERVAL
+// when it is set
+func WithSchedulerIntervalFromEnv() SchedulerConfiguration {
+	return func(cs *ChangeScheduler) error {
+		interval, ok, err := durationFromEnv("SCHEDULED_CHANGES_INTERVAL")
+		if err != nil || !ok {
+			return err
+		}
+		return WithSchedulerInterval(interval)(cs)
+	}
+}
+
+// WithAppliedListener calls applied with every user changed by the scheduler
+// and the context of the pass applying the change
+func WithAppliedListener(applied func(context.Context, entity.User)) SchedulerConfiguration {
+	return func(cs *ChangeScheduler) error {
+		cs.applied = applied
+		return nil
+	}
+}
+
+// Run applies the due changes every interval until ctx is done
+func (cs *ChangeScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(cs.interval)
+	defer ticker.Stop()
+	for {
+		cs.ApplyDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ApplyDue applies the changes due now and returns how many were applied.
+// Changes the status transitions no longer allow are marked failed.
+func (cs *ChangeScheduler) ApplyDue(ctx context.Context) int {
+	applied := 0
+	for {
+		// a pass settling nothing left the due changes to another instance
+		settled := 0
+		due, err := cs.changesRepo.DueScheduledChanges(ctx, cs.now(), schedulerBatch)
+		if err != nil {
+			cs.logger.ErrorCtx(ctx, "failed to fetch due scheduled changes", slog.Any("error", err))
+			return applied
+		}
+		for _, change := range due {
+			user, err := cs.changesRepo.ApplyScheduledChange(ctx, change.ID)
+			switch {
+			case err == nil:
+				applied++
+				settled++
+				cs.applied(ctx, user)
+			case errors.Is(err, datastore.ErrPendingChangeNotFound):
+				// cancelled, or locked or applied by another instance
+			case errors.Is(err, entity.ErrInvalidTransition):
+				settled++
+				cs.logger.WarnCtx(ctx, "scheduled change failed", slog.String("id", change.ID),
+					slog.String("user_id", change.UserID), slog.Any("error", err))
+			default:
+				// the change stays pending, it is retried on the next tick
+				cs.logger.ErrorCtx(ctx, "failed to apply scheduled change", slog.String("id", change.ID),
+					slog.Any("error", err))
+				return applied
+			}
+		}
+		if len(due) < schedulerBatch || settled == 0 {
+			return applied
+		}
+	}
+}
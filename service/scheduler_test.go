@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeDueChanges applies changes to an active user, the errors of failing
+// are returned by ApplyScheduledChange
+type fakeDueChanges struct {
+	due     []entity.ScheduledChange
+	failing map[string]error
+	user    entity.User
+	queried time.Time
+	// locked changes are held by another instance, they stay due
+	locked  bool
+	fetches int
+}
+
+func (f *fakeDueChanges) ScheduleChange(context.Context, entity.ScheduledChange) (entity.ScheduledChange, error) {
+	return entity.ScheduledChange{}, errors.New("not implemented")
+}
+
+func (f *fakeDueChanges) ScheduledChanges(context.Context, string) ([]entity.ScheduledChange, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeDueChanges) CancelScheduledChange(context.Context, string, string) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakeDueChanges) DueScheduledChanges(_ context.Context, now time.Time, limit int) ([]entity.ScheduledChange, error) {
+	f.queried = now
+	f.fetches++
+	if len(f.due) > limit {
+		return f.due[:limit], nil
+	}
+	return f.due, nil
+}
+
+func (f *fakeDueChanges) ApplyScheduledChange(_ context.Context, id string) (entity.User, error) {
+	if f.locked {
+		return entity.User{}, datastore.ErrPendingChangeNotFound
+	}
+	for i, change := range f.due {
+		if change.ID != id {
+			continue
+		}
+		if err := f.failing[id]; err != nil {
+			if !errors.Is(err, entity.ErrInvalidTransition) {
+				return entity.User{}, err
+			}
+		} else {
+			f.user = change.Apply(f.user)
+		}
+		f.due = append(f.due[:i], f.due[i+1:]...)
+		return f.user, f.failing[id]
+	}
+	return entity.User{}, datastore.ErrPendingChangeNotFound
+}
+
+func TestChangeSchedulerApplyDue(t *testing.T) {
+	ops, terminated := "ops", entity.Terminated
+	transition := fmt.Errorf("%w: inactive to terminated", entity.ErrInvalidTransition)
+	testCase := []struct {
+		name    string
+		due     []entity.ScheduledChange
+		failing map[string]error
+		applied int
+		left    int
+	}{
+		{name: "nothing due"},
+		{
+			name:    "applies every due change",
+			due:     []entity.ScheduledChange{{ID: "1", Department: &ops}, {ID: "2", Status: &terminated}},
+			applied: 2,
+		},
+		{
+			name:    "skips changes the transitions refuse",
+			due:     []entity.ScheduledChange{{ID: "1", Status: &terminated}, {ID: "2", Department: &ops}},
+			failing: map[string]error{"1": transition},
+			applied: 1,
+		},
+		{
+			name:    "keeps changes after a store failure for the next tick",
+			due:     []entity.ScheduledChange{{ID: "1", Department: &ops}, {ID: "2", Department: &ops}},
+			failing: map[string]error{"1": errors.New("connection reset")},
+			left:    2,
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+			repo := &fakeDueChanges{due: tc.due, failing: tc.failing, user: entity.User{ID: "1", UserStatus: entity.Active}}
+			var published []entity.User
+			cs, err := NewChangeScheduler(
+				WithSchedulerRepository(repo, nil),
+				WithAppliedListener(func(_ context.Context, u entity.User) { published = append(published, u) }),
+			)
+			require.NoError(t, err)
+			cs.now = func() time.Time { return now }
+
+			assert.Equal(t, tc.applied, cs.ApplyDue(context.Background()))
+			assert.Len(t, published, tc.applied)
+			assert.Len(t, repo.due, tc.left)
+			assert.Equal(t, now, repo.queried)
+		})
+	}
+}
+
+func TestChangeSchedulerSkipsLockedChanges(t *testing.T) {
+	repo := &fakeDueChanges{locked: true}
+	for i := 0; i < schedulerBatch; i++ {
+		repo.due = append(repo.due, entity.ScheduledChange{ID: strconv.Itoa(i + 1)})
+	}
+	cs, err := NewChangeScheduler(WithSchedulerRepository(repo, nil))
+	require.NoError(t, err)
+
+	assert.Zero(t, cs.ApplyDue(context.Background()))
+	assert.Equal(t, 1, repo.fetches, "a full batch held elsewhere is not fetched again")
+	assert.Len(t, repo.due, schedulerBatch)
+}
+
+func TestWithSchedulerInterval(t *testing.T) {
+	_, err := NewChangeScheduler(WithSchedulerInterval(0))
+	assert.Error(t, err)
+	cs, err := NewChangeScheduler(WithSchedulerInterval(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, cs.interval)
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/scim"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type SCIMConfiguration func(ss *SCIMService) error
+
+// SCIMService provisions users for identity providers speaking SCIM 2.0
+type SCIMService struct {
+	userRepo datastore.UserRepository
+	logger   *slog.Logger
+	mail     *AccountMailer
+	// baseURL is the url of the /scim/v2 root, built from APP_BASE_URL
+	baseURL string
+}
+
+func NewSCIMServices(cfgs ...SCIMConfiguration) (*SCIMService, error) {
+	ss := &SCIMService{
+		logger:  slog.Default(),
+		baseURL: strings.TrimRight(os.Getenv("APP_BASE_URL"), "/") + "/scim/v2",
+	}
+	for _, cfg := range cfgs {
+		if err := cfg(ss); err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+func WithSCIMRepository(ur datastore.UserRepository, err error) SCIMConfiguration {
+	return func(ss *SCIMService) error {
+		if err != nil {
+			return err
+		}
+		ss.userRepo = ur
+		return nil
+	}
+}
+
+// WithSCIMLogger sets the logger used by the scim handlers
+func WithSCIMLogger(logger *slog.Logger) SCIMConfiguration {
+	return func(ss *SCIMService) error {
+		ss.logger = logger
+		return nil
+	}
+}
+
+// WithSCIMAccountMailer sends a verification email for provisioned addresses
+func WithSCIMAccountMailer(am *AccountMailer) SCIMConfiguration {
+	return func(ss *SCIMService) error {
+		ss.mail = am
+		return nil
+	}
+}
+
+func scimJSON(ctx echo.Context, status int, v any) error {
+	ctx.Response().Header().Set(echo.HeaderContentType, scim.MediaType)
+	return ctx.JSON(status, v)
+}
+
+func scimError(ctx echo.Context, status int, err error) error {
+	return scimJSON(ctx, status, scim.NewError(status, err))
+}
+
+// storeError answers the errors of the user repository
+func (ss *SCIMService) storeError(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, datastore.ErrCustomerNotFound):
+		return scimError(ctx, http.StatusNotFound, err)
+	case errors.Is(err, datastore.ErrCustomerExists):
+		e := scim.NewError(http.StatusConflict, datastore.ErrCustomerExists)
+		e.ScimType = scim.TypeUniqueness
+		return scimJSON(ctx, http.StatusConflict, e)
+	case errors.Is(err, entity.ErrInvalidTransition), errors.Is(err, entity.ErrStatusNeedsReason):
+		e := scim.NewError(http.StatusBadRequest, err)
+		e.ScimType = scim.TypeMutability
+		return scimJSON(ctx, http.StatusBadRequest, e)
+	case errors.Is(err, datastore.ErrDepartmentNotFound), errors.Is(err, entity.ErrInitialStatus):
+		return scimError(ctx, http.StatusBadRequest, fmt.Errorf("%w: %v", scim.ErrInvalidValue, err))
+	}
+	ss.logger.ErrorCtx(ctx.Request().Context(), "scim request failed", slog.Any("error", err))
+	return scimError(ctx, http.StatusInternalServerError, err)
+}
+
+func (ss *SCIMService) resource(ctx echo.Context, u entity.User) scim.User {
+	if !auth.Can(ctx, entity.PermUsersReadPII) {
+		u.Email = ""
+	}
+	return scim.FromUser(u, ss.baseURL+"/Users/"+u.ID)
+}
+
+func decodeSCIM(ctx echo.Context, v any) error {
+	if err := json.NewDecoder(ctx.Request().Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", scim.ErrInvalidSyntax, err)
+	}
+	return nil
+}
+
+// save validates u and stores it, current is the stored user or nil to create it
+func (ss *SCIMService) save(ctx echo.Context, u entity.User, current *model.Customer) error {
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), u.ID)
+	status := http.StatusOK
+	if current == nil {
+		u.EmailVerified = false
+		status = http.StatusCreated
+	} else {
+		u.EmailVerified = current.GetEmailVerified() && current.GetEmail() == u.Email
+	}
+	cus, err := model.NewCustomer(&u)
+	if err != nil {
+		return scimError(ctx, http.StatusBadRequest, fmt.Errorf("%w: %v", scim.ErrInvalidValue, err))
+	}
+	if current == nil {
+		cus, err = ss.userRepo.Create(rctx, cus)
+	} else {
+		cus, err = ss.userRepo.Update(rctx, cus)
+	}
+	if err != nil {
+		return ss.storeError(ctx, err)
+	}
+	rctx = custom_slog.WithUserID(rctx, cus.GetID())
+	ss.logger.InfoCtx(rctx, "user provisioned")
+	if ss.mail != nil && (current == nil || current.GetEmail() != cus.GetEmail()) {
+		if err := ss.mail.SendVerification(rctx, cus.GetExportedCustomer().User); err != nil {
+			ss.logger.ErrorCtx(rctx, "failed to send verification email", slog.Any("error", err))
+		}
+	}
+	if status == http.StatusCreated {
+		ctx.Response().Header().Set(echo.HeaderLocation, ss.baseURL+"/Users/"+cus.GetID())
+	}
+	return scimJSON(ctx, status, ss.resource(ctx, cus.GetExportedCustomer().User))
+}
+
+// handlers
+
+func (ss *SCIMService) ServiceProviderConfig(ctx echo.Context) error {
+	return scimJSON(ctx, http.StatusOK, scim.NewServiceProviderConfig(ss.baseURL))
+}
+
+func (ss *SCIMService) ResourceTypes(ctx echo.Context) error {
+	return scimJSON(ctx, http.StatusOK, scim.Page([]scim.ResourceType{scim.NewUserResourceType(ss.baseURL)}, 1, scim.MaxResults))
+}
+
+func (ss *SCIMService) ResourceType(ctx echo.Context) error {
+	if ctx.Param("id") != "User" {
+		return scimError(ctx, http.StatusNotFound, fmt.Errorf("resource type %q not found", ctx.Param("id")))
+	}
+	return scimJSON(ctx, http.StatusOK, scim.NewUserResourceType(ss.baseURL))
+}
+
+func (ss *SCIMService) Schemas(ctx echo.Context) error {
+	return scimJSON(ctx, http.StatusOK, scim.Page(scim.Schemas(ss.baseURL), 1, scim.MaxResults))
+}
+
+func (ss *SCIMService) Schema(ctx echo.Context) error {
+	for _, s := range scim.Schemas(ss.baseURL) {
+		if s.ID == ctx.Param("id") {
+			return scimJSON(ctx, http.StatusOK, s)
+		}
+	}
+	return scimError(ctx, http.StatusNotFound, fmt.Errorf("schema %q not found", ctx.Param("id")))
+}
+
+// ListUsers lists the users matching the filter query parameter, paginated
+// with startIndex and count
+func (ss *SCIMService) ListUsers(ctx echo.Context) error {
+	var filter scim.Filter
+	if f := ctx.QueryParam("filter"); f != "" {
+		var err error
+		if filter, err = scim.ParseFilter(f); err != nil {
+			return scimError(ctx, http.StatusBadRequest, err)
+		}
+	}
+	startIndex, count := scim.Pagination(ctx.QueryParam("startIndex"), ctx.QueryParam("count"))
+	all, err := ss.userRepo.Get(ctx.Request().Context())
+	if err != nil {
+		return ss.storeError(ctx, err)
+	}
+	users := make([]scim.User, 0, len(all))
+	for _, cus := range all.GetExportedCustomers() {
+		u := ss.resource(ctx, cus.User)
+		if filter != nil {
+			ok, err := scim.Match(filter, u)
+			if err != nil {
+				return scimError(ctx, http.StatusInternalServerError, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		users = append(users, u)
+	}
+	return scimJSON(ctx, http.StatusOK, scim.Page(users, startIndex, count))
+}
+
+func (ss *SCIMService) GetUser(ctx echo.Context) error {
+	cus, err := ss.userRepo.GetByID(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ss.storeError(ctx, err)
+	}
+	return scimJSON(ctx, http.StatusOK, ss.resource(ctx, cus.GetExportedCustomer().User))
+}
+
+// CreateUser provisions a user, active unless the request says otherwise
+func (ss *SCIMService) CreateUser(ctx echo.Context) error {
+	req := new(scim.User)
+	if err := decodeSCIM(ctx, req); err != nil {
+		return scimError(ctx, http.StatusBadRequest, err)
+	}
+	return ss.save(ctx, req.ToUser(entity.User{UserStatus: entity.Active}), nil)
+}
+
+// ReplaceUser replaces the attributes of a user with the request
+func (ss *SCIMService) ReplaceUser(ctx echo.Context) error {
+	req := new(scim.User)
+	if err := decodeSCIM(ctx, req); err != nil {
+		return scimError(ctx, http.StatusBadRequest, err)
+	}
+	current, err := ss.userRepo.GetByID(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ss.storeError(ctx, err)
+	}
+	return ss.save(ctx, req.ToUser(current.GetExportedCustomer().User), &current)
+}
+
+// PatchUser applies the add, replace and remove operations of the request
+func (ss *SCIMService) PatchUser(ctx echo.Context) error {
+	req := new(scim.PatchRequest)
+	if err := decodeSCIM(ctx, req); err != nil {
+		return scimError(ctx, http.StatusBadRequest, err)
+	}
+	if err := req.Validate(); err != nil {
+		return scimError(ctx, http.StatusBadRequest, err)
+	}
+	current, err := ss.userRepo.GetByID(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ss.storeError(ctx, err)
+	}
+	user := current.GetExportedCustomer().User
+	patched, err := req.Apply(scim.FromUser(user, ""))
+	if err != nil {
+		return scimError(ctx, scim.StatusFor(err), err)
+	}
+	return ss.save(ctx, patched.ToUser(user), &current)
+}
+
+func (ss *SCIMService) DeleteUser(ctx echo.Context) error {
+	id := ctx.Param("id")
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), id)
+	if _, err := ss.userRepo.GetByID(rctx, id); err != nil {
+		return ss.storeError(ctx, err)
+	}
+	if err := ss.userRepo.Delete(rctx, id); err != nil {
+		return ss.storeError(ctx, err)
+	}
+	ss.logger.InfoCtx(rctx, "user deprovisioned")
+	return ctx.NoContent(http.StatusNoContent)
+}
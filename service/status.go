@@ -0,0 +1,76 @@
+package service
+
+import (
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+// Activate activates the user of the path, the body gives the reason
+func (cs *CustomerService) Activate(ctx echo.Context) error {
+	return cs.changeStatus(ctx, "activate", entity.Active)
+}
+
+// Deactivate deactivates the user of the path, the body gives the reason
+func (cs *CustomerService) Deactivate(ctx echo.Context) error {
+	return cs.changeStatus(ctx, "deactivate", entity.Inactive)
+}
+
+// Terminate terminates the user of the path for good, the body gives the reason
+func (cs *CustomerService) Terminate(ctx echo.Context) error {
+	return cs.changeStatus(ctx, "terminate", entity.Terminated)
+}
+
+func (cs *CustomerService) changeStatus(ctx echo.Context, msg string, to entity.Status) error {
+	id := ctx.Param("id")
+	rctx := custom_slog.WithUserID(ctx.Request().Context(), id)
+	reason := new(entity.StatusReason)
+	if err := ctx.Bind(reason); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(reason); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	out, err := cs.userRepo.ChangeStatus(rctx, entity.StatusChange{
+		UserID:    id,
+		To:        to,
+		Reason:    reason.Reason,
+		ChangedBy: auth.Subject(ctx),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, datastore.ErrCustomerNotFound):
+			return utils.JSON(ctx, msg, http.StatusNotFound, err)
+		case errors.Is(err, entity.ErrInvalidTransition):
+			return utils.JSON(ctx, msg, http.StatusConflict, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to change user status", slog.Any("error", err))
+		return utils.JSON(ctx, msg, http.StatusInternalServerError, err)
+	}
+	// the routes are shared by the versions, v1 keeps its user wrapper
+	if utils.APIVersion(ctx) >= 2 {
+		return utils.JSON(ctx, Successful, http.StatusOK, out.GetExportedCustomer().User)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, out.GetExportedCustomer())
+}
+
+// StatusHistory lists the status changes of the user of the path, oldest first
+func (cs *CustomerService) StatusHistory(ctx echo.Context) error {
+	rctx := ctx.Request().Context()
+	history, err := cs.userRepo.StatusHistory(rctx, ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, datastore.ErrCustomerNotFound) {
+			return utils.JSON(ctx, "fetch status history of", http.StatusNotFound, err)
+		}
+		cs.logger.ErrorCtx(rctx, "failed to fetch status history", slog.Any("error", err))
+		return utils.JSON(ctx, "fetch status history of", http.StatusInternalServerError, err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, history)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestStatusChanges(t *testing.T) {
+	user := `{"userName":"jroe","firstName":"Jane","lastName":"Roe","email":"jroe@example.com","department":"ops","userStatus":%s}`
+	testCase := []struct {
+		name    string
+		handler func(*CustomerService, echo.Context) error
+		method  string
+		id      string
+		body    string
+		status  int
+		want    entity.Status
+	}{
+		{
+			name: "users are not created terminated", handler: (*CustomerService).CreateV2,
+			method: http.MethodPost, body: fmt.Sprintf(user, `"terminated"`), status: http.StatusBadRequest,
+		},
+		{
+			name: "v1 users are not created terminated", handler: (*CustomerService).Create,
+			method: http.MethodPost, body: fmt.Sprintf(user, "2"), status: http.StatusBadRequest,
+		},
+		{
+			name: "updates do not change the status", handler: (*CustomerService).UpdateV2,
+			method: http.MethodPut, id: "1", body: fmt.Sprintf(user, `"inactive"`), status: http.StatusConflict, want: entity.Active,
+		},
+		{
+			name: "v1 updates cannot revive a terminated user", handler: (*CustomerService).Update,
+			method: http.MethodPut, id: "3",
+			body:   `{"id":"3","userName":"xdoe","firstName":"Xavier","lastName":"Doe","email":"xdoe@example.com","department":"eng","userStatus":1}`,
+			status: http.StatusConflict, want: entity.Terminated,
+		},
+		{
+			name: "terminates with a reason", handler: (*CustomerService).Terminate,
+			method: http.MethodPost, id: "1", body: `{"reason":"left the company"}`, status: http.StatusOK, want: entity.Terminated,
+		},
+		{
+			name: "status changes require a reason", handler: (*CustomerService).Deactivate,
+			method: http.MethodPost, id: "1", body: `{}`, status: http.StatusBadRequest, want: entity.Active,
+		},
+		{
+			name: "terminated users are final", handler: (*CustomerService).Activate,
+			method: http.MethodPost, id: "3", body: `{"reason":"rehired"}`, status: http.StatusConflict, want: entity.Terminated,
+		},
+		{
+			name: "status changes of unknown users", handler: (*CustomerService).Deactivate,
+			method: http.MethodPost, id: "9", body: `{"reason":"leave"}`, status: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			users := newFakeUsers(jdoe, xdoe)
+			svc, err := NewCustomerServices(WithCustomerRepository(users, nil))
+			require.NoError(t, err)
+
+			h := func(c echo.Context) error { return tc.handler(svc, c) }
+			rec := serve(h, admin, tc.method, "/v2/user", tc.body, "id", tc.id)
+
+			require.Equal(t, tc.status, rec.Code, rec.Body.String())
+			if u, ok := users.users[tc.id]; ok {
+				assert.Equal(t, tc.want, u.UserStatus)
+			}
+			if tc.method == http.MethodPost && tc.id == "" {
+				assert.Len(t, users.users, 2, "rejected users are not created")
+			}
+		})
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	svc, err := NewCustomerServices(WithCustomerRepository(newFakeUsers(jdoe, xdoe), nil))
+	require.NoError(t, err)
+
+	rec := serve(svc.Deactivate, admin, http.MethodPost, "/v2/user/1/deactivate", `{"reason":"parental leave"}`, "id", "1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = serve(svc.Terminate, admin, http.MethodPost, "/v2/user/1/terminate", `{"reason":"left the company"}`, "id", "1")
+	require.Equal(t, http.StatusConflict, rec.Code, "inactive users are activated before they are terminated")
+
+	rec = serve(svc.StatusHistory, admin, http.MethodGet, "/v2/user/1/status-history", "", "id", "1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res struct {
+		Data []entity.StatusChange `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Data, 1)
+	assert.Equal(t, entity.Active, res.Data[0].From)
+	assert.Equal(t, entity.Inactive, res.Data[0].To)
+	assert.Equal(t, "parental leave", res.Data[0].Reason)
+	assert.Equal(t, "admin", res.Data[0].ChangedBy)
+
+	rec = serve(svc.StatusHistory, admin, http.MethodGet, "/v2/user/9/status-history", "", "id", "9")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
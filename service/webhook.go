@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/utils"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+var (
+	ErrUnknownDeliveryStatus = errors.New("status must be pending, succeeded or dead")
+	ErrNoWebhookSealer       = errors.New("webhook secrets need a sealer")
+)
+
+type WebhookConfiguration func(ws *WebhookService) error
+
+// WebhookService manages the webhooks and their deliveries. It is also the
+// sink of the outbox relay, queueing a delivery of every event to the
+// webhooks subscribing to it for the WebhookDispatcher.
+type WebhookService struct {
+	webhookRepo datastore.WebhookRepository
+	sealer      *auth.Sealer
+	logger      *slog.Logger
+}
+
+// issuedWebhook is the only response carrying the secret of a webhook
+type issuedWebhook struct {
+	entity.Webhook
+	Secret string `json:"secret"`
+}
+
+type replayedDeliveries struct {
+	Replayed int `json:"replayed"`
+}
+
+func NewWebhookServices(cfgs ...WebhookConfiguration) (*WebhookService, error) {
+	ws := &WebhookService{logger: slog.Default()}
+	for _, cfg := range cfgs {
+		if err := cfg(ws); err != nil {
+			return nil, err
+		}
+	}
+	if ws.sealer == nil {
+		return nil, ErrNoWebhookSealer
+	}
+	return ws, nil
+}
+
+func WithWebhookRepository(wr datastore.WebhookRepository, err error) WebhookConfiguration {
+	return func(ws *WebhookService) error {
+		if err != nil {
+			return err
+		}
+		ws.webhookRepo = wr
+		return nil
+	}
+}
+
+// WithWebhookSealer seals the secrets of the webhooks before they are stored
+func WithWebhookSealer(sealer *auth.Sealer, err error) WebhookConfiguration {
+	return func(ws *WebhookService) error {
+		if err != nil {
+			return err
+		}
+		ws.sealer = sealer
+		return nil
+	}
+}
+
+// WithWebhookLogger sets the logger used by the webhook handlers
+func WithWebhookLogger(logger *slog.Logger) WebhookConfiguration {
+	return func(ws *WebhookService) error {
+		ws.logger = logger
+		return nil
+	}
+}
+
+// Publish queues the deliveries of event, it implements events.Sink
+func (ws *WebhookService) Publish(ctx context.Context, event entity.Event) error {
+	return ws.webhookRepo.EnqueueDeliveries(ctx, event)
+}
+
+// SealSecrets seals the secrets stored in plaintext before secrets were
+// sealed, it runs once before the deliveries are dispatched
+func (ws *WebhookService) SealSecrets(ctx context.Context) error {
+	n, err := ws.webhookRepo.SealWebhookSecrets(ctx, ws.sealer.Seal)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		ws.logger.InfoCtx(ctx, "webhook secrets sealed", slog.Int("count", n))
+	}
+	return nil
+}
+
+// handlers
+
+func (ws *WebhookService) Create(ctx echo.Context) error {
+	req := new(entity.Webhook)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	secret, sealed, err := ws.newSecret()
+	if err != nil {
+		return ws.fail(ctx, "create webhook of", err)
+	}
+	req.Secret = sealed
+	req.CreatedBy = auth.Subject(ctx)
+	webhook, err := ws.webhookRepo.CreateWebhook(ctx.Request().Context(), *req)
+	if err != nil {
+		return ws.fail(ctx, "create webhook of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusCreated, issuedWebhook{Webhook: webhook, Secret: secret})
+}
+
+func (ws *WebhookService) List(ctx echo.Context) error {
+	webhooks, err := ws.webhookRepo.Webhooks(ctx.Request().Context())
+	if err != nil {
+		return ws.fail(ctx, "fetch webhooks of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, webhooks)
+}
+
+func (ws *WebhookService) Get(ctx echo.Context) error {
+	webhook, err := ws.webhookRepo.WebhookByID(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return ws.fail(ctx, "fetch webhook of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, webhook)
+}
+
+// Update replaces the url and event types of the webhook of the path, its
+// secret is kept
+func (ws *WebhookService) Update(ctx echo.Context) error {
+	req := new(entity.Webhook)
+	if err := ctx.Bind(req); err != nil {
+		return utils.JSON(ctx, "bind", http.StatusBadRequest, err)
+	}
+	if err := model.Validate(req); err != nil {
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, err)
+	}
+	req.ID = ctx.Param("id")
+	webhook, err := ws.webhookRepo.UpdateWebhook(ctx.Request().Context(), *req)
+	if err != nil {
+		return ws.fail(ctx, "update webhook of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, webhook)
+}
+
+// RotateSecret replaces the secret of the webhook of the path, the pending
+// deliveries are signed with the new one
+func (ws *WebhookService) RotateSecret(ctx echo.Context) error {
+	secret, sealed, err := ws.newSecret()
+	if err != nil {
+		return ws.fail(ctx, "rotate secret of", err)
+	}
+	webhook, err := ws.webhookRepo.RotateWebhookSecret(ctx.Request().Context(), ctx.Param("id"), sealed)
+	if err != nil {
+		return ws.fail(ctx, "rotate secret of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, issuedWebhook{Webhook: webhook, Secret: secret})
+}
+
+func (ws *WebhookService) Delete(ctx echo.Context) error {
+	if err := ws.webhookRepo.DeleteWebhook(ctx.Request().Context(), ctx.Param("id")); err != nil {
+		return ws.fail(ctx, "delete webhook of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, nil)
+}
+
+// Deliveries lists the latest deliveries of the webhook of the path,
+// ?status= keeps those with the status
+func (ws *WebhookService) Deliveries(ctx echo.Context) error {
+	status := entity.DeliveryStatus(ctx.QueryParam("status"))
+	switch status {
+	case "", entity.DeliveryPending, entity.DeliverySucceeded, entity.DeliveryDead:
+	default:
+		return utils.JSON(ctx, "validation", http.StatusBadRequest, ErrUnknownDeliveryStatus)
+	}
+	deliveries, err := ws.webhookRepo.WebhookDeliveries(ctx.Request().Context(), ctx.Param("id"), status)
+	if err != nil {
+		return ws.fail(ctx, "fetch deliveries of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, deliveries)
+}
+
+// Replay gives the dead deliveries of the webhook of the path every attempt
+// again, only the delivery of the path when there is one
+func (ws *WebhookService) Replay(ctx echo.Context) error {
+	n, err := ws.webhookRepo.ReplayDeliveries(ctx.Request().Context(), ctx.Param("id"), ctx.Param("deliveryId"))
+	if err != nil {
+		return ws.fail(ctx, "replay deliveries of", err)
+	}
+	return utils.JSON(ctx, Successful, http.StatusOK, replayedDeliveries{Replayed: n})
+}
+
+// fail answers the errors of the webhook repository
+func (ws *WebhookService) fail(ctx echo.Context, msg string, err error) error {
+	if errors.Is(err, datastore.ErrWebhookNotFound) || errors.Is(err, datastore.ErrDeliveryNotFound) {
+		return utils.JSON(ctx, msg, http.StatusNotFound, err)
+	}
+	ws.logger.ErrorCtx(ctx.Request().Context(), "webhook request failed", slog.Any("error", err))
+	return utils.JSON(ctx, msg, http.StatusInternalServerError, err)
+}
+
+// newSecret generates a secret for a webhook and seals it for storage
+func (ws *WebhookService) newSecret() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := entity.WebhookSecretPrefix + hex.EncodeToString(b)
+	sealed, err := ws.sealer.Seal(secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, sealed, nil
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/events"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultDispatchInterval = 5 * time.Second
+	DefaultWebhookAttempts  = 8
+	DefaultWebhookTimeout   = 10 * time.Second
+	dispatchBatch           = 50
+	// a failed attempt is retried after retryBase, doubled after every
+	// further failure up to retryMax
+	retryBase = 30 * time.Second
+	retryMax  = 6 * time.Hour
+)
+
+type DispatcherConfiguration func(wd *WebhookDispatcher) error
+
+// WebhookDispatcher posts the pending deliveries of the webhooks, signed
+// with their secret. Failed deliveries are retried with an exponential
+// backoff until they run out of attempts and are dead, dead deliveries are
+// only attempted again once replayed. Deliveries are claimed before they are
+// attempted, so every instance of the api can run one.
+type WebhookDispatcher struct {
+	webhookRepo datastore.WebhookRepository
+	sealer      *auth.Sealer
+	logger      *slog.Logger
+	client      *http.Client
+	interval    time.Duration
+	attempts    int
+	now         func() time.Time
+}
+
+func NewWebhookDispatcher(cfgs ...DispatcherConfiguration) (*WebhookDispatcher, error) {
+	wd := &WebhookDispatcher{
+		logger: slog.Default(),
+		client: &http.Client{
+			Timeout: DefaultWebhookTimeout,
+			// a redirect is answered like any other non 2xx status
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		interval: DefaultDispatchInterval,
+		attempts: DefaultWebhookAttempts,
+		now:      time.Now,
+	}
+	for _, cfg := range cfgs {
+		if err := cfg(wd); err != nil {
+			return nil, err
+		}
+	}
+	if wd.sealer == nil {
+		return nil, ErrNoWebhookSealer
+	}
+	return wd, nil
+}
+
+func WithDispatcherRepository(wr datastore.WebhookRepository, err error) DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		if err != nil {
+			return err
+		}
+		wd.webhookRepo = wr
+		return nil
+	}
+}
+
+// WithDispatcherSealer opens the secrets of the webhooks signing the
+// deliveries, they are sealed by the sealer of the WebhookService
+func WithDispatcherSealer(sealer *auth.Sealer, err error) DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		if err != nil {
+			return err
+		}
+		wd.sealer = sealer
+		return nil
+	}
+}
+
+func WithDispatcherLogger(logger *slog.Logger) DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		wd.logger = logger
+		return nil
+	}
+}
+
+// WithDispatcherInterval sets how often due deliveries are looked for
+func WithDispatcherInterval(interval time.Duration) DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		if interval <= 0 {
+			return errors.New("the dispatcher interval must be positive")
+		}
+		wd.interval = interval
+		return nil
+	}
+}
+
+// WithDispatcherTimeout sets how long a webhook has to answer a delivery
+func WithDispatcherTimeout(timeout time.Duration) DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		if timeout <= 0 {
+			return errors.New("the webhook timeout must be positive")
+		}
+		wd.client.Timeout = timeout
+		return nil
+	}
+}
+
+// WithDispatcherAttempts sets how many times a delivery is attempted before
+// it is dead
+func WithDispatcherAttempts(attempts int) DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		if attempts <= 0 {
+			return errors.New("the webhook attempts must be positive")
+		}
+		wd.attempts = attempts
+		return nil
+	}
+}
+
+// WithDispatcherFromEnv applies the variables that are set of
+// WEBHOOK_DISPATCH_INTERVAL, how often the due deliveries are posted,
+// WEBHOOK_TIMEOUT, how long a webhook has to answer, and
+// WEBHOOK_MAX_ATTEMPTS, how many times a delivery is attempted before it is
+// dead
+func WithDispatcherFromEnv() DispatcherConfiguration {
+	return func(wd *WebhookDispatcher) error {
+		var cfgs []DispatcherConfiguration
+		interval, ok, err := durationFromEnv("WEBHOOK_DISPATCH_INTERVAL")
+		if err != nil {
+			return err
+		}
+		if ok {
+			cfgs = append(cfgs, WithDispatcherInterval(interval))
+		}
+		timeout, ok, err := durationFromEnv("WEBHOOK_TIMEOUT")
+		if err != nil {
+			return err
+		}
+		if ok {
+			cfgs = append(cfgs, WithDispatcherTimeout(timeout))
+		}
+		attempts, ok, err := intFromEnv("WEBHOOK_MAX_ATTEMPTS")
+		if err != nil {
+			return err
+		}
+		if ok {
+			cfgs = append(cfgs, WithDispatcherAttempts(attempts))
+		}
+		for _, cfg := range cfgs {
+			if err := cfg(wd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Run delivers the due deliveries every interval until ctx is done
+func (wd *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(wd.interval)
+	defer ticker.Stop()
+	for {
+		wd.DeliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts the deliveries due now and returns how many succeeded
+func (wd *WebhookDispatcher) DeliverDue(ctx context.Context) int {
+	delivered := 0
+	for {
+		// a claimed delivery is claimable again once the attempt timed out
+		// and its outcome failed to be recorded
+		due, err := wd.webhookRepo.ClaimDeliveries(ctx, wd.now(), dispatchBatch, 2*wd.client.Timeout)
+		if err != nil {
+			wd.logger.ErrorCtx(ctx, "failed to claim webhook deliveries", slog.Any("error", err))
+			return delivered
+		}
+		webhooks := map[string]entity.Webhook{}
+		for _, delivery := range due {
+			webhook, ok := webhooks[delivery.WebhookID]
+			if !ok {
+				webhook, err = wd.webhookRepo.WebhookByID(ctx, delivery.WebhookID)
+				if errors.Is(err, datastore.ErrWebhookNotFound) {
+					// deleted meanwhile with its deliveries
+					continue
+				}
+				if err != nil {
+					wd.logger.ErrorCtx(ctx, "failed to fetch webhook", slog.Any("error", err))
+					return delivered
+				}
+				webhooks[delivery.WebhookID] = webhook
+			}
+			delivery = wd.attempt(ctx, webhook, delivery)
+			if err := wd.webhookRepo.RecordAttempt(ctx, delivery); err != nil {
+				wd.logger.ErrorCtx(ctx, "failed to record webhook delivery", slog.String("id", delivery.ID),
+					slog.Any("error", err))
+				return delivered
+			}
+			if delivery.Status == entity.DeliverySucceeded {
+				delivered++
+			}
+		}
+		if len(due) < dispatchBatch {
+			return delivered
+		}
+	}
+}
+
+// attempt posts a delivery to its webhook and returns it with the outcome
+func (wd *WebhookDispatcher) attempt(ctx context.Context, webhook entity.Webhook, delivery entity.WebhookDelivery) entity.WebhookDelivery {
+	delivery.Attempts++
+	delivery.LastStatusCode, delivery.LastError = 0, ""
+	status, err := wd.post(ctx, webhook, delivery)
+	delivery.LastStatusCode = status
+	now := wd.now()
+	if err == nil {
+		delivery.Status = entity.DeliverySucceeded
+		delivery.NextAttemptAt, delivery.DeliveredAt = nil, &now
+		return delivery
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= wd.attempts {
+		delivery.Status = entity.DeliveryDead
+		delivery.NextAttemptAt = nil
+		wd.logger.WarnCtx(ctx, "webhook delivery dead", slog.String("id", delivery.ID),
+			slog.String("webhook_id", webhook.ID), slog.Any("error", err))
+		return delivery
+	}
+	next := now.Add(retryDelay(delivery.Attempts))
+	delivery.Status = entity.DeliveryPending
+	delivery.NextAttemptAt = &next
+	return delivery
+}
+
+// post sends the payload of a delivery, it returns the status code answered
+// and an error unless it is a 2xx. A secret that cannot be opened fails the
+// attempt until the secret is rotated.
+func (wd *WebhookDispatcher) post(ctx context.Context, webhook entity.Webhook, delivery entity.WebhookDelivery) (int, error) {
+	secret, err := wd.sealer.Open(webhook.Secret)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := wd.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(events.HeaderWebhookID, delivery.ID)
+	req.Header.Set(events.HeaderEventID, delivery.EventID)
+	req.Header.Set(events.HeaderEventType, string(delivery.EventType))
+	req.Header.Set(events.HeaderWebhookTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(events.HeaderWebhookSignature, events.Sign(secret, timestamp, delivery.Payload))
+	res, err := wd.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// drain the body so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("answered with %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// retryDelay is how long a delivery waits after its attempts failed
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		return retryMax
+	}
+	return delay
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeDeliveries hands out the pending deliveries due like the datastore
+// does, the other methods of the repository are not used by the dispatcher
+type fakeDeliveries struct {
+	datastore.WebhookRepository
+	webhook    entity.Webhook
+	deliveries []entity.WebhookDelivery
+}
+
+func (f *fakeDeliveries) ClaimDeliveries(_ context.Context, now time.Time, limit int, lease time.Duration) ([]entity.WebhookDelivery, error) {
+	var due []entity.WebhookDelivery
+	for i, d := range f.deliveries {
+		if len(due) == limit || d.Status != entity.DeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		leased := now.Add(lease)
+		f.deliveries[i].NextAttemptAt = &leased
+		due = append(due, d)
+	}
+	return due, nil
+}
+
+func (f *fakeDeliveries) WebhookByID(_ context.Context, id string) (entity.Webhook, error) {
+	if id != f.webhook.ID {
+		return entity.Webhook{}, datastore.ErrWebhookNotFound
+	}
+	return f.webhook, nil
+}
+
+func (f *fakeDeliveries) RecordAttempt(_ context.Context, delivery entity.WebhookDelivery) error {
+	for i, d := range f.deliveries {
+		if d.ID == delivery.ID {
+			f.deliveries[i] = delivery
+		}
+	}
+	return nil
+}
+
+func TestWebhookDispatcherDeliverDue(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	fail := true
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	sealer, err := auth.NewSealer([]byte("session secret"), auth.PurposeWebhookSecrets)
+	require.NoError(t, err)
+	secret, err := sealer.Seal("whsec_test")
+	require.NoError(t, err)
+	repo := &fakeDeliveries{
+		webhook: entity.Webhook{ID: "1", URL: srv.URL, Secret: secret},
+		deliveries: []entity.WebhookDelivery{{
+			ID: "5", WebhookID: "1", EventID: "7", EventType: entity.EventUserCreated,
+			Status: entity.DeliveryPending, NextAttemptAt: &now, Payload: []byte(`{"id":"7"}`),
+		}},
+	}
+	wd, err := NewWebhookDispatcher(WithDispatcherRepository(repo, nil), WithDispatcherSealer(sealer, nil),
+		WithDispatcherAttempts(3))
+	require.NoError(t, err)
+	wd.now = func() time.Time { return now }
+
+	// the first failure waits retryBase, the second twice as long
+	assert.Equal(t, 0, wd.DeliverDue(context.Background()))
+	d := repo.deliveries[0]
+	assert.Equal(t, entity.DeliveryPending, d.Status)
+	assert.Equal(t, 1, d.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, d.LastStatusCode)
+	assert.Equal(t, now.Add(retryBase), *d.NextAttemptAt)
+	assert.Equal(t, 0, wd.DeliverDue(context.Background()), "not due yet")
+	assert.Equal(t, 1, repo.deliveries[0].Attempts)
+
+	now = now.Add(retryBase)
+	wd.DeliverDue(context.Background())
+	assert.Equal(t, now.Add(2*retryBase), *repo.deliveries[0].NextAttemptAt)
+
+	// out of attempts
+	now = now.Add(2 * retryBase)
+	wd.DeliverDue(context.Background())
+	d = repo.deliveries[0]
+	assert.Equal(t, entity.DeliveryDead, d.Status)
+	assert.Equal(t, 3, d.Attempts)
+	assert.Nil(t, d.NextAttemptAt)
+
+	// replayed
+	fail = false
+	repo.deliveries[0].Status, repo.deliveries[0].Attempts, repo.deliveries[0].NextAttemptAt = entity.DeliveryPending, 0, &now
+	assert.Equal(t, 1, wd.DeliverDue(context.Background()))
+	d = repo.deliveries[0]
+	assert.Equal(t, entity.DeliverySucceeded, d.Status)
+	assert.Equal(t, now, *d.DeliveredAt)
+	assert.Empty(t, d.LastError)
+
+	assert.Equal(t, "5", got.Header.Get(events.HeaderWebhookID))
+	assert.Equal(t, "7", got.Header.Get(events.HeaderEventID))
+	assert.Equal(t, "UserCreated", got.Header.Get(events.HeaderEventType))
+	assert.JSONEq(t, `{"id":"7"}`, string(body))
+	assert.NoError(t, events.Verify("whsec_test", got.Header.Get(events.HeaderWebhookTimestamp),
+		got.Header.Get(events.HeaderWebhookSignature), body, time.Minute, now))
+
+	// a secret sealed with another key fails the attempts until it is rotated
+	other, err := auth.NewSealer([]byte("another secret"), auth.PurposeWebhookSecrets)
+	require.NoError(t, err)
+	repo.webhook.Secret, err = other.Seal("whsec_test")
+	require.NoError(t, err)
+	repo.deliveries[0].Status, repo.deliveries[0].Attempts, repo.deliveries[0].NextAttemptAt = entity.DeliveryPending, 0, &now
+	assert.Equal(t, 0, wd.DeliverDue(context.Background()))
+	assert.Equal(t, 1, repo.deliveries[0].Attempts)
+	assert.Contains(t, repo.deliveries[0].LastError, auth.ErrInvalidSealing.Error())
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, retryBase, retryDelay(1))
+	assert.Equal(t, 4*retryBase, retryDelay(3))
+	assert.Equal(t, retryMax, retryDelay(20))
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ellis90/assessment-bg/auth"
+	"github.com/ellis90/assessment-bg/datastore"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/ellis90/assessment-bg/openapi"
+	"github.com/ellis90/assessment-bg/utils/custom_slog"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWebhooks keeps webhooks and their deliveries, the dead deliveries are
+// replayed like the datastore does
+type fakeWebhooks struct {
+	webhooks   map[string]entity.Webhook
+	deliveries []entity.WebhookDelivery
+	nextID     int
+}
+
+func (f *fakeWebhooks) CreateWebhook(_ context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	f.nextID++
+	webhook.ID = strconv.Itoa(f.nextID)
+	webhook.CreatedAt = time.Now()
+	f.webhooks[webhook.ID] = webhook
+	return webhook, nil
+}
+
+func (f *fakeWebhooks) Webhooks(context.Context) ([]entity.Webhook, error) {
+	out := make([]entity.Webhook, 0, len(f.webhooks))
+	for _, w := range f.webhooks {
+		out = append(out, w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (f *fakeWebhooks) WebhookByID(_ context.Context, id string) (entity.Webhook, error) {
+	w, ok := f.webhooks[id]
+	if !ok {
+		return entity.Webhook{}, datastore.ErrWebhookNotFound
+	}
+	return w, nil
+}
+
+func (f *fakeWebhooks) UpdateWebhook(_ context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	w, ok := f.webhooks[webhook.ID]
+	if !ok {
+		return entity.Webhook{}, datastore.ErrWebhookNotFound
+	}
+	w.URL, w.EventTypes = webhook.URL, webhook.EventTypes
+	f.webhooks[w.ID] = w
+	return w, nil
+}
+
+func (f *fakeWebhooks) RotateWebhookSecret(_ context.Context, id, secret string) (entity.Webhook, error) {
+	w, ok := f.webhooks[id]
+	if !ok {
+		return entity.Webhook{}, datastore.ErrWebhookNotFound
+	}
+	w.Secret = secret
+	f.webhooks[id] = w
+	return w, nil
+}
+
+func (f *fakeWebhooks) SealWebhookSecrets(_ context.Context, seal func(string) (string, error)) (int, error) {
+	n := 0
+	for id, w := range f.webhooks {
+		if !strings.HasPrefix(w.Secret, entity.WebhookSecretPrefix) {
+			continue
+		}
+		sealed, err := seal(w.Secret)
+		if err != nil {
+			return n, err
+		}
+		w.Secret = sealed
+		f.webhooks[id] = w
+		n++
+	}
+	return n, nil
+}
+
+func (f *fakeWebhooks) DeleteWebhook(_ context.Context, id string) error {
+	if _, ok := f.webhooks[id]; !ok {
+		return datastore.ErrWebhookNotFound
+	}
+	delete(f.webhooks, id)
+	return nil
+}
+
+func (f *fakeWebhooks) EnqueueDeliveries(_ context.Context, event entity.Event) error {
+	for _, w := range f.webhooks {
+		if w.Subscribes(event.Type) {
+			f.deliveries = append(f.deliveries, entity.WebhookDelivery{
+				ID: strconv.Itoa(len(f.deliveries) + 1), WebhookID: w.ID, EventID: event.ID,
+				EventType: event.Type, Status: entity.DeliveryPending, CreatedAt: time.Now(),
+			})
+		}
+	}
+	return nil
+}
+
+func (f *fakeWebhooks) ClaimDeliveries(context.Context, time.Time, int, time.Duration) ([]entity.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (f *fakeWebhooks) RecordAttempt(context.Context, entity.WebhookDelivery) error {
+	return nil
+}
+
+func (f *fakeWebhooks) WebhookDeliveries(_ context.Context, webhookID string, status entity.DeliveryStatus) ([]entity.WebhookDelivery, error) {
+	if _, ok := f.webhooks[webhookID]; !ok {
+		return nil, datastore.ErrWebhookNotFound
+	}
+	out := make([]entity.WebhookDelivery, 0)
+	for i := len(f.deliveries) - 1; i >= 0; i-- {
+		d := f.deliveries[i]
+		if d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeWebhooks) ReplayDeliveries(_ context.Context, webhookID, deliveryID string) (int, error) {
+	if _, ok := f.webhooks[webhookID]; !ok {
+		return 0, datastore.ErrWebhookNotFound
+	}
+	n := 0
+	for i, d := range f.deliveries {
+		if d.WebhookID == webhookID && d.Status == entity.DeliveryDead && (deliveryID == "" || d.ID == deliveryID) {
+			f.deliveries[i].Status, f.deliveries[i].Attempts = entity.DeliveryPending, 0
+			n++
+		}
+	}
+	if n == 0 && deliveryID != "" {
+		return 0, datastore.ErrDeliveryNotFound
+	}
+	return n, nil
+}
+
+func TestWebhooks(t *testing.T) {
+	hooks := &fakeWebhooks{webhooks: map[string]entity.Webhook{}}
+	sealer, err := auth.NewSealer([]byte("session secret"), auth.PurposeWebhookSecrets)
+	require.NoError(t, err)
+	ws, err := NewWebhookServices(WithWebhookRepository(hooks, nil), WithWebhookSealer(sealer, nil))
+	require.NoError(t, err)
+	// the responses are checked against the openapi document
+	var logged bytes.Buffer
+	validate, err := openapi.NewValidator(openapi.ValidationConfig{
+		Requests: true, Responses: true, Logger: custom_slog.New(&logged, slog.LevelDebug, nil),
+	})
+	require.NoError(t, err)
+	ops := auth.Principal{Subject: "ops", Permissions: []entity.Permission{entity.PermWebhooksManage}}
+	call := func(h echo.HandlerFunc, method, target, body string, params ...string) *httptest.ResponseRecorder {
+		return serve(validate(h), ops, method, target, body, params...)
+	}
+	var created struct {
+		Data struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	rec := call(ws.Create, http.MethodPost, "/v2/admin/webhooks", `{"url":"https://example.com/hooks","eventTypes":["UserCreated","UserDeleted"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Data.Secret, "whsec_"))
+	assert.NotContains(t, hooks.webhooks["1"].Secret, "whsec_", "the secret is stored sealed")
+	opened, err := sealer.Open(hooks.webhooks["1"].Secret)
+	require.NoError(t, err)
+	assert.Equal(t, created.Data.Secret, opened)
+	assert.Equal(t, "ops", hooks.webhooks["1"].CreatedBy)
+
+	rec = call(ws.Get, http.MethodGet, "/v2/admin/webhooks/1", "", "id", "1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NotContains(t, rec.Body.String(), "whsec_", "the secret is only answered when it is generated")
+
+	testCase := []struct {
+		name    string
+		handler func(*WebhookService, echo.Context) error
+		method  string
+		target  string
+		params  []string
+		body    string
+		status  int
+	}{
+		{name: "needs a url", handler: (*WebhookService).Create, method: http.MethodPost, target: "/v2/admin/webhooks", body: `{"eventTypes":["UserCreated"]}`, status: http.StatusBadRequest},
+		{name: "only http urls", handler: (*WebhookService).Create, method: http.MethodPost, target: "/v2/admin/webhooks", body: `{"url":"ftp://example.com"}`, status: http.StatusBadRequest},
+		{name: "unknown event type", handler: (*WebhookService).Create, method: http.MethodPost, target: "/v2/admin/webhooks", body: `{"url":"https://example.com","eventTypes":["UserRenamed"]}`, status: http.StatusBadRequest},
+		{name: "unknown webhook", handler: (*WebhookService).Get, method: http.MethodGet, target: "/v2/admin/webhooks/9", params: []string{"id", "9"}, status: http.StatusNotFound},
+		{name: "unknown status", handler: (*WebhookService).Deliveries, method: http.MethodGet, target: "/v2/admin/webhooks/1/deliveries?status=lost", params: []string{"id", "1"}, status: http.StatusBadRequest},
+		{name: "unknown delivery", handler: (*WebhookService).Replay, method: http.MethodPost, target: "/v2/admin/webhooks/1/deliveries/9/replay", params: []string{"id", "1", "deliveryId", "9"}, status: http.StatusNotFound},
+		{name: "updates", handler: (*WebhookService).Update, method: http.MethodPut, target: "/v2/admin/webhooks/1", params: []string{"id", "1"}, body: `{"url":"https://example.com/v2","eventTypes":["UserCreated"]}`, status: http.StatusOK},
+	}
+	for _, tc := range testCase {
+		h := func(c echo.Context) error { return tc.handler(ws, c) }
+		rec := call(h, tc.method, tc.target, tc.body, tc.params...)
+		assert.Equal(t, tc.status, rec.Code, "%s: %s", tc.name, rec.Body.String())
+	}
+	assert.Len(t, hooks.webhooks, 1, "rejected bodies create nothing")
+	assert.Equal(t, "https://example.com/v2", hooks.webhooks["1"].URL)
+
+	// only the subscribed events are delivered
+	for i, typ := range []entity.EventType{entity.EventUserCreated, entity.EventUserUpdated, entity.EventUserCreated} {
+		require.NoError(t, ws.Publish(context.Background(), entity.Event{ID: strconv.Itoa(i + 1), Type: typ}))
+	}
+	require.Len(t, hooks.deliveries, 2)
+	hooks.deliveries[0].Status, hooks.deliveries[0].Attempts = entity.DeliveryDead, 8
+	hooks.deliveries[0].LastStatusCode, hooks.deliveries[0].LastError = 503, "answered with 503 Service Unavailable"
+
+	rec = call(ws.Deliveries, http.MethodGet, "/v2/admin/webhooks/1/deliveries?status=dead", "", "id", "1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"lastStatusCode":503`)
+	assert.NotContains(t, rec.Body.String(), `"eventId":"3"`)
+
+	rec = call(ws.Replay, http.MethodPost, "/v2/admin/webhooks/1/deliveries/2/replay", "", "id", "1", "deliveryId", "2")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "only dead deliveries are replayed")
+	rec = call(ws.Replay, http.MethodPost, "/v2/admin/webhooks/1/deliveries/replay", "", "id", "1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"replayed":1`)
+	assert.Equal(t, entity.DeliveryPending, hooks.deliveries[0].Status)
+	assert.Zero(t, hooks.deliveries[0].Attempts)
+
+	rec = call(ws.RotateSecret, http.MethodPost, "/v2/admin/webhooks/1/rotate-secret", "", "id", "1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NotContains(t, rec.Body.String(), created.Data.Secret)
+	opened, err = sealer.Open(hooks.webhooks["1"].Secret)
+	require.NoError(t, err)
+	assert.NotEqual(t, created.Data.Secret, opened)
+
+	// the secrets stored before they were sealed are sealed once
+	hooks.webhooks["2"] = entity.Webhook{ID: "2", URL: "https://example.com/old", Secret: "whsec_old"}
+	require.NoError(t, ws.SealSecrets(context.Background()))
+	opened, err = sealer.Open(hooks.webhooks["2"].Secret)
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_old", opened)
+	sealed := hooks.webhooks["2"].Secret
+	require.NoError(t, ws.SealSecrets(context.Background()))
+	assert.Equal(t, sealed, hooks.webhooks["2"].Secret)
+
+	require.Equal(t, http.StatusOK, call(ws.Delete, http.MethodDelete, "/v2/admin/webhooks/1", "", "id", "1").Code)
+	assert.Equal(t, http.StatusNotFound, call(ws.Deliveries, http.MethodGet, "/v2/admin/webhooks/1/deliveries", "", "id", "1").Code)
+	assert.NotContains(t, logged.String(), "response does not match")
+}
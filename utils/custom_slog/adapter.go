@@ -6,6 +6,7 @@ import (
 	"golang.org/x/exp/slog"
 )
 
+// Logger adapts a slog.Logger to the pgx.Logger interface
 type Logger struct {
 	l *slog.Logger
 }
@@ -15,25 +16,24 @@ func NewLogger(l *slog.Logger) *Logger {
 }
 
 func (l *Logger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]any) {
-	logArrs := make([]slog.Attr, 0, len(data))
+	logAttrs := make([]slog.Attr, 0, len(data))
 
 	for k, v := range data {
-		logArrs = append(logArrs, slog.Any(k, v))
+		logAttrs = append(logAttrs, slog.Any(k, v))
 	}
 
-	log := l.l.With(logArrs)
-
 	switch level {
 	case pgx.LogLevelTrace:
-		log.Debug("PGX_LOG_LEVEL")
+		l.l.LogAttrs(ctx, slog.LevelDebug, msg, append(logAttrs, slog.String("PGX_LOG_LEVEL", level.String()))...)
 	case pgx.LogLevelDebug:
-		log.Debug(msg)
+		l.l.LogAttrs(ctx, slog.LevelDebug, msg, logAttrs...)
 	case pgx.LogLevelInfo:
-		log.Info(msg)
+		l.l.LogAttrs(ctx, slog.LevelInfo, msg, logAttrs...)
 	case pgx.LogLevelWarn:
-		log.With(msg)
+		l.l.LogAttrs(ctx, slog.LevelWarn, msg, logAttrs...)
 	case pgx.LogLevelError:
-		log.Error(msg)
+		l.l.LogAttrs(ctx, slog.LevelError, msg, logAttrs...)
 	default:
+		l.l.LogAttrs(ctx, slog.LevelError, msg, append(logAttrs, slog.String("INVALID_PGX_LOG_LEVEL", level.String()))...)
 	}
 }
@@ -0,0 +1,90 @@
+package custom_slog
+
+import (
+	"context"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	RequestIDKey = "request_id"
+	RouteKey     = "route"
+	UserIDKey    = "user_id"
+	PrincipalKey = "principal"
+)
+
+// Fields are the request scoped values added to every log line
+type Fields struct {
+	RequestID string
+	Route     string
+	UserID    string
+	Principal string
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx carrying f
+func WithFields(ctx context.Context, f Fields) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithUserID returns a copy of ctx whose fields carry the given user id
+func WithUserID(ctx context.Context, id string) context.Context {
+	f := FieldsFromContext(ctx)
+	f.UserID = id
+	return WithFields(ctx, f)
+}
+
+// WithPrincipal returns a copy of ctx whose fields carry the authenticated caller
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	f := FieldsFromContext(ctx)
+	f.Principal = principal
+	return WithFields(ctx, f)
+}
+
+// FieldsFromContext returns the fields stored in ctx, if any
+func FieldsFromContext(ctx context.Context) Fields {
+	if ctx == nil {
+		return Fields{}
+	}
+	f, _ := ctx.Value(fieldsKey{}).(Fields)
+	return f
+}
+
+func (f Fields) attrs() []slog.Attr {
+	attrs := make([]slog.Attr, 0, 4)
+	if f.RequestID != "" {
+		attrs = append(attrs, slog.String(RequestIDKey, f.RequestID))
+	}
+	if f.Route != "" {
+		attrs = append(attrs, slog.String(RouteKey, f.Route))
+	}
+	if f.UserID != "" {
+		attrs = append(attrs, slog.String(UserIDKey, f.UserID))
+	}
+	if f.Principal != "" {
+		attrs = append(attrs, slog.String(PrincipalKey, f.Principal))
+	}
+	return attrs
+}
+
+// ContextHandler decorates a slog.Handler with the Fields of the record context
+type ContextHandler struct {
+	slog.Handler
+}
+
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(FieldsFromContext(ctx).attrs()...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
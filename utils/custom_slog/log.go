@@ -2,12 +2,23 @@ package custom_slog
 
 import (
 	"golang.org/x/exp/slog"
+	"io"
 	"os"
 )
 
-func StructuredLog() *slog.Logger {
-	jsonHandler := slog.NewJSONHandler(os.Stdout).
+// StructuredLog is the application logger writing json to stdout,
+// with the redaction configured for the environment
+func StructuredLog() (*slog.Logger, error) {
+	r, err := RedactorFromEnv()
+	return New(os.Stdout, slog.LevelInfo, r), err
+}
+
+// New returns a json logger writing to w that redacts every attribute
+// with r and enriches every record with the request Fields of its context
+func New(w io.Writer, level slog.Leveler, r *Redactor) *slog.Logger {
+	jsonHandler := slog.HandlerOptions{Level: level}.NewJSONHandler(w)
+	handler := NewRedactHandler(jsonHandler, r).
 		WithAttrs([]slog.Attr{slog.String("app-version", "v0.0.1-beta")})
-	logger := slog.New(jsonHandler)
+	logger := slog.New(NewContextHandler(handler))
 	return logger
 }
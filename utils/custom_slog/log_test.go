@@ -0,0 +1,68 @@
+package custom_slog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+	"testing"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		line := make(map[string]any)
+		if !assert.NoError(t, dec.Decode(&line)) {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestContextFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := New(buf, slog.LevelDebug, &Redactor{Mode: PIIPlain})
+	ctx := WithFields(context.Background(), Fields{RequestID: "req-1", Route: "/user/:id"})
+	ctx = WithUserID(ctx, "42")
+
+	logger.InfoCtx(ctx, "with fields")
+	logger.Info("without fields")
+
+	lines := decodeLines(t, buf)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "req-1", lines[0][RequestIDKey])
+		assert.Equal(t, "/user/:id", lines[0][RouteKey])
+		assert.Equal(t, "42", lines[0][UserIDKey])
+		assert.NotContains(t, lines[1], RequestIDKey)
+	}
+}
+
+func TestPGXAdapterLevels(t *testing.T) {
+	testCase := []struct {
+		name  string
+		level pgx.LogLevel
+		want  string
+	}{
+		{name: "debug", level: pgx.LogLevelDebug, want: "DEBUG"},
+		{name: "info", level: pgx.LogLevelInfo, want: "INFO"},
+		{name: "warn", level: pgx.LogLevelWarn, want: "WARN"},
+		{name: "error", level: pgx.LogLevelError, want: "ERROR"},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			NewLogger(New(buf, slog.LevelDebug, &Redactor{Mode: PIIPlain})).Log(context.Background(), tc.level, "query", map[string]any{"sql": "select 1"})
+
+			lines := decodeLines(t, buf)
+			if assert.Len(t, lines, 1) {
+				assert.Equal(t, tc.want, lines[0]["level"])
+				assert.Equal(t, "query", lines[0]["msg"])
+				assert.Equal(t, "select 1", lines[0]["sql"])
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package custom_slog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/exp/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// PIIMode tells the Redactor what to do with personal data
+type PIIMode string
+
+const (
+	PIIMask  PIIMode = "mask"
+	PIIHash  PIIMode = "hash"
+	PIIPlain PIIMode = "plain"
+
+	Redacted = "[REDACTED]"
+
+	// maxDepth bounds the walk of nested values, deeper ones are redacted
+	maxDepth = 8
+)
+
+var (
+	ErrInvalidPIIMode = errors.New("invalid pii redaction mode")
+	ErrMissingHashKey = errors.New("the hash pii redaction mode needs LOG_PII_HASH_KEY")
+
+	// secretKeys are never written to the logs whatever the PIIMode
+	secretKeys = map[string]bool{
+		"password":       true,
+		"pass":           true,
+		"db_password":    true,
+		"secret":         true,
+		"session_secret": true,
+		"token":          true,
+		"authorization":  true,
+		"api_key":        true,
+		"x-api-key":      true,
+		"cookie":         true,
+	}
+	// piiKeys hold personal data of entity.User and the pgx query arguments
+	piiKeys = map[string]bool{
+		"username":   true,
+		"user_name":  true,
+		"firstname":  true,
+		"first_name": true,
+		"lastname":   true,
+		"last_name":  true,
+		"email":      true,
+		"args":       true,
+	}
+
+	emailRegex  = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+	kvPassRegex = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+	urlRegex    = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s"]+`)
+)
+
+// ParsePIIMode parses the mode name, the empty string defaults to PIIMask
+func ParsePIIMode(s string) (PIIMode, error) {
+	switch PIIMode(strings.ToLower(s)) {
+	case "", PIIMask:
+		return PIIMask, nil
+	case PIIHash:
+		return PIIHash, nil
+	case PIIPlain:
+		return PIIPlain, nil
+	default:
+		return PIIMask, fmt.Errorf("%w: %q", ErrInvalidPIIMode, s)
+	}
+}
+
+// Redactor masks secrets and personal data in log attributes.
+// Secrets are always masked, PII according to Mode.
+type Redactor struct {
+	Mode PIIMode
+	// HashKey keys the HMAC of PIIHash so the hashes can't be reversed by
+	// hashing guesses
+	HashKey []byte
+}
+
+// RedactorFromEnv reads LOG_PII_MODE, falling back to plain output when
+// APP_ENV is development and to masking everywhere else. The hash mode
+// reads its key from LOG_PII_HASH_KEY and falls back to masking without it.
+func RedactorFromEnv() (*Redactor, error) {
+	mode := os.Getenv("LOG_PII_MODE")
+	if mode == "" && os.Getenv("APP_ENV") == "development" {
+		mode = string(PIIPlain)
+	}
+	m, err := ParsePIIMode(mode)
+	if err != nil {
+		return &Redactor{Mode: m}, err
+	}
+	key := os.Getenv("LOG_PII_HASH_KEY")
+	if m == PIIHash && key == "" {
+		return &Redactor{Mode: PIIMask}, ErrMissingHashKey
+	}
+	return &Redactor{Mode: m, HashKey: []byte(key)}, nil
+}
+
+// Attr returns a redacted copy of a
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	v := a.Value.Resolve()
+
+	if secretKeys[key] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = r.Attr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	case slog.KindString:
+		return slog.String(a.Key, r.value(key, v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case nil:
+			return a
+		case error:
+			return slog.String(a.Key, r.value(key, x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, r.value(key, x.String()))
+		default:
+			return slog.Any(a.Key, r.any(key, x, 0))
+		}
+	default:
+		return a
+	}
+}
+
+// any redacts the slices, maps and structs logged under key, the LogValuers
+// they hold are resolved and the fields are redacted by their json names
+func (r *Redactor) any(key string, x any, depth int) any {
+	if secretKeys[key] {
+		return Redacted
+	}
+	if depth > maxDepth {
+		return Redacted
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil
+	case slog.LogValuer:
+		return r.data(key, slog.AnyValue(x).Resolve(), depth+1)
+	case error:
+		return r.value(key, x.Error())
+	case string:
+		return r.value(key, x)
+	case json.RawMessage:
+		return r.value(key, string(x))
+	}
+	// structs with exported fields are walked even when they marshal or
+	// print themselves, their own forms would not be redacted
+	if !hasFields(x) {
+		switch x := x.(type) {
+		case json.Marshaler, encoding.TextMarshaler:
+			if piiKeys[key] && r.Mode != PIIPlain {
+				return Redacted
+			}
+			return x
+		case fmt.Stringer:
+			return r.value(key, x.String())
+		}
+	}
+	rv := reflect.ValueOf(x)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return r.any(key, rv.Elem().Interface(), depth+1)
+	case reflect.String:
+		return r.value(key, rv.String())
+	}
+	// the personal data logged whole, like the query arguments, stays out
+	if piiKeys[key] && r.Mode != PIIPlain {
+		return Redacted
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return x
+		}
+		out := make([]any, rv.Len())
+		for i := range out {
+			out[i] = r.any(key, rv.Index(i).Interface(), depth+1)
+		}
+		return out
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		out := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			out[k] = r.any(strings.ToLower(k), iter.Value().Interface(), depth+1)
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]any, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			f := rv.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			out[name] = r.any(strings.ToLower(name), rv.Field(i).Interface(), depth+1)
+		}
+		return out
+	default:
+		return x
+	}
+}
+
+// hasFields reports whether x is a struct, or points to one, with exported fields
+func hasFields(x any) bool {
+	v := reflect.ValueOf(x)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// data returns the plain value of a resolved v, groups become maps
+func (r *Redactor) data(key string, v slog.Value, depth int) any {
+	switch v.Kind() {
+	case slog.KindGroup:
+		out := make(map[string]any, len(v.Group()))
+		for _, ga := range v.Group() {
+			out[ga.Key] = r.data(strings.ToLower(ga.Key), ga.Value.Resolve(), depth+1)
+		}
+		return out
+	case slog.KindAny:
+		return r.any(key, v.Any(), depth)
+	case slog.KindString:
+		if secretKeys[key] {
+			return Redacted
+		}
+		return r.value(key, v.String())
+	default:
+		if secretKeys[key] {
+			return Redacted
+		}
+		return v.Any()
+	}
+}
+
+func (r *Redactor) value(key, s string) string {
+	s = RedactSecrets(s)
+	if r.Mode == PIIPlain {
+		return s
+	}
+	if piiKeys[key] {
+		return r.pii(s)
+	}
+	return emailRegex.ReplaceAllStringFunc(s, r.pii)
+}
+
+func (r *Redactor) pii(s string) string {
+	if s == "" {
+		return s
+	}
+	switch r.Mode {
+	case PIIHash:
+		mac := hmac.New(sha256.New, r.HashKey)
+		mac.Write([]byte(s))
+		return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+	case PIIPlain:
+		return s
+	default:
+		return mask(s)
+	}
+}
+
+// mask keeps the first rune of the local part and the domain of emails
+func mask(s string) string {
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		return string([]rune(s[:at])[:1]) + "***" + s[at:]
+	}
+	return string([]rune(s)[:1]) + "***"
+}
+
+// RedactSecrets masks the passwords of urls and key=value connection strings in s
+func RedactSecrets(s string) string {
+	s = urlRegex.ReplaceAllStringFunc(s, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return raw
+		}
+		return u.Redacted()
+	})
+	return kvPassRegex.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// RedactHandler applies a Redactor to every attribute before handing the
+// record to the wrapped handler
+type RedactHandler struct {
+	handler  slog.Handler
+	redactor *Redactor
+}
+
+func NewRedactHandler(h slog.Handler, r *Redactor) *RedactHandler {
+	if r == nil {
+		r = &Redactor{Mode: PIIMask}
+	}
+	return &RedactHandler{handler: h, redactor: r}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) {
+		out.AddAttrs(h.redactor.Attr(a))
+	})
+	return h.handler.Handle(ctx, out)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = h.redactor.Attr(a)
+	}
+	return &RedactHandler{handler: h.handler.WithAttrs(out), redactor: h.redactor}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{handler: h.handler.WithGroup(name), redactor: h.redactor}
+}
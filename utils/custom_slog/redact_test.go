@@ -0,0 +1,185 @@
+package custom_slog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ellis90/assessment-bg/datastore/model"
+	"github.com/ellis90/assessment-bg/entity"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+	"testing"
+	"time"
+)
+
+const (
+	dbPassword = "s3cr3t-db-pass"
+	email      = "john.peter@example.com"
+)
+
+func testUser() entity.User {
+	return entity.User{
+		ID:         "1",
+		UserName:   "willi",
+		FirstName:  "john",
+		LastName:   "peter",
+		Email:      email,
+		Department: "computer",
+		UserStatus: entity.Active,
+	}
+}
+
+func logEverything(logger *slog.Logger) {
+	dsn := "postgres://root:" + dbPassword + "@integra_db:5432/integra_db?sslmode=disable"
+	logger.Info("connecting to database", slog.String("dsn", dsn))
+	logger.Info("key value dsn", slog.String("conn", "host=db user=root password="+dbPassword+" dbname=x"))
+	logger.Info("secret key", slog.String("DB_PASSWORD", dbPassword), slog.String("session_secret", dbPassword))
+	logger.Error("failed to connect", slog.Any("error", errors.New("dial "+dsn+": refused")))
+	logger.Info("user", slog.Any("user", testUser()))
+	logger.Info("users", slog.Any("users", []entity.User{testUser()}))
+	logger.Info("payload", slog.Any("payload", map[string]any{
+		"owner": struct {
+			Contact  string
+			Password string `json:"password"`
+		}{email, dbPassword},
+	}))
+	logger.With(slog.String("email", email)).Info("with attrs")
+	logger.Error("duplicate", slog.Any("error", errors.New(`duplicate key (email)=(`+email+`) already exists`)))
+	NewLogger(logger).Log(context.Background(), pgx.LogLevelInfo, "Exec", map[string]any{
+		"sql":  "insert into users (email) values ($1)",
+		"args": []any{email},
+	})
+}
+
+func TestSecretsNeverReachOutput(t *testing.T) {
+	for _, mode := range []PIIMode{PIIMask, PIIHash, PIIPlain} {
+		t.Run(string(mode), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			logEverything(New(buf, slog.LevelDebug, &Redactor{Mode: mode}))
+
+			out := buf.String()
+			assert.NotContains(t, out, dbPassword)
+			assert.Contains(t, out, "integra_db:5432")
+		})
+	}
+}
+
+func TestPIIRedaction(t *testing.T) {
+	testCase := []struct {
+		name    string
+		mode    PIIMode
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "mask",
+			mode:    PIIMask,
+			present: []string{"j***@example.com", "w***", "computer"},
+			absent:  []string{email, "willi", "peter"},
+		},
+		{
+			name:    "hash",
+			mode:    PIIHash,
+			present: []string{"hmac-sha256:", "computer"},
+			absent:  []string{email, "willi", "peter", "@example.com"},
+		},
+		{
+			name:    "plain",
+			mode:    PIIPlain,
+			present: []string{email, "willi", "peter"},
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			logEverything(New(buf, slog.LevelDebug, &Redactor{Mode: tc.mode}))
+
+			out := buf.String()
+			for _, s := range tc.present {
+				assert.Contains(t, out, s)
+			}
+			for _, s := range tc.absent {
+				assert.NotContains(t, out, s)
+			}
+		})
+	}
+}
+
+func TestHashIsStable(t *testing.T) {
+	r := &Redactor{Mode: PIIHash, HashKey: []byte("key")}
+	a := r.Attr(slog.String("email", email))
+	b := r.Attr(slog.String("email", email))
+	assert.Equal(t, a.Value.String(), b.Value.String())
+	assert.NotEqual(t, a.Value.String(), r.Attr(slog.String("email", "other@example.com")).Value.String())
+
+	other := &Redactor{Mode: PIIHash, HashKey: []byte("other key")}
+	assert.NotEqual(t, a.Value.String(), other.Attr(slog.String("email", email)).Value.String())
+}
+
+func TestNestedValues(t *testing.T) {
+	r := &Redactor{Mode: PIIMask}
+	users := r.Attr(slog.Any("users", []entity.User{testUser()})).Value.Any()
+	assert.Equal(t, []any{map[string]any{
+		"id":            "1",
+		"userName":      "w***",
+		"firstName":     "j***",
+		"lastName":      "p***",
+		"email":         "j***@example.com",
+		"department":    "computer",
+		"userStatus":    "active",
+		"emailVerified": false,
+	}}, users)
+
+	byID := r.Attr(slog.Any("byId", map[string]*entity.User{"1": {Email: email}})).Value.Any()
+	assert.Equal(t, "j***@example.com", byID.(map[string]any)["1"].(map[string]any)["email"])
+
+	args := r.Attr(slog.Any("args", []any{email, 1})).Value.Any()
+	assert.Equal(t, Redacted, args)
+}
+
+func TestMarshalersWithFields(t *testing.T) {
+	u := testUser()
+	cus := model.AddCustomer(&u)
+	for _, v := range []any{cus.GetExportedCustomer(), &model.ExportCustomers{cus.GetExportedCustomer()}} {
+		buf := new(bytes.Buffer)
+		New(buf, slog.LevelDebug, &Redactor{Mode: PIIMask}).Info("user", slog.Any("payload", v))
+		assert.NotContains(t, buf.String(), email)
+		assert.Contains(t, buf.String(), "j***@example.com")
+	}
+
+	// structs without exported fields keep their own form
+	at := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	got, err := json.Marshal((&Redactor{Mode: PIIMask}).Attr(slog.Any("at", struct{ At time.Time }{at})).Value.Any())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"At":"2026-06-01T00:00:00Z"}`, string(got))
+}
+
+func TestRedactorFromEnv(t *testing.T) {
+	t.Setenv("LOG_PII_MODE", "hash")
+	t.Setenv("LOG_PII_HASH_KEY", "")
+	r, err := RedactorFromEnv()
+	assert.ErrorIs(t, err, ErrMissingHashKey)
+	assert.Equal(t, PIIMask, r.Mode)
+
+	t.Setenv("LOG_PII_HASH_KEY", "key")
+	r, err = RedactorFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, PIIHash, r.Mode)
+	assert.Equal(t, []byte("key"), r.HashKey)
+}
+
+func TestParsePIIMode(t *testing.T) {
+	m, err := ParsePIIMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, PIIMask, m)
+
+	m, err = ParsePIIMode("HASH")
+	assert.NoError(t, err)
+	assert.Equal(t, PIIHash, m)
+
+	m, err = ParsePIIMode("clear")
+	assert.ErrorIs(t, err, ErrInvalidPIIMode)
+	assert.Equal(t, PIIMask, m)
+}
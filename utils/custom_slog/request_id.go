@@ -0,0 +1,23 @@
+package custom_slog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+// validRequestID limits the ids accepted from clients to a safe charset
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,128}$`)
+
+// RequestID returns the id sent by the client when it is valid or a new
+// random one
+func RequestID(client string) string {
+	if validRequestID.MatchString(client) {
+		return client
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/labstack/echo/v4"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// decoders decode the bodies of the media types rendered besides json. They
+// convert the body to json first so it is keyed and validated like json.
+var decoders = map[string]func(body io.Reader, i any) error{
+	echo.MIMEApplicationXML:     decodeXML,
+	echo.MIMETextXML:            decodeXML,
+	MIMEApplicationYAML:         decodeYAML,
+	"application/x-yaml":        decodeYAML,
+	"text/yaml":                 decodeYAML,
+	"text/x-yaml":               decodeYAML,
+	echo.MIMEApplicationMsgpack: decodeMsgpack,
+	"application/x-msgpack":     decodeMsgpack,
+	"application/vnd.msgpack":   decodeMsgpack,
+}
+
+// bind binds the path and query parameters like echo, then decodes the body
+// with the decoder of its Content-Type or echo's for json and forms
+func bind(db *echo.DefaultBinder, i any, c echo.Context) error {
+	req := c.Request()
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	decode := decoders[mediaType]
+	if decode == nil || req.ContentLength == 0 {
+		return db.Bind(i, c)
+	}
+	if err := db.BindPathParams(c, i); err != nil {
+		return err
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodDelete, http.MethodHead:
+		if err := db.BindQueryParams(c, i); err != nil {
+			return err
+		}
+	}
+	return decode(req.Body, i)
+}
+
+func decodeYAML(body io.Reader, i any) error {
+	var v any
+	if err := yaml.NewDecoder(body).Decode(&v); err != nil {
+		return err
+	}
+	return fromPlain(v, i)
+}
+
+func decodeMsgpack(body io.Reader, i any) error {
+	var v any
+	if err := msgpack.NewDecoder(body).Decode(&v); err != nil {
+		return err
+	}
+	return fromPlain(v, i)
+}
+
+func fromPlain(v, i any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, i)
+}
+
+// decodeXML reads the elements rendered by encodeXML: the children of the
+// root element are the fields of i and item elements the items of arrays.
+// XML carries no types, the texts are converted to the types of the fields.
+func decodeXML(body io.Reader, i any) error {
+	dec := xml.NewDecoder(body)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := readXML(dec, start)
+			if err != nil {
+				return err
+			}
+			return fromPlain(coerce(v, reflect.TypeOf(i)), i)
+		}
+	}
+}
+
+// readXML returns the text of an element without children, the items of
+// an element of item elements or the children keyed by name
+func readXML(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	var (
+		text     strings.Builder
+		names    []string
+		children []any
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := readXML(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, t.Name.Local)
+			children = append(children, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) == 0 {
+				return text.String(), nil
+			}
+			if isItems(names) {
+				return children, nil
+			}
+			fields := make(map[string]any, len(children))
+			for i, name := range names {
+				fields[name] = children[i]
+			}
+			return fields, nil
+		}
+	}
+}
+
+func isItems(names []string) bool {
+	for _, name := range names {
+		if name != "item" {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// coerce converts the texts of v to the json types of t, values which do
+// not fit are left for json to reject
+func coerce(v any, t reflect.Type) any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshaler) || reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		return v
+	}
+	text, isText := v.(string)
+	switch t.Kind() {
+	case reflect.Struct:
+		fields, ok := v.(map[string]any)
+		if !ok {
+			return emptyAsNull(v)
+		}
+		for name, ft := range jsonFields(t) {
+			if child, ok := fields[name]; ok {
+				fields[name] = coerce(child, ft)
+			}
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		items, ok := v.([]any)
+		if !ok {
+			if isText && text == "" {
+				return []any{}
+			}
+			return v
+		}
+		for i := range items {
+			items[i] = coerce(items[i], t.Elem())
+		}
+		return items
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(text); isText && err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(text, 64); isText && err == nil {
+			return json.Number(text)
+		}
+	}
+	if isText && t.Kind() != reflect.String && t.Kind() != reflect.Interface {
+		return emptyAsNull(v)
+	}
+	return v
+}
+
+func emptyAsNull(v any) any {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// jsonFields returns the types of the fields of t by json name, embedded
+// structs are promoted and shadowed like encoding/json does
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	var promoted []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			promoted = append(promoted, ft)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	for _, embedded := range promoted {
+		for name, ft := range jsonFields(embedded) {
+			if _, shadowed := fields[name]; !shadowed {
+				fields[name] = ft
+			}
+		}
+	}
+	return fields
+}
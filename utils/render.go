@@ -0,0 +1,402 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// media types echo has no constant for
+const (
+	charsetUTF8                    = "charset=UTF-8"
+	MIMEApplicationYAML            = "application/yaml"
+	MIMEApplicationYAMLCharsetUTF8 = MIMEApplicationYAML + "; " + charsetUTF8
+	MIMETextCSV                    = "text/csv"
+	MIMETextCSVCharsetUTF8         = MIMETextCSV + "; " + charsetUTF8
+)
+
+var ErrNotAcceptable = errors.New("none of the accepted media types can be rendered, use application/json, application/xml, application/yaml, application/msgpack or text/csv for collections")
+
+// renderer encodes responses in one media type, every format but json is
+// converted from the json encoding so they share its field names
+type renderer struct {
+	// mediaTypes are the accepted names, the first is sent as Content-Type
+	mediaTypes []string
+	// collections restricts the renderer to slices, rendered without envelope
+	collections bool
+	render      func(c echo.Context, status int, v any) error
+}
+
+// renderers in order of preference, */* picks the first
+var renderers = []renderer{
+	{
+		mediaTypes: []string{echo.MIMEApplicationJSON},
+		render: func(c echo.Context, status int, v any) error {
+			return c.JSON(status, v)
+		},
+	},
+	{
+		mediaTypes: []string{echo.MIMEApplicationXML, echo.MIMETextXML},
+		render:     blob(echo.MIMEApplicationXMLCharsetUTF8, encodeXML),
+	},
+	{
+		mediaTypes: []string{MIMEApplicationYAML, "application/x-yaml", "text/yaml", "text/x-yaml"},
+		render:     blob(MIMEApplicationYAMLCharsetUTF8, encodeYAML),
+	},
+	{
+		mediaTypes: []string{echo.MIMEApplicationMsgpack, "application/x-msgpack", "application/vnd.msgpack"},
+		render:     blob(echo.MIMEApplicationMsgpack, encodeMsgpack),
+	},
+	{
+		mediaTypes:  []string{MIMETextCSV},
+		collections: true,
+		render:      blob(MIMETextCSVCharsetUTF8, encodeCSV),
+	},
+}
+
+func blob(contentType string, encode func(v any) ([]byte, error)) func(c echo.Context, status int, v any) error {
+	return func(c echo.Context, status int, v any) error {
+		b, err := encode(v)
+		if err != nil {
+			return err
+		}
+		return c.Blob(status, contentType, b)
+	}
+}
+
+// render answers the envelope in the media type negotiated from the Accept
+// header. Failures fall back to json rather than hiding the error behind a
+// 406, successes nothing accepted can render are answered 406.
+func render(c echo.Context, status int, envelope, data any, failed bool) error {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	for _, r := range negotiate(c.Request().Header.Get(echo.HeaderAccept)) {
+		switch {
+		case !r.collections:
+			return r.render(c, status, envelope)
+		case !failed && isCollection(data):
+			return r.render(c, status, data)
+		}
+	}
+	if failed {
+		return c.JSON(status, envelope)
+	}
+	return c.JSON(http.StatusNotAcceptable, newEnvelope(c, "negotiate", http.StatusNotAcceptable, ErrNotAcceptable))
+}
+
+// negotiate returns the renderers matching the media ranges of accept by
+// descending quality, an empty header accepts json
+func negotiate(accept string) []renderer {
+	if strings.TrimSpace(accept) == "" {
+		return renderers[:1]
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	var out []renderer
+	for _, r := range ranges {
+		for _, candidate := range renderers {
+			if candidate.matches(r.mediaType) {
+				out = append(out, candidate)
+			}
+		}
+	}
+	return out
+}
+
+func (r renderer) matches(mediaRange string) bool {
+	wildcard := strings.HasSuffix(mediaRange, "*")
+	prefix := strings.TrimSuffix(strings.TrimSuffix(mediaRange, "*"), "*/")
+	for _, mediaType := range r.mediaTypes {
+		if mediaType == mediaRange || wildcard && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCollection(data any) bool {
+	v := reflect.ValueOf(data)
+	return v.IsValid() && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8
+}
+
+// jsonNode returns the json encoding of v as an ordered tree
+func jsonNode(v any) (*yaml.Node, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return readNode(dec)
+}
+
+func readNode(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if t == '{' {
+			node.Kind, node.Tag = yaml.MappingNode, "!!map"
+		}
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, scalar("!!str", key.(string)))
+			}
+			child, err := readNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		// the closing delimiter
+		_, err := dec.Token()
+		return node, err
+	case json.Number:
+		if strings.ContainsAny(t.String(), ".eE") {
+			return scalar("!!float", t.String()), nil
+		}
+		return scalar("!!int", t.String()), nil
+	case bool:
+		return scalar("!!bool", strconv.FormatBool(t)), nil
+	case string:
+		return scalar("!!str", t), nil
+	default:
+		return scalar("!!null", "null"), nil
+	}
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// plain converts the tree to maps, slices and scalars
+func plain(n *yaml.Node) any {
+	switch n.Kind {
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		for i := 0; i < len(n.Content); i += 2 {
+			m[n.Content[i].Value] = plain(n.Content[i+1])
+		}
+		return m
+	case yaml.SequenceNode:
+		s := make([]any, len(n.Content))
+		for i, item := range n.Content {
+			s[i] = plain(item)
+		}
+		return s
+	}
+	switch n.Tag {
+	case "!!int":
+		if i, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(n.Value, 64)
+		return f
+	case "!!float":
+		f, _ := strconv.ParseFloat(n.Value, 64)
+		return f
+	case "!!bool":
+		return n.Value == "true"
+	case "!!null":
+		return nil
+	}
+	return n.Value
+}
+
+func encodeYAML(v any) ([]byte, error) {
+	node, err := jsonNode(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(node)
+}
+
+func encodeMsgpack(v any) ([]byte, error) {
+	node, err := jsonNode(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	err = enc.Encode(plain(node))
+	return buf.Bytes(), err
+}
+
+// encodeXML renders v in a response element, objects become elements named
+// by their keys and the items of arrays item elements
+func encodeXML(v any) ([]byte, error) {
+	node, err := jsonNode(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	if err := writeXML(enc, "response", node); err != nil {
+		return nil, err
+	}
+	err = enc.Flush()
+	return buf.Bytes(), err
+}
+
+func writeXML(enc *xml.Encoder, name string, n *yaml.Node) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(n.Content); i += 2 {
+			if err := writeXML(enc, n.Content[i].Value, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			if err := writeXML(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	default:
+		if n.Tag != "!!null" {
+			if err := enc.EncodeToken(xml.CharData(n.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlName replaces the characters of key not allowed in element names
+func xmlName(key string) string {
+	name := []rune(key)
+	for i, r := range name {
+		valid := r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			i > 0 && (r == '-' || r == '.' || r >= '0' && r <= '9')
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
+
+// encodeCSV renders a collection with a row per item, nested objects are
+// flattened into dotted columns and arrays are kept as json
+func encodeCSV(v any) ([]byte, error) {
+	node, err := jsonNode(v)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		columns []string
+		index   = make(map[string]int)
+		rows    [][]cell
+	)
+	for _, item := range node.Content {
+		row, err := flatten(nil, "", item)
+		if err != nil {
+			return nil, err
+		}
+		// the columns keep the order of the first item having them
+		for _, cell := range row {
+			if _, ok := index[cell.column]; !ok {
+				index[cell.column] = len(columns)
+				columns = append(columns, cell.column)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for _, cell := range row {
+			record[index[cell.column]] = cell.value
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+type cell struct {
+	column, value string
+}
+
+func flatten(row []cell, prefix string, n *yaml.Node) ([]cell, error) {
+	switch {
+	case n.Kind == yaml.MappingNode:
+		var err error
+		for i := 0; i < len(n.Content) && err == nil; i += 2 {
+			row, err = flatten(row, join(prefix, n.Content[i].Value), n.Content[i+1])
+		}
+		return row, err
+	case n.Kind == yaml.SequenceNode:
+		b, err := json.Marshal(plain(n))
+		return append(row, cell{column: column(prefix), value: string(b)}), err
+	case n.Tag == "!!null":
+		return append(row, cell{column: column(prefix)}), nil
+	}
+	return append(row, cell{column: column(prefix), value: n.Value}), nil
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// column names the column of a collection of scalars "value"
+func column(key string) string {
+	if key == "" {
+		return "value"
+	}
+	return key
+}
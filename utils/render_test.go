@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name" validate:"required"`
+	Status  int      `json:"status"`
+	Admin   bool     `json:"admin"`
+	Scopes  []string `json:"scopes"`
+	Profile struct {
+		Team string `json:"team"`
+	} `json:"profile"`
+}
+
+func newAccount(id, name string) account {
+	a := account{ID: id, Name: name, Status: 1, Scopes: []string{"users:read", "users:write"}}
+	a.Profile.Team = "eng"
+	return a
+}
+
+func TestRender(t *testing.T) {
+	accounts := []account{newAccount("1", "ann"), newAccount("2", "bob, jr")}
+	testCase := []struct {
+		name        string
+		accept      string
+		data        any
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "json by default",
+			data:        newAccount("1", "ann"),
+			status:      http.StatusOK,
+			contentType: echo.MIMEApplicationJSONCharsetUTF8,
+			body:        `{"data":{"id":"1","name":"ann","status":1,"admin":false,"scopes":["users:read","users:write"],"profile":{"team":"eng"}},"message":"successful","status":"OK"}` + "\n",
+		},
+		{
+			name:        "xml",
+			accept:      "application/xml",
+			data:        newAccount("1", "ann"),
+			status:      http.StatusOK,
+			contentType: echo.MIMEApplicationXMLCharsetUTF8,
+			body: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<response><data><id>1</id><name>ann</name><status>1</status><admin>false</admin>` +
+				`<scopes><item>users:read</item><item>users:write</item></scopes><profile><team>eng</team></profile></data>` +
+				`<message>successful</message><status>OK</status></response>`,
+		},
+		{
+			name:        "yaml keeps the field order",
+			accept:      "text/html;q=0.9, application/yaml",
+			data:        newAccount("1", "ann"),
+			status:      http.StatusOK,
+			contentType: MIMEApplicationYAMLCharsetUTF8,
+			body: "data:\n    id: \"1\"\n    name: ann\n    status: 1\n    admin: false\n    scopes:\n        - users:read\n        - users:write\n" +
+				"    profile:\n        team: eng\nmessage: successful\nstatus: OK\n",
+		},
+		{
+			name:        "csv renders collections without envelope",
+			accept:      "text/csv",
+			data:        accounts,
+			status:      http.StatusOK,
+			contentType: MIMETextCSVCharsetUTF8,
+			body: "id,name,status,admin,scopes,profile.team\n" +
+				"1,ann,1,false,\"[\"\"users:read\"\",\"\"users:write\"\"]\",eng\n" +
+				"2,\"bob, jr\",1,false,\"[\"\"users:read\"\",\"\"users:write\"\"]\",eng\n",
+		},
+		{
+			name:        "csv falls back for single values",
+			accept:      "text/csv, application/yaml;q=0.5",
+			data:        newAccount("1", "ann"),
+			status:      http.StatusOK,
+			contentType: MIMEApplicationYAMLCharsetUTF8,
+		},
+		{
+			name:        "unsupported type",
+			accept:      "image/png",
+			data:        newAccount("1", "ann"),
+			status:      http.StatusNotAcceptable,
+			contentType: echo.MIMEApplicationJSONCharsetUTF8,
+			body:        "failed to negotiate user",
+		},
+		{
+			name:        "excluded type",
+			accept:      "application/json;q=0",
+			data:        newAccount("1", "ann"),
+			status:      http.StatusNotAcceptable,
+			contentType: echo.MIMEApplicationJSONCharsetUTF8,
+		},
+		{
+			name:        "errors fall back to json",
+			accept:      "text/csv",
+			data:        errors.New("boom"),
+			status:      http.StatusBadRequest,
+			contentType: echo.MIMEApplicationJSONCharsetUTF8,
+			body:        `"errors":"boom"`,
+		},
+		{
+			name:        "wildcard picks json",
+			accept:      "*/*",
+			data:        accounts,
+			status:      http.StatusOK,
+			contentType: echo.MIMEApplicationJSONCharsetUTF8,
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.accept != "" {
+				req.Header.Set(echo.HeaderAccept, tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			status := http.StatusOK
+			if _, failed := tc.data.(error); failed {
+				status = http.StatusBadRequest
+			}
+
+			require.NoError(t, JSON(c, "successful", status, tc.data))
+
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.contentType, rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, echo.HeaderAccept, rec.Header().Get(echo.HeaderVary))
+			assert.Contains(t, rec.Body.String(), tc.body)
+		})
+	}
+}
+
+func TestRenderMsgpack(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAccept, "application/x-msgpack")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set(APIVersionKey, 2)
+
+	require.NoError(t, JSON(c, "successful", http.StatusOK, newAccount("1", "ann")))
+
+	assert.Equal(t, echo.MIMEApplicationMsgpack, rec.Header().Get(echo.HeaderContentType))
+	var res map[string]any
+	require.NoError(t, msgpack.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, map[string]any{
+		"id": "1", "name": "ann", "status": int64(1), "admin": false,
+		"scopes": []any{"users:read", "users:write"}, "profile": map[string]any{"team": "eng"},
+	}, res["data"])
+}
+
+func TestBind(t *testing.T) {
+	want := newAccount("7", "ann")
+	packed, err := msgpack.Marshal(map[string]any{
+		"id": "7", "name": "ann", "status": 1, "scopes": []string{"users:read", "users:write"},
+		"profile": map[string]any{"team": "eng"},
+	})
+	require.NoError(t, err)
+	testCase := []struct {
+		name        string
+		contentType string
+		body        string
+		errContains string
+	}{
+		{
+			name:        "json",
+			contentType: echo.MIMEApplicationJSON,
+			body:        `{"id":"7","name":"ann","status":1,"scopes":["users:read","users:write"],"profile":{"team":"eng"}}`,
+		},
+		{
+			name:        "xml",
+			contentType: echo.MIMEApplicationXMLCharsetUTF8,
+			body: `<?xml version="1.0" encoding="UTF-8"?><account><id>7</id><name>ann</name><status>1</status>` +
+				`<admin>false</admin><scopes><item>users:read</item><item>users:write</item></scopes><profile><team>eng</team></profile></account>`,
+		},
+		{
+			name:        "yaml",
+			contentType: MIMEApplicationYAML,
+			body:        "id: \"7\"\nname: ann\nstatus: 1\nscopes: [users:read, users:write]\nprofile:\n  team: eng\n",
+		},
+		{name: "msgpack", contentType: echo.MIMEApplicationMsgpack, body: string(packed)},
+		{
+			name:        "xml with a wrong type",
+			contentType: echo.MIMEApplicationXML,
+			body:        `<account><id>7</id><status>one</status></account>`,
+			errContains: `incorrect JSON type for field "status"`,
+		},
+		{
+			name:        "badly formed yaml",
+			contentType: MIMEApplicationYAML,
+			body:        "id: [7",
+			errContains: "yaml",
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			got := account{}
+			err := new(CustomBinder).Bind(&got, c)
+
+			if tc.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
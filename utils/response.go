@@ -4,8 +4,39 @@ import (
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strings"
 )
 
+// APIVersionKey is the echo context key of the api version of a route, it
+// selects the envelope of the responses
+const APIVersionKey = "api_version"
+
+// EnvelopeV2 is the body of successful v2 responses
+type EnvelopeV2 struct {
+	Data any `json:"data"`
+}
+
+// ErrorV2 is the body of failed v2 responses
+type ErrorV2 struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	// Code is the snake cased status text, e.g. "not_found"
+	Code      string `json:"code" validate:"required"`
+	Message   string `json:"message" validate:"required"`
+	RequestID string `json:"requestId"`
+}
+
+// APIVersion returns the api version of the route of the request, 1 unless
+// the route group set another
+func APIVersion(c echo.Context) int {
+	if v, ok := c.Get(APIVersionKey).(int); ok {
+		return v
+	}
+	return 1
+}
+
 func resMsg(msg string) string {
 	if msg == "successful" {
 		return msg
@@ -13,20 +44,43 @@ func resMsg(msg string) string {
 	return fmt.Sprintf("failed to %s user", msg)
 }
 
-// JSON serializes the api response properly to json
+// JSON serializes the api response in the media type negotiated from the
+// Accept header, json unless the client prefers xml, yaml, msgpack or csv
 func JSON(c echo.Context, message string, status int, data any) error {
+	_, failed := data.(error)
+	return render(c, status, newEnvelope(c, message, status, data), data, failed)
+}
+
+// newEnvelope wraps data in the envelope of the api version of the route
+func newEnvelope(c echo.Context, message string, status int, data any) any {
+	if APIVersion(c) >= 2 {
+		return envelopeV2(c, status, data)
+	}
 	switch data.(type) {
 	case error:
-		return c.JSON(status, map[string]any{
-			"message": resMsg(message),
-			"errors":  data.(error).Error(),
-			"status":  http.StatusText(status),
-		})
+		return map[string]any{
+			"message":   resMsg(message),
+			"errors":    data.(error).Error(),
+			"status":    http.StatusText(status),
+			"requestId": c.Response().Header().Get(echo.HeaderXRequestID),
+		}
 	default:
-		return c.JSON(status, map[string]any{
+		return map[string]any{
 			"message": resMsg(message),
 			"data":    data,
 			"status":  http.StatusText(status),
-		})
+		}
+	}
+}
+
+// envelopeV2 wraps data in the v2 envelope, errors carry a machine readable code
+func envelopeV2(c echo.Context, status int, data any) any {
+	if err, ok := data.(error); ok {
+		return ErrorV2{Error: ErrorDetail{
+			Code:      strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+			Message:   err.Error(),
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		}}
 	}
+	return EnvelopeV2{Data: data}
 }
@@ -44,9 +44,9 @@ type CustomBinder struct{}
 func (cb *CustomBinder) Bind(i interface{}, c echo.Context) (err error) {
 	var syntaxError *json.SyntaxError
 	var unmarshalTypeError *json.UnmarshalTypeError
-	// You may use default binder
+	// the default binder reads json and forms, bind adds the other media types
 	db := new(echo.DefaultBinder)
-	if err := db.Bind(i, c); err != nil {
+	if err := bind(db, i, c); err != nil {
 		switch {
 		case errors.Is(err, echo.ErrUnsupportedMediaType):
 			return fmt.Errorf("body contains incorrect JSON type for field %q", echo.ErrUnsupportedMediaType.Error())